@@ -77,9 +77,13 @@ type (
 		PublicDatasetMaxSize  int64  `json:"dataset_max_size,omitempty" yaml:"datasetMaxSize,omitempty"`
 		PublicCourseMaxSize   int64  `json:"course_max_size,omitempty" yaml:"courseMaxSize,omitempty"`
 		// default = "hostpath", hostpath or nfs
+		// applies to public and project dataset and course mounted in sandbox
 		// hostpath: open-hydra-server will use hostpath to mount dataset most likely for aio server or test
-		// nfs: open-hydra-server will use nfs to mount dataset most likely for production
+		// nfs: open-hydra-server will use nfs to mount dataset most likely for production, nfs config is required
 		PublicDatasetVolumeType string `json:"dataset_volume_type,omitempty" yaml:"datasetVolumeType,omitempty"`
+		// default = nil
+		// nfs server exporting dataset and course dirs, only used when dataset volume type is nfs
+		NfsConfig *NfsConfig `json:"nfs_config,omitempty" yaml:"nfsConfig,omitempty"`
 		// default = "/root/public-dataset"
 		PublicDatasetStudentMountPath string `json:"dataset_student_mount_path,omitempty" yaml:"datasetStudentMountPath,omitempty"`
 		PublicCourseStudentMountPath  string `json:"course_student_mount_path,omitempty" yaml:"courseStudentMountPath,omitempty"`
@@ -111,6 +115,249 @@ type (
 		ProjectDatasetStudentMountPath     string              `json:"project_dataset_student_mount_path,omitempty" yaml:"projectDatasetStudentMountPath,omitempty"`
 		ProjectCourseStudentMountPath      string              `json:"project_course_student_mount_path,omitempty" yaml:"projectCourseStudentMountPath,omitempty"`
 		UseDefaultGpuConfigWhenZeroIsGiven bool                `json:"use_default_gpu_config_when_zero_is_given,omitempty" yaml:"useDefaultGpuConfigWhenZeroIsGiven,omitempty"`
+		// default = 5
+		// maximum devices created or deleted at the same time by a device batch when request does not set concurrency
+		DeviceBatchConcurrency uint8 `json:"device_batch_concurrency,omitempty" yaml:"deviceBatchConcurrency,omitempty"`
+		// default = 3600
+		// finished device batch is dropped from memory after given seconds
+		DeviceBatchRetentionSeconds uint32 `json:"device_batch_retention_seconds,omitempty" yaml:"deviceBatchRetentionSeconds,omitempty"`
+		// default = 100
+		// maximum device batches kept in memory, oldest finished batch is dropped first and new batch is refused when all are running
+		MaximumDeviceBatches uint16 `json:"maximum_device_batches,omitempty" yaml:"maximumDeviceBatches,omitempty"`
+		// default = 1
+		// maximum devices a single user can own at the same time, stopped devices are counted as well
+		// 0 means no limit
+		MaximumDevicesPerUser uint8 `json:"maximum_devices_per_user,omitempty" yaml:"maximumDevicesPerUser,omitempty"`
+		// default = 1800
+		// web terminal session is closed after no input for given seconds, 0 falls back to default
+		DeviceExecIdleTimeoutSeconds uint32 `json:"device_exec_idle_timeout_seconds,omitempty" yaml:"deviceExecIdleTimeoutSeconds,omitempty"`
+		// default = fair-share
+		// order of gpu devices waiting for free gpu, one of fair-share, fifo or disabled
+		// fair-share serves higher priority first then user holding less gpu then user whose groups hold less gpu, groups are those in quota config
+		// disabled creates device right away and leaves pod pending
+		// running device can not be given more gpu of a driver while devices wait in queue for it
+		GpuQueuePolicy string `json:"gpu_queue_policy,omitempty" yaml:"gpuQueuePolicy,omitempty"`
+		// default = 10
+		// queued gpu devices are checked against free gpu every given seconds, creating stopping or deleting device triggers a check immediately
+		GpuQueueSyncIntervalSeconds uint32 `json:"gpu_queue_sync_interval_seconds,omitempty" yaml:"gpuQueueSyncIntervalSeconds,omitempty"`
+		// default = 60
+		// expected minutes a gpu device is held by user, only used to estimate wait of queued devices
+		GpuSessionMinutes uint32 `json:"gpu_session_minutes,omitempty" yaml:"gpuSessionMinutes,omitempty"`
+		// default = 60
+		// devices whose deployment lost its service are repaired every given seconds
+		DeviceReconcileIntervalSeconds uint32 `json:"device_reconcile_interval_seconds,omitempty" yaml:"deviceReconcileIntervalSeconds,omitempty"`
+		// default = 60
+		// usage ledger is synced with running devices every given seconds besides whenever pods of devices change
+		UsageLedgerSyncIntervalSeconds uint32 `json:"usage_ledger_sync_interval_seconds,omitempty" yaml:"usageLedgerSyncIntervalSeconds,omitempty"`
+		// default = nil
+		// quota of devices by role, group and user, nothing is limited when not set
+		Quota *QuotaConfig `json:"quota,omitempty" yaml:"quota,omitempty"`
+		// default = nil
+		// profile name -> gpu profile, device may ask for gpu by profile name instead of gpu driver
+		GpuProfiles map[string]GpuProfile `json:"gpu_profiles,omitempty" yaml:"gpuProfiles,omitempty"`
+		// default = {"nvidia.com/gpu": "nvidia.com/gpu.product", "amd.com/gpu": "amd.com/gpu.product-name"}
+		// gpu resource key -> node label telling gpu model, resource key not listed here uses label of other key from the same vendor domain
+		GpuModelNodeLabels map[string]string `json:"gpu_model_node_labels,omitempty" yaml:"gpuModelNodeLabels,omitempty"`
+		// default = nodeport
+		// how sandbox ports are exposed, one of nodeport, ingress, httproute or proxy
+		// nodeport creates a node port service for device which suits aio install, ingress and httproute create a cluster ip service
+		// and an ingress or gateway api httproute for every port of device with routing given in sandbox route
+		// proxy creates a cluster ip service and sandbox is reached through open-hydra-server which checks user before forwarding
+		SandboxExposureMode string `json:"sandbox_exposure_mode,omitempty" yaml:"sandboxExposureMode,omitempty"`
+		// default = nil
+		// routing of sandbox ports, required when sandbox exposure mode is ingress or httproute
+		SandboxRoute *SandboxRouteConfig `json:"sandbox_route,omitempty" yaml:"sandboxRoute,omitempty"`
+		// default = ""
+		// scheme, host and port browser reaches open-hydra-server api by, e.g. https://openhydra.example.com
+		// scheme and port of it are used by sandbox urls on hosts under sandbox proxy domain, http is used when empty
+		SandboxProxyBaseURL string `json:"sandbox_proxy_base_url,omitempty" yaml:"sandboxProxyBaseURL,omitempty"`
+		// default = ""
+		// domain every port of device is served on its own host under in proxy exposure mode, e.g. sandbox.example.com
+		// wildcard dns and certificate of it must lead to open-hydra-server and api must not be served under it, scheme and port of
+		// sandbox proxy base url are used, it is required by proxy exposure mode, devices are neither created nor proxied without it
+		SandboxProxyDomain string `json:"sandbox_proxy_domain,omitempty" yaml:"sandboxProxyDomain,omitempty"`
+		// default = ""
+		// key signing device access links, a random key is used when empty so links are lost after server restart
+		SessionSecret string `json:"session_secret,omitempty" yaml:"sessionSecret,omitempty"`
+		// default = 28800
+		// link user opens own device by, and cookie it turns into on host of device, expires after given seconds
+		SessionTTLSeconds uint32 `json:"session_ttl_seconds,omitempty" yaml:"sessionTTLSeconds,omitempty"`
+		// default = 900
+		// teacher access link to device of other user expires after given seconds when request does not set ttl
+		DeviceAccessTTLSeconds uint32 `json:"device_access_ttl_seconds,omitempty" yaml:"deviceAccessTTLSeconds,omitempty"`
+		// default = 3600
+		// maximum ttl teacher access link may ask for
+		MaximumDeviceAccessTTLSeconds uint32 `json:"maximum_device_access_ttl_seconds,omitempty" yaml:"maximumDeviceAccessTTLSeconds,omitempty"`
+		// default = hostpath
+		// where private dirs of sandbox, host path volumes with {username} or {workspace}, are kept, one of hostpath or pvc
+		// hostpath creates dir under workspace path on server so server and all sandboxes must share that path
+		// pvc gives every user a persistent volume claim on first device which is mounted in place of private dirs and kept afterwards
+		// every private dir is mounted from its own sub path of the claim, e.g. {workspace}/keras/{username} from keras/<username>
+		WorkspaceVolumeMode string `json:"workspace_volume_mode,omitempty" yaml:"workspaceVolumeMode,omitempty"`
+		// default = ""
+		// storage class of workspace claim, default storage class of cluster is used when empty
+		WorkspaceStorageClass string `json:"workspace_storage_class,omitempty" yaml:"workspaceStorageClass,omitempty"`
+		// default = 10Gi
+		// size of workspace claim when quota does not set workspace, claim keeps its size once created
+		WorkspaceSize string `json:"workspace_size,omitempty" yaml:"workspaceSize,omitempty"`
+		// default = ReadWriteOnce
+		// access mode of workspace claim, ReadWriteMany is required when maximum devices per user is not 1 since devices of
+		// one user may run on different nodes, pod of device is recreated on update so a single device never holds the claim twice
+		WorkspaceAccessMode string `json:"workspace_access_mode,omitempty" yaml:"workspaceAccessMode,omitempty"`
+		// default = nil
+		// where devices are scheduled by sandbox and role, affinity of device request is allowed as it is when not set
+		SchedulingPolicy *SchedulingPolicyConfig `json:"scheduling_policy,omitempty" yaml:"schedulingPolicy,omitempty"`
+		// default = nil
+		// network policies isolating devices of different users from each other, devices are not isolated when not set
+		NetworkPolicy *NetworkPolicyConfig `json:"network_policy,omitempty" yaml:"networkPolicy,omitempty"`
+	}
+
+	// NetworkPolicyConfig gives every user a network policy, devices of user only accept traffic from devices of the same user
+	// and ingress peers and only reach devices of the same user, dns and egress peers
+	NetworkPolicyConfig struct {
+		// who may reach devices, at least one is required, e.g. ingress controller or gateway for ingress and httproute exposure mode,
+		// open-hydra-server for proxy exposure mode or cidr of clients or nodes for node port
+		Ingress []NetworkPeer `json:"ingress,omitempty" yaml:"ingress,omitempty"`
+		// where devices may go, e.g. package mirrors
+		Egress []NetworkPeer `json:"egress,omitempty" yaml:"egress,omitempty"`
+		// labels of namespace dns runs in, default kubernetes.io/metadata.name: kube-system
+		DnsNamespaceSelector map[string]string `json:"dns_namespace_selector,omitempty" yaml:"dnsNamespaceSelector,omitempty"`
+		// exam mode blocks all egress of devices including dns and devices of the same user, ingress is kept so devices are still served
+		ExamMode bool `json:"exam_mode,omitempty" yaml:"examMode,omitempty"`
+	}
+
+	// NetworkPeer is selected by cidr or by namespace and pod labels, peer setting none of them is anyone
+	NetworkPeer struct {
+		// e.g. 10.0.0.0/8
+		CIDR string `json:"cidr,omitempty" yaml:"cidr,omitempty"`
+		// cidrs within cidr left out of peer
+		Except []string `json:"except,omitempty" yaml:"except,omitempty"`
+		// labels of namespace of peer, pods of namespace devices run in are selected when only pod selector is set
+		NamespaceSelector map[string]string `json:"namespace_selector,omitempty" yaml:"namespaceSelector,omitempty"`
+		PodSelector       map[string]string `json:"pod_selector,omitempty" yaml:"podSelector,omitempty"`
+		// tcp ports traffic is allowed on, every port when empty
+		Ports []int32 `json:"ports,omitempty" yaml:"ports,omitempty"`
+	}
+
+	SchedulingPolicyConfig struct {
+		// sandbox name -> scheduling policy of devices running that sandbox
+		Sandboxes map[string]SchedulingPolicy `json:"sandboxes,omitempty" yaml:"sandboxes,omitempty"`
+		// role -> scheduling policy of devices owned by user of that role, 1 is teacher and 2 is student
+		// node selector of role is merged over the one of sandbox, other fields set here override the ones of sandbox
+		// tolerations and topology spread constraints of both are added
+		Roles map[int]SchedulingPolicy `json:"roles,omitempty" yaml:"roles,omitempty"`
+	}
+
+	SchedulingPolicy struct {
+		NodeSelector              map[string]string          `json:"node_selector,omitempty" yaml:"nodeSelector,omitempty"`
+		Tolerations               []Toleration               `json:"tolerations,omitempty" yaml:"tolerations,omitempty"`
+		TopologySpreadConstraints []TopologySpreadConstraint `json:"topology_spread_constraints,omitempty" yaml:"topologySpreadConstraints,omitempty"`
+		PriorityClassName         string                     `json:"priority_class_name,omitempty" yaml:"priorityClassName,omitempty"`
+		// one of allow, restrict or deny, how affinity given by device request is treated, allow when empty
+		// restrict only lets node affinity refer node labels listed in allowed affinity keys, deny refuses any affinity
+		// affinity of gpu model is set by open-hydra-server and always allowed
+		UserAffinity        string   `json:"user_affinity,omitempty" yaml:"userAffinity,omitempty"`
+		AllowedAffinityKeys []string `json:"allowed_affinity_keys,omitempty" yaml:"allowedAffinityKeys,omitempty"`
+	}
+
+	// Toleration lets device run on tainted nodes e.g. gpu nodes, see toleration of kubernetes pod
+	Toleration struct {
+		Key               string `json:"key,omitempty" yaml:"key,omitempty"`
+		Operator          string `json:"operator,omitempty" yaml:"operator,omitempty"`
+		Value             string `json:"value,omitempty" yaml:"value,omitempty"`
+		Effect            string `json:"effect,omitempty" yaml:"effect,omitempty"`
+		TolerationSeconds *int64 `json:"toleration_seconds,omitempty" yaml:"tolerationSeconds,omitempty"`
+	}
+
+	// TopologySpreadConstraint spreads devices of the same sandbox across topology key
+	TopologySpreadConstraint struct {
+		MaxSkew     int32  `json:"max_skew" yaml:"maxSkew"`
+		TopologyKey string `json:"topology_key" yaml:"topologyKey"`
+		// DoNotSchedule or ScheduleAnyway, ScheduleAnyway when empty
+		WhenUnsatisfiable string `json:"when_unsatisfiable,omitempty" yaml:"whenUnsatisfiable,omitempty"`
+	}
+
+	SandboxRouteConfig struct {
+		// default = path
+		// path routes port by /<device>-<port> on host, host routes port by <device>-<port>.<host>
+		Routing string `json:"routing,omitempty" yaml:"routing,omitempty"`
+		// domain of sandbox urls, required by host routing, path routing matches any host and uses server ip in url when empty
+		Host string `json:"host,omitempty" yaml:"host,omitempty"`
+		// port of ingress controller or gateway in sandbox urls, default port of scheme is used when 0
+		Port uint16 `json:"port,omitempty" yaml:"port,omitempty"`
+		// sandbox urls use https, it is implied when tls secret name is set
+		Https bool `json:"https,omitempty" yaml:"https,omitempty"`
+		// secret holding certificate of host, only used by ingress, httproute relies on listener of gateway
+		TLSSecretName    string `json:"tls_secret_name,omitempty" yaml:"tlsSecretName,omitempty"`
+		IngressClassName string `json:"ingress_class_name,omitempty" yaml:"ingressClassName,omitempty"`
+		// annotations of ingress or httproute, e.g. to set proxy body size or websocket timeout of ingress controller
+		Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+		// gateway httproute attaches to, required by httproute
+		GatewayName      string `json:"gateway_name,omitempty" yaml:"gatewayName,omitempty"`
+		GatewayNamespace string `json:"gateway_namespace,omitempty" yaml:"gatewayNamespace,omitempty"`
+	}
+
+	// NfsConfig tells where dataset and course dirs of open-hydra-server are exported
+	// export path left empty means dir is exported under the same path as base path on open-hydra-server
+	NfsConfig struct {
+		Server             string `json:"server" yaml:"server"`
+		PublicDatasetPath  string `json:"dataset_path,omitempty" yaml:"datasetPath,omitempty"`
+		PublicCoursePath   string `json:"course_path,omitempty" yaml:"coursePath,omitempty"`
+		ProjectDatasetPath string `json:"project_dataset_path,omitempty" yaml:"projectDatasetPath,omitempty"`
+		ProjectCoursePath  string `json:"project_course_path,omitempty" yaml:"projectCoursePath,omitempty"`
+	}
+
+	// GpuProfile maps a kind of gpu, whole card, time-slicing shared replica or mig slice, to resource key on node
+	GpuProfile struct {
+		// one of whole, shared or mig
+		Type string `json:"type" yaml:"type"`
+		// resource key advertised by device plugin e.g. nvidia.com/gpu, nvidia.com/gpu.shared or nvidia.com/mig-1g.5gb
+		// it should be listed in gpu resource keys as well and not be shared with other profile
+		ResourceKey string `json:"resource_key" yaml:"resourceKey"`
+		// key of sandbox gpuImageSet to pick image with, default to profile name then resource key
+		ImageKey string `json:"image_key,omitempty" yaml:"imageKey,omitempty"`
+	}
+
+	QuotaConfig struct {
+		// role -> quota of every single user of that role, 1 is teacher and 2 is student
+		Roles map[int]ResourceQuota `json:"roles,omitempty" yaml:"roles,omitempty"`
+		// quota shared by all members of group together
+		Groups []GroupQuota `json:"groups,omitempty" yaml:"groups,omitempty"`
+		// username -> quota of user, fields set here override the ones of role quota
+		Users map[string]ResourceQuota `json:"users,omitempty" yaml:"users,omitempty"`
+		// gpu hours budget is given for every day, week or month, week starts on monday, default week
+		BudgetPeriod string `json:"budget_period,omitempty" yaml:"budgetPeriod,omitempty"`
+		// running gpu devices of user are stopped once gpu hours budget of user or any group of user is used up
+		StopGpuDevicesOnBudgetExhausted bool `json:"stop_gpu_devices_on_budget_exhausted,omitempty" yaml:"stopGpuDevicesOnBudgetExhausted,omitempty"`
+		// seconds gpu devices keep running with a warning after budget is used up, 0 stops them at once
+		BudgetGracePeriodSeconds uint32 `json:"budget_grace_period_seconds,omitempty" yaml:"budgetGracePeriodSeconds,omitempty"`
+	}
+
+	GroupQuota struct {
+		Name    string        `json:"name" yaml:"name"`
+		Members []string      `json:"members,omitempty" yaml:"members,omitempty"`
+		Quota   ResourceQuota `json:"quota" yaml:"quota"`
+	}
+
+	// ResourceQuota leaves resource unlimited when its field is not set
+	// student can only request gpu for own device when gpu of that resource key is granted
+	ResourceQuota struct {
+		// maximum devices, stopped devices are counted as well
+		Devices *int64 `json:"devices,omitempty" yaml:"devices,omitempty"`
+		// maximum cpu limit of all running devices, e.g. 8 or 8000m
+		Cpu string `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+		// maximum memory limit of all running devices, e.g. 32Gi
+		Memory string `json:"memory,omitempty" yaml:"memory,omitempty"`
+		// gpu resource key -> maximum gpu of all running devices, queued devices count as running
+		Gpu map[string]int64 `json:"gpu,omitempty" yaml:"gpu,omitempty"`
+		// gpu model -> maximum gpu of that model of all running devices
+		// once set gpu device must ask for one of listed models, gpu of model not listed or without model is not allowed
+		GpuModels map[string]int64 `json:"gpu_models,omitempty" yaml:"gpuModels,omitempty"`
+		// size of workspace claim of user in pvc workspace volume mode, e.g. 20Gi, not used by group quota
+		Workspace string `json:"workspace,omitempty" yaml:"workspace,omitempty"`
+		// gpu hours devices may use in every budget period, taken from usage ledger
+		// no new gpu device is started once it is used up
+		GpuHours *float64 `json:"gpu_hours,omitempty" yaml:"gpuHours,omitempty"`
 	}
 )
 
@@ -156,6 +403,27 @@ func DefaultConfig() *OpenHydraServerConfig {
 		ProjectDatasetStudentMountPath:     "/root/notebook/dataset-project",
 		ProjectCourseStudentMountPath:      "/root/notebook/course-project",
 		UseDefaultGpuConfigWhenZeroIsGiven: false,
+		DeviceBatchConcurrency:             5,
+		DeviceBatchRetentionSeconds:        3600,
+		MaximumDeviceBatches:               100,
+		MaximumDevicesPerUser:              1,
+		DeviceExecIdleTimeoutSeconds:       1800,
+		GpuQueuePolicy:                     "fair-share",
+		GpuQueueSyncIntervalSeconds:        10,
+		GpuSessionMinutes:                  60,
+		DeviceReconcileIntervalSeconds:     60,
+		UsageLedgerSyncIntervalSeconds:     60,
+		GpuModelNodeLabels: map[string]string{
+			"nvidia.com/gpu": "nvidia.com/gpu.product",
+			"amd.com/gpu":    "amd.com/gpu.product-name",
+		},
+		SandboxExposureMode:           "nodeport",
+		SessionTTLSeconds:             28800,
+		DeviceAccessTTLSeconds:        900,
+		MaximumDeviceAccessTTLSeconds: 3600,
+		WorkspaceVolumeMode:           "hostpath",
+		WorkspaceSize:                 "10Gi",
+		WorkspaceAccessMode:           "ReadWriteOnce",
 	}
 }
 
@@ -58,7 +58,16 @@ func init() {
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&Device{},
+		&DeviceAccess{},
+		&DeviceBatch{},
+		&DeviceBatchList{},
+		&DeviceBudget{},
+		&DeviceEventList{},
 		&DeviceList{},
+		&DeviceQuota{},
+		&UsageRecord{},
+		&UsageRecordList{},
+		&UsageReport{},
 	)
 	// AddToGroupVersion allows the serialization of client types like ListOptions.
 	v1.AddToGroupVersion(scheme, SchemeGroupVersion)
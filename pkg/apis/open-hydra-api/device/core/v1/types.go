@@ -21,9 +21,39 @@ type Device struct {
 
 // DeviceStatus defines the observed state of Device of cluster
 type DeviceStatus struct {
+	// short reason why device is not running, e.g. Unschedulable or ImagePullBackOff
+	Reason string `json:"reason,omitempty"`
+	// human readable detail of reason
+	Message               string                   `json:"message,omitempty"`
+	Conditions            []coreV1.PodCondition    `json:"conditions,omitempty"`
+	InitContainerStatuses []coreV1.ContainerStatus `json:"initContainerStatuses,omitempty"`
+	ContainerStatuses     []coreV1.ContainerStatus `json:"containerStatuses,omitempty"`
+	// recent events of device, only filled when getting a single device
+	Events []DeviceEvent `json:"events,omitempty"`
+}
+
+type DeviceEvent struct {
+	// Normal or Warning
+	Type   string `json:"type,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// kind/name of object the event is about, e.g. Pod/openhydra-deploy-user1-xxx
+	Object        string      `json:"object,omitempty"`
+	Message       string      `json:"message,omitempty"`
+	Count         int32       `json:"count,omitempty"`
+	LastTimestamp metav1.Time `json:"lastTimestamp,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+type DeviceEventList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeviceEvent `json:"items"`
 }
 
 type DeviceSpec struct {
+	// id of device among devices of the same user, empty means default device
+	DeviceId           string           `json:"deviceId,omitempty"`
 	DeviceName         string           `json:"deviceName,omitempty"`
 	DeviceNamespace    string           `json:"deviceNamespace,omitempty"`
 	DeviceType         string           `json:"deviceType,omitempty"`
@@ -42,6 +72,15 @@ type DeviceSpec struct {
 	SandboxURLs        string           `json:"sandboxURLs,omitempty"`
 	SandboxName        string           `json:"sandboxName,omitempty"`
 	Affinity           *coreV1.Affinity `json:"affinity,omitempty"`
+	// higher priority is served first in gpu queue, only teacher can set it
+	QueuePriority int32 `json:"queuePriority,omitempty"`
+	// estimated seconds until queued device gets gpu, -1 when device asks for more gpu than cluster has
+	// LineNo tells position of device in queue
+	EstimatedWaitSeconds int64 `json:"estimatedWaitSeconds,omitempty"`
+	// name of gpu profile in server config, gpu driver is taken from profile when set
+	GpuProfile string `json:"gpuProfile,omitempty"`
+	// model of gpu on node device is scheduled to, taken from node label
+	GpuModel string `json:"gpuModel,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -51,3 +90,223 @@ type DeviceList struct {
 	metav1.ListMeta `json:"metadata,omitempty"`
 	Items           []Device `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:subresource:status
+// +k8s:openapi-gen=true
+// +resource:path=devicebatches,strategy=DeviceBatchStrategy,shortname=devbatch
+// DeviceBatch is the Schema for the batch device operation API
+type DeviceBatch struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              DeviceBatchSpec   `json:"spec,omitempty"`
+	Status            DeviceBatchStatus `json:"status,omitempty"`
+}
+
+type DeviceBatchSpec struct {
+	// create or delete
+	Operation string `json:"operation"`
+	// users to operate on, will be merged with users selected by LabelSelector
+	Usernames []string `json:"usernames,omitempty"`
+	// for create it is matched against labels of users
+	// for delete it is matched against labels of device deployments, which are the labels given on device creation
+	// so stopped and queued devices without pod are selected as well
+	LabelSelector string `json:"labelSelector,omitempty"`
+	// maximum devices to create or delete at the same time, 0 means use server default
+	Concurrency int `json:"concurrency,omitempty"`
+	// template for every device to create, openHydraUsername will be overwritten for each user
+	// only deviceId is used for delete and only for users given by usernames, devices matched by LabelSelector are deleted by their own device id
+	Template DeviceSpec `json:"template,omitempty"`
+}
+
+// DeviceBatchStatus defines the observed state of DeviceBatch
+type DeviceBatchStatus struct {
+	// Running, Succeeded or Failed, Failed means at least one user failed
+	Phase     string              `json:"phase,omitempty"`
+	Total     int                 `json:"total"`
+	Succeeded int                 `json:"succeeded"`
+	Failed    int                 `json:"failed"`
+	Results   []DeviceBatchResult `json:"results,omitempty"`
+	// time batch finished, batch is dropped after retention period of server from then on
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+type DeviceBatchResult struct {
+	OpenHydraUsername string `json:"openHydraUsername"`
+	// device created or deleted for user
+	DeviceId string `json:"deviceId,omitempty"`
+	// Pending, Succeeded, Failed or NotFound, NotFound means device to delete does not exist and is counted as failed
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+type DeviceBatchList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DeviceBatch `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// DeviceQuota shows quota of user along with what is used and what is left
+type DeviceQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              DeviceQuotaSpec `json:"spec,omitempty"`
+}
+
+type DeviceQuotaSpec struct {
+	OpenHydraUsername string `json:"openHydraUsername,omitempty"`
+	// role quota of user merged with quota of user
+	Hard QuotaResources `json:"hard"`
+	Used QuotaResources `json:"used"`
+	// resource without limit in hard is left out
+	Remaining QuotaResources `json:"remaining"`
+	// quota of groups user belongs to, used is the sum of all members
+	Groups []GroupQuotaUsage `json:"groups,omitempty"`
+}
+
+type GroupQuotaUsage struct {
+	Name      string         `json:"name"`
+	Hard      QuotaResources `json:"hard"`
+	Used      QuotaResources `json:"used"`
+	Remaining QuotaResources `json:"remaining"`
+}
+
+type QuotaResources struct {
+	Devices *int64 `json:"devices,omitempty"`
+	// cpu limit of running devices
+	Cpu string `json:"cpu,omitempty"`
+	// memory limit of running devices
+	Memory string `json:"memory,omitempty"`
+	// gpu resource key -> gpu of running and queued devices
+	Gpu map[string]int64 `json:"gpu,omitempty"`
+	// gpu model -> gpu of running and queued devices asking for that model
+	GpuModels map[string]int64 `json:"gpuModels,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// DeviceAccess is a short-lived link teacher opens device of other user with through device proxy
+type DeviceAccess struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              DeviceAccessSpec `json:"spec,omitempty"`
+}
+
+type DeviceAccessSpec struct {
+	OpenHydraUsername string `json:"openHydraUsername,omitempty"`
+	DeviceId          string `json:"deviceId,omitempty"`
+	// name of sandbox port, link opens every port of device when empty
+	Port string `json:"port,omitempty"`
+	// link expires after given seconds, 0 means use server default
+	TTLSeconds int64 `json:"ttlSeconds,omitempty"`
+	// only GET, HEAD and OPTIONS requests are forwarded and websocket is refused
+	ReadOnly bool `json:"readOnly,omitempty"`
+	// following fields are set by server
+	// teacher link is issued to
+	Operator  string       `json:"operator,omitempty"`
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+	// link of every port, split by comma like sandbox urls of device
+	URLs string `json:"urls,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// UsageRecord is an entry of usage ledger, written when device starts, stops or is resized
+type UsageRecord struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              UsageRecordSpec `json:"spec,omitempty"`
+}
+
+type UsageRecordSpec struct {
+	// start, stop or resize
+	Event             string      `json:"event"`
+	Time              metav1.Time `json:"time"`
+	OpenHydraUsername string      `json:"openHydraUsername"`
+	DeviceId          string      `json:"deviceId,omitempty"`
+	SandboxName       string      `json:"sandboxName,omitempty"`
+	PodName           string      `json:"podName,omitempty"`
+	// cpu limit of device from then on, stop keeps the amounts device held before
+	CpuMilli int64 `json:"cpuMilli"`
+	// memory limit of device from then on
+	MemoryBytes int64 `json:"memoryBytes"`
+	Gpu         int64 `json:"gpu"`
+	// gpu resource key
+	GpuDriver string `json:"gpuDriver,omitempty"`
+	GpuModel  string `json:"gpuModel,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+type UsageRecordList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []UsageRecord `json:"items"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// UsageReport sums up usage ledger in a time range
+type UsageReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              UsageReportSpec `json:"spec,omitempty"`
+}
+
+type UsageReportSpec struct {
+	From metav1.Time `json:"from"`
+	To   metav1.Time `json:"to"`
+	// user, group, sandbox or gpu
+	GroupBy string            `json:"groupBy"`
+	Items   []UsageReportItem `json:"items"`
+}
+
+type UsageReportItem struct {
+	// username, group name, sandbox name or gpu type usage is summed up by
+	// gpu type is gpu model when device asks for one otherwise gpu resource key, cpu devices are summed up as cpu
+	Key            string  `json:"key"`
+	CpuCoreHours   float64 `json:"cpuCoreHours"`
+	MemoryGiBHours float64 `json:"memoryGiBHours"`
+	GpuHours       float64 `json:"gpuHours"`
+	// number of devices used in time range
+	Devices int `json:"devices"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// DeviceBudget shows gpu hours budget of user and groups of user in current budget period
+type DeviceBudget struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              DeviceBudgetSpec `json:"spec,omitempty"`
+}
+
+type DeviceBudgetSpec struct {
+	OpenHydraUsername string `json:"openHydraUsername,omitempty"`
+	// day, week or month
+	Period string      `json:"period"`
+	From   metav1.Time `json:"from"`
+	// budget is given again at
+	ResetAt metav1.Time `json:"resetAt"`
+	// budget of user followed by budget of every group of user, budget not set is left out
+	Budgets []GpuBudgetUsage `json:"budgets,omitempty"`
+	// any budget is used up, no new gpu device is started
+	Exhausted bool `json:"exhausted"`
+	// running gpu devices are stopped at, only set when budget is used up and server stops devices
+	StopAt *metav1.Time `json:"stopAt,omitempty"`
+}
+
+type GpuBudgetUsage struct {
+	// user or group
+	Scope     string  `json:"scope"`
+	Name      string  `json:"name"`
+	GpuHours  float64 `json:"gpuHours"`
+	Used      float64 `json:"used"`
+	Remaining float64 `json:"remaining"`
+}
@@ -27,6 +27,8 @@ type SettingStatus struct {
 type SettingSpec struct {
 	DefaultGpuPerDevice uint8           `json:"default_gpu_per_device" yaml:"defaultGpuPerDevice"`
 	PluginList          apis.PluginList `json:"plugin_list,omitempty" yaml:"pluginList,omitempty"`
+	// exam mode blocks all egress of devices, left unchanged when not set
+	ExamMode *bool `json:"exam_mode,omitempty" yaml:"examMode,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -29,7 +29,7 @@ func (in *Setting) DeepCopyInto(out *Setting) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 	return
 }
@@ -60,7 +60,9 @@ func (in *SettingList) DeepCopyInto(out *SettingList) {
 	if in.Items != nil {
 		in, out := &in.Items, &out.Items
 		*out = make([]SettingSpec, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -86,6 +88,11 @@ func (in *SettingList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SettingSpec) DeepCopyInto(out *SettingSpec) {
 	*out = *in
+	if in.ExamMode != nil {
+		in, out := &in.ExamMode, &out.ExamMode
+		*out = new(bool)
+		**out = **in
+	}
 	return
 }
 
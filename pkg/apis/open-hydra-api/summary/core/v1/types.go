@@ -32,6 +32,10 @@ type SumUpSpec struct {
 	DefaultGpuPerDevice uint8                       `json:"defaultGpuPerDevice"`
 	TotalLine           uint16                      `json:"totalLine"`
 	GpuResourceSumUp    map[string]GpuResourceSumUp `json:"gpuResourceSumUp"`
+	// profile name -> capacity of gpu profile
+	GpuProfileSumUp map[string]GpuProfileSumUp `json:"gpuProfileSumUp,omitempty"`
+	// gpu model found in node labels -> capacity of gpu of that model
+	GpuModelSumUp map[string]GpuModelSumUp `json:"gpuModelSumUp,omitempty"`
 }
 
 type GpuResourceSumUp struct {
@@ -39,6 +43,20 @@ type GpuResourceSumUp struct {
 	Allocatable int64 `json:"allocatable"`
 }
 
+type GpuModelSumUp struct {
+	// number of nodes with gpu of this model
+	Nodes int `json:"nodes"`
+	// gpu resource key -> allocation of gpu of this model
+	GpuResourceSumUp map[string]GpuResourceSumUp `json:"gpuResourceSumUp"`
+}
+
+type GpuProfileSumUp struct {
+	Type        string `json:"type"`
+	ResourceKey string `json:"resourceKey"`
+	Allocated   int64  `json:"allocated"`
+	Allocatable int64  `json:"allocatable"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 // +k8s:openapi-gen=true
 type SumUpList struct {
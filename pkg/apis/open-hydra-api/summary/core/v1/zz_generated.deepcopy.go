@@ -24,12 +24,67 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GpuModelSumUp) DeepCopyInto(out *GpuModelSumUp) {
+	*out = *in
+	if in.GpuResourceSumUp != nil {
+		in, out := &in.GpuResourceSumUp, &out.GpuResourceSumUp
+		*out = make(map[string]GpuResourceSumUp, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GpuModelSumUp.
+func (in *GpuModelSumUp) DeepCopy() *GpuModelSumUp {
+	if in == nil {
+		return nil
+	}
+	out := new(GpuModelSumUp)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GpuProfileSumUp) DeepCopyInto(out *GpuProfileSumUp) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GpuProfileSumUp.
+func (in *GpuProfileSumUp) DeepCopy() *GpuProfileSumUp {
+	if in == nil {
+		return nil
+	}
+	out := new(GpuProfileSumUp)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GpuResourceSumUp) DeepCopyInto(out *GpuResourceSumUp) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GpuResourceSumUp.
+func (in *GpuResourceSumUp) DeepCopy() *GpuResourceSumUp {
+	if in == nil {
+		return nil
+	}
+	out := new(GpuResourceSumUp)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SumUp) DeepCopyInto(out *SumUp) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 	return
 }
@@ -88,6 +143,27 @@ func (in *SumUpList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SumUpSpec) DeepCopyInto(out *SumUpSpec) {
 	*out = *in
+	if in.GpuResourceSumUp != nil {
+		in, out := &in.GpuResourceSumUp, &out.GpuResourceSumUp
+		*out = make(map[string]GpuResourceSumUp, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.GpuProfileSumUp != nil {
+		in, out := &in.GpuProfileSumUp, &out.GpuProfileSumUp
+		*out = make(map[string]GpuProfileSumUp, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.GpuModelSumUp != nil {
+		in, out := &in.GpuModelSumUp, &out.GpuModelSumUp
+		*out = make(map[string]GpuModelSumUp, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	return
 }
 
@@ -14,8 +14,10 @@ import (
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilRuntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/endpoints/openapi"
 	genericApiServer "k8s.io/apiserver/pkg/server"
+	genericFilters "k8s.io/apiserver/pkg/server/filters"
 )
 
 func init() {
@@ -52,6 +54,8 @@ func RunApiServer(recommendOption *option.Options, config *config.OpenHydraServe
 	if err := recommendOption.ApiServerOption.ApplyTo(recommendedConfig); err != nil {
 		return err
 	}
+	// streaming device log and web terminal must not be cut by request timeout
+	recommendedConfig.LongRunningFunc = genericFilters.BasicLongRunningRequestCheck(sets.NewString("watch"), sets.NewString("log", "exec", "proxy"))
 
 	completedConfig := recommendedConfig.Complete()
 	completedConfig.EnableDiscovery = false
@@ -94,6 +94,15 @@ func registerApiResource(apiServer *genericApiServer.GenericAPIServer, config *c
 	RBuilder.AddDeviceGetRoute()
 	RBuilder.AddDeviceUpdateRoute()
 	RBuilder.AddDeviceDeleteRoute()
+	RBuilder.AddDeviceStopRoute()
+	RBuilder.AddDeviceStartRoute()
+	RBuilder.AddDeviceEventsRoute()
+	RBuilder.AddDeviceLogRoute()
+	RBuilder.AddDeviceExecRoute()
+	RBuilder.AddDeviceProxyRoute()
+	RBuilder.AddDeviceAccessRoute()
+	RBuilder.AddDeviceQuotaRoute()
+	RBuilder.AddDeviceBudgetRoute()
 	RBuilder.AddSummaryGetRoute()
 	RBuilder.AddDatasetListRoute()
 	RBuilder.AddDatasetCreateRoute()
@@ -108,6 +117,15 @@ func registerApiResource(apiServer *genericApiServer.GenericAPIServer, config *c
 	RBuilder.AddCourseCreateRoute()
 	RBuilder.AddCourseUpdateRoute()
 	RBuilder.AddCourseDeleteRoute()
+	RBuilder.AddDeviceBatchCreateRoute()
+	RBuilder.AddDeviceBatchListRoute()
+	RBuilder.AddDeviceBatchGetRoute()
+	RBuilder.AddUsageReportRoute()
+	// api server only runs on leader so only one gpu queue admits devices
+	RBuilder.RunGpuQueue(stopChan)
+	RBuilder.RunDeviceReconciler(stopChan)
+	RBuilder.RunWatchHubs(stopChan)
+	RBuilder.RunUsageLedger(stopChan)
 	if !config.DisableAuth {
 		ws.Filter(RBuilder.Filter)
 	}
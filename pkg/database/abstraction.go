@@ -1,8 +1,11 @@
 package database
 
 import (
+	"time"
+
 	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
 	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
 	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
 )
 
@@ -10,6 +13,7 @@ type IDataBase interface {
 	IDataBaseDataset
 	IDataBaseUser
 	IDataBaseCourse
+	IDataBaseUsage
 	InitDb() error
 }
 
@@ -53,3 +57,11 @@ type IDataBaseCourse interface {
 	// List all courses
 	ListCourses() (xCourseV1.CourseList, error)
 }
+
+type IDataBaseUsage interface {
+	// Create a new usage record
+	CreateUsageRecord(record *xDeviceV1.UsageRecord) error
+	// List usage records in [from, to) ordered by time along with the last record of every device before from
+	// so devices already running at from are known
+	ListUsageRecords(from, to time.Time) (xDeviceV1.UsageRecordList, error)
+}
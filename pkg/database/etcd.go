@@ -1,9 +1,12 @@
 package database
 
 import (
+	"time"
+
 	"open-hydra/cmd/open-hydra-server/app/config"
 	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
 	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
 	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
 )
 
@@ -93,3 +96,13 @@ func (db *Etcd) DeleteCourse(name string) error {
 func (db *Etcd) ListCourses() (xCourseV1.CourseList, error) {
 	return xCourseV1.CourseList{}, nil
 }
+
+// implements IDataBaseUsage creates a new usage record
+func (db *Etcd) CreateUsageRecord(record *xDeviceV1.UsageRecord) error {
+	return nil
+}
+
+// implements IDataBaseUsage lists usage records in a time range
+func (db *Etcd) ListUsageRecords(from, to time.Time) (xDeviceV1.UsageRecordList, error) {
+	return xDeviceV1.UsageRecordList{}, nil
+}
@@ -4,8 +4,11 @@ import (
 	"fmt"
 	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
 	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
 	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
 	"open-hydra/pkg/util"
+	"strconv"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -16,12 +19,15 @@ type Faker struct {
 	fakeUsers    map[string]*xUserV1.OpenHydraUser
 	fakeDatasets map[string]*xDatasetV1.Dataset
 	fakeCourses  map[string]*xCourseV1.Course
+	// kept in the order they are created
+	fakeUsageRecords []xDeviceV1.UsageRecord
 }
 
 func (f *Faker) Init() {
 	f.fakeUsers = make(map[string]*xUserV1.OpenHydraUser)
 	f.fakeDatasets = make(map[string]*xDatasetV1.Dataset)
 	f.fakeCourses = make(map[string]*xCourseV1.Course)
+	f.fakeUsageRecords = nil
 }
 
 // implements IDataBaseUser creates a new user
@@ -171,3 +177,33 @@ func (db *Faker) ListCourses() (xCourseV1.CourseList, error) {
 	}
 	return result, nil
 }
+
+// implements IDataBaseUsage creates a new usage record
+func (db *Faker) CreateUsageRecord(record *xDeviceV1.UsageRecord) error {
+	record.Name = strconv.Itoa(len(db.fakeUsageRecords) + 1)
+	db.fakeUsageRecords = append(db.fakeUsageRecords, *record)
+	return nil
+}
+
+// implements IDataBaseUsage lists usage records in [from, to) along with the last record of every device before from
+func (db *Faker) ListUsageRecords(from, to time.Time) (xDeviceV1.UsageRecordList, error) {
+	result := xDeviceV1.UsageRecordList{}
+	result.Kind = "List"
+	result.APIVersion = "v1"
+	last := map[string]int{}
+	for index, record := range db.fakeUsageRecords {
+		if record.Spec.Time.Time.Before(from) {
+			last[record.Spec.OpenHydraUsername+"/"+record.Spec.DeviceId] = index
+		}
+	}
+	for index, record := range db.fakeUsageRecords {
+		if record.Spec.Time.Time.Before(from) {
+			if last[record.Spec.OpenHydraUsername+"/"+record.Spec.DeviceId] == index {
+				result.Items = append(result.Items, record)
+			}
+		} else if record.Spec.Time.Time.Before(to) {
+			result.Items = append(result.Items, record)
+		}
+	}
+	return result, nil
+}
@@ -5,10 +5,13 @@ import (
 	stdErr "errors"
 	"fmt"
 	"log/slog"
+	"strconv"
+	"time"
 
 	"open-hydra/cmd/open-hydra-server/app/config"
 	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
 	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
 	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
 	"open-hydra/pkg/util"
 
@@ -246,6 +249,11 @@ func (db *Mysql) InitDb() error {
 	if err != nil {
 		return err
 	}
+
+	_, err = inst.Exec("CREATE TABLE IF NOT EXISTS usage_record ( id BIGINT AUTO_INCREMENT PRIMARY KEY, event VARCHAR(32), time DATETIME , username VARCHAR(255), device_id VARCHAR(255), sandbox_name VARCHAR(255), pod_name VARCHAR(255), cpu_milli BIGINT , memory_bytes BIGINT , gpu BIGINT , gpu_driver VARCHAR(255), gpu_model VARCHAR(255), INDEX (time), INDEX (username, device_id, time) )")
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -359,3 +367,53 @@ func (db *Mysql) ListCourses() (xCourseV1.CourseList, error) {
 
 	return result, nil
 }
+
+// CreateUsageRecord implements IDataBaseUsage creates a new usage record
+func (db *Mysql) CreateUsageRecord(record *xDeviceV1.UsageRecord) error {
+	inst, err := db.getDB()
+	if err != nil {
+		return err
+	}
+	spec := record.Spec
+	result, err := inst.Exec("INSERT INTO usage_record (event, time, username, device_id, sandbox_name, pod_name, cpu_milli, memory_bytes, gpu, gpu_driver, gpu_model) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)", spec.Event, spec.Time.Time, spec.OpenHydraUsername, spec.DeviceId, spec.SandboxName, spec.PodName, spec.CpuMilli, spec.MemoryBytes, spec.Gpu, spec.GpuDriver, spec.GpuModel)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed to create usage record of device %s of user %s into database", spec.DeviceId, spec.OpenHydraUsername), "error", err)
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Failed get create usage record of device %s of user %s result", spec.DeviceId, spec.OpenHydraUsername), "error", err)
+		return nil
+	}
+	record.Name = strconv.FormatInt(id, 10)
+	return nil
+}
+
+// ListUsageRecords implements IDataBaseUsage lists usage records in [from, to) along with the last record of every device before from
+// records are only written by leader one after another, so the largest id of device is its last record
+func (db *Mysql) ListUsageRecords(from, to time.Time) (xDeviceV1.UsageRecordList, error) {
+	inst, err := db.getDB()
+	if err != nil {
+		return xDeviceV1.UsageRecordList{}, err
+	}
+
+	rows, err := inst.Query("SELECT id, event, time, username, device_id, sandbox_name, pod_name, cpu_milli, memory_bytes, gpu, gpu_driver, gpu_model FROM usage_record WHERE (time >= ? AND time < ?) OR id IN (SELECT MAX(id) FROM usage_record WHERE time < ? GROUP BY username, device_id) ORDER BY time, id", from, to, from)
+	if err != nil {
+		return xDeviceV1.UsageRecordList{}, err
+	}
+	defer rows.Close()
+	var result xDeviceV1.UsageRecordList
+	for rows.Next() {
+		var record xDeviceV1.UsageRecord
+		var id int64
+		util.FillObjectGVK(&record)
+		err = rows.Scan(&id, &record.Spec.Event, &record.Spec.Time.Time, &record.Spec.OpenHydraUsername, &record.Spec.DeviceId, &record.Spec.SandboxName, &record.Spec.PodName, &record.Spec.CpuMilli, &record.Spec.MemoryBytes, &record.Spec.Gpu, &record.Spec.GpuDriver, &record.Spec.GpuModel)
+		if err != nil {
+			return xDeviceV1.UsageRecordList{}, err
+		}
+		record.Name = strconv.FormatInt(id, 10)
+		result.Items = append(result.Items, record)
+	}
+
+	return result, nil
+}
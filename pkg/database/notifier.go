@@ -0,0 +1,74 @@
+package database
+
+import (
+	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
+	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
+)
+
+const (
+	ChangeKindUser    = "user"
+	ChangeKindDataset = "dataset"
+	ChangeKindCourse  = "course"
+)
+
+// Change tells an object of database is created, updated or deleted
+type Change struct {
+	// one of ChangeKindUser, ChangeKindDataset or ChangeKindCourse
+	Kind string
+	Name string
+}
+
+// NotifyingDataBase reports every successful change made through it, reads are passed to wrapped database as they are
+// changes made by others directly in database are not seen
+type NotifyingDataBase struct {
+	IDataBase
+	notify func(Change)
+}
+
+func NewNotifyingDataBase(db IDataBase, notify func(Change)) *NotifyingDataBase {
+	return &NotifyingDataBase{IDataBase: db, notify: notify}
+}
+
+func (db *NotifyingDataBase) changed(kind, name string, err error) error {
+	if err == nil {
+		db.notify(Change{Kind: kind, Name: name})
+	}
+	return err
+}
+
+func (db *NotifyingDataBase) CreateUser(user *xUserV1.OpenHydraUser) error {
+	return db.changed(ChangeKindUser, user.Name, db.IDataBase.CreateUser(user))
+}
+
+func (db *NotifyingDataBase) UpdateUser(user *xUserV1.OpenHydraUser) error {
+	return db.changed(ChangeKindUser, user.Name, db.IDataBase.UpdateUser(user))
+}
+
+func (db *NotifyingDataBase) DeleteUser(name string) error {
+	return db.changed(ChangeKindUser, name, db.IDataBase.DeleteUser(name))
+}
+
+func (db *NotifyingDataBase) CreateDataset(dataset *xDatasetV1.Dataset) error {
+	return db.changed(ChangeKindDataset, dataset.Name, db.IDataBase.CreateDataset(dataset))
+}
+
+func (db *NotifyingDataBase) UpdateDataset(dataset *xDatasetV1.Dataset) error {
+	return db.changed(ChangeKindDataset, dataset.Name, db.IDataBase.UpdateDataset(dataset))
+}
+
+func (db *NotifyingDataBase) DeleteDataset(name string) error {
+	return db.changed(ChangeKindDataset, name, db.IDataBase.DeleteDataset(name))
+}
+
+func (db *NotifyingDataBase) CreateCourse(course *xCourseV1.Course) error {
+	return db.changed(ChangeKindCourse, course.Name, db.IDataBase.CreateCourse(course))
+}
+
+func (db *NotifyingDataBase) UpdateCourse(course *xCourseV1.Course) error {
+	return db.changed(ChangeKindCourse, course.Name, db.IDataBase.UpdateCourse(course))
+}
+
+func (db *NotifyingDataBase) DeleteCourse(name string) error {
+	return db.changed(ChangeKindCourse, name, db.IDataBase.DeleteCourse(name))
+}
@@ -316,13 +316,37 @@ func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenA
 		"open-hydra/pkg/apis/open-hydra-api/dataset/core/v1.DatasetSpec":      schema_open_hydra_api_dataset_core_v1_DatasetSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/dataset/core/v1.DatasetStatus":    schema_open_hydra_api_dataset_core_v1_DatasetStatus(ref),
 		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.Device":            schema_open_hydra_api_device_core_v1_Device(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceAccess":      schema_open_hydra_api_device_core_v1_DeviceAccess(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceAccessSpec":  schema_open_hydra_api_device_core_v1_DeviceAccessSpec(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatch":       schema_open_hydra_api_device_core_v1_DeviceBatch(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchList":   schema_open_hydra_api_device_core_v1_DeviceBatchList(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchResult": schema_open_hydra_api_device_core_v1_DeviceBatchResult(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchSpec":   schema_open_hydra_api_device_core_v1_DeviceBatchSpec(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchStatus": schema_open_hydra_api_device_core_v1_DeviceBatchStatus(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBudget":      schema_open_hydra_api_device_core_v1_DeviceBudget(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBudgetSpec":  schema_open_hydra_api_device_core_v1_DeviceBudgetSpec(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEvent":       schema_open_hydra_api_device_core_v1_DeviceEvent(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEventList":   schema_open_hydra_api_device_core_v1_DeviceEventList(ref),
 		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceList":        schema_open_hydra_api_device_core_v1_DeviceList(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceQuota":       schema_open_hydra_api_device_core_v1_DeviceQuota(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceQuotaSpec":   schema_open_hydra_api_device_core_v1_DeviceQuotaSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceSpec":        schema_open_hydra_api_device_core_v1_DeviceSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceStatus":      schema_open_hydra_api_device_core_v1_DeviceStatus(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.GpuBudgetUsage":    schema_open_hydra_api_device_core_v1_GpuBudgetUsage(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.GroupQuotaUsage":   schema_open_hydra_api_device_core_v1_GroupQuotaUsage(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources":    schema_open_hydra_api_device_core_v1_QuotaResources(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecord":       schema_open_hydra_api_device_core_v1_UsageRecord(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecordList":   schema_open_hydra_api_device_core_v1_UsageRecordList(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecordSpec":   schema_open_hydra_api_device_core_v1_UsageRecordSpec(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReport":       schema_open_hydra_api_device_core_v1_UsageReport(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportItem":   schema_open_hydra_api_device_core_v1_UsageReportItem(ref),
+		"open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportSpec":   schema_open_hydra_api_device_core_v1_UsageReportSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/setting/core/v1.Setting":          schema_open_hydra_api_setting_core_v1_Setting(ref),
 		"open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingList":      schema_open_hydra_api_setting_core_v1_SettingList(ref),
 		"open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec":      schema_open_hydra_api_setting_core_v1_SettingSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingStatus":    schema_open_hydra_api_setting_core_v1_SettingStatus(ref),
+		"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuModelSumUp":    schema_open_hydra_api_summary_core_v1_GpuModelSumUp(ref),
+		"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuProfileSumUp":  schema_open_hydra_api_summary_core_v1_GpuProfileSumUp(ref),
 		"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp": schema_open_hydra_api_summary_core_v1_GpuResourceSumUp(ref),
 		"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUp":            schema_open_hydra_api_summary_core_v1_SumUp(ref),
 		"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpList":        schema_open_hydra_api_summary_core_v1_SumUpList(ref),
@@ -333,7 +357,14 @@ func GetOpenAPIDefinitions(ref common.ReferenceCallback) map[string]common.OpenA
 		"open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserSpec":   schema_open_hydra_api_user_core_v1_OpenHydraUserSpec(ref),
 		"open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserStatus": schema_open_hydra_api_user_core_v1_OpenHydraUserStatus(ref),
 		"open-hydra/pkg/open-hydra/apis.EmptyDir":                             schema_open_hydra_pkg_open_hydra_apis_EmptyDir(ref),
+		"open-hydra/pkg/open-hydra/apis.EnvFromSource":                        schema_open_hydra_pkg_open_hydra_apis_EnvFromSource(ref),
+		"open-hydra/pkg/open-hydra/apis.EnvVar":                               schema_open_hydra_pkg_open_hydra_apis_EnvVar(ref),
+		"open-hydra/pkg/open-hydra/apis.HealthCheck":                          schema_open_hydra_pkg_open_hydra_apis_HealthCheck(ref),
 		"open-hydra/pkg/open-hydra/apis.HostPath":                             schema_open_hydra_pkg_open_hydra_apis_HostPath(ref),
+		"open-hydra/pkg/open-hydra/apis.InitContainer":                        schema_open_hydra_pkg_open_hydra_apis_InitContainer(ref),
+		"open-hydra/pkg/open-hydra/apis.KeyRef":                               schema_open_hydra_pkg_open_hydra_apis_KeyRef(ref),
+		"open-hydra/pkg/open-hydra/apis.NFS":                                  schema_open_hydra_pkg_open_hydra_apis_NFS(ref),
+		"open-hydra/pkg/open-hydra/apis.PersistentVolumeClaim":                schema_open_hydra_pkg_open_hydra_apis_PersistentVolumeClaim(ref),
 		"open-hydra/pkg/open-hydra/apis.PluginList":                           schema_open_hydra_pkg_open_hydra_apis_PluginList(ref),
 		"open-hydra/pkg/open-hydra/apis.Sandbox":                              schema_open_hydra_pkg_open_hydra_apis_Sandbox(ref),
 		"open-hydra/pkg/open-hydra/apis.Volume":                               schema_open_hydra_pkg_open_hydra_apis_Volume(ref),
@@ -15554,11 +15585,12 @@ func schema_open_hydra_api_device_core_v1_Device(ref common.ReferenceCallback) c
 	}
 }
 
-func schema_open_hydra_api_device_core_v1_DeviceList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceAccess(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Description: "DeviceAccess is a short-lived link teacher opens device of other user with through device proxy",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
 					"kind": {
 						SchemaProps: spec.SchemaProps{
@@ -15577,162 +15609,94 @@ func schema_open_hydra_api_device_core_v1_DeviceList(ref common.ReferenceCallbac
 					"metadata": {
 						SchemaProps: spec.SchemaProps{
 							Default: map[string]interface{}{},
-							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
 						},
 					},
-					"items": {
+					"spec": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"array"},
-							Items: &spec.SchemaOrArray{
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Default: map[string]interface{}{},
-										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.Device"),
-									},
-								},
-							},
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceAccessSpec"),
 						},
 					},
 				},
-				Required: []string{"items"},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.Device"},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceAccessSpec"},
 	}
 }
 
-func schema_open_hydra_api_device_core_v1_DeviceSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceAccessSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"deviceName": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceNamespace": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceType": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceIP": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceCpu": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceRam": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"deviceGpu": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"integer"},
-							Format: "byte",
-						},
-					},
-					"deviceStatus": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"gpuDriver": {
+					"openHydraUsername": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"openHydraUsername": {
+					"deviceId": {
 						SchemaProps: spec.SchemaProps{
 							Type:   []string{"string"},
 							Format: "",
 						},
 					},
-					"role": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"integer"},
-							Format: "int32",
-						},
-					},
-					"chineseName": {
+					"port": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Description: "name of sandbox port, link opens every port of device when empty",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
-					"lineNo": {
+					"ttlSeconds": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Description: "link expires after given seconds, 0 means use server default",
+							Type:        []string{"integer"},
+							Format:      "int64",
 						},
 					},
-					"usePublicDataSet": {
+					"readOnly": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"boolean"},
-							Format: "",
+							Description: "only GET, HEAD and OPTIONS requests are forwarded and websocket is refused",
+							Type:        []string{"boolean"},
+							Format:      "",
 						},
 					},
-					"sandboxURLs": {
+					"operator": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Description: "following fields are set by server teacher link is issued to",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
-					"sandboxName": {
+					"expiresAt": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
 						},
 					},
-					"affinity": {
+					"urls": {
 						SchemaProps: spec.SchemaProps{
-							Ref: ref("k8s.io/api/core/v1.Affinity"),
+							Description: "link of every port, split by comma like sandbox urls of device",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/api/core/v1.Affinity"},
-	}
-}
-
-func schema_open_hydra_api_device_core_v1_DeviceStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
-	return common.OpenAPIDefinition{
-		Schema: spec.Schema{
-			SchemaProps: spec.SchemaProps{
-				Description: "DeviceStatus defines the observed state of Device of cluster",
-				Type:        []string{"object"},
-			},
-		},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
 	}
 }
 
-func schema_open_hydra_api_setting_core_v1_Setting(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBatch(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Description: "Setting is the Schema for the Dataset API",
+				Description: "DeviceBatch is the Schema for the batch device operation API",
 				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
 					"kind": {
@@ -15758,24 +15722,24 @@ func schema_open_hydra_api_setting_core_v1_Setting(ref common.ReferenceCallback)
 					"spec": {
 						SchemaProps: spec.SchemaProps{
 							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"),
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchSpec"),
 						},
 					},
 					"status": {
 						SchemaProps: spec.SchemaProps{
 							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingStatus"),
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchStatus"),
 						},
 					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingStatus"},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchSpec", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchStatus"},
 	}
 }
 
-func schema_open_hydra_api_setting_core_v1_SettingList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBatchList(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
@@ -15808,7 +15772,7 @@ func schema_open_hydra_api_setting_core_v1_SettingList(ref common.ReferenceCallb
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
 										Default: map[string]interface{}{},
-										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"),
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatch"),
 									},
 								},
 							},
@@ -15819,81 +15783,178 @@ func schema_open_hydra_api_setting_core_v1_SettingList(ref common.ReferenceCallb
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatch"},
 	}
 }
 
-func schema_open_hydra_api_setting_core_v1_SettingSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBatchResult(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"default_gpu_per_device": {
+					"openHydraUsername": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "byte",
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
 						},
 					},
-					"plugin_list": {
+					"deviceId": {
 						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/open-hydra/apis.PluginList"),
+							Description: "device created or deleted for user",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"result": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Pending, Succeeded, Failed or NotFound, NotFound means device to delete does not exist and is counted as failed",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"message": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
 				},
-				Required: []string{"default_gpu_per_device"},
+				Required: []string{"openHydraUsername", "result"},
 			},
 		},
-		Dependencies: []string{
-			"open-hydra/pkg/open-hydra/apis.PluginList"},
 	}
 }
 
-func schema_open_hydra_api_setting_core_v1_SettingStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBatchSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Description: "SettingStatus defines the observed state of Device of cluster",
-				Type:        []string{"object"},
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"operation": {
+						SchemaProps: spec.SchemaProps{
+							Description: "create or delete",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"usernames": {
+						SchemaProps: spec.SchemaProps{
+							Description: "users to operate on, will be merged with users selected by LabelSelector",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: "",
+										Type:    []string{"string"},
+										Format:  "",
+									},
+								},
+							},
+						},
+					},
+					"labelSelector": {
+						SchemaProps: spec.SchemaProps{
+							Description: "for create it is matched against labels of users for delete it is matched against labels of device deployments, which are the labels given on device creation so stopped and queued devices without pod are selected as well",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"concurrency": {
+						SchemaProps: spec.SchemaProps{
+							Description: "maximum devices to create or delete at the same time, 0 means use server default",
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+					"template": {
+						SchemaProps: spec.SchemaProps{
+							Description: "template for every device to create, openHydraUsername will be overwritten for each user only deviceId is used for delete and only for users given by usernames, devices matched by LabelSelector are deleted by their own device id",
+							Default:     map[string]interface{}{},
+							Ref:         ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceSpec"),
+						},
+					},
+				},
+				Required: []string{"operation"},
 			},
 		},
+		Dependencies: []string{
+			"open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceSpec"},
 	}
 }
 
-func schema_open_hydra_api_summary_core_v1_GpuResourceSumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBatchStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Type: []string{"object"},
+				Description: "DeviceBatchStatus defines the observed state of DeviceBatch",
+				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"allocated": {
+					"phase": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Running, Succeeded or Failed, Failed means at least one user failed",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"total": {
 						SchemaProps: spec.SchemaProps{
 							Default: 0,
 							Type:    []string{"integer"},
-							Format:  "int64",
+							Format:  "int32",
 						},
 					},
-					"allocatable": {
+					"succeeded": {
 						SchemaProps: spec.SchemaProps{
 							Default: 0,
 							Type:    []string{"integer"},
-							Format:  "int64",
+							Format:  "int32",
+						},
+					},
+					"failed": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int32",
+						},
+					},
+					"results": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchResult"),
+									},
+								},
+							},
+						},
+					},
+					"completionTime": {
+						SchemaProps: spec.SchemaProps{
+							Description: "time batch finished, batch is dropped after retention period of server from then on",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
 						},
 					},
 				},
-				Required: []string{"allocated", "allocatable"},
+				Required: []string{"total", "succeeded", "failed"},
 			},
 		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBatchResult"},
 	}
 }
 
-func schema_open_hydra_api_summary_core_v1_SumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBudget(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Description: "SumUp is the Schema for the cluster summary API",
+				Description: "DeviceBudget shows gpu hours budget of user and groups of user in current budget period",
 				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
 					"kind": {
@@ -15919,35 +15980,1561 @@ func schema_open_hydra_api_summary_core_v1_SumUp(ref common.ReferenceCallback) c
 					"spec": {
 						SchemaProps: spec.SchemaProps{
 							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpSpec"),
-						},
-					},
-					"status": {
-						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpStatus"),
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBudgetSpec"),
 						},
 					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpSpec", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpStatus"},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceBudgetSpec"},
 	}
 }
 
-func schema_open_hydra_api_summary_core_v1_SumUpList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_device_core_v1_DeviceBudgetSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"kind": {
+					"openHydraUsername": {
 						SchemaProps: spec.SchemaProps{
-							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
-							Type:        []string{"string"},
-							Format:      "",
-						},
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"period": {
+						SchemaProps: spec.SchemaProps{
+							Description: "day, week or month",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"from": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"resetAt": {
+						SchemaProps: spec.SchemaProps{
+							Description: "budget is given again at",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"budgets": {
+						SchemaProps: spec.SchemaProps{
+							Description: "budget of user followed by budget of every group of user, budget not set is left out",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.GpuBudgetUsage"),
+									},
+								},
+							},
+						},
+					},
+					"exhausted": {
+						SchemaProps: spec.SchemaProps{
+							Description: "any budget is used up, no new gpu device is started",
+							Default:     false,
+							Type:        []string{"boolean"},
+							Format:      "",
+						},
+					},
+					"stopAt": {
+						SchemaProps: spec.SchemaProps{
+							Description: "running gpu devices are stopped at, only set when budget is used up and server stops devices",
+							Ref:         ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+				},
+				Required: []string{"period", "from", "resetAt", "exhausted"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.GpuBudgetUsage"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceEvent(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"type": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Normal or Warning",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"reason": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"object": {
+						SchemaProps: spec.SchemaProps{
+							Description: "kind/name of object the event is about, e.g. Pod/openhydra-deploy-user1-xxx",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"message": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"count": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "int32",
+						},
+					},
+					"lastTimestamp": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceEventList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEvent"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEvent"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.Device"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.Device"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceQuota(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "DeviceQuota shows quota of user along with what is used and what is left",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceQuotaSpec"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceQuotaSpec"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceQuotaSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"openHydraUsername": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"hard": {
+						SchemaProps: spec.SchemaProps{
+							Description: "role quota of user merged with quota of user",
+							Default:     map[string]interface{}{},
+							Ref:         ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+					"used": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+					"remaining": {
+						SchemaProps: spec.SchemaProps{
+							Description: "resource without limit in hard is left out",
+							Default:     map[string]interface{}{},
+							Ref:         ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+					"groups": {
+						SchemaProps: spec.SchemaProps{
+							Description: "quota of groups user belongs to, used is the sum of all members",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.GroupQuotaUsage"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"hard", "used", "remaining"},
+			},
+		},
+		Dependencies: []string{
+			"open-hydra/pkg/apis/open-hydra-api/device/core/v1.GroupQuotaUsage", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"deviceId": {
+						SchemaProps: spec.SchemaProps{
+							Description: "id of device among devices of the same user, empty means default device",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"deviceName": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceNamespace": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceType": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceIP": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceCpu": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceRam": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"deviceGpu": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "byte",
+						},
+					},
+					"deviceStatus": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"gpuDriver": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"openHydraUsername": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"openHydraProjectId": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"role": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "int32",
+						},
+					},
+					"chineseName": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"lineNo": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"usePublicDataSet": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"boolean"},
+							Format: "",
+						},
+					},
+					"sandboxURLs": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"sandboxName": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"affinity": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/api/core/v1.Affinity"),
+						},
+					},
+					"queuePriority": {
+						SchemaProps: spec.SchemaProps{
+							Description: "higher priority is served first in gpu queue, only teacher can set it",
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+					"estimatedWaitSeconds": {
+						SchemaProps: spec.SchemaProps{
+							Description: "estimated seconds until queued device gets gpu, -1 when device asks for more gpu than cluster has LineNo tells position of device in queue",
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"gpuProfile": {
+						SchemaProps: spec.SchemaProps{
+							Description: "name of gpu profile in server config, gpu driver is taken from profile when set",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"gpuModel": {
+						SchemaProps: spec.SchemaProps{
+							Description: "model of gpu on node device is scheduled to, taken from node label",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/api/core/v1.Affinity"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_DeviceStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "DeviceStatus defines the observed state of Device of cluster",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"reason": {
+						SchemaProps: spec.SchemaProps{
+							Description: "short reason why device is not running, e.g. Unschedulable or ImagePullBackOff",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"message": {
+						SchemaProps: spec.SchemaProps{
+							Description: "human readable detail of reason",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"conditions": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("k8s.io/api/core/v1.PodCondition"),
+									},
+								},
+							},
+						},
+					},
+					"initContainerStatuses": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("k8s.io/api/core/v1.ContainerStatus"),
+									},
+								},
+							},
+						},
+					},
+					"containerStatuses": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("k8s.io/api/core/v1.ContainerStatus"),
+									},
+								},
+							},
+						},
+					},
+					"events": {
+						SchemaProps: spec.SchemaProps{
+							Description: "recent events of device, only filled when getting a single device",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEvent"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/api/core/v1.ContainerStatus", "k8s.io/api/core/v1.PodCondition", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.DeviceEvent"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_GpuBudgetUsage(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"scope": {
+						SchemaProps: spec.SchemaProps{
+							Description: "user or group",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"gpuHours": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+					"used": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+					"remaining": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+				},
+				Required: []string{"scope", "name", "gpuHours", "used", "remaining"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_GroupQuotaUsage(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"hard": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+					"used": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+					"remaining": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"),
+						},
+					},
+				},
+				Required: []string{"name", "hard", "used", "remaining"},
+			},
+		},
+		Dependencies: []string{
+			"open-hydra/pkg/apis/open-hydra-api/device/core/v1.QuotaResources"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_QuotaResources(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"devices": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "int64",
+						},
+					},
+					"cpu": {
+						SchemaProps: spec.SchemaProps{
+							Description: "cpu limit of running devices",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"memory": {
+						SchemaProps: spec.SchemaProps{
+							Description: "memory limit of running devices",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"gpu": {
+						SchemaProps: spec.SchemaProps{
+							Description: "gpu resource key -> gpu of running and queued devices",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: 0,
+										Type:    []string{"integer"},
+										Format:  "int64",
+									},
+								},
+							},
+						},
+					},
+					"gpuModels": {
+						SchemaProps: spec.SchemaProps{
+							Description: "gpu model -> gpu of running and queued devices asking for that model",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: 0,
+										Type:    []string{"integer"},
+										Format:  "int64",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageRecord(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "UsageRecord is an entry of usage ledger, written when device starts, stops or is resized",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecordSpec"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecordSpec"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageRecordList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecord"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageRecord"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageRecordSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"event": {
+						SchemaProps: spec.SchemaProps{
+							Description: "start, stop or resize",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"time": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"openHydraUsername": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"deviceId": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"sandboxName": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"podName": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"cpuMilli": {
+						SchemaProps: spec.SchemaProps{
+							Description: "cpu limit of device from then on, stop keeps the amounts device held before",
+							Default:     0,
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"memoryBytes": {
+						SchemaProps: spec.SchemaProps{
+							Description: "memory limit of device from then on",
+							Default:     0,
+							Type:        []string{"integer"},
+							Format:      "int64",
+						},
+					},
+					"gpu": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+					"gpuDriver": {
+						SchemaProps: spec.SchemaProps{
+							Description: "gpu resource key",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"gpuModel": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+				},
+				Required: []string{"event", "time", "openHydraUsername", "cpuMilli", "memoryBytes", "gpu"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageReport(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "UsageReport sums up usage ledger in a time range",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportSpec"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportSpec"},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageReportItem(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"key": {
+						SchemaProps: spec.SchemaProps{
+							Description: "username, group name, sandbox name or gpu type usage is summed up by gpu type is gpu model when device asks for one otherwise gpu resource key, cpu devices are summed up as cpu",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"cpuCoreHours": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+					"memoryGiBHours": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+					"gpuHours": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"number"},
+							Format:  "double",
+						},
+					},
+					"devices": {
+						SchemaProps: spec.SchemaProps{
+							Description: "number of devices used in time range",
+							Default:     0,
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+				},
+				Required: []string{"key", "cpuCoreHours", "memoryGiBHours", "gpuHours", "devices"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_device_core_v1_UsageReportSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"from": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"to": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("k8s.io/apimachinery/pkg/apis/meta/v1.Time"),
+						},
+					},
+					"groupBy": {
+						SchemaProps: spec.SchemaProps{
+							Description: "user, group, sandbox or gpu",
+							Default:     "",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportItem"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"from", "to", "groupBy", "items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.Time", "open-hydra/pkg/apis/open-hydra-api/device/core/v1.UsageReportItem"},
+	}
+}
+
+func schema_open_hydra_api_setting_core_v1_Setting(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "Setting is the Schema for the Dataset API",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingStatus"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingStatus"},
+	}
+}
+
+func schema_open_hydra_api_setting_core_v1_SettingList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/setting/core/v1.SettingSpec"},
+	}
+}
+
+func schema_open_hydra_api_setting_core_v1_SettingSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"default_gpu_per_device": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "byte",
+						},
+					},
+					"plugin_list": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/open-hydra/apis.PluginList"),
+						},
+					},
+					"exam_mode": {
+						SchemaProps: spec.SchemaProps{
+							Description: "exam mode blocks all egress of devices, left unchanged when not set",
+							Type:        []string{"boolean"},
+							Format:      "",
+						},
+					},
+				},
+				Required: []string{"default_gpu_per_device"},
+			},
+		},
+		Dependencies: []string{
+			"open-hydra/pkg/open-hydra/apis.PluginList"},
+	}
+}
+
+func schema_open_hydra_api_setting_core_v1_SettingStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "SettingStatus defines the observed state of Device of cluster",
+				Type:        []string{"object"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_GpuModelSumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"nodes": {
+						SchemaProps: spec.SchemaProps{
+							Description: "number of nodes with gpu of this model",
+							Default:     0,
+							Type:        []string{"integer"},
+							Format:      "int32",
+						},
+					},
+					"gpuResourceSumUp": {
+						SchemaProps: spec.SchemaProps{
+							Description: "gpu resource key -> allocation of gpu of this model",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"nodes", "gpuResourceSumUp"},
+			},
+		},
+		Dependencies: []string{
+			"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_GpuProfileSumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"type": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"resourceKey": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"allocated": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+					"allocatable": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+				},
+				Required: []string{"type", "resourceKey", "allocated", "allocatable"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_GpuResourceSumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"allocated": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+					"allocatable": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+				},
+				Required: []string{"allocated", "allocatable"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_SumUp(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "SumUp is the Schema for the cluster summary API",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpSpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpStatus"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpSpec", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUpStatus"},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_SumUpList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+						},
+					},
+					"items": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUp"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"items"},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUp"},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_SumUpSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"podAllocatable": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int32",
+						},
+					},
+					"podAllocated": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int32",
+						},
+					},
+					"gpuAllocatable": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"gpuAllocated": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"defaultCpuPerDevice": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"defaultRamPerDevice": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"defaultGpuPerDevice": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "byte",
+						},
+					},
+					"totalLine": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int32",
+						},
+					},
+					"gpuResourceSumUp": {
+						SchemaProps: spec.SchemaProps{
+							Type: []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"),
+									},
+								},
+							},
+						},
+					},
+					"gpuProfileSumUp": {
+						SchemaProps: spec.SchemaProps{
+							Description: "profile name -> capacity of gpu profile",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuProfileSumUp"),
+									},
+								},
+							},
+						},
+					},
+					"gpuModelSumUp": {
+						SchemaProps: spec.SchemaProps{
+							Description: "gpu model found in node labels -> capacity of gpu of that model",
+							Type:        []string{"object"},
+							AdditionalProperties: &spec.SchemaOrBool{
+								Allows: true,
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuModelSumUp"),
+									},
+								},
+							},
+						},
+					},
+				},
+				Required: []string{"podAllocatable", "podAllocated", "gpuAllocatable", "gpuAllocated", "defaultCpuPerDevice", "defaultRamPerDevice", "defaultGpuPerDevice", "totalLine", "gpuResourceSumUp"},
+			},
+		},
+		Dependencies: []string{
+			"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuModelSumUp", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuProfileSumUp", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"},
+	}
+}
+
+func schema_open_hydra_api_summary_core_v1_SumUpStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "SumUpStatus defines the observed state of Device of cluster",
+				Type:        []string{"object"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_user_core_v1_OpenHydraUser(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "OpenHydraUser is the Schema for the OpenHydraUser API",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"apiVersion": {
+						SchemaProps: spec.SchemaProps{
+							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
+					"metadata": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+						},
+					},
+					"spec": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserSpec"),
+						},
+					},
+					"status": {
+						SchemaProps: spec.SchemaProps{
+							Default: map[string]interface{}{},
+							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserStatus"),
+						},
+					},
+				},
+			},
+		},
+		Dependencies: []string{
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserSpec", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserStatus"},
+	}
+}
+
+func schema_open_hydra_api_user_core_v1_OpenHydraUserList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"kind": {
+						SchemaProps: spec.SchemaProps{
+							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Type:        []string{"string"},
+							Format:      "",
+						},
 					},
 					"apiVersion": {
 						SchemaProps: spec.SchemaProps{
@@ -15969,7 +17556,7 @@ func schema_open_hydra_api_summary_core_v1_SumUpList(ref common.ReferenceCallbac
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
 										Default: map[string]interface{}{},
-										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUp"),
+										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUser"),
 									},
 								},
 							},
@@ -15980,292 +17567,420 @@ func schema_open_hydra_api_summary_core_v1_SumUpList(ref common.ReferenceCallbac
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/summary/core/v1.SumUp"},
+			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUser"},
 	}
 }
 
-func schema_open_hydra_api_summary_core_v1_SumUpSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_api_user_core_v1_OpenHydraUserSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"podAllocatable": {
+					"chineseName": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "int32",
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"podAllocated": {
+					"description": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "int32",
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"gpuAllocatable": {
+					"password": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"gpuAllocated": {
+					"email": {
 						SchemaProps: spec.SchemaProps{
-							Default: "",
-							Type:    []string{"string"},
-							Format:  "",
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"defaultCpuPerDevice": {
+					"role": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int32",
+						},
+					},
+				},
+				Required: []string{"password", "role"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_api_user_core_v1_OpenHydraUserStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "OpenHydraUserSpecUserStatus defines the observed state of Device of cluster",
+				Type:        []string{"object"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_pkg_open_hydra_apis_EmptyDir(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Type: []string{"object"},
+				Properties: map[string]spec.Schema{
+					"medium": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"defaultRamPerDevice": {
+					"size_limit": {
+						SchemaProps: spec.SchemaProps{
+							Default: 0,
+							Type:    []string{"integer"},
+							Format:  "int64",
+						},
+					},
+					"name": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"defaultGpuPerDevice": {
+				},
+				Required: []string{"medium", "size_limit", "name"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_pkg_open_hydra_apis_EnvFromSource(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EnvFromSource refers a secret or a config map, exactly one of them should be set",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"prefix": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "byte",
+							Description: "prepended to every key of secret or config map",
+							Type:        []string{"string"},
+							Format:      "",
 						},
 					},
-					"totalLine": {
+					"secret_name": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "int32",
+							Type:   []string{"string"},
+							Format: "",
 						},
 					},
-					"gpuResourceSumUp": {
+					"config_map_name": {
 						SchemaProps: spec.SchemaProps{
-							Type: []string{"object"},
-							AdditionalProperties: &spec.SchemaOrBool{
-								Allows: true,
-								Schema: &spec.Schema{
-									SchemaProps: spec.SchemaProps{
-										Default: map[string]interface{}{},
-										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"),
-									},
-								},
-							},
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"optional": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"boolean"},
+							Format: "",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_pkg_open_hydra_apis_EnvVar(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "EnvVar takes value from value, key of a secret or key of a config map, exactly one of them should be set",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"value": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"string"},
+							Format: "",
+						},
+					},
+					"secret_key_ref": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("open-hydra/pkg/open-hydra/apis.KeyRef"),
+						},
+					},
+					"config_map_key_ref": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("open-hydra/pkg/open-hydra/apis.KeyRef"),
 						},
 					},
 				},
-				Required: []string{"podAllocatable", "podAllocated", "gpuAllocatable", "gpuAllocated", "defaultCpuPerDevice", "defaultRamPerDevice", "defaultGpuPerDevice", "totalLine", "gpuResourceSumUp"},
+				Required: []string{"name"},
 			},
 		},
 		Dependencies: []string{
-			"open-hydra/pkg/apis/open-hydra-api/summary/core/v1.GpuResourceSumUp"},
-	}
-}
-
-func schema_open_hydra_api_summary_core_v1_SumUpStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
-	return common.OpenAPIDefinition{
-		Schema: spec.Schema{
-			SchemaProps: spec.SchemaProps{
-				Description: "SumUpStatus defines the observed state of Device of cluster",
-				Type:        []string{"object"},
-			},
-		},
+			"open-hydra/pkg/open-hydra/apis.KeyRef"},
 	}
 }
 
-func schema_open_hydra_api_user_core_v1_OpenHydraUser(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_pkg_open_hydra_apis_HealthCheck(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
-				Description: "OpenHydraUser is the Schema for the OpenHydraUser API",
+				Description: "HealthCheck becomes readiness probe of sandbox container and liveness probe as well when liveness is set zero seconds and threshold fall back to defaults of kubernetes",
 				Type:        []string{"object"},
 				Properties: map[string]spec.Schema{
-					"kind": {
+					"type": {
 						SchemaProps: spec.SchemaProps{
-							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
+							Description: "http or tcp",
+							Default:     "",
 							Type:        []string{"string"},
 							Format:      "",
 						},
 					},
-					"apiVersion": {
+					"path": {
 						SchemaProps: spec.SchemaProps{
-							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Description: "path of http check, {base-url} is replaced by base url sandbox serves port under e.g. /{base-url}/api/status",
 							Type:        []string{"string"},
 							Format:      "",
 						},
 					},
-					"metadata": {
+					"initial_delay_seconds": {
 						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta"),
+							Type:   []string{"integer"},
+							Format: "int32",
 						},
 					},
-					"spec": {
+					"period_seconds": {
 						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserSpec"),
+							Type:   []string{"integer"},
+							Format: "int32",
 						},
 					},
-					"status": {
+					"timeout_seconds": {
 						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserStatus"),
+							Type:   []string{"integer"},
+							Format: "int32",
+						},
+					},
+					"failure_threshold": {
+						SchemaProps: spec.SchemaProps{
+							Type:   []string{"integer"},
+							Format: "int32",
+						},
+					},
+					"liveness": {
+						SchemaProps: spec.SchemaProps{
+							Description: "restart sandbox container when check keeps failing",
+							Type:        []string{"boolean"},
+							Format:      "",
 						},
 					},
 				},
+				Required: []string{"type"},
 			},
 		},
-		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ObjectMeta", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserSpec", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUserStatus"},
 	}
 }
 
-func schema_open_hydra_api_user_core_v1_OpenHydraUserList(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_pkg_open_hydra_apis_HostPath(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"kind": {
+					"name": {
 						SchemaProps: spec.SchemaProps{
-							Description: "Kind is a string value representing the REST resource this object represents. Servers may infer this from the endpoint the client submits requests to. Cannot be updated. In CamelCase. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#types-kinds",
-							Type:        []string{"string"},
-							Format:      "",
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
 						},
 					},
-					"apiVersion": {
+					"path": {
 						SchemaProps: spec.SchemaProps{
-							Description: "APIVersion defines the versioned schema of this representation of an object. Servers should convert recognized schemas to the latest internal value, and may reject unrecognized values. More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#resources",
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"type": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+				},
+				Required: []string{"name", "path", "type"},
+			},
+		},
+	}
+}
+
+func schema_open_hydra_pkg_open_hydra_apis_InitContainer(ref common.ReferenceCallback) common.OpenAPIDefinition {
+	return common.OpenAPIDefinition{
+		Schema: spec.Schema{
+			SchemaProps: spec.SchemaProps{
+				Description: "InitContainer is a setup step of sandbox e.g. filling workspace with course material",
+				Type:        []string{"object"},
+				Properties: map[string]spec.Schema{
+					"name": {
+						SchemaProps: spec.SchemaProps{
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
+						},
+					},
+					"image": {
+						SchemaProps: spec.SchemaProps{
+							Description: "image of sandbox is used when empty",
 							Type:        []string{"string"},
 							Format:      "",
 						},
 					},
-					"metadata": {
+					"command": {
 						SchemaProps: spec.SchemaProps{
-							Default: map[string]interface{}{},
-							Ref:     ref("k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta"),
+							Description: "{username}, {project-id} and {device-id} are replaced in command and args",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: "",
+										Type:    []string{"string"},
+										Format:  "",
+									},
+								},
+							},
 						},
 					},
-					"items": {
+					"args": {
 						SchemaProps: spec.SchemaProps{
 							Type: []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: "",
+										Type:    []string{"string"},
+										Format:  "",
+									},
+								},
+							},
+						},
+					},
+					"env": {
+						SchemaProps: spec.SchemaProps{
+							Description: "added after env of sandbox",
+							Type:        []string{"array"},
 							Items: &spec.SchemaOrArray{
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
 										Default: map[string]interface{}{},
-										Ref:     ref("open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUser"),
+										Ref:     ref("open-hydra/pkg/open-hydra/apis.EnvVar"),
 									},
 								},
 							},
 						},
 					},
 				},
-				Required: []string{"items"},
+				Required: []string{"name"},
 			},
 		},
 		Dependencies: []string{
-			"k8s.io/apimachinery/pkg/apis/meta/v1.ListMeta", "open-hydra/pkg/apis/open-hydra-api/user/core/v1.OpenHydraUser"},
+			"open-hydra/pkg/open-hydra/apis.EnvVar"},
 	}
 }
 
-func schema_open_hydra_api_user_core_v1_OpenHydraUserSpec(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_pkg_open_hydra_apis_KeyRef(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"chineseName": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"description": {
-						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
-						},
-					},
-					"password": {
+					"name": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"email": {
+					"key": {
 						SchemaProps: spec.SchemaProps{
-							Type:   []string{"string"},
-							Format: "",
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
 						},
 					},
-					"role": {
+					"optional": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "int32",
+							Description: "container still starts when secret, config map or key is missing",
+							Type:        []string{"boolean"},
+							Format:      "",
 						},
 					},
 				},
-				Required: []string{"password", "role"},
-			},
-		},
-	}
-}
-
-func schema_open_hydra_api_user_core_v1_OpenHydraUserStatus(ref common.ReferenceCallback) common.OpenAPIDefinition {
-	return common.OpenAPIDefinition{
-		Schema: spec.Schema{
-			SchemaProps: spec.SchemaProps{
-				Description: "OpenHydraUserSpecUserStatus defines the observed state of Device of cluster",
-				Type:        []string{"object"},
+				Required: []string{"name", "key"},
 			},
 		},
 	}
 }
 
-func schema_open_hydra_pkg_open_hydra_apis_EmptyDir(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_pkg_open_hydra_apis_NFS(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
 				Type: []string{"object"},
 				Properties: map[string]spec.Schema{
-					"medium": {
+					"name": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"size_limit": {
+					"server": {
 						SchemaProps: spec.SchemaProps{
-							Default: 0,
-							Type:    []string{"integer"},
-							Format:  "int64",
+							Default: "",
+							Type:    []string{"string"},
+							Format:  "",
 						},
 					},
-					"name": {
+					"path": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
+					"read_only": {
+						SchemaProps: spec.SchemaProps{
+							Default: false,
+							Type:    []string{"boolean"},
+							Format:  "",
+						},
+					},
 				},
-				Required: []string{"medium", "size_limit", "name"},
+				Required: []string{"name", "server", "path", "read_only"},
 			},
 		},
 	}
 }
 
-func schema_open_hydra_pkg_open_hydra_apis_HostPath(ref common.ReferenceCallback) common.OpenAPIDefinition {
+func schema_open_hydra_pkg_open_hydra_apis_PersistentVolumeClaim(ref common.ReferenceCallback) common.OpenAPIDefinition {
 	return common.OpenAPIDefinition{
 		Schema: spec.Schema{
 			SchemaProps: spec.SchemaProps{
@@ -16278,22 +17993,22 @@ func schema_open_hydra_pkg_open_hydra_apis_HostPath(ref common.ReferenceCallback
 							Format:  "",
 						},
 					},
-					"path": {
+					"claim_name": {
 						SchemaProps: spec.SchemaProps{
 							Default: "",
 							Type:    []string{"string"},
 							Format:  "",
 						},
 					},
-					"type": {
+					"read_only": {
 						SchemaProps: spec.SchemaProps{
-							Default: "",
-							Type:    []string{"string"},
+							Default: false,
+							Type:    []string{"boolean"},
 							Format:  "",
 						},
 					},
 				},
-				Required: []string{"name", "path", "type"},
+				Required: []string{"name", "claim_name", "read_only"},
 			},
 		},
 	}
@@ -16428,9 +18143,8 @@ func schema_open_hydra_pkg_open_hydra_apis_Sandbox(ref common.ReferenceCallback)
 							Items: &spec.SchemaOrArray{
 								Schema: &spec.Schema{
 									SchemaProps: spec.SchemaProps{
-										Default: 0,
-										Type:    []string{"integer"},
-										Format:  "int32",
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/open-hydra/apis.SandboxPort"),
 									},
 								},
 							},
@@ -16468,11 +18182,53 @@ func schema_open_hydra_pkg_open_hydra_apis_Sandbox(ref common.ReferenceCallback)
 							Format: "",
 						},
 					},
+					"env": {
+						SchemaProps: spec.SchemaProps{
+							Description: "env of sandbox container, {username}, {project-id} and {device-id} in value are replaced, referred names only take {username}",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/open-hydra/apis.EnvVar"),
+									},
+								},
+							},
+						},
+					},
+					"env_from": {
+						SchemaProps: spec.SchemaProps{
+							Description: "secrets and config maps in open-hydra namespace whose keys all become env of sandbox container, names only take {username}",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/open-hydra/apis.EnvFromSource"),
+									},
+								},
+							},
+						},
+					},
+					"init_containers": {
+						SchemaProps: spec.SchemaProps{
+							Description: "run one by one before sandbox container starts, they share env and volume mounts of sandbox",
+							Type:        []string{"array"},
+							Items: &spec.SchemaOrArray{
+								Schema: &spec.Schema{
+									SchemaProps: spec.SchemaProps{
+										Default: map[string]interface{}{},
+										Ref:     ref("open-hydra/pkg/open-hydra/apis.InitContainer"),
+									},
+								},
+							},
+						},
+					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"open-hydra/pkg/open-hydra/apis.Volume", "open-hydra/pkg/open-hydra/apis.VolumeMount"},
+			"open-hydra/pkg/open-hydra/apis.EnvFromSource", "open-hydra/pkg/open-hydra/apis.EnvVar", "open-hydra/pkg/open-hydra/apis.InitContainer", "open-hydra/pkg/open-hydra/apis.SandboxPort", "open-hydra/pkg/open-hydra/apis.Volume", "open-hydra/pkg/open-hydra/apis.VolumeMount"},
 	}
 }
 
@@ -16492,11 +18248,22 @@ func schema_open_hydra_pkg_open_hydra_apis_Volume(ref common.ReferenceCallback)
 							Ref: ref("open-hydra/pkg/open-hydra/apis.HostPath"),
 						},
 					},
+					"persistent_volume_claim": {
+						SchemaProps: spec.SchemaProps{
+							Description: "claim must be in the namespace of device",
+							Ref:         ref("open-hydra/pkg/open-hydra/apis.PersistentVolumeClaim"),
+						},
+					},
+					"nfs": {
+						SchemaProps: spec.SchemaProps{
+							Ref: ref("open-hydra/pkg/open-hydra/apis.NFS"),
+						},
+					},
 				},
 			},
 		},
 		Dependencies: []string{
-			"open-hydra/pkg/open-hydra/apis.EmptyDir", "open-hydra/pkg/open-hydra/apis.HostPath"},
+			"open-hydra/pkg/open-hydra/apis.EmptyDir", "open-hydra/pkg/open-hydra/apis.HostPath", "open-hydra/pkg/open-hydra/apis.NFS", "open-hydra/pkg/open-hydra/apis.PersistentVolumeClaim"},
 	}
 }
 
@@ -16534,6 +18301,13 @@ func schema_open_hydra_pkg_open_hydra_apis_VolumeMount(ref common.ReferenceCallb
 							Format:  "",
 						},
 					},
+					"sub_path": {
+						SchemaProps: spec.SchemaProps{
+							Description: "dir inside volume mounted instead of its root",
+							Type:        []string{"string"},
+							Format:      "",
+						},
+					},
 				},
 				Required: []string{"name", "mount_path", "source_path", "read_only"},
 			},
@@ -17,6 +17,10 @@ const (
 	SettingPath       = "settings"
 	CourseKind        = "Course"
 	CoursePath        = "courses"
+	DeviceBatchKind   = "DeviceBatch"
+	DeviceBatchPath   = "devicebatches"
+	UsageReportKind   = "UsageReport"
+	UsageReportPath   = "usagereports"
 )
 
 // we should register the api resource here
@@ -64,5 +68,19 @@ func ApiResources() []metaV1.APIResource {
 			Kind:         CourseKind,
 			Verbs:        metaV1.Verbs{"get", "list", "watch", "create", "update", "delete"},
 		},
+		{
+			Name:         DeviceBatchPath,
+			SingularName: "devbatch",
+			Namespaced:   false,
+			Kind:         DeviceBatchKind,
+			Verbs:        metaV1.Verbs{"get", "list", "create"},
+		},
+		{
+			Name:         UsageReportPath,
+			SingularName: "usagereport",
+			Namespaced:   false,
+			Kind:         UsageReportKind,
+			Verbs:        metaV1.Verbs{"get"},
+		},
 	}
 }
@@ -6,12 +6,17 @@ type VolumeMount struct {
 	MountPath  string `json:"mount_path"`
 	SourcePath string `json:"source_path"`
 	ReadOnly   bool   `json:"read_only"`
+	// dir inside volume mounted instead of its root
+	SubPath string `json:"sub_path,omitempty"`
 }
 
 // +k8s:openapi-gen=true
 type Volume struct {
 	EmptyDir *EmptyDir `json:"empty_dir,omitempty"`
 	HostPath *HostPath `json:"host_path,omitempty"`
+	// claim must be in the namespace of device
+	PersistentVolumeClaim *PersistentVolumeClaim `json:"persistent_volume_claim,omitempty"`
+	NFS                   *NFS                   `json:"nfs,omitempty"`
 }
 
 // +k8s:openapi-gen=true
@@ -28,6 +33,21 @@ type HostPath struct {
 	Type string `json:"type"`
 }
 
+// +k8s:openapi-gen=true
+type PersistentVolumeClaim struct {
+	Name      string `json:"name"`
+	ClaimName string `json:"claim_name"`
+	ReadOnly  bool   `json:"read_only"`
+}
+
+// +k8s:openapi-gen=true
+type NFS struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"read_only"`
+}
+
 type GpuSet struct {
 	GpuDriverName string `json:"gpu_driver_name"`
 	Gpu           uint8  `json:"gpu"`
@@ -47,11 +67,75 @@ type Sandbox struct {
 	VolumeMounts    []VolumeMount     `json:"volume_mounts,omitempty"`
 	Volumes         []Volume          `json:"volumes,omitempty"`
 	IconName        string            `json:"icon_name,omitempty"`
+	// env of sandbox container, {username}, {project-id} and {device-id} in value are replaced, referred names only take {username}
+	Env []EnvVar `json:"env,omitempty"`
+	// secrets and config maps in open-hydra namespace whose keys all become env of sandbox container, names only take {username}
+	EnvFrom []EnvFromSource `json:"env_from,omitempty"`
+	// run one by one before sandbox container starts, they share env and volume mounts of sandbox
+	InitContainers []InitContainer `json:"init_containers,omitempty"`
+}
+
+// EnvVar takes value from value, key of a secret or key of a config map, exactly one of them should be set
+// +k8s:openapi-gen=true
+type EnvVar struct {
+	Name            string  `json:"name"`
+	Value           string  `json:"value,omitempty"`
+	SecretKeyRef    *KeyRef `json:"secret_key_ref,omitempty"`
+	ConfigMapKeyRef *KeyRef `json:"config_map_key_ref,omitempty"`
+}
+
+// +k8s:openapi-gen=true
+type KeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// container still starts when secret, config map or key is missing
+	Optional bool `json:"optional,omitempty"`
+}
+
+// EnvFromSource refers a secret or a config map, exactly one of them should be set
+// +k8s:openapi-gen=true
+type EnvFromSource struct {
+	// prepended to every key of secret or config map
+	Prefix        string `json:"prefix,omitempty"`
+	SecretName    string `json:"secret_name,omitempty"`
+	ConfigMapName string `json:"config_map_name,omitempty"`
+	Optional      bool   `json:"optional,omitempty"`
+}
+
+// InitContainer is a setup step of sandbox e.g. filling workspace with course material
+// +k8s:openapi-gen=true
+type InitContainer struct {
+	Name string `json:"name"`
+	// image of sandbox is used when empty
+	Image string `json:"image,omitempty"`
+	// {username}, {project-id} and {device-id} are replaced in command and args
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// added after env of sandbox
+	Env []EnvVar `json:"env,omitempty"`
 }
 
 type SandboxPort struct {
 	Port uint16 `json:"port"`
 	Name string `json:"name"`
+	// device is only ready once port passes health check, at most one port of sandbox can set it
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+}
+
+// HealthCheck becomes readiness probe of sandbox container and liveness probe as well when liveness is set
+// zero seconds and threshold fall back to defaults of kubernetes
+// +k8s:openapi-gen=true
+type HealthCheck struct {
+	// http or tcp
+	Type string `json:"type"`
+	// path of http check, {base-url} is replaced by base url sandbox serves port under e.g. /{base-url}/api/status
+	Path                string `json:"path,omitempty"`
+	InitialDelaySeconds int32  `json:"initial_delay_seconds,omitempty"`
+	PeriodSeconds       int32  `json:"period_seconds,omitempty"`
+	TimeoutSeconds      int32  `json:"timeout_seconds,omitempty"`
+	FailureThreshold    int32  `json:"failure_threshold,omitempty"`
+	// restart sandbox container when check keeps failing
+	Liveness bool `json:"liveness,omitempty"`
 }
 
 // +k8s:openapi-gen=true
@@ -5,6 +5,7 @@ import (
 	stdErr "errors"
 	"fmt"
 	"log/slog"
+	"sort"
 	"strings"
 
 	"open-hydra/cmd/open-hydra-server/app/config"
@@ -15,13 +16,23 @@ import (
 	"open-hydra/pkg/util"
 
 	"github.com/emicklei/go-restful/v3"
+	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-const OpenhydraNamespace = "open-hydra"
+const (
+	OpenhydraNamespace  = "open-hydra"
+	DeviceStatusStopped = "Stopped"
+	// pod is running and sandbox passes the health check it declares, urls of device work from now on
+	// device of sandbox without health check stays running
+	DeviceStatusReady = "Ready"
+	// gpu device waits in gpu queue for free gpu
+	DeviceStatusQueued = "Queued"
+	// maximum events returned for a single device, older events are dropped
+	maxDeviceEvents = 20
+)
 
 type HttpErrMsg struct {
 	Error string `json:"errMsg"`
@@ -46,83 +57,312 @@ func writeAPIStatusError(response *restful.Response, err error) {
 }
 
 func combineDeviceList(pods []coreV1.Pod, services []coreV1.Service, users xUserV1.OpenHydraUserList, config *config.OpenHydraServerConfig) []xDeviceV1.Device {
-	podFlat := make(map[string]coreV1.Pod)
+	// user -> device id -> pod
+	podFlat := make(map[string]map[string]coreV1.Pod)
 
 	// now wo are going combine user and pod
 	// first we put all pod into a map with label app as key
+	// pod without device label is created before user can own multiple devices so it is the default device
 	for _, pod := range pods {
 		if _, found := pod.Labels[k8s.OpenHydraUserLabelKey]; !found {
 			continue
 		}
-		podFlat[pod.Labels[k8s.OpenHydraUserLabelKey]] = pod
+		username := pod.Labels[k8s.OpenHydraUserLabelKey]
+		if _, found := podFlat[username]; !found {
+			podFlat[username] = make(map[string]coreV1.Pod)
+		}
+		podFlat[username][k8s.DeviceId(pod.Labels[k8s.OpenHydraDeviceLabelKey])] = pod
 	}
 
-	serviceFlat := make(map[string]coreV1.Service)
+	serviceFlat := make(map[string]map[string]coreV1.Service)
 	for _, service := range services {
 		if _, found := service.Labels[k8s.OpenHydraUserLabelKey]; !found {
 			continue
 		}
-		serviceFlat[service.Labels[k8s.OpenHydraUserLabelKey]] = service
+		username := service.Labels[k8s.OpenHydraUserLabelKey]
+		if _, found := serviceFlat[username]; !found {
+			serviceFlat[username] = make(map[string]coreV1.Service)
+		}
+		serviceFlat[username][k8s.DeviceId(service.Labels[k8s.OpenHydraDeviceLabelKey])] = service
 	}
 
 	var result []xDeviceV1.Device
 
 	for _, user := range users.Items {
+		deviceIds := map[string]struct{}{}
+		for deviceId := range podFlat[user.Name] {
+			deviceIds[deviceId] = struct{}{}
+		}
+		for deviceId := range serviceFlat[user.Name] {
+			deviceIds[deviceId] = struct{}{}
+		}
+		// user without any device still shows up with an empty default device
+		if len(deviceIds) == 0 {
+			deviceIds[k8s.OpenHydraDefaultDeviceId] = struct{}{}
+		}
+		sortedIds := make([]string, 0, len(deviceIds))
+		for deviceId := range deviceIds {
+			sortedIds = append(sortedIds, deviceId)
+		}
+		// default device goes first
+		sort.Slice(sortedIds, func(i, j int) bool {
+			if sortedIds[i] == k8s.OpenHydraDefaultDeviceId || sortedIds[j] == k8s.OpenHydraDefaultDeviceId {
+				return sortedIds[i] == k8s.OpenHydraDefaultDeviceId
+			}
+			return sortedIds[i] < sortedIds[j]
+		})
 
-		device := xDeviceV1.Device{}
-		util.FillKindAndApiVersion(&device.TypeMeta, "Device")
-		device.Name = user.Name
-		device.Namespace = user.Namespace
-		device.Spec.Role = user.Spec.Role
-		device.Spec.ChineseName = user.Spec.ChineseName
-		if _, found := podFlat[user.Name]; found {
-			// only fill up device if we found a pod
-			device.Labels = podFlat[user.Name].Labels
-			device.Spec.DeviceCpu = podFlat[user.Name].Spec.Containers[0].Resources.Limits.Cpu().String()
-			device.Spec.DeviceRam = podFlat[user.Name].Spec.Containers[0].Resources.Limits.Memory().String()
-			device.Spec.DeviceIP = podFlat[user.Name].Status.PodIP
-			device.Spec.DeviceName = podFlat[user.Name].Name
-			device.Spec.DeviceNamespace = podFlat[user.Name].Namespace
-			if _, foundGpuDriver := podFlat[user.Name].Spec.Containers[0].Resources.Requests[coreV1.ResourceName(config.DefaultGpuDriver)]; foundGpuDriver {
-				device.Spec.DeviceType = "gpu"
-				device.Spec.GpuDriver = config.DefaultGpuDriver
-				device.Spec.DeviceGpu = uint8(podFlat[user.Name].Spec.Containers[0].Resources.Requests.Name(coreV1.ResourceName(config.DefaultGpuDriver), resource.DecimalSI).Value())
-			} else {
-				device.Spec.DeviceType = "cpu"
+		for _, deviceId := range sortedIds {
+			device := xDeviceV1.Device{}
+			util.FillKindAndApiVersion(&device.TypeMeta, "Device")
+			device.Name = user.Name
+			device.Namespace = user.Namespace
+			device.Spec.DeviceId = deviceId
+			device.Spec.Role = user.Spec.Role
+			device.Spec.ChineseName = user.Spec.ChineseName
+			if pod, found := podFlat[user.Name][deviceId]; found {
+				// only fill up device if we found a pod
+				device.Labels = pod.Labels
+				device.Spec.DeviceCpu = pod.Spec.Containers[0].Resources.Limits.Cpu().String()
+				device.Spec.DeviceRam = pod.Spec.Containers[0].Resources.Limits.Memory().String()
+				device.Spec.DeviceIP = pod.Status.PodIP
+				device.Spec.DeviceName = pod.Name
+				device.Spec.DeviceNamespace = pod.Namespace
+				if gpuDriver, gpu := containersGpu(pod.Spec.Containers, config.GpuResourceKeys); gpu > 0 {
+					device.Spec.DeviceType = "gpu"
+					device.Spec.GpuDriver = gpuDriver
+					device.Spec.DeviceGpu = uint8(gpu)
+					device.Spec.GpuProfile = gpuProfileOfResourceKey(gpuDriver, config)
+					device.Spec.GpuModel = gpuModelOfAffinity(pod.Spec.Affinity, gpuModelLabelKey(gpuDriver, config))
+				} else {
+					device.Spec.DeviceType = "cpu"
+				}
+				device.Spec.OpenHydraUsername = user.Name
+				// device with a pod is not waiting in gpu queue
+				device.Spec.LineNo = "0"
+				device.CreationTimestamp = pod.CreationTimestamp
+				device.Spec.DeviceStatus = string(pod.Status.Phase)
+				if pod.Status.Phase == coreV1.PodRunning && podHealthChecked(pod) && podReady(pod) {
+					device.Spec.DeviceStatus = DeviceStatusReady
+				}
+				if pod.DeletionTimestamp != nil {
+					device.Spec.DeviceStatus = "Terminating"
+				}
+				device.Status = deviceStatusFromPod(pod)
+				if _, found := pod.Labels[k8s.OpenHydraSandboxKey]; found {
+					device.Spec.SandboxName = pod.Labels[k8s.OpenHydraSandboxKey]
+				}
 			}
-			device.Spec.OpenHydraUsername = user.Name
-			// Todo: add line no
-			device.Spec.LineNo = "0"
-			device.CreationTimestamp = podFlat[user.Name].CreationTimestamp
-			device.Spec.DeviceStatus = string(podFlat[user.Name].Status.Phase)
-			if podFlat[user.Name].DeletionTimestamp != nil {
-				device.Spec.DeviceStatus = "Terminating"
+
+			if service, found := serviceFlat[user.Name][deviceId]; found {
+				var portURLs []string
+				for _, port := range service.Spec.Ports {
+					if sandboxProxied(config) {
+						if portURL := sandboxProxyUrl(user.Name, deviceId, port.Name, config); portURL != "" {
+							portURLs = append(portURLs, portURL)
+						}
+						continue
+					}
+					portURLs = append(portURLs, combineUrl(config.ServerIP, user.Name, deviceId, port.Name, port.NodePort, config.EnableJupyterLabBaseURL, config))
+				}
+				device.Spec.SandboxURLs = strings.Join(portURLs, ",")
 			}
-			if _, found := podFlat[user.Name].Labels[k8s.OpenHydraSandboxKey]; found {
-				device.Spec.SandboxName = podFlat[user.Name].Labels[k8s.OpenHydraSandboxKey]
+			result = append(result, device)
+		}
+	}
+	return result
+}
+
+// fillStoppedDevices marks device as stopped or queued when user has no pod but a deployment scaled to zero
+// since there is no pod, device info is taken from pod template of deployment
+// stopped device is appended if no device entry of the same id is found for user
+func fillStoppedDevices(devices []xDeviceV1.Device, deployments []appsV1.Deployment, config *config.OpenHydraServerConfig) []xDeviceV1.Device {
+	for _, deploy := range deployments {
+		username, found := deploy.Labels[k8s.OpenHydraUserLabelKey]
+		if !found {
+			continue
+		}
+		if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != 0 || len(deploy.Spec.Template.Spec.Containers) == 0 {
+			continue
+		}
+		deviceId := k8s.DeviceId(deploy.Labels[k8s.OpenHydraDeviceLabelKey])
+
+		// prefer entry of the same device id, otherwise take over the empty entry of user without any device
+		var device, placeholder *xDeviceV1.Device
+		userFound := false
+		for index := range devices {
+			if devices[index].Name != username {
+				continue
+			}
+			userFound = true
+			if devices[index].Spec.DeviceId == deviceId {
+				device = &devices[index]
+				break
 			}
+			if devices[index].Spec.DeviceStatus == "" && devices[index].Spec.SandboxURLs == "" {
+				placeholder = &devices[index]
+			}
+		}
+		if device == nil {
+			device = placeholder
+		}
+		if device != nil && device.Spec.DeviceStatus != "" {
+			// pod found, status already filled
+			continue
+		}
+		if !userFound {
+			// user is filtered out or not found
+			continue
+		}
+		if device == nil {
+			devices = append(devices, xDeviceV1.Device{})
+			device = &devices[len(devices)-1]
+			util.FillKindAndApiVersion(&device.TypeMeta, "Device")
+			device.Name = username
 		}
 
-		if _, found := serviceFlat[user.Name]; found {
-			var portURLs []string
-			for _, port := range serviceFlat[user.Name].Spec.Ports {
-				portURLs = append(portURLs, combineUrl(config.ServerIP, user.Name, port.Name, port.NodePort, config.EnableJupyterLabBaseURL, config))
+		container := deploy.Spec.Template.Spec.Containers[0]
+		device.Labels = deploy.Spec.Template.Labels
+		device.Spec.DeviceId = deviceId
+		device.Spec.DeviceCpu = container.Resources.Limits.Cpu().String()
+		device.Spec.DeviceRam = container.Resources.Limits.Memory().String()
+		if gpuDriver, gpu := containersGpu(deploy.Spec.Template.Spec.Containers, config.GpuResourceKeys); gpu > 0 {
+			device.Spec.DeviceType = "gpu"
+			device.Spec.GpuDriver = gpuDriver
+			device.Spec.DeviceGpu = uint8(gpu)
+			device.Spec.GpuProfile = gpuProfileOfResourceKey(gpuDriver, config)
+			device.Spec.GpuModel = gpuModelOfAffinity(deploy.Spec.Template.Spec.Affinity, gpuModelLabelKey(gpuDriver, config))
+		} else {
+			device.Spec.DeviceType = "cpu"
+		}
+		device.Spec.OpenHydraUsername = username
+		device.Spec.LineNo = "0"
+		device.CreationTimestamp = deploy.CreationTimestamp
+		device.Spec.DeviceStatus = DeviceStatusStopped
+		if k8s.IsDeploymentQueued(deploy) {
+			device.Spec.DeviceStatus = DeviceStatusQueued
+		}
+		device.Spec.SandboxName = deploy.Labels[k8s.OpenHydraSandboxKey]
+	}
+	return devices
+}
+
+// deviceStatusFromPod explains why pod is not running, reason is taken in order of
+// pod level reason such as Evicted, scheduling failure, init container and container waiting or failure
+func deviceStatusFromPod(pod coreV1.Pod) xDeviceV1.DeviceStatus {
+	status := xDeviceV1.DeviceStatus{
+		Reason:                pod.Status.Reason,
+		Message:               pod.Status.Message,
+		Conditions:            pod.Status.Conditions,
+		InitContainerStatuses: pod.Status.InitContainerStatuses,
+		ContainerStatuses:     pod.Status.ContainerStatuses,
+	}
+	if status.Reason != "" {
+		return status
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == coreV1.PodScheduled && condition.Status == coreV1.ConditionFalse {
+			status.Reason = condition.Reason
+			status.Message = condition.Message
+			return status
+		}
+	}
+
+	for _, containerStatuses := range [][]coreV1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses} {
+		for _, containerStatus := range containerStatuses {
+			if containerStatus.State.Waiting != nil && containerStatus.State.Waiting.Reason != "" {
+				status.Reason = containerStatus.State.Waiting.Reason
+				status.Message = containerStatus.State.Waiting.Message
+				return status
+			}
+			if containerStatus.State.Terminated != nil && containerStatus.State.Terminated.ExitCode != 0 {
+				status.Reason = containerStatus.State.Terminated.Reason
+				status.Message = fmt.Sprintf("container %s exited with code %d", containerStatus.Name, containerStatus.State.Terminated.ExitCode)
+				return status
 			}
-			device.Spec.SandboxURLs = strings.Join(portURLs, ",")
 		}
-		result = append(result, device)
+	}
+
+	// running sandbox which does not pass readiness probe yet
+	if pod.Status.Phase == coreV1.PodRunning {
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == coreV1.PodReady && condition.Status == coreV1.ConditionFalse {
+				status.Reason = condition.Reason
+				status.Message = condition.Message
+				return status
+			}
+		}
+	}
+	return status
+}
+
+// podHealthChecked reports whether sandbox of pod declares a health check, pod without readiness probe is ready once it starts
+// which does not tell sandbox serves its urls
+func podHealthChecked(pod coreV1.Pod) bool {
+	for _, container := range pod.Spec.Containers {
+		if container.ReadinessProbe != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// podReady reports whether ready condition of pod is true
+func podReady(pod coreV1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == coreV1.PodReady {
+			return condition.Status == coreV1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// combineDeviceEvents converts k8s events into device events ordered from oldest to newest
+// only the latest maxDeviceEvents are kept
+func combineDeviceEvents(events []coreV1.Event) []xDeviceV1.DeviceEvent {
+	result := make([]xDeviceV1.DeviceEvent, 0, len(events))
+	for _, event := range events {
+		result = append(result, xDeviceV1.DeviceEvent{
+			Type:          event.Type,
+			Reason:        event.Reason,
+			Object:        fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+			Message:       event.Message,
+			Count:         event.Count,
+			LastTimestamp: eventTime(event),
+		})
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].LastTimestamp.Before(&result[j].LastTimestamp)
+	})
+	if len(result) > maxDeviceEvents {
+		result = result[len(result)-maxDeviceEvents:]
 	}
 	return result
 }
 
-func combineUrl(serverAddress, username, portName string, port int32, enableJupyterLabBaseURL bool, config *config.OpenHydraServerConfig) string {
+// eventTime returns last time event is seen, events reported by events.k8s.io api only set event time
+func eventTime(event coreV1.Event) metav1.Time {
+	if !event.LastTimestamp.IsZero() {
+		return event.LastTimestamp
+	}
+	if !event.EventTime.IsZero() {
+		return metav1.NewTime(event.EventTime.Time)
+	}
+	return event.CreationTimestamp
+}
+
+func combineUrl(serverAddress, username, deviceId, portName string, port int32, enableJupyterLabBaseURL bool, config *config.OpenHydraServerConfig) string {
+	if sandboxRouted(config) {
+		return sandboxRouteUrl(username, deviceId, portName, config)
+	}
 	addressSet := strings.Split(serverAddress, ",")
 	if len(addressSet) <= 1 {
 		if enableJupyterLabBaseURL {
 			if _, ok := config.ApplyPortNameForIngress[portName]; ok {
-				return fmt.Sprintf("http://%s:%d/%s/%s", serverAddress, config.IngressPort, fmt.Sprintf("%s-%s", username, portName), config.ApplyPortNameForIngress[portName])
+				return fmt.Sprintf("http://%s:%d/%s/%s", serverAddress, config.IngressPort, k8s.DeviceRouteName(username, deviceId, portName), config.ApplyPortNameForIngress[portName])
 			} else {
-				return fmt.Sprintf("http://%s:%d/%s", serverAddress, config.IngressPort, username)
+				return fmt.Sprintf("http://%s:%d/%s", serverAddress, config.IngressPort, k8s.DeviceBaseName(username, deviceId))
 			}
 		} else {
 			return fmt.Sprintf("http://%s:%d", serverAddress, port)
@@ -145,6 +385,10 @@ func ParseJsonToPluginList(jsonData string) (apis.PluginList, error) {
 }
 
 func preCreateUserDir(volumes []apis.Volume, username string, config *config.OpenHydraServerConfig) error {
+	err := checkDatasetVolume(config)
+	if err != nil {
+		return err
+	}
 	for index, volume := range volumes {
 		if volume.HostPath == nil {
 			continue
@@ -154,7 +398,7 @@ func preCreateUserDir(volumes []apis.Volume, username string, config *config.Ope
 			// only private dir needs to be create on pod booting
 			dirToCreate = strings.Replace(dirToCreate, "{username}", username, -1)
 			dirToCreate = strings.Replace(dirToCreate, "{workspace}", config.WorkspacePath, -1)
-			err := util.CreateDirIfNotExists(dirToCreate)
+			err = util.CreateDirIfNotExists(dirToCreate)
 			if err != nil {
 				return err
 			}
@@ -168,14 +412,21 @@ func preCreateUserDir(volumes []apis.Volume, username string, config *config.Ope
 
 			continue
 		}
+		var basePath, exportPath string
 		if strings.Contains(volume.HostPath.Path, "{dataset-public}") {
 			dirToCreate = strings.Replace(dirToCreate, "{dataset-public}", config.PublicDatasetBasePath, -1)
+			basePath, exportPath = config.PublicDatasetBasePath, nfsConfig(config).PublicDatasetPath
 		}
 		if strings.Contains(volume.HostPath.Path, "{course-public}") {
 			dirToCreate = strings.Replace(dirToCreate, "{course-public}", config.PublicCourseBasePath, -1)
+			basePath, exportPath = config.PublicCourseBasePath, nfsConfig(config).PublicCoursePath
 		}
 
 		volumes[index].HostPath.Path = dirToCreate
+		if basePath != "" {
+			// public dataset and course may be served by nfs
+			volumes[index] = sharedVolume(*volumes[index].HostPath, basePath, exportPath, config)
+		}
 	}
 	return nil
 }
@@ -20,7 +20,7 @@ import (
 func (builder *OpenHydraRouteBuilder) AddCourseListRoute() {
 	path := "/" + CoursePath
 	builder.addPathAuthorization(path, http.MethodGet, 1)
-	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listCourse").To(builder.CourseListRouteHandler).
+	builder.RootWS.Route(builder.watchable(builder.RootWS.GET(path)).Operation("listCourse").To(builder.CourseListRouteHandler).
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
 		Returns(http.StatusUnauthorized, "unauthorized", "").
@@ -28,6 +28,11 @@ func (builder *OpenHydraRouteBuilder) AddCourseListRoute() {
 }
 
 func (builder *OpenHydraRouteBuilder) CourseListRouteHandler(request *restful.Request, response *restful.Response) {
+	if isWatch(request) {
+		builder.serveWatch(request, response, builder.watchHubs[CoursePath])
+		return
+	}
+
 	courseList, err := builder.Database.ListCourses()
 	if err != nil {
 		writeAPIStatusError(response, err)
@@ -35,6 +40,7 @@ func (builder *OpenHydraRouteBuilder) CourseListRouteHandler(request *restful.Re
 	}
 	courseList.Kind = "List"
 	courseList.APIVersion = "v1"
+	courseList.ResourceVersion = builder.watchHubs[CoursePath].sync(courseObjects(&courseList))
 	response.WriteEntity(courseList)
 }
 
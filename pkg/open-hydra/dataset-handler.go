@@ -34,7 +34,7 @@ import (
 func (builder *OpenHydraRouteBuilder) AddDatasetListRoute() {
 	path := "/" + DatasetPath
 	builder.addPathAuthorization(path, http.MethodGet, 1)
-	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listDataset").To(builder.DatasetListRouteHandler).
+	builder.RootWS.Route(builder.watchable(builder.RootWS.GET(path)).Operation("listDataset").To(builder.DatasetListRouteHandler).
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
 		Returns(http.StatusUnauthorized, "unauthorized", "").
@@ -42,6 +42,11 @@ func (builder *OpenHydraRouteBuilder) AddDatasetListRoute() {
 }
 
 func (builder *OpenHydraRouteBuilder) DatasetListRouteHandler(request *restful.Request, response *restful.Response) {
+	if isWatch(request) {
+		builder.serveWatch(request, response, builder.watchHubs[DatasetPath])
+		return
+	}
+
 	datasetList, err := builder.Database.ListDatasets()
 	if err != nil {
 		writeAPIStatusError(response, err)
@@ -49,6 +54,7 @@ func (builder *OpenHydraRouteBuilder) DatasetListRouteHandler(request *restful.R
 	}
 	datasetList.Kind = "List"
 	datasetList.APIVersion = "v1"
+	datasetList.ResourceVersion = builder.watchHubs[DatasetPath].sync(datasetObjects(&datasetList))
 	response.WriteEntity(datasetList)
 }
 
@@ -0,0 +1,56 @@
+package openhydra
+
+import (
+	"fmt"
+	"strings"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/apis"
+)
+
+const (
+	// dataset and course are mounted from dirs on node by host path
+	DatasetVolumeHostPath = "hostpath"
+	// dataset and course are mounted from nfs server given in nfs config
+	DatasetVolumeNFS = "nfs"
+)
+
+// checkDatasetVolume ensures nfs server is set when dataset and course are mounted by nfs
+func checkDatasetVolume(serverConfig *config.OpenHydraServerConfig) error {
+	if serverConfig.PublicDatasetVolumeType != DatasetVolumeNFS {
+		return nil
+	}
+	if serverConfig.NfsConfig == nil || serverConfig.NfsConfig.Server == "" {
+		return fmt.Errorf("nfs server is not set while dataset volume type is %s", DatasetVolumeNFS)
+	}
+	return nil
+}
+
+// nfsConfig returns nfs config or an empty one when not set
+func nfsConfig(serverConfig *config.OpenHydraServerConfig) config.NfsConfig {
+	if serverConfig.NfsConfig == nil {
+		return config.NfsConfig{}
+	}
+	return *serverConfig.NfsConfig
+}
+
+// sharedVolume mounts dir under basePath of open-hydra-server, by nfs when dataset volume type is nfs otherwise by host path
+// exportPath is where basePath is exported on nfs server, basePath itself is used when empty
+// nfs volume is read only since dataset and course are only changed through open-hydra-server
+func sharedVolume(hostPath apis.HostPath, basePath, exportPath string, serverConfig *config.OpenHydraServerConfig) apis.Volume {
+	if serverConfig.PublicDatasetVolumeType != DatasetVolumeNFS {
+		return apis.Volume{HostPath: &hostPath}
+	}
+	path := hostPath.Path
+	if exportPath != "" && (path == basePath || strings.HasPrefix(path, strings.TrimSuffix(basePath, "/")+"/")) {
+		path = exportPath + strings.TrimPrefix(path, basePath)
+	}
+	return apis.Volume{
+		NFS: &apis.NFS{
+			Name:     hostPath.Name,
+			Server:   nfsConfig(serverConfig).Server,
+			Path:     path,
+			ReadOnly: true,
+		},
+	}
+}
@@ -0,0 +1,315 @@
+package openhydra
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/option"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+	"open-hydra/pkg/util"
+
+	"github.com/emicklei/go-restful/v3"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// access link carries token in query, device proxy turns it into cookie on first use
+	deviceAccessQueryParameter = "openhydra-access"
+	deviceAccessCookie         = "openhydra-access"
+	// request attribute holding verified access token for device proxy
+	deviceAccessAttribute = "openhydra-device-access"
+	// reason of event recorded on device when teacher is given access
+	deviceAccessEventReason = "TeacherAccess"
+)
+
+// deviceAccessToken is signed into teacher access link, empty port opens every port of device
+type deviceAccessToken struct {
+	Operator string `json:"o"`
+	Username string `json:"u"`
+	DeviceId string `json:"d"`
+	Port     string `json:"p,omitempty"`
+	ReadOnly bool   `json:"r,omitempty"`
+	Expiry   int64  `json:"e"`
+}
+
+// allows tells whether token opens given port of device
+func (token *deviceAccessToken) allows(username, deviceId, portName string) bool {
+	return token.Username == username && token.DeviceId == k8s.DeviceId(deviceId) && (token.Port == "" || token.Port == portName)
+}
+
+// cookie keeps access link for pages of device opened by it, it is only sent to ports link opens
+func (token *deviceAccessToken) cookie(value string) *http.Cookie {
+	path := strings.TrimSuffix(sandboxProxyPath(token.Username, token.DeviceId, token.Port), "/") + "/"
+	return &http.Cookie{
+		Name:     deviceAccessCookie,
+		Value:    value,
+		Path:     path,
+		Expires:  time.Unix(token.Expiry, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// deviceAccessReadOnlyAllowed tells whether request may pass with read-only access, websocket is refused since it can run code
+func deviceAccessReadOnlyAllowed(request *http.Request) bool {
+	switch request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return request.Header.Get("Upgrade") == ""
+	}
+	return false
+}
+
+// deviceAccessOfRequest returns access token of link in query or cookie given when link is opened
+func deviceAccessOfRequest(request *http.Request) string {
+	if value := request.URL.Query().Get(deviceAccessQueryParameter); value != "" {
+		return value
+	}
+	if cookie, err := request.Cookie(deviceAccessCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+func (builder *OpenHydraRouteBuilder) signDeviceAccess(token *deviceAccessToken) (string, error) {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return fmt.Sprintf("%s.%s", payload, builder.signature(payload)), nil
+}
+
+func (builder *OpenHydraRouteBuilder) verifyDeviceAccess(value string, now time.Time) (*deviceAccessToken, error) {
+	payload, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(builder.signature(payload)), []byte(signature)) {
+		return nil, fmt.Errorf("access link is not valid")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("access link is not valid")
+	}
+	token := &deviceAccessToken{}
+	err = json.Unmarshal(data, token)
+	if err != nil {
+		return nil, fmt.Errorf("access link is not valid")
+	}
+	if now.Unix() >= token.Expiry {
+		return nil, fmt.Errorf("access link is expired")
+	}
+	return token, nil
+}
+
+// authenticateDeviceAccess lets user holding access link into the port of device it opens
+// link is only accepted on host of port so cookie it turns into is never sent to api or other sandboxes
+func (builder *OpenHydraRouteBuilder) authenticateDeviceAccess(r1 *restful.Request, r2 *restful.Response, value string) bool {
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(r2, http.StatusInternalServerError, fmt.Sprintf("Failed to get server config: %v", err))
+		return false
+	}
+	username, deviceId, portName := r1.PathParameter("username"), r1.PathParameter("deviceId"), r1.PathParameter("port")
+	if !onSandboxProxyHost(r1.Request, username, deviceId, portName, serverConfig) {
+		writeHttpResponseAndLogError(r2, http.StatusUnauthorized, fmt.Sprintf("access link is only accepted on host of device for path: %s", r1.Request.URL.Path))
+		return false
+	}
+	if origin := r1.Request.Header.Get("Origin"); origin != "" {
+		// page of other sandbox must not drive this one with cookie of browser
+		if originURL, err := url.Parse(origin); err != nil || !strings.EqualFold(originURL.Host, r1.Request.Host) {
+			writeHttpResponseAndLogError(r2, http.StatusForbidden, fmt.Sprintf("access link is not accepted from origin: %s", origin))
+			return false
+		}
+	}
+	token, err := builder.verifyDeviceAccess(value, time.Now())
+	if err != nil {
+		writeHttpResponseAndLogError(r2, http.StatusUnauthorized, err.Error())
+		return false
+	}
+	if !token.allows(username, deviceId, portName) {
+		writeHttpResponseAndLogError(r2, http.StatusForbidden, fmt.Sprintf("access link of user: %s does not open path: %s", token.Operator, r1.Request.URL.Path))
+		return false
+	}
+	user, err := builder.Database.GetUser(token.Operator)
+	if err != nil {
+		slog.Error("Failed to get user of access link", "user", token.Operator, "error", err)
+		writeHttpResponseAndLogError(r2, http.StatusUnauthorized, "user of access link is not found")
+		return false
+	}
+	r1.SetAttribute(deviceAccessAttribute, token)
+	return builder.authorizeUser(r1, r2, user)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceAccessRoute() {
+	// user opens own device by access link, only teacher can open device of other user
+	path := "/" + DevicePath + "/{username}/access"
+	builder.addPathAuthorization(path, http.MethodPost, 3)
+	builder.RootWS.Route(builder.RootWS.POST(path).Operation("createDeviceAccess").To(builder.DeviceAccessRouteHandler).
+		Doc("issue a short-lived link opening device through device proxy on host of device, link to device of other user is recorded as event of device").
+		Reads(xDeviceV1.DeviceAccess{}).
+		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceAccess{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceAccessRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	access := xDeviceV1.DeviceAccess{}
+	err = request.ReadEntity(&access)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("Failed to read request entity: %v", err))
+		return
+	}
+
+	if !sandboxProxied(serverConfig) {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("access link needs sandbox exposure mode %s", SandboxExposureProxy))
+		return
+	}
+	if serverConfig.SandboxProxyDomain == "" {
+		// without own origin sandbox could reuse cookie of link for other devices, read-only would not hold either
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "access link needs sandbox proxy domain so device is served on its own host")
+		return
+	}
+
+	username := request.PathParameter("username")
+	operator := request.HeaderParameter(openHydraHeaderUser)
+	if request.HeaderParameter(openHydraHeaderRole) != "1" && operator != username {
+		writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to open device for user: %s", operator, username))
+		return
+	}
+	owner := operator == username
+
+	defaultTTL, maximumTTL := int64(serverConfig.DeviceAccessTTLSeconds), int64(serverConfig.MaximumDeviceAccessTTLSeconds)
+	if owner {
+		// owner is given a link for the whole session
+		defaultTTL, maximumTTL = int64(serverConfig.SessionTTLSeconds), int64(serverConfig.SessionTTLSeconds)
+	}
+	ttl := access.Spec.TTLSeconds
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if ttl <= 0 || ttl > maximumTTL {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("ttl of access link must be between 1 and %d seconds", maximumTTL))
+		return
+	}
+
+	deviceId := k8s.DeviceId(access.Spec.DeviceId)
+	deploy, err := builder.k8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to get device for user %s: %v", username, err))
+		return
+	}
+	if len(deploy) == 0 {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId)))
+		return
+	}
+	service, err := builder.k8sHelper.GetUserService(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId)))
+		return
+	}
+	var ports []string
+	for _, port := range service.Spec.Ports {
+		if access.Spec.Port == "" || access.Spec.Port == port.Name {
+			ports = append(ports, port.Name)
+		}
+	}
+	if len(ports) == 0 {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("port %s is not found in device %s of user %s", access.Spec.Port, deviceId, username))
+		return
+	}
+
+	now := time.Now()
+	token := &deviceAccessToken{
+		Operator: operator,
+		Username: username,
+		DeviceId: deviceId,
+		Port:     access.Spec.Port,
+		ReadOnly: access.Spec.ReadOnly,
+		Expiry:   now.Add(time.Duration(ttl) * time.Second).Unix(),
+	}
+	value, err := builder.signDeviceAccess(token)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to sign access link: %v", err))
+		return
+	}
+
+	// student is told about every link of teacher, link is not given out when that fails
+	if !owner {
+		err = builder.k8sHelper.CreateEvent(deviceAccessEvent(deploy[0], token, now), builder.kubeClient)
+		if err != nil {
+			writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to record access of device %s for user %s: %v", deviceId, username, err))
+			return
+		}
+	}
+	slog.Info("audit: device access link issued", "operator", token.Operator, "username", username, "deviceId", deviceId, "port", access.Spec.Port, "readOnly", token.ReadOnly, "ttlSeconds", ttl)
+
+	var urls []string
+	for _, port := range ports {
+		urls = append(urls, fmt.Sprintf("%s?%s=%s", sandboxProxyUrl(username, deviceId, port, serverConfig), deviceAccessQueryParameter, url.QueryEscape(value)))
+	}
+	expiresAt := metaV1.NewTime(time.Unix(token.Expiry, 0))
+	access.Spec.OpenHydraUsername = username
+	access.Spec.DeviceId = deviceId
+	access.Spec.TTLSeconds = ttl
+	access.Spec.Operator = token.Operator
+	access.Spec.ExpiresAt = &expiresAt
+	access.Spec.URLs = strings.Join(urls, ",")
+	util.FillKindAndApiVersion(&access.TypeMeta, "DeviceAccess")
+	response.WriteEntity(&access)
+}
+
+// deviceAccessEvent records teacher access on deployment of device so it shows up in device events of student
+func deviceAccessEvent(deploy appsV1.Deployment, token *deviceAccessToken, now time.Time) *coreV1.Event {
+	mode := "full"
+	if token.ReadOnly {
+		mode = "read-only"
+	}
+	target := "every port"
+	if token.Port != "" {
+		target = fmt.Sprintf("port %s", token.Port)
+	}
+	return &coreV1.Event{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", deploy.Name, now.UnixNano()),
+			Namespace: OpenhydraNamespace,
+		},
+		InvolvedObject: coreV1.ObjectReference{
+			Kind:       "Deployment",
+			APIVersion: appsV1.SchemeGroupVersion.String(),
+			Name:       deploy.Name,
+			Namespace:  OpenhydraNamespace,
+			UID:        deploy.UID,
+		},
+		Reason:         deviceAccessEventReason,
+		Message:        fmt.Sprintf("teacher %s is given %s access to %s of device until %s", token.Operator, mode, target, time.Unix(token.Expiry, 0).UTC().Format(time.RFC3339)),
+		Source:         coreV1.EventSource{Component: option.GroupVersion.Group},
+		FirstTimestamp: metaV1.NewTime(now),
+		LastTimestamp:  metaV1.NewTime(now),
+		Count:          1,
+		Type:           coreV1.EventTypeNormal,
+	}
+}
@@ -0,0 +1,385 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+	"open-hydra/pkg/util"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	DeviceBatchOperationCreate = "create"
+	DeviceBatchOperationDelete = "delete"
+	DeviceBatchPhaseRunning    = "Running"
+	DeviceBatchPhaseSucceeded  = "Succeeded"
+	DeviceBatchPhaseFailed     = "Failed"
+	DeviceBatchResultPending   = "Pending"
+	DeviceBatchResultNotFound  = "NotFound"
+)
+
+func (builder *OpenHydraRouteBuilder) AddDeviceBatchCreateRoute() {
+	path := "/" + DeviceBatchPath
+	builder.addPathAuthorization(path, http.MethodPost, 1)
+	builder.RootWS.Route(builder.RootWS.POST(path).Operation("createDeviceBatch").To(builder.DeviceBatchCreateRouteHandler).
+		Returns(http.StatusAccepted, "accepted", xDeviceV1.DeviceBatch{}).
+		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusConflict, "conflict", "").
+		Returns(http.StatusTooManyRequests, "too many requests", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", ""))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceBatchCreateRouteHandler(request *restful.Request, response *restful.Response) {
+	batch := xDeviceV1.DeviceBatch{}
+	err := request.ReadEntity(&batch)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("Failed to read request entity due to %s", err.Error()))
+		return
+	}
+
+	if batch.Spec.Operation != DeviceBatchOperationCreate && batch.Spec.Operation != DeviceBatchOperationDelete {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("operation must be %s or %s", DeviceBatchOperationCreate, DeviceBatchOperationDelete))
+		return
+	}
+
+	if batch.Spec.Operation == DeviceBatchOperationCreate && batch.Spec.Template.SandboxName == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "template sandboxName is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	targets, err := builder.resolveDeviceBatchTargets(&batch)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(targets) == 0 {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "no user matched by usernames or labelSelector")
+		return
+	}
+
+	if batch.Name == "" {
+		batch.Name = fmt.Sprintf("batch-%s", strings.Split(uuid.New().String(), "-")[0])
+	}
+	util.FillKindAndApiVersion(&batch.TypeMeta, DeviceBatchKind)
+	batch.CreationTimestamp = metaV1.Now()
+	batch.Status = xDeviceV1.DeviceBatchStatus{
+		Phase:   DeviceBatchPhaseRunning,
+		Total:   len(targets),
+		Results: targets,
+	}
+
+	builder.deviceBatchLock.Lock()
+	builder.pruneDeviceBatches(time.Now(), 1, serverConfig)
+	if _, found := builder.deviceBatches[batch.Name]; found {
+		builder.deviceBatchLock.Unlock()
+		writeHttpResponseAndLogError(response, http.StatusConflict, fmt.Sprintf("device batch %s already exists", batch.Name))
+		return
+	}
+	if len(builder.deviceBatches) >= int(serverConfig.MaximumDeviceBatches) {
+		builder.deviceBatchLock.Unlock()
+		writeHttpResponseAndLogError(response, http.StatusTooManyRequests, fmt.Sprintf("%d device batches are running, try again once one of them finishes", len(builder.deviceBatches)))
+		return
+	}
+	builder.deviceBatches[batch.Name] = &batch
+	result := batch.DeepCopy()
+	builder.deviceBatchLock.Unlock()
+
+	slog.Info("Received request to run device batch", "name", batch.Name, "operation", batch.Spec.Operation, "total", len(targets))
+
+	go builder.runDeviceBatch(batch.DeepCopy(), serverConfig)
+
+	response.WriteHeaderAndEntity(http.StatusAccepted, result)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceBatchListRoute() {
+	path := "/" + DeviceBatchPath
+	builder.addPathAuthorization(path, http.MethodGet, 1)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listDeviceBatch").To(builder.DeviceBatchListRouteHandler).
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceBatchList{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceBatchListRouteHandler(request *restful.Request, response *restful.Response) {
+	result := xDeviceV1.DeviceBatchList{}
+	result.Kind = "List"
+	result.APIVersion = "v1"
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	builder.deviceBatchLock.Lock()
+	builder.pruneDeviceBatches(time.Now(), 0, serverConfig)
+	for _, batch := range builder.deviceBatches {
+		result.Items = append(result.Items, *batch.DeepCopy())
+	}
+	builder.deviceBatchLock.Unlock()
+
+	// newest batch goes first
+	sort.Slice(result.Items, func(i, j int) bool {
+		return result.Items[j].CreationTimestamp.Before(&result.Items[i].CreationTimestamp)
+	})
+
+	response.WriteEntity(result)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceBatchGetRoute() {
+	path := "/" + DeviceBatchPath + "/{name}"
+	builder.addPathAuthorization(path, http.MethodGet, 1)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getDeviceBatch").To(builder.DeviceBatchGetRouteHandler).
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceBatch{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceBatchGetRouteHandler(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("name")
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	builder.deviceBatchLock.Lock()
+	builder.pruneDeviceBatches(time.Now(), 0, serverConfig)
+	batch, found := builder.deviceBatches[name]
+	var result *xDeviceV1.DeviceBatch
+	if found {
+		result = batch.DeepCopy()
+	}
+	builder.deviceBatchLock.Unlock()
+
+	if !found {
+		writeHttpResponseAndLogError(response, http.StatusNotFound, fmt.Sprintf("device batch %s not found", name))
+		return
+	}
+
+	response.WriteEntity(result)
+}
+
+// resolveDeviceBatchTargets merges usernames given in spec with users or devices matched by label selector
+// for create every user gets device of template, for delete every device matched is deleted by its own device id
+// result is de-duplicated and sorted
+func (builder *OpenHydraRouteBuilder) resolveDeviceBatchTargets(batch *xDeviceV1.DeviceBatch) ([]xDeviceV1.DeviceBatchResult, error) {
+	type target struct {
+		username string
+		deviceId string
+	}
+	targetSet := map[target]struct{}{}
+	templateDeviceId := k8s.DeviceId(batch.Spec.Template.DeviceId)
+	for _, username := range batch.Spec.Usernames {
+		if username != "" {
+			targetSet[target{username: username, deviceId: templateDeviceId}] = struct{}{}
+		}
+	}
+
+	if batch.Spec.LabelSelector != "" {
+		selector, err := labels.Parse(batch.Spec.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse label selector: %v", err)
+		}
+
+		if batch.Spec.Operation == DeviceBatchOperationCreate {
+			users, err := builder.Database.ListUsers()
+			if err != nil {
+				return nil, fmt.Errorf("failed to list users: %v", err)
+			}
+			for _, user := range users.Items {
+				if selector.Matches(labels.Set(user.Labels)) {
+					targetSet[target{username: user.Name, deviceId: templateDeviceId}] = struct{}{}
+				}
+			}
+		} else {
+			// match deployments rather than pods, stopped and queued devices run no pod but still hold gpu queue slot and node port
+			deploys, err := builder.k8sHelper.ListDeploymentWithLabel(batch.Spec.LabelSelector, OpenhydraNamespace, builder.kubeClient)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list devices: %v", err)
+			}
+			for _, deploy := range deploys {
+				if username, found := deploy.Labels[k8s.OpenHydraUserLabelKey]; found {
+					// deployment without device label is legacy default device
+					targetSet[target{username: username, deviceId: k8s.DeviceId(deploy.Labels[k8s.OpenHydraDeviceLabelKey])}] = struct{}{}
+				}
+			}
+		}
+	}
+
+	var result []xDeviceV1.DeviceBatchResult
+	for item := range targetSet {
+		result = append(result, xDeviceV1.DeviceBatchResult{
+			OpenHydraUsername: item.username,
+			DeviceId:          item.deviceId,
+			Result:            DeviceBatchResultPending,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].OpenHydraUsername != result[j].OpenHydraUsername {
+			return result[i].OpenHydraUsername < result[j].OpenHydraUsername
+		}
+		return result[i].DeviceId < result[j].DeviceId
+	})
+	return result, nil
+}
+
+// runDeviceBatch creates or deletes device for every user in batch with bounded concurrency
+// progress is written back to the batch stored in builder so it can be queried while running
+func (builder *OpenHydraRouteBuilder) runDeviceBatch(batch *xDeviceV1.DeviceBatch, serverConfig *config.OpenHydraServerConfig) {
+	concurrency := batch.Spec.Concurrency
+	if concurrency <= 0 {
+		concurrency = int(serverConfig.DeviceBatchConcurrency)
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	group := new(errgroup.Group)
+	group.SetLimit(concurrency)
+	for index := range batch.Status.Results {
+		index := index
+		username := batch.Status.Results[index].OpenHydraUsername
+		deviceId := batch.Status.Results[index].DeviceId
+		group.Go(func() error {
+			var err error
+			if batch.Spec.Operation == DeviceBatchOperationCreate {
+				device := xDeviceV1.Device{
+					ObjectMeta: metaV1.ObjectMeta{
+						Name:   username,
+						Labels: map[string]string{},
+					},
+					Spec: *batch.Spec.Template.DeepCopy(),
+				}
+				for key, value := range batch.Labels {
+					device.Labels[key] = value
+				}
+				device.Labels[k8s.OpenHydraBatchLabelKey] = batch.Name
+				device.Spec.OpenHydraUsername = username
+				err = builder.createDevice(&device, serverConfig)
+			} else {
+				err = builder.deleteBatchDevice(username, deviceId, serverConfig)
+			}
+			builder.updateDeviceBatchResult(batch.Name, index, err)
+			// error is recorded per user, we never stop the whole batch
+			return nil
+		})
+	}
+	_ = group.Wait()
+
+	builder.deviceBatchLock.Lock()
+	defer builder.deviceBatchLock.Unlock()
+	stored, found := builder.deviceBatches[batch.Name]
+	if !found {
+		return
+	}
+	completionTime := metaV1.Now()
+	stored.Status.CompletionTime = &completionTime
+	stored.Status.Phase = DeviceBatchPhaseSucceeded
+	if stored.Status.Failed > 0 {
+		stored.Status.Phase = DeviceBatchPhaseFailed
+	}
+	slog.Info("Device batch finished", "name", batch.Name, "succeeded", stored.Status.Succeeded, "failed", stored.Status.Failed)
+}
+
+// deleteBatchDevice deletes a single device of user, unlike device delete route a device that does not exist is reported as not found
+// so a batch naming users without such device does not claim success for them
+func (builder *OpenHydraRouteBuilder) deleteBatchDevice(username, deviceId string, serverConfig *config.OpenHydraServerConfig) error {
+	selectors := []string{k8s.DeviceLabelSelector(username, deviceId)}
+	if k8s.DeviceId(deviceId) == k8s.OpenHydraDefaultDeviceId {
+		selectors = append(selectors, k8s.LegacyDeviceLabelSelector(username))
+	}
+	found := false
+	for _, selector := range selectors {
+		deploy, err := builder.k8sHelper.ListDeploymentWithLabel(selector, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			return errors.NewInternalError(err)
+		}
+		if len(deploy) > 0 {
+			found = true
+		}
+	}
+	if !found {
+		return errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId))
+	}
+	return builder.deleteDevice(username, deviceId, serverConfig)
+}
+
+// pruneDeviceBatches drops finished batches once retention is over, then oldest finished ones until room more batches fit in
+// caller must hold device batch lock
+func (builder *OpenHydraRouteBuilder) pruneDeviceBatches(now time.Time, room int, serverConfig *config.OpenHydraServerConfig) {
+	retention := time.Duration(serverConfig.DeviceBatchRetentionSeconds) * time.Second
+	var finished []*xDeviceV1.DeviceBatch
+	for name, batch := range builder.deviceBatches {
+		if batch.Status.CompletionTime == nil {
+			continue
+		}
+		if !now.Before(batch.Status.CompletionTime.Add(retention)) {
+			delete(builder.deviceBatches, name)
+			continue
+		}
+		finished = append(finished, batch)
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].Status.CompletionTime.Before(finished[j].Status.CompletionTime)
+	})
+	for _, batch := range finished {
+		if len(builder.deviceBatches)+room <= int(serverConfig.MaximumDeviceBatches) {
+			return
+		}
+		delete(builder.deviceBatches, batch.Name)
+	}
+}
+
+func (builder *OpenHydraRouteBuilder) updateDeviceBatchResult(name string, index int, err error) {
+	builder.deviceBatchLock.Lock()
+	defer builder.deviceBatchLock.Unlock()
+	stored, found := builder.deviceBatches[name]
+	if !found || index >= len(stored.Status.Results) {
+		return
+	}
+	if errors.IsNotFound(err) {
+		stored.Status.Results[index].Result = DeviceBatchResultNotFound
+		stored.Status.Results[index].Message = err.Error()
+		stored.Status.Failed++
+		return
+	}
+	if err != nil {
+		slog.Error("Device batch failed for user", "name", name, "user", stored.Status.Results[index].OpenHydraUsername, "device", stored.Status.Results[index].DeviceId, "error", err)
+		stored.Status.Results[index].Result = DeviceBatchPhaseFailed
+		stored.Status.Results[index].Message = err.Error()
+		stored.Status.Failed++
+		return
+	}
+	stored.Status.Results[index].Result = DeviceBatchPhaseSucceeded
+	stored.Status.Succeeded++
+}
@@ -0,0 +1,251 @@
+package openhydra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/websocket"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// client must offer this sub protocol, credential may be offered as another sub protocol see openHydraWebSocketAuthProtocolPrefix
+	deviceExecProtocol     = "exec.openhydra.io"
+	deviceExecMessageStdin = "stdin"
+	// resize terminal to cols x rows
+	deviceExecMessageResize = "resize"
+	// websocket close reason must not exceed 123 bytes
+	maxCloseReasonLength = 123
+)
+
+// prefer bash but sandbox image may only have sh
+var deviceExecShell = []string{"/bin/sh", "-c", "command -v bash > /dev/null && exec bash || exec sh"}
+
+// deviceExecMessage is sent by client as text message, terminal output is sent back as binary message
+type deviceExecMessage struct {
+	Type string `json:"type"`
+	Data string `json:"data,omitempty"`
+	Cols uint16 `json:"cols,omitempty"`
+	Rows uint16 `json:"rows,omitempty"`
+}
+
+var deviceExecUpgrader = websocket.Upgrader{
+	Subprotocols: []string{deviceExecProtocol},
+	// credential is not a cookie so cross site page can not use it, origin check brings nothing
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceExecRoute() {
+	path := "/" + DevicePath + "/{username}/exec"
+	builder.addPathAuthorization(path, http.MethodGet, 3)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("execDevice").To(builder.DeviceExecRouteHandler).
+		Doc(fmt.Sprintf("websocket terminal into device, client must offer sub protocol %s", deviceExecProtocol)).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
+		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusSwitchingProtocols, "switching protocols", ""))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceExecRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	reqUser := request.HeaderParameter(openHydraHeaderUser)
+	if !serverConfig.DisableAuth {
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can open terminal into other user device
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to exec into device for user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	deviceId := k8s.DeviceId(request.QueryParameter("deviceId"))
+	pods, err := builder.k8sHelper.GetUserPods(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pod := pickDevicePod(pods)
+	if pod == nil {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId)))
+		return
+	}
+	if pod.Status.Phase != coreV1.PodRunning {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("device %s of user %s is not running", deviceId, username))
+		return
+	}
+
+	conn, err := deviceExecUpgrader.Upgrade(response.ResponseWriter, request.Request, nil)
+	if err != nil {
+		// upgrader already replied to client
+		slog.Error("Failed to upgrade device exec connection", "error", err)
+		return
+	}
+
+	audit := slog.With("operator", reqUser, "username", username, "deviceId", deviceId, "pod", pod.Name)
+	audit.Info("audit: device exec session started")
+	start := time.Now()
+
+	idleTimeout := time.Duration(serverConfig.DeviceExecIdleTimeoutSeconds) * time.Second
+	if idleTimeout <= 0 {
+		idleTimeout = 1800 * time.Second
+	}
+	reason, err := builder.runDeviceExecSession(request.Request.Context(), conn, pod, idleTimeout)
+	if err != nil {
+		audit.Warn("audit: device exec session ended", "duration", time.Since(start).String(), "reason", reason, "error", err)
+	} else {
+		audit.Info("audit: device exec session ended", "duration", time.Since(start).String(), "reason", reason)
+	}
+}
+
+// runDeviceExecSession proxies websocket to tty of sandbox container until shell exits, client leaves or session is idle
+// it closes conn and returns why session ended
+func (builder *OpenHydraRouteBuilder) runDeviceExecSession(parent context.Context, conn *websocket.Conn, pod *coreV1.Pod, idleTimeout time.Duration) (string, error) {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	output := &deviceExecWriter{conn: conn}
+	stdinReader, stdinWriter := io.Pipe()
+	sizes := &terminalSizeQueue{ctx: ctx, sizes: make(chan remotecommand.TerminalSize, 1)}
+
+	var idled, clientLeft atomic.Bool
+	idleTimer := time.AfterFunc(idleTimeout, func() {
+		idled.Store(true)
+		cancel()
+	})
+	defer idleTimer.Stop()
+
+	go func() {
+		// shell only exits on its own when stdin is closed
+		<-ctx.Done()
+		stdinWriter.Close()
+	}()
+
+	go func() {
+		defer cancel()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				clientLeft.Store(true)
+				return
+			}
+			var message deviceExecMessage
+			if err := json.Unmarshal(data, &message); err != nil {
+				slog.Warn("Ignore malformed device exec message", "error", err)
+				continue
+			}
+			idleTimer.Reset(idleTimeout)
+			switch message.Type {
+			case deviceExecMessageStdin:
+				if _, err := stdinWriter.Write([]byte(message.Data)); err != nil {
+					return
+				}
+			case deviceExecMessageResize:
+				sizes.push(remotecommand.TerminalSize{Width: message.Cols, Height: message.Rows})
+			}
+		}
+	}()
+
+	err := builder.k8sHelper.ExecInPod(ctx, pod.Namespace, pod.Name, pod.Spec.Containers[0].Name, deviceExecShell, remotecommand.StreamOptions{
+		Stdin:             stdinReader,
+		Stdout:            output,
+		Tty:               true,
+		TerminalSizeQueue: sizes,
+	}, builder.cfg.KubeConfig, builder.kubeClient)
+
+	reason := "shell exited"
+	switch {
+	case idled.Load():
+		reason = "idle timeout"
+		err = nil
+	case clientLeft.Load():
+		reason = "client closed"
+		err = nil
+	case err != nil:
+		reason = err.Error()
+	}
+	if len(reason) > maxCloseReasonLength {
+		reason = reason[:maxCloseReasonLength]
+	}
+	output.close(reason)
+	return reason, err
+}
+
+// deviceExecWriter sends terminal output as binary message, gorilla websocket allows only one concurrent writer
+type deviceExecWriter struct {
+	conn *websocket.Conn
+	lock sync.Mutex
+}
+
+func (w *deviceExecWriter) Write(data []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if err := w.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+func (w *deviceExecWriter) close(reason string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason), time.Now().Add(time.Second))
+	w.conn.Close()
+}
+
+// terminalSizeQueue only keeps the latest size, older sizes are useless once a new one arrives
+type terminalSizeQueue struct {
+	ctx   context.Context
+	sizes chan remotecommand.TerminalSize
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	select {
+	case size := <-q.sizes:
+		return &size
+	case <-q.ctx.Done():
+		return nil
+	}
+}
+
+func (q *terminalSizeQueue) push(size remotecommand.TerminalSize) {
+	select {
+	case <-q.sizes:
+	default:
+	}
+	q.sizes <- size
+}
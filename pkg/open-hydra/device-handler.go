@@ -11,17 +11,25 @@ import (
 	"open-hydra/pkg/open-hydra/k8s"
 	"open-hydra/pkg/util"
 	"os"
+	"reflect"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/emicklei/go-restful/v3"
+	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func (builder *OpenHydraRouteBuilder) AddDeviceListRoute() {
 	path := "/" + DevicePath
 	builder.addPathAuthorization(path, http.MethodGet, 1)
-	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listDevice").To(builder.DeviceListRouteHandler).
+	builder.RootWS.Route(builder.watchable(builder.RootWS.GET(path)).Operation("listDevice").To(builder.DeviceListRouteHandler).
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
 		Returns(http.StatusUnauthorized, "unauthorized", "").
@@ -33,6 +41,11 @@ func (builder *OpenHydraRouteBuilder) DeviceListRouteHandler(request *restful.Re
 	filter := request.QueryParameters("group")
 	fmt.Println(filter)
 
+	if isWatch(request) {
+		builder.serveWatch(request, response, builder.watchHubs[DevicePath])
+		return
+	}
+
 	serverConfig, err := builder.GetServerConfigFromConfigMap()
 	if err != nil {
 		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
@@ -40,20 +53,30 @@ func (builder *OpenHydraRouteBuilder) DeviceListRouteHandler(request *restful.Re
 		return
 	}
 
+	result, err := builder.listDevices(serverConfig)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result.ResourceVersion = builder.watchHubs[DevicePath].sync(deviceObjects(&result))
+
+	response.WriteEntity(result)
+}
+
+// listDevices lists devices of all users, user without device is listed with a terminated device
+func (builder *OpenHydraRouteBuilder) listDevices(serverConfig *config.OpenHydraServerConfig) (xDeviceV1.DeviceList, error) {
 	result := xDeviceV1.DeviceList{}
 	result.Kind = "List"
 	result.APIVersion = "v1"
 
 	users, err := builder.Database.ListUsers()
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
-		return
+		return result, err
 	}
 
 	allUserDevice, err := builder.k8sHelper.ListPod("open-hydra", builder.kubeClient)
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
-		return
+		return result, err
 	}
 
 	allUserService, err := builder.k8sHelper.ListService(OpenhydraNamespace, builder.kubeClient)
@@ -63,13 +86,22 @@ func (builder *OpenHydraRouteBuilder) DeviceListRouteHandler(request *restful.Re
 
 	result.Items = combineDeviceList(allUserDevice, allUserService, users, serverConfig)
 
-	response.WriteEntity(result)
+	allUserDeploy, err := builder.k8sHelper.ListDeployment(OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		slog.Warn("Failed to list deployment", "error", err)
+	}
+	result.Items = fillStoppedDevices(result.Items, allUserDeploy, serverConfig)
+	builder.fillGpuQueuePositions(result.Items)
+	builder.fillGpuBudgetWarnings(result.Items)
+	builder.fillGpuModels(result.Items, allUserDevice, serverConfig)
+	return result, nil
 }
 
 func (builder *OpenHydraRouteBuilder) AddDeviceGetRoute() {
 	path := "/" + DevicePath + "/{username}"
 	builder.addPathAuthorization(path, http.MethodGet, 3)
 	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getDevice").To(builder.DeviceGetRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
 		Returns(http.StatusNotFound, "not found", "").
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
@@ -114,7 +146,8 @@ func (builder *OpenHydraRouteBuilder) DeviceGetRouteHandler(request *restful.Req
 		return
 	}
 
-	userLabel := fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, username)
+	deviceId := k8s.DeviceId(request.QueryParameter("deviceId"))
+	userLabel := k8s.DeviceLabelSelector(username, deviceId)
 
 	device, err := builder.k8sHelper.GetUserPods(userLabel, OpenhydraNamespace, builder.kubeClient)
 	if err != nil {
@@ -134,12 +167,29 @@ func (builder *OpenHydraRouteBuilder) DeviceGetRouteHandler(request *restful.Req
 	}
 
 	result := combineDeviceList(device, services, v1.OpenHydraUserList{Items: []v1.OpenHydraUser{*user}}, serverConfig)
-	if len(result) == 0 {
-		writeHttpResponseAndLogError(response, http.StatusNotFound, "not found")
-		return
+
+	deploy, err := builder.k8sHelper.ListDeploymentWithLabel(userLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		slog.Warn("Failed to list user deployment", "error", err)
+	}
+	result = fillStoppedDevices(result, deploy, serverConfig)
+	builder.fillGpuQueuePositions(result)
+	builder.fillGpuBudgetWarnings(result)
+	builder.fillGpuModels(result, device, serverConfig)
+
+	for _, item := range result {
+		if item.Spec.DeviceId == deviceId {
+			events, err := builder.listDeviceEvents(username, deviceId)
+			if err != nil {
+				slog.Warn("Failed to list device events", "error", err)
+			}
+			item.Status.Events = events
+			response.WriteAsJson(item)
+			return
+		}
 	}
 
-	response.WriteAsJson(result[0])
+	writeHttpResponseAndLogError(response, http.StatusNotFound, "not found")
 }
 
 func (builder *OpenHydraRouteBuilder) AddDeviceCreateRoute() {
@@ -148,6 +198,7 @@ func (builder *OpenHydraRouteBuilder) AddDeviceCreateRoute() {
 	builder.RootWS.Route(builder.RootWS.POST(path).Operation("createDevice").To(builder.DeviceCreateRouteHandler).
 		Returns(http.StatusCreated, "created", "").
 		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusConflict, "conflict", "").
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
 		Returns(http.StatusUnauthorized, "unauthorized", ""))
@@ -195,72 +246,235 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 				return
 			}
 
-			if reqDevice.Spec.DeviceGpu != 0 {
+			// gpu driver of profile is needed for quota check
+			err = resolveGpuProfile(&reqDevice, serverConfig)
+			if err != nil {
+				writeAPIStatusError(response, err)
+				return
+			}
+			gpuDriver := reqDevice.Spec.GpuDriver
+			if gpuDriver == "" {
+				gpuDriver = serverConfig.DefaultGpuDriver
+			}
+			// student can only ask for gpu granted by quota
+			if reqDevice.Spec.DeviceGpu != 0 && !quotaGrantsGpu(reqUser, roleFromHeader(request), gpuDriver, serverConfig) {
 				writeHttpResponseAndLogError(response, http.StatusForbidden, "user do not have the right to create gpu device")
 				return
 			}
+			// only teacher can jump the gpu queue
+			reqDevice.Spec.QueuePriority = 0
 		}
 	}
 
-	// check if user exists
-	_, err = builder.Database.GetUser(reqDevice.Spec.OpenHydraUsername)
+	err = builder.createDevice(&reqDevice, serverConfig)
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusBadRequest, "user not found")
+		writeAPIStatusError(response, err)
 		return
 	}
 
+	response.WriteEntity(&reqDevice)
+}
+
+// createDevice creates deployment and service for reqDevice, caller should check authorization before calling it
+// on success reqDevice is updated with device type and status
+func (builder *OpenHydraRouteBuilder) createDevice(reqDevice *xDeviceV1.Device, serverConfig *config.OpenHydraServerConfig) error {
+	// check if user exists
+	user, err := builder.Database.GetUser(reqDevice.Spec.OpenHydraUsername)
+	if err != nil {
+		return errors.NewBadRequest("user not found")
+	}
+
+	// device id ends up in k8s resource name and label value
+	reqDevice.Spec.DeviceId = k8s.DeviceId(reqDevice.Spec.DeviceId)
+	if errs := validation.IsDNS1123Label(reqDevice.Spec.DeviceId); len(errs) > 0 {
+		return errors.NewBadRequest(fmt.Sprintf("invalid device id %s: %s", reqDevice.Spec.DeviceId, strings.Join(errs, ",")))
+	}
+	// separator keeps names of devices of different users apart
+	if strings.Contains(reqDevice.Spec.DeviceId, k8s.DeviceNameSeparator) || strings.Contains(user.Name, k8s.DeviceNameSeparator) {
+		return errors.NewBadRequest(fmt.Sprintf("device id %s and username %s must not contain %s", reqDevice.Spec.DeviceId, user.Name, k8s.DeviceNameSeparator))
+	}
+
 	// check if device already exists
-	pod, err := builder.k8sHelper.ListPodWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, reqDevice.Spec.OpenHydraUsername), OpenhydraNamespace, builder.kubeClient)
+	deviceLabel := k8s.DeviceLabelSelector(reqDevice.Spec.OpenHydraUsername, reqDevice.Spec.DeviceId)
+	deploy, err := builder.k8sHelper.ListDeploymentWithLabel(deviceLabel, OpenhydraNamespace, builder.kubeClient)
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
-		return
+		return errors.NewInternalError(err)
+	}
+	if len(deploy) > 0 {
+		if _, err := builder.k8sHelper.GetUserService(deviceLabel, OpenhydraNamespace, builder.kubeClient); err != nil {
+			// young deployment may belong to a create still running, same grace as reconciler keeps it from being repaired twice
+			if time.Since(deploy[0].CreationTimestamp.Time) < deviceReconcileGracePeriod {
+				return errors.NewConflict(xDeviceV1.Resource("device"), reqDevice.Spec.DeviceId, fmt.Errorf("device %s with student name %s is still being created, retry later", reqDevice.Spec.DeviceId, reqDevice.Spec.OpenHydraUsername))
+			}
+			// a create interrupted before service is created left deployment alone, retry finishes it instead of being rejected
+			err = builder.repairDevice(&deploy[0], serverConfig)
+			if err != nil {
+				return errors.NewInternalError(err)
+			}
+			_, gpu := deploymentGpu(deploy[0], serverConfig.GpuResourceKeys)
+			reqDevice.Spec.DeviceType = "cpu"
+			if gpu > 0 {
+				reqDevice.Spec.DeviceType = "gpu"
+			}
+			reqDevice.Spec.DeviceStatus = "Creating"
+			return nil
+		}
+	}
+
+	pod, err := builder.k8sHelper.ListPodWithLabel(deviceLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return errors.NewInternalError(err)
 	}
 	// we consider pod as user device if we found it already exists then we return error
 	if len(pod) > 0 {
-		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("device with student name %s already exists", reqDevice.Spec.OpenHydraUsername))
+		return errors.NewBadRequest(fmt.Sprintf("device %s with student name %s already exists", reqDevice.Spec.DeviceId, reqDevice.Spec.OpenHydraUsername))
+	}
+
+	// a stopped device has no pod but deployment is still there
+	if len(deploy) > 0 {
+		if k8s.IsDeploymentQueued(deploy[0]) {
+			return errors.NewBadRequest(fmt.Sprintf("device %s with student name %s already exists and is waiting in gpu queue", reqDevice.Spec.DeviceId, reqDevice.Spec.OpenHydraUsername))
+		}
+		return errors.NewBadRequest(fmt.Sprintf("device %s with student name %s already exists and is stopped, start it instead", reqDevice.Spec.DeviceId, reqDevice.Spec.OpenHydraUsername))
+	}
+
+	// every deployment of user is a device no matter it is running or stopped, 0 means no limit
+	if serverConfig.MaximumDevicesPerUser > 0 {
+		userDeploy, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, reqDevice.Spec.OpenHydraUsername), OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			return errors.NewInternalError(err)
+		}
+		if len(userDeploy) >= int(serverConfig.MaximumDevicesPerUser) {
+			return errors.NewBadRequest(fmt.Sprintf("user %s already owns %d devices which reaches the limit", reqDevice.Spec.OpenHydraUsername, len(userDeploy)))
+		}
+	}
+
+	deployParameter, err := builder.buildDeployParameter(reqDevice, user.Spec.Role, serverConfig)
+	if err != nil {
+		return err
+	}
+
+	// gpu device waits in queue and queue decides when it starts
+	if deployParameter.GpuSet.Gpu > 0 && serverConfig.GpuQueuePolicy != GpuQueuePolicyDisabled {
+		deployParameter.Queued = true
+		deployParameter.QueuePriority = reqDevice.Spec.QueuePriority
+	}
+
+	// policy goes first so device of user is never reachable by other users
+	if err := builder.ensureNetworkPolicy(reqDevice.Spec.OpenHydraUsername, serverConfig); err != nil {
+		return errors.NewInternalError(fmt.Errorf("failed to apply network policy of user %s: %v", reqDevice.Spec.OpenHydraUsername, err))
+	}
+
+	var deployment *appsV1.Deployment
+	builder.quotaLock.Lock()
+	err = builder.checkDeviceQuota(user.Name, user.Spec.Role, usageOfDeployParameter(deployParameter, serverConfig), serverConfig)
+	if err == nil {
+		deployment, err = builder.k8sHelper.CreateDeployment(deployParameter)
+		if err != nil {
+			err = errors.NewInternalError(err)
+		}
+	}
+	builder.quotaLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// service and routes are owned by deployment so k8s removes them along with device
+	err = builder.k8sHelper.CreateService(OpenhydraNamespace, reqDevice.Spec.OpenHydraUsername, reqDevice.Spec.DeviceId, reqDevice.Spec.SandboxName, builder.kubeClient, deployParameter.Ports, sandboxServiceType(serverConfig), deployment)
+	if err == nil {
+		err = builder.createDeviceRoutes(reqDevice.Spec.OpenHydraUsername, reqDevice.Spec.DeviceId, deployParameter.Ports, deployment, serverConfig)
+	}
+	if err != nil {
+		// device can not be reached without service or routes, remove what is created so user can simply create it again
+		builder.rollbackDevice(deviceLabel)
+		return errors.NewInternalError(err)
+	}
+
+	reqDevice.Spec.DeviceType = "cpu"
+	if deployParameter.GpuSet.Gpu > 0 {
+		reqDevice.Spec.DeviceType = "gpu"
+	}
+
+	reqDevice.Spec.DeviceStatus = "Creating"
+	if deployParameter.Queued {
+		builder.admitFromGpuQueue(reqDevice, serverConfig)
+	}
+	return nil
+}
+
+// admitFromGpuQueue syncs gpu queue right away so device starts at once if gpu is free
+// otherwise device is reported as queued along with its position, queue loop will admit it later
+func (builder *OpenHydraRouteBuilder) admitFromGpuQueue(device *xDeviceV1.Device, serverConfig *config.OpenHydraServerConfig) {
+	err := builder.syncGpuQueue(serverConfig)
+	if err != nil {
+		// device stays in queue and will be picked up by queue loop
+		slog.Warn("Failed to sync gpu queue", "error", err)
+	}
+	position, found := builder.getGpuQueuePosition(k8s.DeviceLabelSelector(device.Spec.OpenHydraUsername, device.Spec.DeviceId))
+	if !found && err == nil {
+		// admitted
 		return
 	}
+	device.Spec.DeviceStatus = DeviceStatusQueued
+	if found {
+		device.Spec.LineNo = strconv.Itoa(position.lineNo)
+		device.Spec.EstimatedWaitSeconds = position.estimatedWaitSeconds
+	}
+}
 
-	gpuSet := builder.BuildGpu(reqDevice, serverConfig)
+// buildDeployParameter resolves image, ports and volumes of sandbox in plugin config map and resources of device
+// it is shared by device create and update so both end up with the same deployment
+func (builder *OpenHydraRouteBuilder) buildDeployParameter(reqDevice *xDeviceV1.Device, role int, serverConfig *config.OpenHydraServerConfig) (*k8s.DeploymentParameters, error) {
+	err := checkSandboxProxyConfig(serverConfig)
+	if err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	err = resolveGpuProfile(reqDevice, serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	gpuSet := builder.BuildGpu(*reqDevice, serverConfig)
 
 	// we need to get config map openhydra-plugin first
 	// TODO: we should use informer to cache config map instead of query api-server directly for performance
 	pluginConfigMap, err := builder.k8sHelper.GetConfigMap("openhydra-plugin", OpenhydraNamespace)
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to get configmap: %v", err))
-		return
+		return nil, errors.NewInternalError(fmt.Errorf("failed to get configmap: %v", err))
 	}
 
 	// parse to plugin list
 	plugins, err := ParseJsonToPluginList(pluginConfigMap.Data["plugins"])
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to unmarshal json: %v", err))
-		return
+		return nil, errors.NewInternalError(fmt.Errorf("failed to unmarshal json: %v", err))
 	}
 
 	var image string
 	var ports map[string]int
+	var healthChecks map[string]envApi.HealthCheck
 	var command, args []string
 	var volumeMounts []envApi.VolumeMount
 	var volumes []envApi.Volume
 	if _, found := plugins.Sandboxes[reqDevice.Spec.SandboxName]; !found {
 		// if sandbox name did not match any sandbox name then return error
-		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("sandbox %s not found, please ensure sandbox is proper config", reqDevice.Spec.SandboxName))
-		return
+		return nil, errors.NewBadRequest(fmt.Sprintf("sandbox %s not found, please ensure sandbox is proper config", reqDevice.Spec.SandboxName))
 	} else {
 		if len(plugins.Sandboxes[reqDevice.Spec.SandboxName].Ports) > int(serverConfig.MaximumPortsPerSandbox) {
 			// if sandbox exceed maximum ports limit then return error
-			writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("sandbox %s exceed maximum ports limit", reqDevice.Spec.SandboxName))
-			return
+			return nil, errors.NewBadRequest(fmt.Sprintf("sandbox %s exceed maximum ports limit", reqDevice.Spec.SandboxName))
 		}
 		// TODO: we need consider security issue for certain volume mount
 		volumeMounts = plugins.Sandboxes[reqDevice.Spec.SandboxName].VolumeMounts
 		volumes = plugins.Sandboxes[reqDevice.Spec.SandboxName].Volumes
+		if serverConfig.WorkspaceVolumeMode == WorkspaceVolumePVC {
+			volumes, volumeMounts, err = builder.useWorkspaceClaim(volumes, volumeMounts, reqDevice.Spec.OpenHydraUsername, role, serverConfig)
+			if err != nil {
+				return nil, errors.NewInternalError(fmt.Errorf("failed to prepare workspace: %v", err))
+			}
+		}
 		// handle private dir creation
 		err = preCreateUserDir(volumes, reqDevice.Spec.OpenHydraUsername, serverConfig)
 		if err != nil {
-			writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to create user dir: %v", err))
-			return
+			return nil, errors.NewInternalError(fmt.Errorf("failed to create user dir: %v", err))
 		}
 
 		if builder.cfg.AddProjectResource && reqDevice.Spec.OpenHydraProjectId != "" {
@@ -270,12 +484,10 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 			if err != nil {
 				slog.Warn(fmt.Sprintf("project dataset base path %s not found will not mount project dataset dir in container", builder.cfg.ProjectDatasetBasePath))
 			} else {
-				volumes = append(volumes, envApi.Volume{
-					HostPath: &envApi.HostPath{
-						Name: "project-dataset",
-						Path: projectDatasetFullPath,
-					},
-				})
+				volumes = append(volumes, sharedVolume(envApi.HostPath{
+					Name: "project-dataset",
+					Path: projectDatasetFullPath,
+				}, builder.cfg.ProjectDatasetBasePath, nfsConfig(serverConfig).ProjectDatasetPath, serverConfig))
 				volumeMounts = append(volumeMounts, envApi.VolumeMount{
 					Name:      "project-dataset",
 					MountPath: builder.cfg.ProjectDatasetStudentMountPath,
@@ -288,12 +500,10 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 			if err != nil {
 				slog.Warn(fmt.Sprintf("project course base path %s not found will not mount project course dir in container", builder.cfg.ProjectCourseBasePath))
 			} else {
-				volumes = append(volumes, envApi.Volume{
-					HostPath: &envApi.HostPath{
-						Name: "project-course",
-						Path: projectCourseFullPath,
-					},
-				})
+				volumes = append(volumes, sharedVolume(envApi.HostPath{
+					Name: "project-course",
+					Path: projectCourseFullPath,
+				}, builder.cfg.ProjectCourseBasePath, nfsConfig(serverConfig).ProjectCoursePath, serverConfig))
 				volumeMounts = append(volumeMounts, envApi.VolumeMount{
 					Name:      "project-course",
 					MountPath: builder.cfg.ProjectCourseStudentMountPath,
@@ -308,14 +518,20 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 		for _, port := range plugins.Sandboxes[reqDevice.Spec.SandboxName].Ports {
 
 			ports[port.Name] = int(port.Port)
+			if port.HealthCheck != nil {
+				// sandbox container has a single readiness probe
+				if len(healthChecks) > 0 || (port.HealthCheck.Type != k8s.HealthCheckHTTP && port.HealthCheck.Type != k8s.HealthCheckTCP) {
+					return nil, errors.NewBadRequest(fmt.Sprintf("health check of port %s in sandbox %s should be %s or %s and set on one port only", port.Name, reqDevice.Spec.SandboxName, k8s.HealthCheckHTTP, k8s.HealthCheckTCP))
+				}
+				healthChecks = map[string]envApi.HealthCheck{port.Name: *port.HealthCheck}
+			}
 		}
 		// set image with different hardware type if match
 		if gpuSet.Gpu > 0 {
 			// go with gpu image
 			if reqDevice.Spec.GpuDriver == "" {
 				if serverConfig.DefaultGpuDriver == "" {
-					writeHttpResponseAndLogError(response, http.StatusBadRequest, "both gpu driver and DefaultGpuDriver are empty")
-					return
+					return nil, errors.NewBadRequest("both gpu driver and DefaultGpuDriver are empty")
 				}
 				reqDevice.Spec.GpuDriver = serverConfig.DefaultGpuDriver
 			}
@@ -330,24 +546,22 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 				}
 			}
 			if !gpuIsAllowed {
-				writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("gpu driver %s is not allowed", reqDevice.Spec.GpuDriver))
-				return
+				return nil, errors.NewBadRequest(fmt.Sprintf("gpu driver %s is not allowed", reqDevice.Spec.GpuDriver))
 			}
 
 			// ensure key is found in GPUImageSet
 			// we do not put any default fall back option here which is on purpose
 			// because different gpu must go with different image especially for none cuda compatible gpu
-			if _, found := plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[reqDevice.Spec.GpuDriver]; !found {
-				writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("gpu image %s not found in sandbox %s", reqDevice.Spec.GpuDriver, reqDevice.Spec.SandboxName))
-				return
+			imageKey := gpuImageKey(*reqDevice, plugins.Sandboxes[reqDevice.Spec.SandboxName], serverConfig)
+			if _, found := plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[imageKey]; !found {
+				return nil, errors.NewBadRequest(fmt.Sprintf("gpu image %s not found in sandbox %s", imageKey, reqDevice.Spec.SandboxName))
 			}
 
-			if plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[reqDevice.Spec.GpuDriver] == "" {
-				writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("gpu image %s is empty in sandbox %s", reqDevice.Spec.GpuDriver, reqDevice.Spec.SandboxName))
-				return
+			if plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[imageKey] == "" {
+				return nil, errors.NewBadRequest(fmt.Sprintf("gpu image %s is empty in sandbox %s", imageKey, reqDevice.Spec.SandboxName))
 			}
 
-			image = plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[reqDevice.Spec.GpuDriver]
+			image = plugins.Sandboxes[reqDevice.Spec.SandboxName].GPUImageSet[imageKey]
 			slog.Debug(fmt.Sprintf("set image to gpu image '%s' with driver name '%s'", image, reqDevice.Spec.GpuDriver))
 		} else {
 			// go with cpu image
@@ -358,21 +572,34 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 
 	// if image is empty then return error
 	if image == "" {
-		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("no image found for sandbox %s", reqDevice.Spec.SandboxName))
-		return
+		return nil, errors.NewBadRequest(fmt.Sprintf("no image found for sandbox %s", reqDevice.Spec.SandboxName))
 	}
 
 	// if no ports found then return error
 	if len(ports) == 0 {
-		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("no ports found for sandbox %s", reqDevice.Spec.SandboxName))
-		return
+		return nil, errors.NewBadRequest(fmt.Sprintf("no ports found for sandbox %s", reqDevice.Spec.SandboxName))
+	}
+
+	// affinity of request is checked before affinity of gpu model is added
+	policy := schedulingPolicy(reqDevice.Spec.SandboxName, role, serverConfig)
+	err = checkUserAffinity(reqDevice.Spec.Affinity, policy, serverConfig)
+	if err != nil {
+		return nil, errors.NewForbidden(xDeviceV1.Resource("device"), reqDevice.Spec.OpenHydraUsername, fmt.Errorf("scheduling policy of sandbox %s: %v", reqDevice.Spec.SandboxName, err))
+	}
+
+	if gpuSet.Gpu > 0 {
+		err = builder.resolveGpuModel(reqDevice, serverConfig)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	deployParameter := &k8s.DeploymentParameters{
-		CpuMemorySet: builder.CombineReqLimit(reqDevice, serverConfig),
+		CpuMemorySet: builder.CombineReqLimit(*reqDevice, serverConfig),
 		Image:        image,
 		Namespace:    OpenhydraNamespace,
 		Username:     reqDevice.Spec.OpenHydraUsername,
+		DeviceId:     reqDevice.Spec.DeviceId,
 		SandboxName:  reqDevice.Spec.SandboxName,
 		VolumeMounts: volumeMounts,
 		GpuSet:       gpuSet,
@@ -383,38 +610,30 @@ func (builder *OpenHydraRouteBuilder) DeviceCreateRouteHandler(request *restful.
 		Volumes:      volumes,
 		Affinity:     reqDevice.Spec.Affinity,
 		CustomLabels: reqDevice.Labels,
+		BaseURLs:     sandboxProxyBaseURLs(reqDevice.Spec.OpenHydraUsername, reqDevice.Spec.DeviceId, ports, serverConfig),
+		HealthChecks: healthChecks,
 	}
 
-	err = builder.k8sHelper.CreateDeployment(deployParameter)
+	err = applySandboxEnv(deployParameter, plugins.Sandboxes[reqDevice.Spec.SandboxName], reqDevice)
 	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
-		return
+		return nil, err
 	}
+	applySchedulingPolicy(deployParameter, policy)
 
-	err = builder.k8sHelper.CreateService(OpenhydraNamespace, reqDevice.Spec.OpenHydraUsername, reqDevice.Spec.SandboxName, builder.kubeClient, ports)
-	if err != nil {
-		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
-		return
-	}
-
-	reqDevice.Spec.DeviceType = "cpu"
-	if gpuSet.Gpu > 0 {
-		reqDevice.Spec.DeviceType = "gpu"
-	}
-
-	reqDevice.Spec.DeviceStatus = "Creating"
-	response.WriteEntity(&reqDevice)
+	return deployParameter, nil
 }
 
 func (builder *OpenHydraRouteBuilder) AddDeviceUpdateRoute() {
 	path := "/" + DevicePath + "/{username}"
-	builder.addPathAuthorization(path, http.MethodPut, 1)
+	builder.addPathAuthorization(path, http.MethodPut, 3)
 	builder.RootWS.Route(builder.RootWS.PUT(path).Operation("getUpdateDevice").To(builder.DeviceUpdateRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, deviceId in body or default device if not set")).
 		Returns(http.StatusNotFound, "not found", "").
 		Returns(http.StatusBadRequest, "bad request", "").
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
-		Returns(http.StatusUnauthorized, "unauthorized", ""))
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.Device{}))
 }
 
 func (builder *OpenHydraRouteBuilder) DeviceUpdateRouteHandler(request *restful.Request, response *restful.Response) {
@@ -423,19 +642,319 @@ func (builder *OpenHydraRouteBuilder) DeviceUpdateRouteHandler(request *restful.
 		return
 	}
 
-	_, found := builder.CacheDevices[request.PathParameter("username")]
-	if !found {
-		writeHttpResponseAndLogError(response, http.StatusNotFound, "not found")
+	reqDevice := xDeviceV1.Device{}
+	err := request.ReadEntity(&reqDevice)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("Failed to read request entity due to %s", err.Error()))
+		return
+	}
+
+	slog.Info("Received request to update device", "device", reqDevice)
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	if reqDevice.Spec.OpenHydraUsername != "" && reqDevice.Spec.OpenHydraUsername != username {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "openHydraUsername do not match username in path")
+		return
+	}
+	reqDevice.Spec.OpenHydraUsername = username
+	if request.QueryParameter("deviceId") != "" {
+		reqDevice.Spec.DeviceId = request.QueryParameter("deviceId")
+	}
+	reqDevice.Spec.DeviceId = k8s.DeviceId(reqDevice.Spec.DeviceId)
+
+	isStudent := false
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can update other user device
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to update device for user: %s", reqUser, username))
+				return
+			}
+			isStudent = true
+		}
+	}
+
+	err = builder.updateDevice(&reqDevice, isStudent, serverConfig)
+	if err != nil {
+		writeAPIStatusError(response, err)
+		return
+	}
+
+	util.FillKindAndApiVersion(&reqDevice.TypeMeta, "Device")
+	response.WriteEntity(&reqDevice)
+}
+
+// updateDevice patches deployment of user device with cpu, memory, gpu and sandbox given in reqDevice
+// fields left empty keep current value, set deviceType to cpu to release gpu of device
+// deployment is rolled back if service could not follow the change of sandbox ports
+func (builder *OpenHydraRouteBuilder) updateDevice(reqDevice *xDeviceV1.Device, isStudent bool, serverConfig *config.OpenHydraServerConfig) error {
+	username := reqDevice.Spec.OpenHydraUsername
+	deviceLabel := k8s.DeviceLabelSelector(username, reqDevice.Spec.DeviceId)
+	deploy, err := builder.k8sHelper.ListDeploymentWithLabel(deviceLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	if len(deploy) == 0 || len(deploy[0].Spec.Template.Spec.Containers) == 0 {
+		return errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, reqDevice.Spec.DeviceId))
+	}
+	if k8s.IsDeploymentQueued(deploy[0]) {
+		return errors.NewBadRequest(fmt.Sprintf("device %s of user %s is waiting in gpu queue, stop it before update", reqDevice.Spec.DeviceId, username))
+	}
+	user, err := builder.Database.GetUser(username)
+	if err != nil {
+		return errors.NewBadRequest("user not found")
+	}
+
+	current := deviceFromDeployment(deploy[0], serverConfig)
+	if reqDevice.Spec.DeviceCpu == "" {
+		reqDevice.Spec.DeviceCpu = current.Spec.DeviceCpu
+	}
+	if reqDevice.Spec.DeviceRam == "" {
+		reqDevice.Spec.DeviceRam = current.Spec.DeviceRam
+	}
+	if reqDevice.Spec.SandboxName == "" {
+		reqDevice.Spec.SandboxName = current.Spec.SandboxName
+	}
+	if reqDevice.Spec.Affinity == nil {
+		reqDevice.Spec.Affinity = current.Spec.Affinity
+	}
+	if reqDevice.Spec.DeviceType == "cpu" {
+		reqDevice.Spec.DeviceGpu = 0
+		reqDevice.Spec.GpuDriver = ""
+		reqDevice.Spec.GpuProfile = ""
+	} else if reqDevice.Spec.DeviceGpu == 0 && reqDevice.Spec.GpuProfile == "" {
+		reqDevice.Spec.DeviceGpu = current.Spec.DeviceGpu
+		if reqDevice.Spec.GpuDriver == "" {
+			reqDevice.Spec.GpuDriver = current.Spec.GpuDriver
+			reqDevice.Spec.GpuProfile = current.Spec.GpuProfile
+		}
+	}
+	if reqDevice.Spec.GpuModel == "" && reqDevice.Spec.GpuDriver == current.Spec.GpuDriver {
+		reqDevice.Spec.GpuModel = current.Spec.GpuModel
+	}
+	labels := map[string]string{}
+	for key, value := range current.Labels {
+		labels[key] = value
+	}
+	for key, value := range reqDevice.Labels {
+		labels[key] = value
+	}
+	reqDevice.Labels = labels
+
+	deployParameter, err := builder.buildDeployParameter(reqDevice, user.Spec.Role, serverConfig)
+	if err != nil {
+		return err
+	}
+
+	// gpu validation only applies when device asks for more gpu than it holds
+	// device is recreated on update, so gpu it holds is released before new pod asks for gpu
+	sameDriver := deployParameter.GpuSet.GpuDriverName == current.Spec.GpuDriver
+	gpuToAdd := int64(deployParameter.GpuSet.Gpu)
+	if sameDriver {
+		gpuToAdd -= int64(current.Spec.DeviceGpu)
+	}
+
+	// stopped device holds nothing but itself, its resources are checked when it starts
+	stopped := deploy[0].Spec.Replicas != nil && *deploy[0].Spec.Replicas == 0
+	if gpuToAdd > 0 {
+		if isStudent && !quotaGrantsGpu(username, user.Spec.Role, deployParameter.GpuSet.GpuDriverName, serverConfig) {
+			return errors.NewForbidden(xDeviceV1.Resource("device"), username, fmt.Errorf("user do not have the right to add gpu to device"))
+		}
+	}
+	if gpuToAdd > 0 && !stopped {
+		// running device must not take gpu ahead of devices waiting for it, stopped device waits in queue when it starts
+		waiting, err := builder.gpuQueueWaiting(deployParameter.GpuSet.GpuDriverName, serverConfig)
+		if err != nil {
+			return errors.NewInternalError(err)
+		}
+		if waiting {
+			return errors.NewBadRequest(fmt.Sprintf("devices are waiting in gpu queue for %s, stop device before adding gpu so it starts from queue", deployParameter.GpuSet.GpuDriverName))
+		}
+		free, err := builder.freeGpu(deployParameter.GpuSet.GpuDriverName)
+		if err != nil {
+			return errors.NewInternalError(err)
+		}
+		if gpuToAdd > free {
+			return errors.NewBadRequest(fmt.Sprintf("not enough gpu %s, requested %d more but only %d is free", deployParameter.GpuSet.GpuDriverName, gpuToAdd, free))
+		}
+	}
+
+	desired := deviceUsage{devices: 1}
+	if !stopped {
+		desired = usageOfDeployParameter(deployParameter, serverConfig)
+	}
+
+	builder.quotaLock.Lock()
+	var previous *appsV1.Deployment
+	err = builder.checkDeviceQuota(username, user.Spec.Role, desired.sub(usageOfDeployment(deploy[0], serverConfig)), serverConfig)
+	if err == nil {
+		previous, err = builder.k8sHelper.UpdateDeployment(deployParameter)
+		if err != nil {
+			err = errors.NewInternalError(err)
+		}
+	}
+	builder.quotaLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	err = builder.syncServicePorts(username, reqDevice.Spec.DeviceId, current.Spec.SandboxName, deployParameter, serverConfig)
+	if err != nil {
+		slog.Error("Failed to update service of device, rolling back deployment", "user", username, "error", err)
+		rollbackErr := builder.k8sHelper.RollbackDeployment(previous, builder.kubeClient)
+		if rollbackErr != nil {
+			slog.Error("Failed to roll back deployment of device", "user", username, "error", rollbackErr)
+		}
+		return errors.NewInternalError(err)
+	}
+
+	reqDevice.Spec.DeviceType = "cpu"
+	if deployParameter.GpuSet.Gpu > 0 {
+		reqDevice.Spec.DeviceType = "gpu"
+	}
+	reqDevice.Name = username
+	reqDevice.Spec.DeviceStatus = "Updating"
+	if stopped {
+		reqDevice.Spec.DeviceStatus = DeviceStatusStopped
+	}
+	return nil
+}
+
+// syncServicePorts recreates user service and routes when sandbox ports changed, node ports stay untouched otherwise
+// if new service can not be created we try to bring the old one back before returning error
+func (builder *OpenHydraRouteBuilder) syncServicePorts(username, deviceId, previousSandbox string, deployParameter *k8s.DeploymentParameters, serverConfig *config.OpenHydraServerConfig) error {
+	userLabel := k8s.DeviceLabelSelector(username, deviceId)
+	var owner *appsV1.Deployment
+	if deploy, err := builder.k8sHelper.ListDeploymentWithLabel(userLabel, OpenhydraNamespace, builder.kubeClient); err == nil && len(deploy) > 0 {
+		owner = &deploy[0]
+	}
+	service, err := builder.k8sHelper.GetUserService(userLabel, OpenhydraNamespace, builder.kubeClient)
+	previousPorts := map[string]int{}
+	if err == nil && service != nil {
+		for _, port := range service.Spec.Ports {
+			previousPorts[port.Name] = int(port.Port)
+		}
+		if reflect.DeepEqual(previousPorts, deployParameter.Ports) {
+			return nil
+		}
+		err = builder.k8sHelper.DeleteUserService(userLabel, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = builder.k8sHelper.CreateService(OpenhydraNamespace, username, deviceId, deployParameter.SandboxName, builder.kubeClient, deployParameter.Ports, sandboxServiceType(serverConfig), owner)
+	if err != nil {
+		if len(previousPorts) > 0 {
+			restoreErr := builder.k8sHelper.CreateService(OpenhydraNamespace, username, deviceId, previousSandbox, builder.kubeClient, previousPorts, sandboxServiceType(serverConfig), owner)
+			if restoreErr != nil {
+				slog.Error("Failed to restore service of device", "user", username, "error", restoreErr)
+			}
+		}
+		return err
+	}
+
+	err = builder.k8sHelper.DeleteUserRoute(userLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	return builder.createDeviceRoutes(username, deviceId, deployParameter.Ports, owner, serverConfig)
+}
+
+// freeGpu returns gpu of given driver that is allocatable on nodes but not requested by any pod
+func (builder *OpenHydraRouteBuilder) freeGpu(gpuDriver string) (int64, error) {
+	nodes, err := builder.k8sHelper.GetAllNode(builder.kubeClient)
+	if err != nil {
+		return 0, err
+	}
+	pods, err := builder.k8sHelper.ListPod(OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return 0, err
+	}
+
+	allocatable := resource.NewQuantity(0, resource.DecimalSI)
+	for _, node := range nodes {
+		allocatable.Add(node.Status.Allocatable[coreV1.ResourceName(gpuDriver)])
+	}
+	for _, pod := range pods {
+		for _, ctr := range pod.Spec.Containers {
+			allocatable.Sub(ctr.Resources.Requests[coreV1.ResourceName(gpuDriver)])
+		}
+	}
+	return allocatable.Value(), nil
+}
+
+// fillGpuModels fills gpu model of gpu devices from labels of node their pod is scheduled to
+func (builder *OpenHydraRouteBuilder) fillGpuModels(devices []xDeviceV1.Device, pods []coreV1.Pod, serverConfig *config.OpenHydraServerConfig) {
+	nodes, err := builder.k8sHelper.GetAllNode(builder.kubeClient)
+	if err != nil {
+		slog.Warn("Failed to list node, gpu model is left empty", "error", err)
 		return
 	}
+	nodeLabels := map[string]map[string]string{}
+	for _, node := range nodes {
+		nodeLabels[node.Name] = node.Labels
+	}
+	podNodes := map[string]string{}
+	for _, pod := range pods {
+		podNodes[pod.Name] = pod.Spec.NodeName
+	}
+	for index := range devices {
+		device := &devices[index]
+		if device.Spec.DeviceType != "gpu" || device.Spec.DeviceName == "" {
+			continue
+		}
+		labels, found := nodeLabels[podNodes[device.Spec.DeviceName]]
+		if !found {
+			continue
+		}
+		device.Spec.GpuModel = gpuModelOfNode(device.Spec.GpuDriver, labels, serverConfig)
+	}
+}
 
-	response.WriteAsJson(builder.CacheDevices[request.PathParameter("username")])
+// deviceFromDeployment converts resources in pod template of deployment back to device spec
+// cpu is given in m and memory in Mi as device create expects
+func deviceFromDeployment(deploy appsV1.Deployment, serverConfig *config.OpenHydraServerConfig) xDeviceV1.Device {
+	device := xDeviceV1.Device{}
+	container := deploy.Spec.Template.Spec.Containers[0]
+	device.Name = deploy.Labels[k8s.OpenHydraUserLabelKey]
+	device.Labels = deploy.Spec.Template.Labels
+	device.Spec.OpenHydraUsername = deploy.Labels[k8s.OpenHydraUserLabelKey]
+	device.Spec.DeviceId = k8s.DeviceId(deploy.Labels[k8s.OpenHydraDeviceLabelKey])
+	device.Spec.SandboxName = deploy.Labels[k8s.OpenHydraSandboxKey]
+	device.Spec.DeviceCpu = strconv.FormatInt(container.Resources.Limits.Cpu().MilliValue(), 10)
+	device.Spec.DeviceRam = strconv.FormatInt(container.Resources.Limits.Memory().Value()/(1<<20), 10)
+	device.Spec.Affinity = deploy.Spec.Template.Spec.Affinity
+	device.Spec.DeviceType = "cpu"
+	if driver, gpu := containersGpu(deploy.Spec.Template.Spec.Containers, serverConfig.GpuResourceKeys); gpu > 0 {
+		device.Spec.DeviceType = "gpu"
+		device.Spec.GpuDriver = driver
+		device.Spec.DeviceGpu = uint8(gpu)
+		device.Spec.GpuProfile = gpuProfileOfResourceKey(driver, serverConfig)
+		device.Spec.GpuModel = gpuModelOfAffinity(device.Spec.Affinity, gpuModelLabelKey(driver, serverConfig))
+	}
+	return device
 }
 
 func (builder *OpenHydraRouteBuilder) AddDeviceDeleteRoute() {
 	path := "/" + DevicePath + "/{username}"
 	builder.addPathAuthorization(path, http.MethodDelete, 3)
 	builder.RootWS.Route(builder.RootWS.DELETE(path).Operation("deleteDevice").To(builder.DeviceDeleteRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
 		Returns(http.StatusNotFound, "not found", "").
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
@@ -473,44 +992,316 @@ func (builder *OpenHydraRouteBuilder) DeviceDeleteRouteHandler(request *restful.
 		}
 	}
 
-	err = builder.k8sHelper.DeleteUserDeployment(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, username), OpenhydraNamespace, builder.kubeClient)
+	deviceId := k8s.DeviceId(request.QueryParameter("deviceId"))
+
+	// delete is best effort, error already logged
+	_ = builder.deleteDevice(username, deviceId, serverConfig)
+
+	result := xDeviceV1.Device{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name: fmt.Sprintf("%s-%s", username, "device"),
+		},
+		Spec: xDeviceV1.DeviceSpec{
+			DeviceId:          deviceId,
+			OpenHydraUsername: username,
+			DeviceStatus:      "Terminating",
+		},
+	}
+
+	util.FillKindAndApiVersion(&result.TypeMeta, "Device")
+	response.WriteEntity(&result)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceStopRoute() {
+	path := "/" + DevicePath + "/{username}/stop"
+	builder.addPathAuthorization(path, http.MethodPost, 3)
+	builder.RootWS.Route(builder.RootWS.POST(path).Operation("stopDevice").To(builder.DeviceStopRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.Device{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceStopRouteHandler(request *restful.Request, response *restful.Response) {
+	builder.scaleDeviceRouteHandler(request, response, 0, DeviceStatusStopped)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceStartRoute() {
+	path := "/" + DevicePath + "/{username}/start"
+	builder.addPathAuthorization(path, http.MethodPost, 3)
+	builder.RootWS.Route(builder.RootWS.POST(path).Operation("startDevice").To(builder.DeviceStartRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.Device{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceStartRouteHandler(request *restful.Request, response *restful.Response) {
+	builder.scaleDeviceRouteHandler(request, response, 1, "Creating")
+}
+
+// scaleDeviceRouteHandler scales user deployment to replicas, service is kept so urls of device remain the same
+func (builder *OpenHydraRouteBuilder) scaleDeviceRouteHandler(request *restful.Request, response *restful.Response, replicas int32, status string) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
 	if err != nil {
-		slog.Error("Failed to delete user deployment will proceed to delete service any way", err)
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
 	}
 
-	if serverConfig.PatchResourceNotRelease {
-		// if with certain calico version, we may encounter bug like delete deploy but rs and pod will not be deleted
-		// so we have to manually delete rs and pod
-		err = builder.k8sHelper.DeleteUserReplicaSet(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, username), OpenhydraNamespace, builder.kubeClient)
-		if err != nil {
-			slog.Error("patch:PatchResourceNotRelease -> Failed to delete user replica set will proceed anyway", err)
+	username := request.PathParameter("username")
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
 		}
 
-		err = builder.k8sHelper.DeleteUserPod(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, username), OpenhydraNamespace, builder.kubeClient)
-		if err != nil {
-			slog.Error("patch:PatchResourceNotRelease -> Failed to delete user pod will proceed anyway", err)
+		if reqRole != "1" {
+			// only teacher can stop or start other user device
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to stop or start device for user: %s", reqUser, username))
+				return
+			}
 		}
 	}
 
-	err = builder.k8sHelper.DeleteUserService(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, username), OpenhydraNamespace, builder.kubeClient)
+	deviceId := k8s.DeviceId(request.QueryParameter("deviceId"))
+	userLabel := k8s.DeviceLabelSelector(username, deviceId)
+	deploy, err := builder.k8sHelper.ListDeploymentWithLabel(userLabel, OpenhydraNamespace, builder.kubeClient)
 	if err != nil {
-		slog.Error("Failed to delete user service", err)
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if len(deploy) == 0 {
+		writeHttpResponseAndLogError(response, http.StatusNotFound, fmt.Sprintf("device %s for user %s not found", deviceId, username))
+		return
+	}
+
+	queued := k8s.IsDeploymentQueued(deploy[0])
+	stopped := deploy[0].Spec.Replicas != nil && *deploy[0].Spec.Replicas == 0 && !queued
+	_, gpu := deploymentGpu(deploy[0], serverConfig.GpuResourceKeys)
+
+	builder.quotaLock.Lock()
+	if replicas > 0 && stopped {
+		// starting device takes resources back
+		var user *v1.OpenHydraUser
+		user, err = builder.Database.GetUser(username)
+		if err != nil {
+			builder.quotaLock.Unlock()
+			writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+			return
+		}
+		running := deploy[0].DeepCopy()
+		running.Spec.Replicas = &replicas
+		err = builder.checkDeviceQuota(username, user.Spec.Role, usageOfDeployment(*running, serverConfig).sub(usageOfDeployment(deploy[0], serverConfig)), serverConfig)
+		if err != nil {
+			builder.quotaLock.Unlock()
+			writeAPIStatusError(response, err)
+			return
+		}
+	}
+	switch {
+	case replicas == 0 && queued:
+		// leaving queue
+		err = builder.k8sHelper.DequeueUserDeployment(userLabel, OpenhydraNamespace, 0, builder.kubeClient)
+	case replicas > 0 && queued:
+		// already waiting for gpu
+	case replicas > 0 && stopped && gpu > 0 && serverConfig.GpuQueuePolicy != GpuQueuePolicyDisabled:
+		err = builder.k8sHelper.QueueUserDeployment(userLabel, OpenhydraNamespace, builder.kubeClient)
+		queued = true
+	default:
+		err = builder.k8sHelper.ScaleUserDeployment(userLabel, OpenhydraNamespace, replicas, builder.kubeClient)
+	}
+	builder.quotaLock.Unlock()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to scale device for user %s: %v", username, err))
+		return
 	}
 
 	result := xDeviceV1.Device{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name: fmt.Sprintf("%s-%s", username, "device"),
+			Name: username,
 		},
 		Spec: xDeviceV1.DeviceSpec{
+			DeviceId:          deviceId,
 			OpenHydraUsername: username,
-			DeviceStatus:      "Terminating",
+			DeviceStatus:      status,
 		},
 	}
+	if replicas > 0 && queued {
+		builder.admitFromGpuQueue(&result, serverConfig)
+	}
+	if replicas == 0 {
+		// gpu may be released
+		builder.notifyGpuQueue()
+	}
 
 	util.FillKindAndApiVersion(&result.TypeMeta, "Device")
 	response.WriteEntity(&result)
 }
 
+func (builder *OpenHydraRouteBuilder) AddDeviceEventsRoute() {
+	path := "/" + DevicePath + "/{username}/events"
+	builder.addPathAuthorization(path, http.MethodGet, 3)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listDeviceEvents").To(builder.DeviceEventsRouteHandler).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceEventList{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceEventsRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can get events of other user device
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to get device events for user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	events, err := builder.listDeviceEvents(username, k8s.DeviceId(request.QueryParameter("deviceId")))
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to list device events for user %s: %v", username, err))
+		return
+	}
+
+	result := xDeviceV1.DeviceEventList{Items: events}
+	util.FillKindAndApiVersion(&result.TypeMeta, "DeviceEventList")
+	response.WriteEntity(&result)
+}
+
+// listDeviceEvents collects events of pods, replica sets and deployment of a single device
+// replica set events matter when pod can not even be created, e.g. exceeded resource quota
+func (builder *OpenHydraRouteBuilder) listDeviceEvents(username, deviceId string) ([]xDeviceV1.DeviceEvent, error) {
+	userLabel := k8s.DeviceLabelSelector(username, deviceId)
+	pods, err := builder.k8sHelper.GetUserPods(userLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(userLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	// replica sets inherit device label from pod template of deployment
+	replicaSets, err := builder.k8sHelper.ListReplicaSetWithLabel(userLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []coreV1.ObjectReference
+	for _, pod := range pods {
+		objects = append(objects, coreV1.ObjectReference{Kind: "Pod", Name: pod.Name})
+	}
+	for _, replicaSet := range replicaSets {
+		objects = append(objects, coreV1.ObjectReference{Kind: "ReplicaSet", Name: replicaSet.Name})
+	}
+	for _, deploy := range deploys {
+		objects = append(objects, coreV1.ObjectReference{Kind: "Deployment", Name: deploy.Name})
+	}
+
+	var events []coreV1.Event
+	for _, object := range objects {
+		selector := fields.Set{"involvedObject.kind": object.Kind, "involvedObject.name": object.Name}
+		objectEvents, err := builder.k8sHelper.ListEvents(OpenhydraNamespace, selector.String(), builder.kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, objectEvents...)
+	}
+
+	return combineDeviceEvents(events), nil
+}
+
+// deleteDevice deletes all k8s resources of a single device of user
+// every step is tried even if previous one failed so we release as much as we can, the first error is returned
+func (builder *OpenHydraRouteBuilder) deleteDevice(username, deviceId string, serverConfig *config.OpenHydraServerConfig) error {
+	selectors := []string{k8s.DeviceLabelSelector(username, deviceId)}
+	if k8s.DeviceId(deviceId) == k8s.OpenHydraDefaultDeviceId {
+		// resources created before user can own multiple devices have no device label, they belong to default device
+		selectors = append(selectors, k8s.LegacyDeviceLabelSelector(username))
+	}
+
+	var firstErr error
+	for _, selector := range selectors {
+		err := builder.k8sHelper.DeleteUserDeployment(selector, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			slog.Error("Failed to delete user deployment will proceed to delete service any way", "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		if serverConfig.PatchResourceNotRelease {
+			// if with certain calico version, we may encounter bug like delete deploy but rs and pod will not be deleted
+			// so we have to manually delete rs and pod
+			err = builder.k8sHelper.DeleteUserReplicaSet(selector, OpenhydraNamespace, builder.kubeClient)
+			if err != nil {
+				slog.Error("patch:PatchResourceNotRelease -> Failed to delete user replica set will proceed anyway", "error", err)
+			}
+
+			err = builder.k8sHelper.DeleteUserPod(selector, OpenhydraNamespace, builder.kubeClient)
+			if err != nil {
+				slog.Error("patch:PatchResourceNotRelease -> Failed to delete user pod will proceed anyway", "error", err)
+			}
+		}
+
+		err = builder.k8sHelper.DeleteUserService(selector, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			slog.Error("Failed to delete user service", "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+
+		err = builder.k8sHelper.DeleteUserRoute(selector, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			slog.Error("Failed to delete user route", "error", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	// gpu may be released
+	builder.notifyGpuQueue()
+
+	return firstErr
+}
+
 func (builder *OpenHydraRouteBuilder) GetCpu(postDevice xDeviceV1.Device, serverConfig *config.OpenHydraServerConfig) (string, string) {
 	cpuReq := serverConfig.DefaultCpuPerDevice
 	cpuLimit := serverConfig.DefaultCpuPerDevice
@@ -0,0 +1,172 @@
+package openhydra
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	"github.com/emicklei/go-restful/v3"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+func (builder *OpenHydraRouteBuilder) AddDeviceLogRoute() {
+	path := "/" + DevicePath + "/{username}/log"
+	builder.addPathAuthorization(path, http.MethodGet, 3)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getDeviceLog").To(builder.DeviceLogRouteHandler).
+		Produces("text/plain", restful.MIME_JSON).
+		Param(builder.RootWS.QueryParameter("deviceId", "id of device, default device if not set")).
+		Param(builder.RootWS.QueryParameter("follow", "keep streaming new log lines until client disconnects").DataType("boolean")).
+		Param(builder.RootWS.QueryParameter("tailLines", "number of lines from the end of log to show").DataType("integer")).
+		Param(builder.RootWS.QueryParameter("sinceSeconds", "only show log newer than given seconds").DataType("integer")).
+		Param(builder.RootWS.QueryParameter("previous", "show log of previous terminated container").DataType("boolean")).
+		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", ""))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceLogRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can get log of other user device
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to get device log for user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	logOptions, err := parseDeviceLogOptions(request)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	deviceId := k8s.DeviceId(request.QueryParameter("deviceId"))
+	pods, err := builder.k8sHelper.GetUserPods(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pod := pickDevicePod(pods)
+	if pod == nil {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId)))
+		return
+	}
+	// first container is always the sandbox
+	logOptions.Container = pod.Spec.Containers[0].Name
+
+	stream, err := builder.k8sHelper.GetPodLogs(request.Request.Context(), pod.Namespace, pod.Name, logOptions, builder.kubeClient)
+	if err != nil {
+		// e.g. container is still waiting to start, status code from k8s is kept
+		writeAPIStatusError(response, err)
+		return
+	}
+	defer stream.Close()
+
+	response.AddHeader("Content-Type", "text/plain; charset=utf-8")
+	response.WriteHeader(http.StatusOK)
+	buffer := make([]byte, 4096)
+	for {
+		n, readErr := stream.Read(buffer)
+		if n > 0 {
+			if _, writeErr := response.Write(buffer[:n]); writeErr != nil {
+				// client is gone
+				return
+			}
+			response.Flush()
+		}
+		if readErr != nil {
+			if readErr != io.EOF {
+				slog.Warn("Failed to read device log stream", "pod", pod.Name, "error", readErr)
+			}
+			return
+		}
+	}
+}
+
+func parseDeviceLogOptions(request *restful.Request) (*coreV1.PodLogOptions, error) {
+	options := &coreV1.PodLogOptions{}
+	var err error
+	if value := request.QueryParameter("follow"); value != "" {
+		options.Follow, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid follow: %s", value)
+		}
+	}
+	if value := request.QueryParameter("previous"); value != "" {
+		options.Previous, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid previous: %s", value)
+		}
+	}
+	if value := request.QueryParameter("tailLines"); value != "" {
+		tailLines, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || tailLines < 0 {
+			return nil, fmt.Errorf("invalid tailLines: %s", value)
+		}
+		options.TailLines = &tailLines
+	}
+	if value := request.QueryParameter("sinceSeconds"); value != "" {
+		sinceSeconds, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || sinceSeconds <= 0 {
+			return nil, fmt.Errorf("invalid sinceSeconds: %s", value)
+		}
+		options.SinceSeconds = &sinceSeconds
+	}
+	return options, nil
+}
+
+// pickDevicePod returns the newest pod which is not terminating, during update there might be two pods of a device
+// terminating pod is only returned when nothing else is left
+func pickDevicePod(pods []coreV1.Pod) *coreV1.Pod {
+	var result *coreV1.Pod
+	for index := range pods {
+		pod := &pods[index]
+		if len(pod.Spec.Containers) == 0 {
+			continue
+		}
+		if result == nil {
+			result = pod
+			continue
+		}
+		if (result.DeletionTimestamp != nil) != (pod.DeletionTimestamp != nil) {
+			if pod.DeletionTimestamp == nil {
+				result = pod
+			}
+			continue
+		}
+		if result.CreationTimestamp.Before(&pod.CreationTimestamp) {
+			result = pod
+		}
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	appsV1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// deployment younger than it may still be in the middle of create, reconciler leaves it to create
+const deviceReconcileGracePeriod = time.Minute
+
+// deploymentPorts returns ports of sandbox container of deployment by port name
+func deploymentPorts(deploy *appsV1.Deployment) map[string]int {
+	ports := map[string]int{}
+	if len(deploy.Spec.Template.Spec.Containers) == 0 {
+		return ports
+	}
+	for _, port := range deploy.Spec.Template.Spec.Containers[0].Ports {
+		ports[port.Name] = int(port.ContainerPort)
+	}
+	return ports
+}
+
+// repairDevice creates service and routes of device whose deployment is left without service
+// service created by someone else meanwhile is taken as repaired, routes are recreated since they may be partly created
+func (builder *OpenHydraRouteBuilder) repairDevice(deploy *appsV1.Deployment, serverConfig *config.OpenHydraServerConfig) error {
+	username := deploy.Labels[k8s.OpenHydraUserLabelKey]
+	deviceId := deploy.Labels[k8s.OpenHydraDeviceLabelKey]
+	ports := deploymentPorts(deploy)
+	err := builder.k8sHelper.CreateService(OpenhydraNamespace, username, deviceId, deploy.Labels[k8s.OpenHydraSandboxKey], builder.kubeClient, ports, sandboxServiceType(serverConfig), deploy)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create service of device %s of user %s: %v", deviceId, username, err)
+	}
+
+	err = builder.k8sHelper.DeleteUserRoute(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	return builder.createDeviceRoutes(username, deviceId, ports, deploy, serverConfig)
+}
+
+// rollbackDevice deletes whatever an unfinished create of device left behind
+// every step is tried even if previous one failed, failures are only logged since resources are owned by deployment anyway
+func (builder *OpenHydraRouteBuilder) rollbackDevice(deviceLabel string) {
+	err := builder.k8sHelper.DeleteUserRoute(deviceLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		slog.Error("Failed to delete route of device on rollback", "device", deviceLabel, "error", err)
+	}
+	err = builder.k8sHelper.DeleteUserService(deviceLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		slog.Error("Failed to delete service of device on rollback", "device", deviceLabel, "error", err)
+	}
+	err = builder.k8sHelper.DeleteUserDeployment(deviceLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		slog.Error("Failed to delete deployment of device on rollback", "device", deviceLabel, "error", err)
+	}
+
+	// gpu may be released
+	builder.notifyGpuQueue()
+}
+
+// migrateLegacyDevices labels devices created before user can own multiple devices as default device of their user
+// so every device api finds them by device label
+func (builder *OpenHydraRouteBuilder) migrateLegacyDevices() error {
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s,!%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue, k8s.OpenHydraDeviceLabelKey), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	for _, deploy := range deploys {
+		username := deploy.Labels[k8s.OpenHydraUserLabelKey]
+		slog.Info("Labeling device created before user can own multiple devices as default device, its pod is replaced once", "user", username)
+		if err := builder.k8sHelper.LabelLegacyDevice(username, OpenhydraNamespace, builder.kubeClient); err != nil {
+			// other users are still migrated
+			slog.Error("Failed to label legacy device", "user", username, "error", err)
+		}
+	}
+	return nil
+}
+
+// reconcileDevices repairs devices whose deployment has no service, e.g. server stopped in the middle of create
+// legacy devices are labeled first so they are not taken as devices without service
+func (builder *OpenHydraRouteBuilder) reconcileDevices(serverConfig *config.OpenHydraServerConfig) error {
+	if err := builder.migrateLegacyDevices(); err != nil {
+		return err
+	}
+
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s,%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue, k8s.OpenHydraDeviceLabelKey), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+
+	for index := range deploys {
+		deploy := &deploys[index]
+		if deploy.DeletionTimestamp != nil || time.Since(deploy.CreationTimestamp.Time) < deviceReconcileGracePeriod {
+			continue
+		}
+		deviceLabel := k8s.DeviceLabelSelector(deploy.Labels[k8s.OpenHydraUserLabelKey], deploy.Labels[k8s.OpenHydraDeviceLabelKey])
+		if _, err := builder.k8sHelper.GetUserService(deviceLabel, OpenhydraNamespace, builder.kubeClient); err == nil {
+			continue
+		}
+		slog.Info("Repairing device without service", "device", deviceLabel)
+		if err := builder.repairDevice(deploy, serverConfig); err != nil {
+			// other devices are still repaired
+			slog.Error("Failed to repair device", "device", deviceLabel, "error", err)
+		}
+	}
+	return nil
+}
+
+// RunDeviceReconciler repairs devices and network policies of their users periodically until stopChan is closed
+func (builder *OpenHydraRouteBuilder) RunDeviceReconciler(stopChan <-chan struct{}) {
+	interval := time.Duration(builder.cfg.DeviceReconcileIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	reconcile := func() {
+		serverConfig, err := builder.GetServerConfigFromConfigMap()
+		if err != nil {
+			slog.Error("Failed to get server config for device reconciler", "error", err)
+			return
+		}
+		if err := builder.reconcileDevices(serverConfig); err != nil {
+			slog.Error("Failed to reconcile devices", "error", err)
+		}
+		if err := builder.reconcileNetworkPolicies(serverConfig); err != nil {
+			slog.Error("Failed to reconcile network policies", "error", err)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// legacy devices are labeled right after start rather than after first interval
+		reconcile()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+			}
+			reconcile()
+		}
+	}()
+}
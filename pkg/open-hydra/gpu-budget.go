@@ -0,0 +1,285 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+	"open-hydra/pkg/util"
+
+	"github.com/emicklei/go-restful/v3"
+	appsV1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	BudgetPeriodDay   = "day"
+	BudgetPeriodWeek  = "week"
+	BudgetPeriodMonth = "month"
+	BudgetScopeUser   = "user"
+	BudgetScopeGroup  = "group"
+	// reason shown on running gpu device while it is about to be stopped
+	DeviceReasonGpuBudgetExhausted = "GpuBudgetExhausted"
+)
+
+// budgetPeriod returns start of budget period now is in and when next one starts
+func budgetPeriod(period string, now time.Time) (time.Time, time.Time) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case BudgetPeriodDay:
+		return day, day.AddDate(0, 0, 1)
+	case BudgetPeriodMonth:
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		return start, start.AddDate(0, 0, 7)
+	}
+}
+
+// gpuHoursByUser sums up gpu hours every user used in [from, now) from usage ledger
+func (builder *OpenHydraRouteBuilder) gpuHoursByUser(from, now time.Time, serverConfig *config.OpenHydraServerConfig) (map[string]float64, error) {
+	records, err := builder.Database.ListUsageRecords(from, now)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]float64{}
+	for _, item := range usageReport(records.Items, from, now, now, UsageGroupByUser, serverConfig) {
+		result[item.Key] = item.GpuHours
+	}
+	return result, nil
+}
+
+func gpuBudgetUsage(scope, name string, gpuHours, used float64) xDeviceV1.GpuBudgetUsage {
+	remaining := gpuHours - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return xDeviceV1.GpuBudgetUsage{Scope: scope, Name: name, GpuHours: gpuHours, Used: used, Remaining: remaining}
+}
+
+// gpuBudgets returns budget of user followed by budgets of groups of user, group budget is used by all members together
+// budget not set is left out
+func gpuBudgets(username string, role int, used map[string]float64, serverConfig *config.OpenHydraServerConfig) []xDeviceV1.GpuBudgetUsage {
+	if serverConfig.Quota == nil {
+		return nil
+	}
+	var result []xDeviceV1.GpuBudgetUsage
+	if gpuHours := userQuota(username, role, serverConfig).GpuHours; gpuHours != nil {
+		result = append(result, gpuBudgetUsage(BudgetScopeUser, username, *gpuHours, used[username]))
+	}
+	for _, group := range userGroups(username, serverConfig) {
+		if group.Quota.GpuHours == nil {
+			continue
+		}
+		groupUsed := 0.0
+		for _, member := range group.Members {
+			groupUsed += used[member]
+		}
+		result = append(result, gpuBudgetUsage(BudgetScopeGroup, group.Name, *group.Quota.GpuHours, groupUsed))
+	}
+	return result
+}
+
+// exhaustedGpuBudget returns first budget used up, nil when none is
+func exhaustedGpuBudget(budgets []xDeviceV1.GpuBudgetUsage) *xDeviceV1.GpuBudgetUsage {
+	for index := range budgets {
+		if budgets[index].Remaining <= 0 {
+			return &budgets[index]
+		}
+	}
+	return nil
+}
+
+// checkGpuBudget returns forbidden error when gpu hours budget of user or any group of user is used up
+func (builder *OpenHydraRouteBuilder) checkGpuBudget(username string, role int, serverConfig *config.OpenHydraServerConfig) error {
+	if len(gpuBudgets(username, role, nil, serverConfig)) == 0 {
+		return nil
+	}
+	now := time.Now()
+	from, resetAt := budgetPeriod(serverConfig.Quota.BudgetPeriod, now)
+	used, err := builder.gpuHoursByUser(from, now, serverConfig)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	if budget := exhaustedGpuBudget(gpuBudgets(username, role, used, serverConfig)); budget != nil {
+		return errors.NewForbidden(xDeviceV1.Resource("device"), username, fmt.Errorf("gpu hours budget of %s %s is used up, used %.2f of %.2f until %s", budget.Scope, budget.Name, budget.Used, budget.GpuHours, resetAt.Format(time.RFC3339)))
+	}
+	return nil
+}
+
+// enforceGpuBudgets stops running gpu devices of users whose budget is used up once grace period is over
+// devices keep running with a warning meanwhile, user getting budget back e.g. in new period is let go
+func (builder *OpenHydraRouteBuilder) enforceGpuBudgets(serverConfig *config.OpenHydraServerConfig) error {
+	stops := map[string]time.Time{}
+	defer builder.setGpuBudgetStops(stops)
+	if serverConfig.Quota == nil || !serverConfig.Quota.StopGpuDevicesOnBudgetExhausted {
+		return nil
+	}
+
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	// username -> running gpu devices
+	running := map[string][]appsV1.Deployment{}
+	for _, deploy := range deploys {
+		if deploy.Spec.Replicas == nil || *deploy.Spec.Replicas == 0 {
+			continue
+		}
+		if _, gpu := deploymentGpu(deploy, serverConfig.GpuResourceKeys); gpu > 0 {
+			username := deploy.Labels[k8s.OpenHydraUserLabelKey]
+			running[username] = append(running[username], deploy)
+		}
+	}
+	if len(running) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	from, _ := budgetPeriod(serverConfig.Quota.BudgetPeriod, now)
+	used, err := builder.gpuHoursByUser(from, now, serverConfig)
+	if err != nil {
+		return err
+	}
+	grace := time.Duration(serverConfig.Quota.BudgetGracePeriodSeconds) * time.Second
+	for username, gpuDeploys := range running {
+		user, err := builder.Database.GetUser(username)
+		if err != nil {
+			slog.Error("Failed to get user for gpu budget", "user", username, "error", err)
+			continue
+		}
+		if exhaustedGpuBudget(gpuBudgets(username, user.Spec.Role, used, serverConfig)) == nil {
+			continue
+		}
+		stopAt, found := builder.getGpuBudgetStop(username)
+		if !found {
+			stopAt = now.Add(grace)
+			slog.Warn("Gpu hours budget of user is used up, gpu devices are stopped", "user", username, "stopAt", stopAt)
+		}
+		if now.Before(stopAt) {
+			stops[username] = stopAt
+			continue
+		}
+		for _, deploy := range gpuDeploys {
+			deviceLabel := k8s.DeviceLabelSelector(username, deploy.Labels[k8s.OpenHydraDeviceLabelKey])
+			if err := builder.k8sHelper.ScaleUserDeployment(deviceLabel, OpenhydraNamespace, 0, builder.kubeClient); err != nil {
+				// tried again on next sync
+				slog.Error("Failed to stop gpu device of user whose budget is used up", "device", deviceLabel, "error", err)
+				stops[username] = stopAt
+			}
+		}
+		// gpu is released
+		builder.notifyGpuQueue()
+	}
+	return nil
+}
+
+func (builder *OpenHydraRouteBuilder) setGpuBudgetStops(stops map[string]time.Time) {
+	builder.gpuBudgetLock.Lock()
+	defer builder.gpuBudgetLock.Unlock()
+	builder.gpuBudgetStops = stops
+}
+
+func (builder *OpenHydraRouteBuilder) getGpuBudgetStop(username string) (time.Time, bool) {
+	builder.gpuBudgetLock.RLock()
+	defer builder.gpuBudgetLock.RUnlock()
+	stopAt, found := builder.gpuBudgetStops[username]
+	return stopAt, found
+}
+
+// fillGpuBudgetWarnings warns on gpu devices about to be stopped since budget of their user is used up
+func (builder *OpenHydraRouteBuilder) fillGpuBudgetWarnings(devices []xDeviceV1.Device) {
+	for index := range devices {
+		device := &devices[index]
+		if device.Spec.DeviceType != "gpu" || device.Status.Reason != "" {
+			continue
+		}
+		stopAt, found := builder.getGpuBudgetStop(device.Spec.OpenHydraUsername)
+		if !found {
+			continue
+		}
+		device.Status.Reason = DeviceReasonGpuBudgetExhausted
+		device.Status.Message = fmt.Sprintf("gpu hours budget is used up, device is stopped at %s", stopAt.Format(time.RFC3339))
+	}
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceBudgetRoute() {
+	path := "/" + DevicePath + "/{username}/budget"
+	builder.addPathAuthorization(path, http.MethodGet, 3)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getDeviceBudget").To(builder.DeviceBudgetRouteHandler).
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceBudget{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceBudgetRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can get budget of other user
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to get budget of user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	user, err := builder.Database.GetUser(username)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusNotFound, fmt.Sprintf("user %s not found", username))
+		return
+	}
+
+	period := BudgetPeriodWeek
+	if serverConfig.Quota != nil && serverConfig.Quota.BudgetPeriod != "" {
+		period = serverConfig.Quota.BudgetPeriod
+	}
+	now := time.Now()
+	from, resetAt := budgetPeriod(period, now)
+	used, err := builder.gpuHoursByUser(from, now, serverConfig)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := xDeviceV1.DeviceBudget{}
+	util.FillKindAndApiVersion(&result.TypeMeta, "DeviceBudget")
+	result.Name = username
+	result.Spec.OpenHydraUsername = username
+	result.Spec.Period = period
+	result.Spec.From = metaV1.NewTime(from)
+	result.Spec.ResetAt = metaV1.NewTime(resetAt)
+	result.Spec.Budgets = gpuBudgets(username, user.Spec.Role, used, serverConfig)
+	result.Spec.Exhausted = exhaustedGpuBudget(result.Spec.Budgets) != nil
+	if stopAt, found := builder.getGpuBudgetStop(username); found {
+		result.Spec.StopAt = &metaV1.Time{Time: stopAt}
+	}
+	_ = response.WriteEntity(result)
+}
@@ -0,0 +1,157 @@
+package openhydra
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	xSumUpV1 "open-hydra/pkg/apis/open-hydra-api/summary/core/v1"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// gpuModelLabelKey returns node label telling model of gpu of given driver, empty when there is none
+// driver without label configured uses label of any configured driver from the same vendor domain, e.g. nvidia.com/mig-1g.5gb uses nvidia.com/gpu
+func gpuModelLabelKey(gpuDriver string, serverConfig *config.OpenHydraServerConfig) string {
+	if labelKey, found := serverConfig.GpuModelNodeLabels[gpuDriver]; found {
+		return labelKey
+	}
+	domain, _, found := strings.Cut(gpuDriver, "/")
+	if !found {
+		return ""
+	}
+	resourceKeys := make([]string, 0, len(serverConfig.GpuModelNodeLabels))
+	for resourceKey := range serverConfig.GpuModelNodeLabels {
+		resourceKeys = append(resourceKeys, resourceKey)
+	}
+	sort.Strings(resourceKeys)
+	for _, resourceKey := range resourceKeys {
+		if strings.HasPrefix(resourceKey, domain+"/") {
+			return serverConfig.GpuModelNodeLabels[resourceKey]
+		}
+	}
+	return ""
+}
+
+// gpuModelOfNode returns gpu model of given gpu driver from node labels
+func gpuModelOfNode(gpuDriver string, nodeLabels map[string]string, serverConfig *config.OpenHydraServerConfig) string {
+	labelKey := gpuModelLabelKey(gpuDriver, serverConfig)
+	if labelKey == "" {
+		return ""
+	}
+	return nodeLabels[labelKey]
+}
+
+// gpuModelOfAffinity returns gpu model device is pinned to by withGpuModelAffinity, empty when it is not pinned
+func gpuModelOfAffinity(affinity *coreV1.Affinity, labelKey string) string {
+	if labelKey == "" || affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expression := range term.MatchExpressions {
+			if expression.Key == labelKey && expression.Operator == coreV1.NodeSelectorOpIn && len(expression.Values) == 1 {
+				return expression.Values[0]
+			}
+		}
+	}
+	return ""
+}
+
+// withGpuModelAffinity returns copy of affinity that only allows nodes labeled with gpu model
+// requirement is added to every node selector term since terms are ORed, requirement of the same label key is replaced
+func withGpuModelAffinity(affinity *coreV1.Affinity, labelKey, model string) *coreV1.Affinity {
+	result := affinity.DeepCopy()
+	if result == nil {
+		result = &coreV1.Affinity{}
+	}
+	if result.NodeAffinity == nil {
+		result.NodeAffinity = &coreV1.NodeAffinity{}
+	}
+	if result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = &coreV1.NodeSelector{}
+	}
+	selector := result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(selector.NodeSelectorTerms) == 0 {
+		selector.NodeSelectorTerms = []coreV1.NodeSelectorTerm{{}}
+	}
+	requirement := coreV1.NodeSelectorRequirement{Key: labelKey, Operator: coreV1.NodeSelectorOpIn, Values: []string{model}}
+	for index := range selector.NodeSelectorTerms {
+		var expressions []coreV1.NodeSelectorRequirement
+		for _, expression := range selector.NodeSelectorTerms[index].MatchExpressions {
+			if expression.Key != labelKey {
+				expressions = append(expressions, expression)
+			}
+		}
+		selector.NodeSelectorTerms[index].MatchExpressions = append(expressions, requirement)
+	}
+	return result
+}
+
+// gpuModelCatalog sums up gpu of every model found on node labels, gpu allocated is counted from pods scheduled to node
+func gpuModelCatalog(nodes []coreV1.Node, pods []coreV1.Pod, serverConfig *config.OpenHydraServerConfig) map[string]xSumUpV1.GpuModelSumUp {
+	podsOfNode := map[string][]coreV1.Pod{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName != "" {
+			podsOfNode[pod.Spec.NodeName] = append(podsOfNode[pod.Spec.NodeName], pod)
+		}
+	}
+	result := map[string]xSumUpV1.GpuModelSumUp{}
+	for _, node := range nodes {
+		counted := map[string]bool{}
+		for _, gpuResourceKey := range serverConfig.GpuResourceKeys {
+			allocatable := node.Status.Allocatable[coreV1.ResourceName(gpuResourceKey)]
+			if allocatable.IsZero() {
+				continue
+			}
+			model := gpuModelOfNode(gpuResourceKey, node.Labels, serverConfig)
+			if model == "" {
+				continue
+			}
+			allocated := resource.NewQuantity(0, resource.DecimalSI)
+			for _, pod := range podsOfNode[node.Name] {
+				for _, ctr := range pod.Spec.Containers {
+					allocated.Add(ctr.Resources.Requests[coreV1.ResourceName(gpuResourceKey)])
+				}
+			}
+			sumUp := result[model]
+			if !counted[model] {
+				sumUp.Nodes++
+				counted[model] = true
+			}
+			if sumUp.GpuResourceSumUp == nil {
+				sumUp.GpuResourceSumUp = map[string]xSumUpV1.GpuResourceSumUp{}
+			}
+			resourceSumUp := sumUp.GpuResourceSumUp[gpuResourceKey]
+			resourceSumUp.Allocatable += allocatable.Value()
+			resourceSumUp.Allocated += allocated.Value()
+			sumUp.GpuResourceSumUp[gpuResourceKey] = resourceSumUp
+			result[model] = sumUp
+		}
+	}
+	return result
+}
+
+// resolveGpuModel pins gpu device asking for gpu model to nodes of that model by node affinity
+// model must be found on some node with gpu of device driver
+func (builder *OpenHydraRouteBuilder) resolveGpuModel(device *xDeviceV1.Device, serverConfig *config.OpenHydraServerConfig) error {
+	if device.Spec.GpuModel == "" {
+		return nil
+	}
+	labelKey := gpuModelLabelKey(device.Spec.GpuDriver, serverConfig)
+	if labelKey == "" {
+		return errors.NewBadRequest(fmt.Sprintf("no gpu model node label is configured for gpu driver %s", device.Spec.GpuDriver))
+	}
+	nodes, err := builder.k8sHelper.GetAllNode(builder.kubeClient)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	if _, found := gpuModelCatalog(nodes, nil, serverConfig)[device.Spec.GpuModel].GpuResourceSumUp[device.Spec.GpuDriver]; !found {
+		return errors.NewBadRequest(fmt.Sprintf("gpu model %s with gpu driver %s is not found on any node", device.Spec.GpuModel, device.Spec.GpuDriver))
+	}
+	device.Spec.Affinity = withGpuModelAffinity(device.Spec.Affinity, labelKey, device.Spec.GpuModel)
+	return nil
+}
@@ -0,0 +1,119 @@
+package openhydra
+
+import (
+	"fmt"
+	"sort"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	xSumUpV1 "open-hydra/pkg/apis/open-hydra-api/summary/core/v1"
+	"open-hydra/pkg/open-hydra/apis"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// whole physical gpu card
+	GpuProfileTypeWhole = "whole"
+	// replica of a gpu card shared by time-slicing
+	GpuProfileTypeShared = "shared"
+	// mig slice of a gpu card
+	GpuProfileTypeMig = "mig"
+)
+
+// resolveGpuProfile sets gpu driver of device to resource key of gpu profile it asks for
+// device asking for profile without gpu number gets 1 of it, nothing is changed when no profile is given
+func resolveGpuProfile(device *xDeviceV1.Device, serverConfig *config.OpenHydraServerConfig) error {
+	if device.Spec.GpuProfile == "" {
+		return nil
+	}
+	profile, found := serverConfig.GpuProfiles[device.Spec.GpuProfile]
+	if !found {
+		return errors.NewBadRequest(fmt.Sprintf("gpu profile %s is not found", device.Spec.GpuProfile))
+	}
+	if device.Spec.GpuDriver != "" && device.Spec.GpuDriver != profile.ResourceKey {
+		return errors.NewBadRequest(fmt.Sprintf("gpu driver %s does not match resource key %s of gpu profile %s", device.Spec.GpuDriver, profile.ResourceKey, device.Spec.GpuProfile))
+	}
+	device.Spec.GpuDriver = profile.ResourceKey
+	if device.Spec.DeviceGpu == 0 {
+		device.Spec.DeviceGpu = 1
+	}
+	return nil
+}
+
+// gpuProfileOfResourceKey returns name of gpu profile using given resource key or empty if there is none
+// names are sorted so result is stable even if profiles share resource key by mistake
+func gpuProfileOfResourceKey(resourceKey string, serverConfig *config.OpenHydraServerConfig) string {
+	if resourceKey == "" {
+		return ""
+	}
+	names := make([]string, 0, len(serverConfig.GpuProfiles))
+	for name := range serverConfig.GpuProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if serverConfig.GpuProfiles[name].ResourceKey == resourceKey {
+			return name
+		}
+	}
+	return ""
+}
+
+// gpuImageKey returns key of sandbox gpuImageSet for device
+// image key of profile goes first, then profile name if sandbox has it, then gpu driver
+func gpuImageKey(device xDeviceV1.Device, sandbox apis.Sandbox, serverConfig *config.OpenHydraServerConfig) string {
+	if profile, found := serverConfig.GpuProfiles[device.Spec.GpuProfile]; found {
+		if profile.ImageKey != "" {
+			return profile.ImageKey
+		}
+		if _, found := sandbox.GPUImageSet[device.Spec.GpuProfile]; found {
+			return device.Spec.GpuProfile
+		}
+	}
+	return device.Spec.GpuDriver
+}
+
+// containersGpu returns the first gpu resource key containers request and number of it summed up over all containers
+func containersGpu(containers []coreV1.Container, gpuResourceKeys []string) (string, int64) {
+	for _, gpuResourceKey := range gpuResourceKeys {
+		total := resource.NewQuantity(0, resource.DecimalSI)
+		for _, container := range containers {
+			total.Add(container.Resources.Requests[coreV1.ResourceName(gpuResourceKey)])
+		}
+		if total.Value() > 0 {
+			return gpuResourceKey, total.Value()
+		}
+	}
+	return "", 0
+}
+
+// sumUpGpuProfiles counts allocatable gpu of every profile on nodes and allocated gpu of it by pods
+func sumUpGpuProfiles(pods []coreV1.Pod, nodeList *coreV1.NodeList, profiles map[string]config.GpuProfile) map[string]xSumUpV1.GpuProfileSumUp {
+	if len(profiles) == 0 {
+		return nil
+	}
+	result := map[string]xSumUpV1.GpuProfileSumUp{}
+	for name, profile := range profiles {
+		key := coreV1.ResourceName(profile.ResourceKey)
+		allocatable := resource.NewQuantity(0, resource.DecimalSI)
+		allocated := resource.NewQuantity(0, resource.DecimalSI)
+		for _, node := range nodeList.Items {
+			allocatable.Add(node.Status.Allocatable[key])
+		}
+		for _, pod := range pods {
+			for _, ctr := range pod.Spec.Containers {
+				allocated.Add(ctr.Resources.Requests[key])
+			}
+		}
+		result[name] = xSumUpV1.GpuProfileSumUp{
+			Type:        profile.Type,
+			ResourceKey: profile.ResourceKey,
+			Allocatable: allocatable.Value(),
+			Allocated:   allocated.Value(),
+		}
+	}
+	return result
+}
@@ -0,0 +1,354 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// higher priority first, then user holding less gpu, then groups of user holding less gpu, then first come first served
+	GpuQueuePolicyFairShare = "fair-share"
+	// first come first served only
+	GpuQueuePolicyFifo = "fifo"
+	// gpu device is created right away and its pod stays pending until gpu is free
+	GpuQueuePolicyDisabled = "disabled"
+)
+
+// gpuQueueEntry is a gpu device waiting in queue
+type gpuQueueEntry struct {
+	// device label selector, identifies device in queue
+	label    string
+	username string
+	driver   string
+	gpu      int64
+	priority int32
+	queuedAt time.Time
+	// gpu held by all members of groups of user
+	groupUsage int64
+}
+
+// gpuQueuePosition is what a queued device reports to user
+type gpuQueuePosition struct {
+	// 1-based position among devices waiting for the same gpu driver
+	lineNo int
+	// -1 when device asks for more gpu than cluster has
+	estimatedWaitSeconds int64
+}
+
+// gpuHolder is a device holding gpu, it is expected to release gpu at releaseAt
+type gpuHolder struct {
+	gpu       int64
+	releaseAt time.Time
+}
+
+func gpuQueueSelector() string {
+	return fmt.Sprintf("%s=%s", k8s.OpenHydraQueueLabelKey, k8s.OpenHydraQueueLabelValue)
+}
+
+// deploymentGpu returns gpu driver and number of gpu requested by pod template of deployment
+func deploymentGpu(deploy appsV1.Deployment, gpuResourceKeys []string) (string, int64) {
+	return containersGpu(deploy.Spec.Template.Spec.Containers, gpuResourceKeys)
+}
+
+func gpuQueueEntryFromDeployment(deploy appsV1.Deployment, gpuResourceKeys []string) gpuQueueEntry {
+	entry := gpuQueueEntry{
+		label:    k8s.DeviceLabelSelector(deploy.Labels[k8s.OpenHydraUserLabelKey], deploy.Labels[k8s.OpenHydraDeviceLabelKey]),
+		username: deploy.Labels[k8s.OpenHydraUserLabelKey],
+		queuedAt: deploy.CreationTimestamp.Time,
+	}
+	entry.driver, entry.gpu = deploymentGpu(deploy, gpuResourceKeys)
+	if queuedAt, err := time.Parse(time.RFC3339Nano, deploy.Annotations[k8s.OpenHydraQueuedAtAnnotation]); err == nil {
+		entry.queuedAt = queuedAt
+	}
+	if priority, err := strconv.Atoi(deploy.Annotations[k8s.OpenHydraQueuePriorityAnnotation]); err == nil {
+		entry.priority = int32(priority)
+	}
+	return entry
+}
+
+// groupGpuUsage sums gpu held by members of all groups of user, user in no group has none
+func groupGpuUsage(username string, usage map[string]int64, serverConfig *config.OpenHydraServerConfig) int64 {
+	var result int64
+	for _, group := range userGroups(username, serverConfig) {
+		for _, member := range group.Members {
+			result += usage[member]
+		}
+	}
+	return result
+}
+
+// sortGpuQueue orders entries by policy, usage is gpu currently held by each user
+func sortGpuQueue(entries []gpuQueueEntry, policy string, usage map[string]int64) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if policy == GpuQueuePolicyFairShare {
+			if entries[i].priority != entries[j].priority {
+				return entries[i].priority > entries[j].priority
+			}
+			if usage[entries[i].username] != usage[entries[j].username] {
+				return usage[entries[i].username] < usage[entries[j].username]
+			}
+			if entries[i].groupUsage != entries[j].groupUsage {
+				return entries[i].groupUsage < entries[j].groupUsage
+			}
+		}
+		return entries[i].queuedAt.Before(entries[j].queuedAt)
+	})
+}
+
+// estimateGpuWait simulates gpu release of holders and entries admitted before, every device is expected to hold gpu
+// for session, entries must be sorted and of the same driver, wait in seconds is returned in the same order
+func estimateGpuWait(entries []gpuQueueEntry, free int64, holders []gpuHolder, now time.Time, session time.Duration) []int64 {
+	capacity := free
+	for _, holder := range holders {
+		capacity += holder.gpu
+	}
+	pending := make([]gpuHolder, len(holders))
+	copy(pending, holders)
+	sortHolders := func() {
+		sort.SliceStable(pending, func(i, j int) bool { return pending[i].releaseAt.Before(pending[j].releaseAt) })
+	}
+	sortHolders()
+
+	result := make([]int64, len(entries))
+	clock := now
+	for index, entry := range entries {
+		if entry.gpu > capacity {
+			result[index] = -1
+			continue
+		}
+		for free < entry.gpu && len(pending) > 0 {
+			if pending[0].releaseAt.After(clock) {
+				clock = pending[0].releaseAt
+			}
+			free += pending[0].gpu
+			pending = pending[1:]
+		}
+		free -= entry.gpu
+		result[index] = int64(clock.Sub(now).Seconds())
+		pending = append(pending, gpuHolder{gpu: entry.gpu, releaseAt: clock.Add(session)})
+		sortHolders()
+	}
+	return result
+}
+
+// syncGpuQueue admits queued gpu devices in order of policy as long as there is gpu left for them
+// a driver stops admitting once the first device in line does not fit, so large requests are not starved by small ones
+// position and estimated wait of devices left in queue are kept for device get and list
+func (builder *OpenHydraRouteBuilder) syncGpuQueue(serverConfig *config.OpenHydraServerConfig) error {
+	builder.gpuQueueLock.Lock()
+	defer builder.gpuQueueLock.Unlock()
+
+	queued, err := builder.k8sHelper.ListDeploymentWithLabel(gpuQueueSelector(), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	if len(queued) == 0 {
+		builder.setGpuQueuePositions(map[string]gpuQueuePosition{})
+		return nil
+	}
+
+	// queue is disabled after devices were queued, let them all go
+	if serverConfig.GpuQueuePolicy == GpuQueuePolicyDisabled {
+		for _, deploy := range queued {
+			entry := gpuQueueEntryFromDeployment(deploy, serverConfig.GpuResourceKeys)
+			if err := builder.k8sHelper.DequeueUserDeployment(entry.label, OpenhydraNamespace, 1, builder.kubeClient); err != nil {
+				return err
+			}
+		}
+		builder.setGpuQueuePositions(map[string]gpuQueuePosition{})
+		return nil
+	}
+
+	nodes, err := builder.k8sHelper.GetAllNode(builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	pods, err := builder.k8sHelper.ListPod(OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	session := time.Duration(serverConfig.GpuSessionMinutes) * time.Minute
+
+	// pod of a device just admitted may not show up yet and pod of a device just stopped may still hold gpu
+	// so gpu used is the larger one of what pods request and what running deployments ask for
+	podUsed := map[string]int64{}
+	podStarted := map[string]time.Time{}
+	for _, pod := range pods {
+		for _, ctr := range pod.Spec.Containers {
+			for _, gpuResourceKey := range serverConfig.GpuResourceKeys {
+				podUsed[gpuResourceKey] += ctr.Resources.Requests.Name(coreV1.ResourceName(gpuResourceKey), resource.DecimalSI).Value()
+			}
+		}
+		label := k8s.DeviceLabelSelector(pod.Labels[k8s.OpenHydraUserLabelKey], pod.Labels[k8s.OpenHydraDeviceLabelKey])
+		if started, found := podStarted[label]; !found || pod.CreationTimestamp.Time.Before(started) {
+			podStarted[label] = pod.CreationTimestamp.Time
+		}
+	}
+	deployUsed := map[string]int64{}
+	usage := map[string]int64{}
+	holders := map[string][]gpuHolder{}
+	for _, deploy := range deploys {
+		if k8s.IsDeploymentQueued(deploy) || deploy.Spec.Replicas == nil || *deploy.Spec.Replicas == 0 {
+			continue
+		}
+		driver, gpu := deploymentGpu(deploy, serverConfig.GpuResourceKeys)
+		if gpu == 0 {
+			continue
+		}
+		gpu *= int64(*deploy.Spec.Replicas)
+		deployUsed[driver] += gpu
+		usage[deploy.Labels[k8s.OpenHydraUserLabelKey]] += gpu
+		releaseAt := now
+		if started, found := podStarted[k8s.DeviceLabelSelector(deploy.Labels[k8s.OpenHydraUserLabelKey], deploy.Labels[k8s.OpenHydraDeviceLabelKey])]; found && started.Add(session).After(now) {
+			releaseAt = started.Add(session)
+		}
+		holders[driver] = append(holders[driver], gpuHolder{gpu: gpu, releaseAt: releaseAt})
+	}
+	free := map[string]int64{}
+	for _, node := range nodes {
+		for _, gpuResourceKey := range serverConfig.GpuResourceKeys {
+			free[gpuResourceKey] += node.Status.Allocatable.Name(coreV1.ResourceName(gpuResourceKey), resource.DecimalSI).Value()
+		}
+	}
+	for driver := range free {
+		free[driver] -= max(podUsed[driver], deployUsed[driver])
+	}
+
+	var entries []gpuQueueEntry
+	for _, deploy := range queued {
+		entry := gpuQueueEntryFromDeployment(deploy, serverConfig.GpuResourceKeys)
+		if entry.gpu == 0 {
+			// gpu is no longer requested, nothing to wait for
+			if err := builder.k8sHelper.DequeueUserDeployment(entry.label, OpenhydraNamespace, 1, builder.kubeClient); err != nil {
+				return err
+			}
+			continue
+		}
+		entry.groupUsage = groupGpuUsage(entry.username, usage, serverConfig)
+		entries = append(entries, entry)
+	}
+	sortGpuQueue(entries, serverConfig.GpuQueuePolicy, usage)
+
+	waiting := map[string][]gpuQueueEntry{}
+	for _, entry := range entries {
+		if len(waiting[entry.driver]) == 0 && entry.gpu <= free[entry.driver] {
+			if err := builder.k8sHelper.DequeueUserDeployment(entry.label, OpenhydraNamespace, 1, builder.kubeClient); err != nil {
+				return err
+			}
+			slog.Info("Gpu device admitted from queue", "device", entry.label, "gpuDriver", entry.driver, "gpu", entry.gpu)
+			free[entry.driver] -= entry.gpu
+			holders[entry.driver] = append(holders[entry.driver], gpuHolder{gpu: entry.gpu, releaseAt: now.Add(session)})
+			continue
+		}
+		waiting[entry.driver] = append(waiting[entry.driver], entry)
+	}
+
+	positions := map[string]gpuQueuePosition{}
+	for driver, driverEntries := range waiting {
+		waits := estimateGpuWait(driverEntries, max(free[driver], 0), holders[driver], now, session)
+		for index, entry := range driverEntries {
+			positions[entry.label] = gpuQueuePosition{lineNo: index + 1, estimatedWaitSeconds: waits[index]}
+		}
+	}
+	builder.setGpuQueuePositions(positions)
+	return nil
+}
+
+// gpuQueueWaiting tells whether devices are waiting in queue for gpu of driver, gpu freed for driver goes to them first
+func (builder *OpenHydraRouteBuilder) gpuQueueWaiting(driver string, serverConfig *config.OpenHydraServerConfig) (bool, error) {
+	if serverConfig.GpuQueuePolicy == GpuQueuePolicyDisabled {
+		return false, nil
+	}
+	queued, err := builder.k8sHelper.ListDeploymentWithLabel(gpuQueueSelector(), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return false, err
+	}
+	for _, deploy := range queued {
+		if entry := gpuQueueEntryFromDeployment(deploy, serverConfig.GpuResourceKeys); entry.driver == driver && entry.gpu > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (builder *OpenHydraRouteBuilder) setGpuQueuePositions(positions map[string]gpuQueuePosition) {
+	builder.gpuQueuePositionLock.Lock()
+	defer builder.gpuQueuePositionLock.Unlock()
+	builder.gpuQueuePositions = positions
+}
+
+func (builder *OpenHydraRouteBuilder) getGpuQueuePosition(label string) (gpuQueuePosition, bool) {
+	builder.gpuQueuePositionLock.RLock()
+	defer builder.gpuQueuePositionLock.RUnlock()
+	position, found := builder.gpuQueuePositions[label]
+	return position, found
+}
+
+// fillGpuQueuePositions reports line number and estimated wait of queued devices
+func (builder *OpenHydraRouteBuilder) fillGpuQueuePositions(devices []xDeviceV1.Device) {
+	for index := range devices {
+		if devices[index].Spec.DeviceStatus != DeviceStatusQueued {
+			continue
+		}
+		position, found := builder.getGpuQueuePosition(k8s.DeviceLabelSelector(devices[index].Spec.OpenHydraUsername, devices[index].Spec.DeviceId))
+		if !found {
+			// queue is not synced yet
+			continue
+		}
+		devices[index].Spec.LineNo = strconv.Itoa(position.lineNo)
+		devices[index].Spec.EstimatedWaitSeconds = position.estimatedWaitSeconds
+	}
+}
+
+// notifyGpuQueue asks queue loop to sync as soon as possible, e.g. gpu is released
+func (builder *OpenHydraRouteBuilder) notifyGpuQueue() {
+	select {
+	case builder.gpuQueueNotify <- struct{}{}:
+	default:
+	}
+}
+
+// RunGpuQueue syncs gpu queue periodically and whenever notified until stopChan is closed
+func (builder *OpenHydraRouteBuilder) RunGpuQueue(stopChan <-chan struct{}) {
+	interval := time.Duration(builder.cfg.GpuQueueSyncIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+			case <-builder.gpuQueueNotify:
+			}
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			if err != nil {
+				slog.Error("Failed to get server config for gpu queue", "error", err)
+				continue
+			}
+			if err := builder.syncGpuQueue(serverConfig); err != nil {
+				slog.Error("Failed to sync gpu queue", "error", err)
+			}
+		}
+	}()
+}
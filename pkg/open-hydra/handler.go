@@ -12,8 +12,11 @@ import (
 	"open-hydra/pkg/database"
 	openHydraK8s "open-hydra/pkg/open-hydra/k8s"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/websocket"
 	"gopkg.in/yaml.v2"
 	"k8s.io/client-go/kubernetes"
 )
@@ -22,33 +25,62 @@ const (
 	openHydraHeaderUser       = "Open-Hydra-User"
 	openHydraHeaderRole       = "Open-Hydra-Role"
 	openHydraAuthStringHeader = "Open-Hydra-Auth"
+	// browser can not set header on websocket, so credential may be given as sub protocol
+	// base64url.bearer.openhydra.io.<base64 url encoded username:password without padding>
+	openHydraWebSocketAuthProtocolPrefix = "base64url.bearer.openhydra.io."
 )
 
-type CacheDevices map[string]*xDeviceV1.Device
-
 type OpenHydraRouteBuilder struct {
 	Database database.IDataBase
 	//Config   *config.OpenHydraServerConfig
-	RootWS *restful.WebService
-	// this is local cache relation between user and device
-	CacheDevices     CacheDevices
+	RootWS           *restful.WebService
 	kubeClient       *kubernetes.Clientset
 	k8sHelper        openHydraK8s.IOpenHydraK8sHelper
 	authorizationMap map[string]map[string]int
 	cfg              *config.OpenHydraServerConfig
+	// device batches are kept in memory only, they are lost after server restart and dropped after retention period
+	deviceBatches   map[string]*xDeviceV1.DeviceBatch
+	deviceBatchLock sync.RWMutex
+	// only one gpu queue sync runs at a time so gpu is never admitted twice
+	gpuQueueLock sync.Mutex
+	// device label selector -> position of device left in gpu queue after last sync
+	gpuQueuePositions    map[string]gpuQueuePosition
+	gpuQueuePositionLock sync.RWMutex
+	gpuQueueNotify       chan struct{}
+	// held from quota check until devices are changed
+	quotaLock sync.Mutex
+	// signs session cookie given by login
+	sessionSecret []byte
+	// resource path -> watch hub of list endpoint
+	watchHubs map[string]*watchHub
+	// only one usage ledger sync runs at a time so no event is recorded twice
+	usageLock sync.Mutex
+	// device label selector -> record device is running with, nil until loaded from ledger
+	usageSessions map[string]xDeviceV1.UsageRecord
+	usageNotify   chan struct{}
+	// username -> time gpu devices of user are stopped at since gpu hours budget is used up
+	gpuBudgetStops map[string]time.Time
+	gpuBudgetLock  sync.RWMutex
 }
 
 func NewOpenHydraRouteBuilder(db database.IDataBase, rootWS *restful.WebService, client *kubernetes.Clientset, k8sHelper openHydraK8s.IOpenHydraK8sHelper, cfg *config.OpenHydraServerConfig) *OpenHydraRouteBuilder {
-	return &OpenHydraRouteBuilder{
+	builder := &OpenHydraRouteBuilder{
 		Database: db,
 		//Config:           config,
 		RootWS:           rootWS,
-		CacheDevices:     map[string]*xDeviceV1.Device{},
 		kubeClient:       client,
 		authorizationMap: make(map[string]map[string]int),
 		k8sHelper:        k8sHelper,
 		cfg:              cfg,
-	}
+		deviceBatches:    map[string]*xDeviceV1.DeviceBatch{},
+		gpuQueueNotify:   make(chan struct{}, 1),
+		usageNotify:      make(chan struct{}, 1),
+		sessionSecret:    newSessionSecret(cfg),
+	}
+	// changes made through database are watched along with pods and services
+	builder.Database = database.NewNotifyingDataBase(db, builder.notifyWatchHubs)
+	builder.watchHubs = builder.newWatchHubs()
+	return builder
 }
 
 func (builder *OpenHydraRouteBuilder) Filter(r1 *restful.Request, r2 *restful.Response, fc *restful.FilterChain) {
@@ -71,6 +103,15 @@ func (builder *OpenHydraRouteBuilder) AuthAndAuthorization(r1 *restful.Request,
 	}
 
 	basicAuth := r1.Request.Header.Get(openHydraAuthStringHeader)
+	if basicAuth == "" {
+		basicAuth = authFromWebSocketProtocol(r1.Request)
+	}
+	if basicAuth == "" && strings.HasSuffix(r1.SelectedRoutePath(), deviceProxyPath) {
+		// browser opening sandbox only carries access link or cookie it turns into on host of device
+		if access := deviceAccessOfRequest(r1.Request); access != "" {
+			return builder.authenticateDeviceAccess(r1, r2, access)
+		}
+	}
 	if basicAuth == "" {
 		writeHttpResponseAndLogError(r2, http.StatusUnauthorized, fmt.Sprintf("no auth header found for path: %s", r1.Request.URL.Path))
 		return false
@@ -105,6 +146,11 @@ func (builder *OpenHydraRouteBuilder) AuthAndAuthorization(r1 *restful.Request,
 		return false
 	}
 
+	return builder.authorizeUser(r1, r2, user)
+}
+
+// authorizeUser checks role of authenticated user against path and passes user on to handler by header
+func (builder *OpenHydraRouteBuilder) authorizeUser(r1 *restful.Request, r2 *restful.Response, user *xUserV1.OpenHydraUser) bool {
 	if !builder.authorization(r1, user) {
 		writeHttpResponseAndLogError(r2, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to access path: %s", user.Name, r1.Request.URL.Path))
 		return false
@@ -116,6 +162,22 @@ func (builder *OpenHydraRouteBuilder) AuthAndAuthorization(r1 *restful.Request,
 	return true
 }
 
+// authFromWebSocketProtocol converts credential in websocket sub protocol into value of auth header
+func authFromWebSocketProtocol(request *http.Request) string {
+	for _, protocol := range websocket.Subprotocols(request) {
+		encoded, found := strings.CutPrefix(protocol, openHydraWebSocketAuthProtocolPrefix)
+		if !found {
+			continue
+		}
+		credential, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("Bearer %s", base64.StdEncoding.EncodeToString(credential))
+	}
+	return ""
+}
+
 func (builder *OpenHydraRouteBuilder) authorization(r1 *restful.Request, user *xUserV1.OpenHydraUser) bool {
 	relPath := strings.ReplaceAll(r1.SelectedRoutePath(), fmt.Sprintf("/apis/%s/v1", option.GroupVersion.Group), "")
 	if _, found := builder.authorizationMap[relPath]; !found {
@@ -1,11 +1,17 @@
 package k8s
 
 import (
+	"context"
+	"io"
 	"open-hydra/pkg/open-hydra/apis"
 
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 type DeploymentParameters struct {
@@ -13,6 +19,7 @@ type DeploymentParameters struct {
 	Image        string
 	Namespace    string
 	Username     string
+	DeviceId     string
 	SandboxName  string
 	VolumeMounts []apis.VolumeMount
 	GpuSet       apis.GpuSet
@@ -23,6 +30,42 @@ type DeploymentParameters struct {
 	Volumes      []apis.Volume
 	Affinity     *coreV1.Affinity
 	CustomLabels map[string]string
+	// deployment is created with zero replicas and waits in gpu queue
+	Queued        bool
+	QueuePriority int32
+	// port name -> base url sandbox serves port under, DeviceRouteName is used for port not listed
+	BaseURLs map[string]string
+	// env and init containers of sandbox with templates already rendered
+	Env            []apis.EnvVar
+	EnvFrom        []apis.EnvFromSource
+	InitContainers []apis.InitContainer
+	// port name -> health check of port
+	HealthChecks map[string]apis.HealthCheck
+	// scheduling of device merged from scheduling policy
+	NodeSelector              map[string]string
+	Tolerations               []coreV1.Toleration
+	TopologySpreadConstraints []coreV1.TopologySpreadConstraint
+	PriorityClassName         string
+}
+
+// DeviceRouteParameters describes ingress or httproute created for every port of device
+type DeviceRouteParameters struct {
+	Namespace string
+	Username  string
+	DeviceId  string
+	Ports     map[string]int
+	// one of DeviceRouteKindIngress or DeviceRouteKindHTTPRoute
+	Kind string
+	// port is routed by host <device>-<port>.<Host> instead of path /<device>-<port>
+	HostRouting      bool
+	Host             string
+	IngressClassName string
+	TLSSecretName    string
+	Annotations      map[string]string
+	GatewayName      string
+	GatewayNamespace string
+	// routes are owned by deployment of device when given
+	Owner *appsV1.Deployment
 }
 
 type IOpenHydraK8sHelper interface {
@@ -33,15 +76,34 @@ type IOpenHydraK8sHelper interface {
 	ListDeployment(namespace string, client *kubernetes.Clientset) ([]appsV1.Deployment, error)
 	ListService(namespace string, client *kubernetes.Clientset) ([]coreV1.Service, error)
 	DeleteUserDeployment(label, namespace string, client *kubernetes.Clientset) error
-	CreateDeployment(deployParameter *DeploymentParameters) error
-	CreateService(namespace, userName, ideType string, client *kubernetes.Clientset, ports map[string]int) error
+	ScaleUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error
+	QueueUserDeployment(label, namespace string, client *kubernetes.Clientset) error
+	DequeueUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error
+	CreateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error)
+	UpdateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error)
+	RollbackDeployment(previous *appsV1.Deployment, client *kubernetes.Clientset) error
+	LabelLegacyDevice(username, namespace string, client *kubernetes.Clientset) error
+	CreateService(namespace, userName, deviceId, ideType string, client *kubernetes.Clientset, ports map[string]int, serviceType coreV1.ServiceType, owner *appsV1.Deployment) error
 	DeleteUserService(label, namespace string, client *kubernetes.Clientset) error
 	GetUserService(label, namespace string, client *kubernetes.Clientset) (*coreV1.Service, error)
+	CreateDeviceRoute(routeParameter *DeviceRouteParameters, client *kubernetes.Clientset) error
+	DeleteUserRoute(label, namespace string, client *kubernetes.Clientset) error
+	EnsureUserWorkspace(namespace, username, storageClass string, accessMode coreV1.PersistentVolumeAccessMode, size resource.Quantity, client *kubernetes.Clientset) (string, error)
+	ApplyNetworkPolicy(policy *networkingV1.NetworkPolicy, client *kubernetes.Clientset) error
+	ListNetworkPolicies(label, namespace string, client *kubernetes.Clientset) ([]networkingV1.NetworkPolicy, error)
+	DeleteNetworkPolicy(name, namespace string, client *kubernetes.Clientset) error
+	ListReplicaSetWithLabel(label, namespace string, client *kubernetes.Clientset) ([]appsV1.ReplicaSet, error)
 	DeleteUserReplicaSet(label, namespace string, client *kubernetes.Clientset) error
 	DeleteUserPod(label, namespace string, client *kubernetes.Clientset) error
+	GetAllNode(client *kubernetes.Clientset) ([]coreV1.Node, error)
+	ListEvents(namespace, fieldSelector string, client *kubernetes.Clientset) ([]coreV1.Event, error)
+	CreateEvent(event *coreV1.Event, client *kubernetes.Clientset) error
+	GetPodLogs(ctx context.Context, namespace, podName string, options *coreV1.PodLogOptions, client *kubernetes.Clientset) (io.ReadCloser, error)
+	ExecInPod(ctx context.Context, namespace, podName, container string, command []string, streamOptions remotecommand.StreamOptions, kubeConfig *rest.Config, client *kubernetes.Clientset) error
 	GetConfigMap(name, namespace string) (*coreV1.ConfigMap, error)
 	UpdateConfigMap(name, namespace string, data map[string]string) error
 	RunInformers(stopChan <-chan struct{})
+	AddWorkloadChangeHandler(handler func()) error
 }
 
 func NewDefaultK8sHelper(clientSet *kubernetes.Clientset, stopChan <-chan struct{}) IOpenHydraK8sHelper {
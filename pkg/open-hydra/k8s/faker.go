@@ -1,14 +1,24 @@
 package k8s
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"open-hydra/cmd/open-hydra-server/app/config"
+	"strings"
 
 	"gopkg.in/yaml.v2"
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 type Fake struct {
@@ -18,7 +28,25 @@ type Fake struct {
 	labelPod          map[string][]coreV1.Pod
 	labelDeploy       map[string][]appsV1.Deployment
 	labelService      map[string][]coreV1.Service
+	workloadHandlers  []func()
 	ServerConfig      *config.OpenHydraServerConfig
+	Nodes             []coreV1.Node
+	Events            []coreV1.Event
+	ReplicaSets       []appsV1.ReplicaSet
+	// pod name -> log content
+	PodLogs map[string]string
+	// device label selector -> route of device
+	Routes map[string]DeviceRouteParameters
+	// claim name -> workspace claim of user
+	Workspaces map[string]coreV1.PersistentVolumeClaim
+	// policy name -> network policy
+	NetworkPolicies map[string]networkingV1.NetworkPolicy
+	// CreateService fails with it when set
+	CreateServiceError error
+	// ListDeploymentWithLabel fails with it when set
+	ListDeploymentError error
+	// CreateDeployment stamps deployment with it, zero leaves deployment past any grace period
+	DeploymentCreationTimestamp v1.Time
 }
 
 func (f *Fake) Init() {
@@ -29,35 +57,64 @@ func (f *Fake) Init() {
 	f.labelDeploy = make(map[string][]appsV1.Deployment)
 	f.labelService = make(map[string][]coreV1.Service)
 	f.ServerConfig = config.DefaultConfig()
+	f.PodLogs = make(map[string]string)
+	f.Routes = make(map[string]DeviceRouteParameters)
+	f.Workspaces = make(map[string]coreV1.PersistentVolumeClaim)
+	f.NetworkPolicies = make(map[string]networkingV1.NetworkPolicy)
+}
+
+// matchLabel reports whether object labels are selected by label, resources are stored by device selector
+// so lookup by user only selector still finds all devices of user
+func matchLabel(label string, objLabels map[string]string) bool {
+	selector, err := labels.Parse(label)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(objLabels))
 }
 
 func (f *Fake) ListDeploymentWithLabel(label, namespace string, client *kubernetes.Clientset) ([]appsV1.Deployment, error) {
+	if f.ListDeploymentError != nil {
+		return nil, f.ListDeploymentError
+	}
 	var result []appsV1.Deployment
-	if _, ok := f.labelDeploy[label]; ok {
-		result = f.labelDeploy[label]
+	for _, deploys := range f.labelDeploy {
+		for _, deploy := range deploys {
+			if matchLabel(label, deploy.Labels) {
+				result = append(result, deploy)
+			}
+		}
 	}
 	return result, nil
 }
 func (f *Fake) ListPodWithLabel(label, namespace string, client *kubernetes.Clientset) ([]coreV1.Pod, error) {
 	var result []coreV1.Pod
-	if _, ok := f.labelPod[label]; ok {
-		result = f.labelPod[label]
+	for _, pods := range f.labelPod {
+		for _, pod := range pods {
+			if matchLabel(label, pod.Labels) {
+				result = append(result, pod)
+			}
+		}
 	}
 	return result, nil
 }
 func (f *Fake) ListPod(namespace string, client *kubernetes.Clientset) ([]coreV1.Pod, error) {
 	var result []coreV1.Pod
 	if _, ok := f.namespacedPod[namespace]; ok {
-		result = f.namespacedPod[namespace]
+		result = append(result, f.namespacedPod[namespace]...)
+	}
+	// pods created along with deployment
+	for _, pods := range f.labelPod {
+		for _, pod := range pods {
+			if pod.Namespace == namespace {
+				result = append(result, pod)
+			}
+		}
 	}
 	return result, nil
 }
 func (f *Fake) GetUserPods(label, namespace string, client *kubernetes.Clientset) ([]coreV1.Pod, error) {
-	var result []coreV1.Pod
-	if _, ok := f.labelPod[label]; ok {
-		result = f.labelPod[label]
-	}
-	return result, nil
+	return f.ListPodWithLabel(label, namespace, client)
 }
 func (f *Fake) ListDeployment(namespace string, client *kubernetes.Clientset) ([]appsV1.Deployment, error) {
 	var result []appsV1.Deployment
@@ -74,50 +131,335 @@ func (f *Fake) ListService(namespace string, client *kubernetes.Clientset) ([]co
 	return result, nil
 }
 func (f *Fake) DeleteUserDeployment(label, namespace string, client *kubernetes.Clientset) error {
-	delete(f.labelDeploy, label)
+	for key, deploys := range f.labelDeploy {
+		if len(deploys) > 0 && matchLabel(label, deploys[0].Labels) {
+			delete(f.labelDeploy, key)
+			// pods are garbage collected along with deployment
+			delete(f.labelPod, key)
+		}
+	}
+	f.workloadChanged()
+	return nil
+}
+func (f *Fake) ScaleUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error {
+	return f.updateUserDeployment(label, namespace, func(deploy *appsV1.Deployment) {
+		deploy.Spec.Replicas = &replicas
+	})
+}
+func (f *Fake) QueueUserDeployment(label, namespace string, client *kubernetes.Clientset) error {
+	return f.updateUserDeployment(label, namespace, func(deploy *appsV1.Deployment) {
+		markQueued(deploy, nil)
+	})
+}
+func (f *Fake) DequeueUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error {
+	return f.updateUserDeployment(label, namespace, func(deploy *appsV1.Deployment) {
+		markDequeued(deploy, replicas)
+	})
+}
+func (f *Fake) updateUserDeployment(label, namespace string, mutate func(deploy *appsV1.Deployment)) error {
+	found := false
+	for key, deploys := range f.labelDeploy {
+		if len(deploys) == 0 || !matchLabel(label, deploys[0].Labels) {
+			continue
+		}
+		found = true
+		for index := range deploys {
+			mutate(&f.labelDeploy[key][index])
+		}
+		for index, deploy := range f.namespacedDeploy[namespace] {
+			if deploy.Name == deploys[0].Name {
+				f.namespacedDeploy[namespace][index] = *f.labelDeploy[key][0].DeepCopy()
+			}
+		}
+		f.syncPod(key, f.labelDeploy[key][0])
+	}
+	if !found {
+		return fmt.Errorf("deployment with label %s not found", label)
+	}
 	return nil
 }
-func (f *Fake) CreateDeployment(deployParameter *DeploymentParameters) error {
-	label := fmt.Sprintf("%s=%s", OpenHydraUserLabelKey, deployParameter.Username)
-	f.labelDeploy[label] = append(f.labelDeploy[label], appsV1.Deployment{})
-	f.namespacedDeploy[deployParameter.Namespace] = append(f.namespacedDeploy[deployParameter.Namespace], appsV1.Deployment{})
-	f.labelPod[label] = append(f.labelPod[label], coreV1.Pod{
+
+// syncPod mimics deployment controller, a running pod exists only when deployment has replicas
+func (f *Fake) syncPod(key string, deployment appsV1.Deployment) {
+	defer f.workloadChanged()
+	if deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0 {
+		delete(f.labelPod, key)
+		return
+	}
+	if len(f.labelPod[key]) > 0 {
+		return
+	}
+	f.labelPod[key] = append(f.labelPod[key], coreV1.Pod{
 		ObjectMeta: v1.ObjectMeta{
-			Labels: map[string]string{
-				OpenHydraUserLabelKey: deployParameter.Username,
-				OpenHydraSandboxKey:   deployParameter.SandboxName,
-			},
+			Name:              deployment.Name + "-fake",
+			Namespace:         deployment.Namespace,
+			Labels:            deployment.Spec.Template.Labels,
+			CreationTimestamp: v1.Now(),
 		},
+		Spec:   deployment.Spec.Template.Spec,
+		Status: coreV1.PodStatus{Phase: coreV1.PodRunning},
 	})
+}
+func (f *Fake) CreateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error) {
+	label := DeviceLabelSelector(deployParameter.Username, deployParameter.DeviceId)
+	deployment := createDeployment(deployParameter)
+	deployment.UID = types.UID(deployment.Name)
+	deployment.CreationTimestamp = f.DeploymentCreationTimestamp
+	f.labelDeploy[label] = append(f.labelDeploy[label], *deployment)
+	f.namespacedDeploy[deployParameter.Namespace] = append(f.namespacedDeploy[deployParameter.Namespace], *deployment)
+	f.syncPod(label, *deployment)
+	return deployment, nil
+}
+func (f *Fake) UpdateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error) {
+	label := DeviceLabelSelector(deployParameter.Username, deployParameter.DeviceId)
+	if len(f.labelDeploy[label]) == 0 {
+		return nil, fmt.Errorf("deployment with label %s not found", label)
+	}
+	previous := f.labelDeploy[label][0].DeepCopy()
+	desired := createDeployment(deployParameter)
+	f.labelDeploy[label][0].Labels = desired.Labels
+	f.labelDeploy[label][0].Spec.Template = desired.Spec.Template
+	f.labelDeploy[label][0].Spec.Strategy = desired.Spec.Strategy
+	// pods are replaced by ones of new template
+	delete(f.labelPod, label)
+	f.syncPod(label, f.labelDeploy[label][0])
+	return previous, nil
+}
+func (f *Fake) RollbackDeployment(previous *appsV1.Deployment, client *kubernetes.Clientset) error {
+	label := DeviceLabelSelector(previous.Labels[OpenHydraUserLabelKey], previous.Labels[OpenHydraDeviceLabelKey])
+	if len(f.labelDeploy[label]) == 0 {
+		return fmt.Errorf("deployment with label %s not found", label)
+	}
+	f.labelDeploy[label][0].Labels = previous.Labels
+	f.labelDeploy[label][0].Spec.Template = previous.Spec.Template
+	delete(f.labelPod, label)
+	f.syncPod(label, f.labelDeploy[label][0])
+	return nil
+}
+func (f *Fake) LabelLegacyDevice(username, namespace string, client *kubernetes.Clientset) error {
+	legacy := LegacyDeviceLabelSelector(username)
+	// pods share labels of pod template
+	for _, deploys := range f.labelDeploy {
+		for index := range deploys {
+			if matchLabel(legacy, deploys[index].Labels) {
+				deploys[index].Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+				deploys[index].Spec.Template.Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+			}
+		}
+	}
+	for _, services := range f.labelService {
+		for index := range services {
+			if matchLabel(legacy, services[index].Labels) {
+				services[index].Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+			}
+		}
+	}
 	return nil
 }
-func (f *Fake) CreateService(namespace, studentID, ideType string, client *kubernetes.Clientset, ports map[string]int) error {
-	label := fmt.Sprintf("%s=%s", OpenHydraUserLabelKey, studentID)
-	f.labelService[label] = append(f.labelService[label], coreV1.Service{})
-	f.namespacedService[namespace] = append(f.namespacedService[namespace], coreV1.Service{})
+func (f *Fake) CreateService(namespace, studentID, deviceId, ideType string, client *kubernetes.Clientset, ports map[string]int, serviceType coreV1.ServiceType, owner *appsV1.Deployment) error {
+	if f.CreateServiceError != nil {
+		return f.CreateServiceError
+	}
+	label := DeviceLabelSelector(studentID, deviceId)
+	service := coreV1.Service{
+		ObjectMeta: v1.ObjectMeta{
+			Name: fmt.Sprintf(OpenHydraServiceNameTemplate, DeviceBaseName(studentID, deviceId)),
+			Labels: map[string]string{
+				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+				OpenHydraUserLabelKey:     studentID,
+				OpenHydraDeviceLabelKey:   DeviceId(deviceId),
+			},
+			OwnerReferences: DeploymentOwnerReferences(owner),
+		},
+	}
+	service.Spec.Type = serviceType
+	for name, port := range ports {
+		service.Spec.Ports = append(service.Spec.Ports, coreV1.ServicePort{
+			Name: name,
+			Port: int32(port),
+		})
+	}
+	f.labelService[label] = append(f.labelService[label], service)
+	f.namespacedService[namespace] = append(f.namespacedService[namespace], service)
+	f.workloadChanged()
 	return nil
 }
 func (f *Fake) DeleteUserService(label, namespace string, client *kubernetes.Clientset) error {
-	delete(f.labelService, label)
+	for key, services := range f.labelService {
+		if len(services) > 0 && matchLabel(label, services[0].Labels) {
+			delete(f.labelService, key)
+		}
+	}
+	f.workloadChanged()
 	return nil
 }
 func (f *Fake) GetUserService(label, namespace string, client *kubernetes.Clientset) (*coreV1.Service, error) {
-	var result *coreV1.Service
-	if _, ok := f.labelService[label]; ok {
-		result = &f.labelService[label][0]
-	} else {
-		return nil, fmt.Errorf("service not found")
+	for _, services := range f.labelService {
+		for index := range services {
+			if matchLabel(label, services[index].Labels) {
+				return &services[index], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("service not found")
+}
+func (f *Fake) CreateDeviceRoute(routeParameter *DeviceRouteParameters, client *kubernetes.Clientset) error {
+	f.Routes[DeviceLabelSelector(routeParameter.Username, routeParameter.DeviceId)] = *routeParameter
+	return nil
+}
+func (f *Fake) DeleteUserRoute(label, namespace string, client *kubernetes.Clientset) error {
+	for key, route := range f.Routes {
+		if matchLabel(label, map[string]string{OpenHydraUserLabelKey: route.Username, OpenHydraDeviceLabelKey: DeviceId(route.DeviceId)}) {
+			delete(f.Routes, key)
+		}
+	}
+	return nil
+}
+func (f *Fake) EnsureUserWorkspace(namespace, username, storageClass string, accessMode coreV1.PersistentVolumeAccessMode, size resource.Quantity, client *kubernetes.Clientset) (string, error) {
+	claimName := fmt.Sprintf(OpenHydraWorkspaceNameTemplate, username)
+	if _, found := f.Workspaces[claimName]; found {
+		return claimName, nil
+	}
+	claim := coreV1.PersistentVolumeClaim{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      claimName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+				OpenHydraUserLabelKey:     username,
+			},
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes: []coreV1.PersistentVolumeAccessMode{accessMode},
+			Resources: coreV1.VolumeResourceRequirements{
+				Requests: coreV1.ResourceList{coreV1.ResourceStorage: size},
+			},
+		},
+	}
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	f.Workspaces[claimName] = claim
+	return claimName, nil
+}
+func (f *Fake) ApplyNetworkPolicy(policy *networkingV1.NetworkPolicy, client *kubernetes.Clientset) error {
+	applied := *policy.DeepCopy()
+	if existing, found := f.NetworkPolicies[policy.Name]; found && len(existing.Annotations) > 0 {
+		annotations := existing.DeepCopy().Annotations
+		for key, value := range policy.Annotations {
+			annotations[key] = value
+		}
+		applied.Annotations = annotations
+	}
+	f.NetworkPolicies[policy.Name] = applied
+	return nil
+}
+func (f *Fake) ListNetworkPolicies(label, namespace string, client *kubernetes.Clientset) ([]networkingV1.NetworkPolicy, error) {
+	var result []networkingV1.NetworkPolicy
+	for _, policy := range f.NetworkPolicies {
+		if policy.Namespace == namespace && matchLabel(label, policy.Labels) {
+			result = append(result, policy)
+		}
+	}
+	return result, nil
+}
+func (f *Fake) DeleteNetworkPolicy(name, namespace string, client *kubernetes.Clientset) error {
+	delete(f.NetworkPolicies, name)
+	return nil
+}
+func (f *Fake) ListReplicaSetWithLabel(label, namespace string, client *kubernetes.Clientset) ([]appsV1.ReplicaSet, error) {
+	var result []appsV1.ReplicaSet
+	for _, replicaSet := range f.ReplicaSets {
+		if replicaSet.Namespace == namespace && matchLabel(label, replicaSet.Labels) {
+			result = append(result, replicaSet)
+		}
 	}
 	return result, nil
 }
 func (f *Fake) DeleteUserReplicaSet(label, namespace string, client *kubernetes.Clientset) error {
+	var left []appsV1.ReplicaSet
+	for _, replicaSet := range f.ReplicaSets {
+		if replicaSet.Namespace != namespace || !matchLabel(label, replicaSet.Labels) {
+			left = append(left, replicaSet)
+		}
+	}
+	f.ReplicaSets = left
 	return nil
 }
 func (f *Fake) DeleteUserPod(label, namespace string, client *kubernetes.Clientset) error {
-	delete(f.labelPod, label)
+	for key, pods := range f.labelPod {
+		if len(pods) > 0 && matchLabel(label, pods[0].Labels) {
+			delete(f.labelPod, key)
+		}
+	}
 	return nil
 }
 
+func (f *Fake) GetAllNode(client *kubernetes.Clientset) ([]coreV1.Node, error) {
+	return f.Nodes, nil
+}
+
+func (f *Fake) ListEvents(namespace, fieldSelector string, client *kubernetes.Clientset) ([]coreV1.Event, error) {
+	selector, err := fields.ParseSelector(fieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	var result []coreV1.Event
+	for _, event := range f.Events {
+		if event.Namespace != namespace {
+			continue
+		}
+		if selector.Matches(fields.Set{
+			"involvedObject.kind": event.InvolvedObject.Kind,
+			"involvedObject.name": event.InvolvedObject.Name,
+		}) {
+			result = append(result, event)
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) CreateEvent(event *coreV1.Event, client *kubernetes.Clientset) error {
+	f.Events = append(f.Events, *event)
+	return nil
+}
+
+// GetPodLogs only honors tailLines of options
+func (f *Fake) GetPodLogs(ctx context.Context, namespace, podName string, options *coreV1.PodLogOptions, client *kubernetes.Clientset) (io.ReadCloser, error) {
+	content, found := f.PodLogs[podName]
+	if !found {
+		return nil, fmt.Errorf("pod %s not found", podName)
+	}
+	if options != nil && options.TailLines != nil {
+		lines := strings.SplitAfter(content, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		if int64(len(lines)) > *options.TailLines {
+			lines = lines[int64(len(lines))-*options.TailLines:]
+		}
+		content = strings.Join(lines, "")
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+// ExecInPod echoes stdin back to stdout and reports every terminal resize as a line of output
+func (f *Fake) ExecInPod(ctx context.Context, namespace, podName, container string, command []string, streamOptions remotecommand.StreamOptions, kubeConfig *rest.Config, client *kubernetes.Clientset) error {
+	if streamOptions.TerminalSizeQueue != nil {
+		go func() {
+			for {
+				size := streamOptions.TerminalSizeQueue.Next()
+				if size == nil {
+					return
+				}
+				fmt.Fprintf(streamOptions.Stdout, "resize %dx%d\n", size.Width, size.Height)
+			}
+		}()
+	}
+	_, err := io.Copy(streamOptions.Stdout, streamOptions.Stdin)
+	return err
+}
+
 func (f *Fake) GetConfigMap(name, namespace string) (*coreV1.ConfigMap, error) {
 	if name == "openhydra-plugin" {
 		return &coreV1.ConfigMap{
@@ -138,7 +480,7 @@ func (f *Fake) GetConfigMap(name, namespace string) (*coreV1.ConfigMap, error) {
 					"developmentInfo": ["test"],
 					"status": "test",
 					"ports": [
-						8888
+						{"name": "jupyter-lab", "port": 8888}
 					],
 					"volume_mounts": [
 						{
@@ -171,7 +513,7 @@ func (f *Fake) GetConfigMap(name, namespace string) (*coreV1.ConfigMap, error) {
 					"developmentInfo": ["jupyter-lab-test"],
 					"status": "running",
 					"ports": [
-						8888
+						{"name": "jupyter-lab", "port": 8888}
 					],
 					"volume_mounts": [
 						{
@@ -204,10 +546,10 @@ func (f *Fake) GetConfigMap(name, namespace string) (*coreV1.ConfigMap, error) {
 					"developmentInfo": ["jupyter-lab-test"],
 					"status": "running",
 					"ports": [
-						8888,
-						8889,
-						8890,
-						8891
+						{"name": "jupyter-lab", "port": 8888},
+						{"name": "port-8889", "port": 8889},
+						{"name": "port-8890", "port": 8890},
+						{"name": "port-8891", "port": 8891}
 					],
 					"volume_mounts": [
 						{
@@ -268,3 +610,15 @@ func (help *Fake) UpdateConfigMap(name, namespace string, data map[string]string
 
 func (help *Fake) RunInformers(stopChan <-chan struct{}) {
 }
+
+func (help *Fake) AddWorkloadChangeHandler(handler func()) error {
+	help.workloadHandlers = append(help.workloadHandlers, handler)
+	return nil
+}
+
+// workloadChanged mimics informer by calling handlers right after pods or services are changed
+func (help *Fake) workloadChanged() {
+	for _, handler := range help.workloadHandlers {
+		handler()
+	}
+}
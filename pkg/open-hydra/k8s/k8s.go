@@ -3,20 +3,31 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
 	"open-hydra/pkg/open-hydra/apis"
 	"strconv"
 	"strings"
+	"time"
 
 	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	coreV1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 const (
@@ -25,14 +36,75 @@ const (
 	OpenHydraUserLabelKey        = "openhydra-user"
 	OpenHydraDeployNameTemplate  = "openhydra-deploy-%s"
 	OpenHydraServiceNameTemplate = "openhydra-service-%s"
-	OpenHydraDeployHookKey       = "openhydra-hook"
-	OpenHydraIDELabelKey         = "openhydra-ide-type"
-	OpenHydraIDELabelJuptyerLab  = "jupyterlab"
-	OpenHydraIDELabelVSCode      = "vscode"
-	OpenHydraIDELabelUnset       = "unset"
-	OpenHydraSandboxKey          = "openhydra-sandbox"
+	OpenHydraRouteNameTemplate   = "openhydra-route-%s"
+	// workspace claim is per user and shared by all devices of user
+	OpenHydraWorkspaceNameTemplate = "openhydra-workspace-%s"
+	// network policy is per user and isolates all devices of user
+	OpenHydraNetworkPolicyNameTemplate = "openhydra-network-%s"
+	OpenHydraDeployHookKey             = "openhydra-hook"
+	OpenHydraIDELabelKey               = "openhydra-ide-type"
+	OpenHydraIDELabelJuptyerLab        = "jupyterlab"
+	OpenHydraIDELabelVSCode            = "vscode"
+	OpenHydraIDELabelUnset             = "unset"
+	OpenHydraSandboxKey                = "openhydra-sandbox"
+	OpenHydraBatchLabelKey             = "openhydra-batch"
+	OpenHydraDeviceLabelKey            = "openhydra-device"
+	OpenHydraDefaultDeviceId           = "default"
+	// joins username, device id and port name in names of device, none of them may contain it so names never collide
+	DeviceNameSeparator = "--"
+	// queued deployment is kept at zero replicas until gpu queue admits it
+	OpenHydraQueueLabelKey           = "openhydra-queued"
+	OpenHydraQueueLabelValue         = "true"
+	OpenHydraQueuedAtAnnotation      = "openhydra-queued-at"
+	OpenHydraQueuePriorityAnnotation = "openhydra-queue-priority"
+	// network policy is stamped with it when device create applies it, reconciler keeps policy young by it though no device is found yet
+	OpenHydraPolicyAppliedAtAnnotation = "openhydra-policy-applied-at"
+	// health check of sandbox port
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+	// port of device is exposed by networking.k8s.io ingress
+	DeviceRouteKindIngress = "ingress"
+	// port of device is exposed by gateway.networking.k8s.io httproute
+	DeviceRouteKindHTTPRoute = "httproute"
 )
 
+// DeviceId returns default device id when deviceId is empty
+func DeviceId(deviceId string) string {
+	if deviceId == "" {
+		return OpenHydraDefaultDeviceId
+	}
+	return deviceId
+}
+
+// DeviceBaseName is used to name deployment and service of device
+// default device is named by username only so it keeps the same name as before user can own multiple devices
+// other devices can never take name of default device of another user since username holds no separator
+func DeviceBaseName(username, deviceId string) string {
+	if DeviceId(deviceId) == OpenHydraDefaultDeviceId {
+		return username
+	}
+	return username + DeviceNameSeparator + deviceId
+}
+
+// DeviceRouteName names route of a port of device, it is the path prefix or host prefix of port and sandbox gets it as base url
+// default device keeps <user>-<port> which sandboxes created before multiple devices are already serving under
+func DeviceRouteName(username, deviceId, portName string) string {
+	if DeviceId(deviceId) == OpenHydraDefaultDeviceId {
+		return username + "-" + portName
+	}
+	return DeviceBaseName(username, deviceId) + DeviceNameSeparator + portName
+}
+
+// LegacyDeviceLabelSelector selects k8s resources of user created before user can own multiple devices, they have no device label
+func LegacyDeviceLabelSelector(username string) string {
+	return fmt.Sprintf("%s=%s,!%s", OpenHydraUserLabelKey, username, OpenHydraDeviceLabelKey)
+}
+
+// DeviceLabelSelector selects all k8s resources of a single device of user
+func DeviceLabelSelector(username, deviceId string) string {
+	return fmt.Sprintf("%s=%s,%s=%s", OpenHydraUserLabelKey, username, OpenHydraDeviceLabelKey, DeviceId(deviceId))
+}
+
 type DefaultHelper struct {
 	clientSet         *kubernetes.Clientset
 	configMapInformer cache.SharedIndexInformer
@@ -171,17 +243,125 @@ func (help *DefaultHelper) DeleteUserDeployment(label, namespace string, client
 	return nil
 }
 
+// ScaleUserDeployment set replicas of user deployment, service is not touched so node ports stay the same
+func (help *DefaultHelper) ScaleUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: label,
+	})
+	if err != nil {
+		return err
+	}
+	if len(deployments.Items) == 0 {
+		return fmt.Errorf("deployment with label %s not found", label)
+	}
+	for _, deployment := range deployments.Items {
+		deployment.Spec.Replicas = &replicas
+		_, err := client.AppsV1().Deployments(namespace).Update(context.TODO(), &deployment, metaV1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsDeploymentQueued reports whether deployment is waiting in gpu queue
+func IsDeploymentQueued(deploy appsV1.Deployment) bool {
+	return deploy.Labels[OpenHydraQueueLabelKey] == OpenHydraQueueLabelValue
+}
+
+// markQueued scales deployment to zero and puts it into gpu queue, time of first queueing is kept
+func markQueued(deploy *appsV1.Deployment, priority *int32) {
+	replicas := int32(0)
+	deploy.Spec.Replicas = &replicas
+	if deploy.Labels == nil {
+		deploy.Labels = map[string]string{}
+	}
+	deploy.Labels[OpenHydraQueueLabelKey] = OpenHydraQueueLabelValue
+	if deploy.Annotations == nil {
+		deploy.Annotations = map[string]string{}
+	}
+	if _, found := deploy.Annotations[OpenHydraQueuedAtAnnotation]; !found {
+		deploy.Annotations[OpenHydraQueuedAtAnnotation] = metaV1.Now().UTC().Format(time.RFC3339Nano)
+	}
+	if priority != nil {
+		deploy.Annotations[OpenHydraQueuePriorityAnnotation] = strconv.Itoa(int(*priority))
+	}
+}
+
+// markDequeued removes deployment from gpu queue and scales it to replicas
+func markDequeued(deploy *appsV1.Deployment, replicas int32) {
+	deploy.Spec.Replicas = &replicas
+	delete(deploy.Labels, OpenHydraQueueLabelKey)
+	delete(deploy.Annotations, OpenHydraQueuedAtAnnotation)
+	delete(deploy.Annotations, OpenHydraQueuePriorityAnnotation)
+}
+
+// QueueUserDeployment scales deployment to zero and puts it into gpu queue
+func (help *DefaultHelper) QueueUserDeployment(label, namespace string, client *kubernetes.Clientset) error {
+	return help.updateUserDeployment(label, namespace, client, func(deploy *appsV1.Deployment) {
+		markQueued(deploy, nil)
+	})
+}
+
+// DequeueUserDeployment removes deployment from gpu queue and scales it to replicas
+func (help *DefaultHelper) DequeueUserDeployment(label, namespace string, replicas int32, client *kubernetes.Clientset) error {
+	return help.updateUserDeployment(label, namespace, client, func(deploy *appsV1.Deployment) {
+		markDequeued(deploy, replicas)
+	})
+}
+
+func (help *DefaultHelper) updateUserDeployment(label, namespace string, client *kubernetes.Clientset, mutate func(deploy *appsV1.Deployment)) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	deployments, err := client.AppsV1().Deployments(namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: label,
+	})
+	if err != nil {
+		return err
+	}
+	if len(deployments.Items) == 0 {
+		return fmt.Errorf("deployment with label %s not found", label)
+	}
+	for _, deployment := range deployments.Items {
+		mutate(&deployment)
+		_, err := client.AppsV1().Deployments(namespace).Update(context.TODO(), &deployment, metaV1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func createDeployment(deployParameter *DeploymentParameters) *appsV1.Deployment {
-	baseName := fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username)
+	baseName := fmt.Sprintf(OpenHydraDeployNameTemplate, DeviceBaseName(deployParameter.Username, deployParameter.DeviceId))
+	deviceId := DeviceId(deployParameter.DeviceId)
 	replicas := int32(1)
 	resourceReq, resourceLim := createResource(deployParameter.CpuMemorySet, deployParameter.GpuSet)
 	ideTypeLabelValue := OpenHydraIDELabelUnset
+	containers := createContainers(baseName, deployParameter.Image, deployParameter.Username, deployParameter.DeviceId, deployParameter.VolumeMounts, resourceReq, resourceLim, deployParameter.Command, deployParameter.Args, deployParameter.Ports, deployParameter.BaseURLs, deployParameter.CustomLabels, deployParameter.Env, deployParameter.EnvFrom)
+	for name, check := range deployParameter.HealthChecks {
+		baseURL, found := deployParameter.BaseURLs[name]
+		if !found {
+			baseURL = DeviceRouteName(deployParameter.Username, deployParameter.DeviceId, name)
+		}
+		containers[0].ReadinessProbe = createProbe(check, deployParameter.Ports[name], baseURL)
+		if check.Liveness {
+			containers[0].LivenessProbe = createProbe(check, deployParameter.Ports[name], baseURL)
+		}
+	}
 	deployment := &appsV1.Deployment{
 		ObjectMeta: metaV1.ObjectMeta{
 			Name:      baseName,
 			Namespace: deployParameter.Namespace,
 			Labels: map[string]string{
 				OpenHydraUserLabelKey:     deployParameter.Username,
+				OpenHydraDeviceLabelKey:   deviceId,
 				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
 				OpenHydraIDELabelKey:      ideTypeLabelValue,
 				OpenHydraSandboxKey:       deployParameter.SandboxName,
@@ -189,23 +369,28 @@ func createDeployment(deployParameter *DeploymentParameters) *appsV1.Deployment
 		},
 		Spec: appsV1.DeploymentSpec{
 			Replicas: &replicas,
+			// old pod of device goes away before new one starts, so resize never needs gpu and workspace of both at once
+			Strategy: appsV1.DeploymentStrategy{Type: appsV1.RecreateDeploymentStrategyType},
 			Selector: &metaV1.LabelSelector{
 				MatchLabels: map[string]string{
-					OpenHydraUserLabelKey: deployParameter.Username,
+					OpenHydraUserLabelKey:   deployParameter.Username,
+					OpenHydraDeviceLabelKey: deviceId,
 				},
 			},
 			Template: coreV1.PodTemplateSpec{
 				ObjectMeta: metaV1.ObjectMeta{
 					Labels: map[string]string{
 						OpenHydraUserLabelKey:     deployParameter.Username,
+						OpenHydraDeviceLabelKey:   deviceId,
 						OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
 						OpenHydraIDELabelKey:      ideTypeLabelValue,
 						OpenHydraSandboxKey:       deployParameter.SandboxName,
 					},
 				},
 				Spec: coreV1.PodSpec{
-					Volumes:    createVolume(deployParameter.Volumes),
-					Containers: createContainers(baseName, deployParameter.Image, deployParameter.Username, deployParameter.VolumeMounts, resourceReq, resourceLim, deployParameter.Command, deployParameter.Args, deployParameter.Ports, deployParameter.CustomLabels),
+					Volumes:        createVolume(deployParameter.Volumes),
+					InitContainers: createInitContainers(baseName, deployParameter.Image, deployParameter.VolumeMounts, resourceReq, resourceLim, deployParameter.Env, deployParameter.EnvFrom, deployParameter.InitContainers),
+					Containers:     containers,
 				},
 			},
 		},
@@ -223,23 +408,52 @@ func createDeployment(deployParameter *DeploymentParameters) *appsV1.Deployment
 	}
 
 	deployment.Spec.Template.Spec.Affinity = deployParameter.Affinity
+	deployment.Spec.Template.Spec.NodeSelector = deployParameter.NodeSelector
+	deployment.Spec.Template.Spec.Tolerations = deployParameter.Tolerations
+	deployment.Spec.Template.Spec.PriorityClassName = deployParameter.PriorityClassName
+	for _, constraint := range deployParameter.TopologySpreadConstraints {
+		// spread devices of the same sandbox
+		constraint.LabelSelector = &metaV1.LabelSelector{
+			MatchLabels: map[string]string{
+				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+				OpenHydraSandboxKey:       deployParameter.SandboxName,
+			},
+		}
+		deployment.Spec.Template.Spec.TopologySpreadConstraints = append(deployment.Spec.Template.Spec.TopologySpreadConstraints, constraint)
+	}
+
+	if deployParameter.Queued {
+		markQueued(deployment, &deployParameter.QueuePriority)
+	}
 
 	return deployment
 }
 
-func (help *DefaultHelper) CreateDeployment(deployParameter *DeploymentParameters) error {
+// CreateDeployment creates deployment of device and returns the created one, service and routes of device are owned by it
+func (help *DefaultHelper) CreateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error) {
 	if deployParameter.Client == nil {
-		return fmt.Errorf("client is nil")
+		return nil, fmt.Errorf("client is nil")
 	}
 
 	deployment := createDeployment(deployParameter)
 
-	_, err := deployParameter.Client.AppsV1().Deployments(deployParameter.Namespace).Create(context.TODO(), deployment, metaV1.CreateOptions{})
-	if err != nil {
-		return err
-	}
+	return deployParameter.Client.AppsV1().Deployments(deployParameter.Namespace).Create(context.TODO(), deployment, metaV1.CreateOptions{})
+}
 
-	return nil
+// DeploymentOwnerReferences makes resource of device owned by deployment of device so it is garbage collected along with deployment
+// nil is returned when owner is unknown
+func DeploymentOwnerReferences(owner *appsV1.Deployment) []metaV1.OwnerReference {
+	if owner == nil || owner.UID == "" {
+		return nil
+	}
+	return []metaV1.OwnerReference{
+		{
+			APIVersion: appsV1.SchemeGroupVersion.String(),
+			Kind:       "Deployment",
+			Name:       owner.Name,
+			UID:        owner.UID,
+		},
+	}
 }
 
 func createResource(cpuMemorySet CpuMemorySet, gpuSet apis.GpuSet) (coreV1.ResourceList, coreV1.ResourceList) {
@@ -258,7 +472,101 @@ func createResource(cpuMemorySet CpuMemorySet, gpuSet apis.GpuSet) (coreV1.Resou
 	return resourceReq, resourceLim
 }
 
-func createContainers(baseName, image, username string, volumes []apis.VolumeMount, resourceReq, resourceLimit coreV1.ResourceList, command, args []string, ports map[string]int, additionalLabels map[string]string) []coreV1.Container {
+// UpdateDeployment replaces labels and pod template of existing user deployment with the one built from deployParameter
+// replicas are kept so a stopped device stays stopped, deployment before update is returned for rollback
+func (help *DefaultHelper) UpdateDeployment(deployParameter *DeploymentParameters) (*appsV1.Deployment, error) {
+	if deployParameter.Client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	// device is found by label since name of device may come from an older naming
+	deployments, err := deployParameter.Client.AppsV1().Deployments(deployParameter.Namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: DeviceLabelSelector(deployParameter.Username, deployParameter.DeviceId),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(deployments.Items) == 0 {
+		return nil, fmt.Errorf("deployment of device %s for user %s not found", DeviceId(deployParameter.DeviceId), deployParameter.Username)
+	}
+	current := &deployments.Items[0]
+	previous := current.DeepCopy()
+
+	desired := createDeployment(deployParameter)
+	current.Labels = desired.Labels
+	current.Spec.Template = desired.Spec.Template
+	// device created with rolling update is moved to recreate before its pod is replaced
+	current.Spec.Strategy = desired.Spec.Strategy
+
+	_, err = deployParameter.Client.AppsV1().Deployments(deployParameter.Namespace).Update(context.TODO(), current, metaV1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return previous, nil
+}
+
+// LabelLegacyDevice turns deployment and service of user created before user can own multiple devices into default device
+// pod template gets device label as well so pod of device is replaced once, service then selects pods of default device only
+func (help *DefaultHelper) LabelLegacyDevice(username, namespace string, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	legacy := metaV1.ListOptions{LabelSelector: LegacyDeviceLabelSelector(username)}
+	deployments, err := client.AppsV1().Deployments(namespace).List(context.TODO(), legacy)
+	if err != nil {
+		return err
+	}
+	for index := range deployments.Items {
+		deploy := &deployments.Items[index]
+		deploy.Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+		if deploy.Spec.Template.Labels == nil {
+			deploy.Spec.Template.Labels = map[string]string{}
+		}
+		deploy.Spec.Template.Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+		_, err = client.AppsV1().Deployments(namespace).Update(context.TODO(), deploy, metaV1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	services, err := client.CoreV1().Services(namespace).List(context.TODO(), legacy)
+	if err != nil {
+		return err
+	}
+	for index := range services.Items {
+		service := &services.Items[index]
+		service.Labels[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+		if service.Spec.Selector == nil {
+			service.Spec.Selector = map[string]string{}
+		}
+		service.Spec.Selector[OpenHydraDeviceLabelKey] = OpenHydraDefaultDeviceId
+		_, err = client.CoreV1().Services(namespace).Update(context.TODO(), service, metaV1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackDeployment restores labels and pod template saved by UpdateDeployment
+func (help *DefaultHelper) RollbackDeployment(previous *appsV1.Deployment, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	current, err := client.AppsV1().Deployments(previous.Namespace).Get(context.TODO(), previous.Name, metaV1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	current.Labels = previous.Labels
+	current.Spec.Template = previous.Spec.Template
+
+	_, err = client.AppsV1().Deployments(previous.Namespace).Update(context.TODO(), current, metaV1.UpdateOptions{})
+	return err
+}
+
+func createContainers(baseName, image, username, deviceId string, volumes []apis.VolumeMount, resourceReq, resourceLimit coreV1.ResourceList, command, args []string, ports map[string]int, baseURLs, additionalLabels map[string]string, sandboxEnv []apis.EnvVar, sandboxEnvFrom []apis.EnvFromSource) []coreV1.Container {
 	container := coreV1.Container{
 		Name:            baseName + "-container",
 		Image:           image,
@@ -276,9 +584,13 @@ func createContainers(baseName, image, username string, volumes []apis.VolumeMou
 			Name:          name,
 			ContainerPort: int32(port),
 		})
+		baseURL, found := baseURLs[name]
+		if !found {
+			baseURL = DeviceRouteName(username, deviceId, name)
+		}
 		envs = append(envs, coreV1.EnvVar{
 			Name:  fmt.Sprintf("OPENHYDRA_%s", strings.ReplaceAll(strings.ToUpper(name), "-", "_")),
-			Value: fmt.Sprintf("%s-%s", username, name),
+			Value: baseURL,
 		})
 	}
 
@@ -315,8 +627,12 @@ func createContainers(baseName, image, username string, volumes []apis.VolumeMou
 		Value: username,
 	})
 
+	// env of sandbox comes last so it may refer env above by $(NAME)
+	envs = append(envs, createEnv(sandboxEnv)...)
+
 	container.Ports = portsExported
 	container.Env = envs
+	container.EnvFrom = createEnvFrom(sandboxEnvFrom)
 
 	if len(command) > 0 {
 		container.Command = command
@@ -331,6 +647,7 @@ func createContainers(baseName, image, username string, volumes []apis.VolumeMou
 			Name:      volume.Name,
 			MountPath: volume.MountPath,
 			ReadOnly:  volume.ReadOnly,
+			SubPath:   volume.SubPath,
 		})
 	}
 
@@ -339,6 +656,109 @@ func createContainers(baseName, image, username string, volumes []apis.VolumeMou
 	}
 }
 
+// createProbe checks port by http get or tcp connect, baseURL replaces {base-url} in path of http check
+func createProbe(check apis.HealthCheck, port int, baseURL string) *coreV1.Probe {
+	probe := &coreV1.Probe{
+		InitialDelaySeconds: check.InitialDelaySeconds,
+		PeriodSeconds:       check.PeriodSeconds,
+		TimeoutSeconds:      check.TimeoutSeconds,
+		FailureThreshold:    check.FailureThreshold,
+	}
+	if check.Type == HealthCheckTCP {
+		probe.TCPSocket = &coreV1.TCPSocketAction{Port: intstr.FromInt(port)}
+		return probe
+	}
+	path := strings.ReplaceAll(check.Path, "{base-url}", strings.Trim(baseURL, "/"))
+	if path == "" {
+		path = "/"
+	}
+	probe.HTTPGet = &coreV1.HTTPGetAction{Path: path, Port: intstr.FromInt(port)}
+	return probe
+}
+
+func createEnv(env []apis.EnvVar) []coreV1.EnvVar {
+	var result []coreV1.EnvVar
+	for _, item := range env {
+		envVar := coreV1.EnvVar{
+			Name:  item.Name,
+			Value: item.Value,
+		}
+		if item.SecretKeyRef != nil {
+			envVar.ValueFrom = &coreV1.EnvVarSource{
+				SecretKeyRef: &coreV1.SecretKeySelector{
+					LocalObjectReference: coreV1.LocalObjectReference{Name: item.SecretKeyRef.Name},
+					Key:                  item.SecretKeyRef.Key,
+					Optional:             &item.SecretKeyRef.Optional,
+				},
+			}
+		} else if item.ConfigMapKeyRef != nil {
+			envVar.ValueFrom = &coreV1.EnvVarSource{
+				ConfigMapKeyRef: &coreV1.ConfigMapKeySelector{
+					LocalObjectReference: coreV1.LocalObjectReference{Name: item.ConfigMapKeyRef.Name},
+					Key:                  item.ConfigMapKeyRef.Key,
+					Optional:             &item.ConfigMapKeyRef.Optional,
+				},
+			}
+		}
+		result = append(result, envVar)
+	}
+	return result
+}
+
+func createEnvFrom(envFrom []apis.EnvFromSource) []coreV1.EnvFromSource {
+	var result []coreV1.EnvFromSource
+	for _, item := range envFrom {
+		source := coreV1.EnvFromSource{Prefix: item.Prefix}
+		optional := item.Optional
+		if item.SecretName != "" {
+			source.SecretRef = &coreV1.SecretEnvSource{
+				LocalObjectReference: coreV1.LocalObjectReference{Name: item.SecretName},
+				Optional:             &optional,
+			}
+		} else {
+			source.ConfigMapRef = &coreV1.ConfigMapEnvSource{
+				LocalObjectReference: coreV1.LocalObjectReference{Name: item.ConfigMapName},
+				Optional:             &optional,
+			}
+		}
+		result = append(result, source)
+	}
+	return result
+}
+
+// createInitContainers runs setup steps of sandbox with the same resources, env and volume mounts as sandbox container
+func createInitContainers(baseName, image string, volumes []apis.VolumeMount, resourceReq, resourceLimit coreV1.ResourceList, sandboxEnv []apis.EnvVar, sandboxEnvFrom []apis.EnvFromSource, initContainers []apis.InitContainer) []coreV1.Container {
+	var result []coreV1.Container
+	for _, init := range initContainers {
+		container := coreV1.Container{
+			Name:            fmt.Sprintf("%s-init-%s", baseName, init.Name),
+			Image:           init.Image,
+			ImagePullPolicy: coreV1.PullPolicy("IfNotPresent"),
+			Command:         init.Command,
+			Args:            init.Args,
+			Env:             createEnv(append(append([]apis.EnvVar{}, sandboxEnv...), init.Env...)),
+			EnvFrom:         createEnvFrom(sandboxEnvFrom),
+			Resources: coreV1.ResourceRequirements{
+				Limits:   resourceLimit,
+				Requests: resourceReq,
+			},
+		}
+		if container.Image == "" {
+			container.Image = image
+		}
+		for _, volume := range volumes {
+			container.VolumeMounts = append(container.VolumeMounts, coreV1.VolumeMount{
+				Name:      volume.Name,
+				MountPath: volume.MountPath,
+				ReadOnly:  volume.ReadOnly,
+				SubPath:   volume.SubPath,
+			})
+		}
+		result = append(result, container)
+	}
+	return result
+}
+
 func createVolume(volumes []apis.Volume) []coreV1.Volume {
 	// Todo: also move host path to volume
 	var volumeMounts []coreV1.Volume
@@ -367,12 +787,36 @@ func createVolume(volumes []apis.Volume) []coreV1.Volume {
 				},
 			})
 		}
+		if volume.PersistentVolumeClaim != nil {
+			volumeMounts = append(volumeMounts, coreV1.Volume{
+				Name: volume.PersistentVolumeClaim.Name,
+				VolumeSource: coreV1.VolumeSource{
+					PersistentVolumeClaim: &coreV1.PersistentVolumeClaimVolumeSource{
+						ClaimName: volume.PersistentVolumeClaim.ClaimName,
+						ReadOnly:  volume.PersistentVolumeClaim.ReadOnly,
+					},
+				},
+			})
+		}
+		if volume.NFS != nil {
+			volumeMounts = append(volumeMounts, coreV1.Volume{
+				Name: volume.NFS.Name,
+				VolumeSource: coreV1.VolumeSource{
+					NFS: &coreV1.NFSVolumeSource{
+						Server:   volume.NFS.Server,
+						Path:     volume.NFS.Path,
+						ReadOnly: volume.NFS.ReadOnly,
+					},
+				},
+			})
+		}
 	}
 
 	return volumeMounts
 }
 
-func (help *DefaultHelper) CreateService(namespace, studentID, ideType string, client *kubernetes.Clientset, ports map[string]int) error {
+// CreateService creates service of device, service is owned by owner when given
+func (help *DefaultHelper) CreateService(namespace, studentID, deviceId, ideType string, client *kubernetes.Clientset, ports map[string]int, serviceType coreV1.ServiceType, owner *appsV1.Deployment) error {
 
 	var portsExported []coreV1.ServicePort
 	for name, port := range ports {
@@ -384,17 +828,20 @@ func (help *DefaultHelper) CreateService(namespace, studentID, ideType string, c
 
 	service := &coreV1.Service{
 		ObjectMeta: metaV1.ObjectMeta{
-			Name:      fmt.Sprintf(OpenHydraServiceNameTemplate, studentID),
+			Name:      fmt.Sprintf(OpenHydraServiceNameTemplate, DeviceBaseName(studentID, deviceId)),
 			Namespace: namespace,
 			Labels: map[string]string{
 				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
 				OpenHydraUserLabelKey:     studentID,
+				OpenHydraDeviceLabelKey:   DeviceId(deviceId),
 			},
+			OwnerReferences: DeploymentOwnerReferences(owner),
 		},
 		Spec: coreV1.ServiceSpec{
-			Type: coreV1.ServiceTypeNodePort,
+			Type: serviceType,
 			Selector: map[string]string{
-				OpenHydraUserLabelKey: studentID,
+				OpenHydraUserLabelKey:   studentID,
+				OpenHydraDeviceLabelKey: DeviceId(deviceId),
 			},
 			Ports: portsExported,
 		},
@@ -448,6 +895,258 @@ func (help *DefaultHelper) GetUserService(label, namespace string, client *kuber
 	return result[0], nil
 }
 
+// deviceRouteHost returns host and path prefix port of device is routed by, host may be empty with path routing
+func deviceRouteHost(routeParameter *DeviceRouteParameters, portName string) (string, string) {
+	routeName := DeviceRouteName(routeParameter.Username, routeParameter.DeviceId, portName)
+	if routeParameter.HostRouting {
+		return fmt.Sprintf("%s.%s", routeName, routeParameter.Host), "/"
+	}
+	return routeParameter.Host, "/" + routeName
+}
+
+func deviceRouteMeta(routeParameter *DeviceRouteParameters, portName string) metaV1.ObjectMeta {
+	return metaV1.ObjectMeta{
+		Name:            fmt.Sprintf(OpenHydraRouteNameTemplate, DeviceRouteName(routeParameter.Username, routeParameter.DeviceId, portName)),
+		Namespace:       routeParameter.Namespace,
+		Annotations:     routeParameter.Annotations,
+		OwnerReferences: DeploymentOwnerReferences(routeParameter.Owner),
+		Labels: map[string]string{
+			OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+			OpenHydraUserLabelKey:     routeParameter.Username,
+			OpenHydraDeviceLabelKey:   DeviceId(routeParameter.DeviceId),
+		},
+	}
+}
+
+// createIngress routes a port of device service by host or path, tls is terminated by ingress when tls secret is given
+func createIngress(routeParameter *DeviceRouteParameters, portName string) *networkingV1.Ingress {
+	host, path := deviceRouteHost(routeParameter, portName)
+	pathType := networkingV1.PathTypePrefix
+	ingress := &networkingV1.Ingress{
+		ObjectMeta: deviceRouteMeta(routeParameter, portName),
+		Spec: networkingV1.IngressSpec{
+			Rules: []networkingV1.IngressRule{
+				{
+					Host: host,
+					IngressRuleValue: networkingV1.IngressRuleValue{
+						HTTP: &networkingV1.HTTPIngressRuleValue{
+							Paths: []networkingV1.HTTPIngressPath{
+								{
+									Path:     path,
+									PathType: &pathType,
+									Backend: networkingV1.IngressBackend{
+										Service: &networkingV1.IngressServiceBackend{
+											Name: fmt.Sprintf(OpenHydraServiceNameTemplate, DeviceBaseName(routeParameter.Username, routeParameter.DeviceId)),
+											Port: networkingV1.ServiceBackendPort{Name: portName},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if routeParameter.IngressClassName != "" {
+		ingress.Spec.IngressClassName = &routeParameter.IngressClassName
+	}
+	if routeParameter.TLSSecretName != "" {
+		tls := networkingV1.IngressTLS{SecretName: routeParameter.TLSSecretName}
+		if host != "" {
+			tls.Hosts = []string{host}
+		}
+		ingress.Spec.TLS = []networkingV1.IngressTLS{tls}
+	}
+	return ingress
+}
+
+// createHTTPRoute routes a port of device service through gateway, tls is terminated by listener of gateway
+// gateway api types are not vendored so route is built as unstructured object
+func createHTTPRoute(routeParameter *DeviceRouteParameters, portName string, port int) *unstructured.Unstructured {
+	host, path := deviceRouteHost(routeParameter, portName)
+	meta := deviceRouteMeta(routeParameter, portName)
+	parentRef := map[string]interface{}{"name": routeParameter.GatewayName}
+	if routeParameter.GatewayNamespace != "" {
+		parentRef["namespace"] = routeParameter.GatewayNamespace
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"matches": []interface{}{
+					map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": path}},
+				},
+				"backendRefs": []interface{}{
+					map[string]interface{}{
+						"name": fmt.Sprintf(OpenHydraServiceNameTemplate, DeviceBaseName(routeParameter.Username, routeParameter.DeviceId)),
+						"port": int64(port),
+					},
+				},
+			},
+		},
+	}
+	if host != "" {
+		spec["hostnames"] = []interface{}{host}
+	}
+	route := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	route.SetAPIVersion("gateway.networking.k8s.io/v1")
+	route.SetKind("HTTPRoute")
+	route.SetName(meta.Name)
+	route.SetNamespace(meta.Namespace)
+	route.SetLabels(meta.Labels)
+	route.SetAnnotations(meta.Annotations)
+	route.SetOwnerReferences(meta.OwnerReferences)
+	return route
+}
+
+func httpRoutePath(namespace string) string {
+	return fmt.Sprintf("/apis/gateway.networking.k8s.io/v1/namespaces/%s/httproutes", namespace)
+}
+
+// CreateDeviceRoute creates an ingress or httproute for every port of device
+func (help *DefaultHelper) CreateDeviceRoute(routeParameter *DeviceRouteParameters, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+	for portName, port := range routeParameter.Ports {
+		switch routeParameter.Kind {
+		case DeviceRouteKindIngress:
+			_, err := client.NetworkingV1().Ingresses(routeParameter.Namespace).Create(context.Background(), createIngress(routeParameter, portName), metaV1.CreateOptions{})
+			if err != nil {
+				return err
+			}
+		case DeviceRouteKindHTTPRoute:
+			body, err := createHTTPRoute(routeParameter, portName, port).MarshalJSON()
+			if err != nil {
+				return err
+			}
+			err = client.CoreV1().RESTClient().Post().AbsPath(httpRoutePath(routeParameter.Namespace)).
+				SetHeader("Content-Type", "application/json").Body(body).Do(context.Background()).Error()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown device route kind %s", routeParameter.Kind)
+		}
+	}
+	return nil
+}
+
+// DeleteUserRoute deletes both ingresses and httproutes selected by label, so exposure mode can change while devices exist
+// httproute is skipped when gateway api is not installed
+func (help *DefaultHelper) DeleteUserRoute(label, namespace string, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+	err := client.NetworkingV1().Ingresses(namespace).DeleteCollection(context.Background(), metaV1.DeleteOptions{}, metaV1.ListOptions{LabelSelector: label})
+	if err != nil {
+		return err
+	}
+	err = client.CoreV1().RESTClient().Delete().AbsPath(httpRoutePath(namespace)).Param("labelSelector", label).Do(context.Background()).Error()
+	if err != nil && !apiErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// EnsureUserWorkspace creates workspace claim of user unless it exists and returns claim name
+// existing claim is reused as it is, size and storage class only apply to new claim
+func (help *DefaultHelper) EnsureUserWorkspace(namespace, username, storageClass string, accessMode coreV1.PersistentVolumeAccessMode, size resource.Quantity, client *kubernetes.Clientset) (string, error) {
+	if client == nil {
+		return "", fmt.Errorf("client is nil")
+	}
+
+	claimName := fmt.Sprintf(OpenHydraWorkspaceNameTemplate, username)
+	_, err := client.CoreV1().PersistentVolumeClaims(namespace).Get(context.Background(), claimName, metaV1.GetOptions{})
+	if err == nil {
+		return claimName, nil
+	}
+	if !apiErrors.IsNotFound(err) {
+		return "", err
+	}
+
+	claim := &coreV1.PersistentVolumeClaim{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      claimName,
+			Namespace: namespace,
+			Labels: map[string]string{
+				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+				OpenHydraUserLabelKey:     username,
+			},
+		},
+		Spec: coreV1.PersistentVolumeClaimSpec{
+			AccessModes: []coreV1.PersistentVolumeAccessMode{accessMode},
+			Resources: coreV1.VolumeResourceRequirements{
+				Requests: coreV1.ResourceList{
+					coreV1.ResourceStorage: size,
+				},
+			},
+		},
+	}
+	if storageClass != "" {
+		claim.Spec.StorageClassName = &storageClass
+	}
+	_, err = client.CoreV1().PersistentVolumeClaims(namespace).Create(context.Background(), claim, metaV1.CreateOptions{})
+	if err != nil && !apiErrors.IsAlreadyExists(err) {
+		return "", err
+	}
+	return claimName, nil
+}
+
+// ApplyNetworkPolicy creates network policy or updates labels and spec of the existing one
+func (help *DefaultHelper) ApplyNetworkPolicy(policy *networkingV1.NetworkPolicy, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	existing, err := client.NetworkingV1().NetworkPolicies(policy.Namespace).Get(context.Background(), policy.Name, metaV1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		_, err = client.NetworkingV1().NetworkPolicies(policy.Namespace).Create(context.Background(), policy, metaV1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	existing.Labels = policy.Labels
+	if existing.Annotations == nil {
+		existing.Annotations = map[string]string{}
+	}
+	for key, value := range policy.Annotations {
+		existing.Annotations[key] = value
+	}
+	existing.Spec = policy.Spec
+	_, err = client.NetworkingV1().NetworkPolicies(policy.Namespace).Update(context.Background(), existing, metaV1.UpdateOptions{})
+	return err
+}
+
+func (help *DefaultHelper) ListNetworkPolicies(label, namespace string, client *kubernetes.Clientset) ([]networkingV1.NetworkPolicy, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	policies, err := client.NetworkingV1().NetworkPolicies(namespace).List(context.Background(), metaV1.ListOptions{
+		LabelSelector: label,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policies.Items, nil
+}
+
+// DeleteNetworkPolicy deletes network policy, policy already gone is not an error
+func (help *DefaultHelper) DeleteNetworkPolicy(name, namespace string, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	err := client.NetworkingV1().NetworkPolicies(namespace).Delete(context.Background(), name, metaV1.DeleteOptions{})
+	if err != nil && !apiErrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
 func (help *DefaultHelper) GetAllNode(client *kubernetes.Clientset) ([]coreV1.Node, error) {
 
 	nodes, err := help.nodeCache.List(labels.Everything())
@@ -463,6 +1162,75 @@ func (help *DefaultHelper) GetAllNode(client *kubernetes.Clientset) ([]coreV1.No
 	return noRefNodes, nil
 }
 
+// ListEvents lists events directly from api server, events are not cached since they are only read on demand
+func (help *DefaultHelper) ListEvents(namespace, fieldSelector string, client *kubernetes.Clientset) ([]coreV1.Event, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	events, err := client.CoreV1().Events(namespace).List(context.TODO(), metaV1.ListOptions{
+		FieldSelector: fieldSelector,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return events.Items, nil
+}
+
+func (help *DefaultHelper) CreateEvent(event *coreV1.Event, client *kubernetes.Clientset) error {
+	if client == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	_, err := client.CoreV1().Events(event.Namespace).Create(context.TODO(), event, metaV1.CreateOptions{})
+	return err
+}
+
+// GetPodLogs opens a log stream of container, caller must close the stream
+// stream ends when ctx is canceled which matters when follow is set
+func (help *DefaultHelper) GetPodLogs(ctx context.Context, namespace, podName string, options *coreV1.PodLogOptions, client *kubernetes.Clientset) (io.ReadCloser, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+
+	return client.CoreV1().Pods(namespace).GetLogs(podName, options).Stream(ctx)
+}
+
+// ExecInPod runs command in container and streams until command exits or ctx is canceled
+func (help *DefaultHelper) ExecInPod(ctx context.Context, namespace, podName, container string, command []string, streamOptions remotecommand.StreamOptions, kubeConfig *rest.Config, client *kubernetes.Clientset) error {
+	if client == nil || kubeConfig == nil {
+		return fmt.Errorf("client is nil")
+	}
+
+	request := client.CoreV1().RESTClient().Post().Resource("pods").Namespace(namespace).Name(podName).SubResource("exec").
+		VersionedParams(&coreV1.PodExecOptions{
+			Container: container,
+			Command:   command,
+			Stdin:     streamOptions.Stdin != nil,
+			Stdout:    streamOptions.Stdout != nil,
+			Stderr:    streamOptions.Stderr != nil,
+			TTY:       streamOptions.Tty,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(kubeConfig, http.MethodPost, request.URL())
+	if err != nil {
+		return err
+	}
+	return executor.StreamWithContext(ctx, streamOptions)
+}
+
+func (help *DefaultHelper) ListReplicaSetWithLabel(label, namespace string, client *kubernetes.Clientset) ([]appsV1.ReplicaSet, error) {
+	if client == nil {
+		return nil, fmt.Errorf("client is nil")
+	}
+	replicaSets, err := client.AppsV1().ReplicaSets(namespace).List(context.TODO(), metaV1.ListOptions{
+		LabelSelector: label,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replicaSets.Items, nil
+}
+
 func (help *DefaultHelper) DeleteUserReplicaSet(label, namespace string, client *kubernetes.Clientset) error {
 	if client == nil {
 		return fmt.Errorf("client is nil")
@@ -557,6 +1325,36 @@ func (help *DefaultHelper) RunInformers(stopChan <-chan struct{}) {
 	help.nodeCache = coreV1listers.NewNodeLister(help.nodeInformer.GetIndexer())
 }
 
+// AddWorkloadChangeHandler calls handler whenever a pod or service of open-hydra workload is added, updated or deleted
+func (help *DefaultHelper) AddWorkloadChangeHandler(handler func()) error {
+	if help.podInformer == nil || help.svcInformer == nil {
+		return fmt.Errorf("informer is not initialized")
+	}
+	workloadHandler := cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			object, err := meta.Accessor(obj)
+			if err != nil {
+				return false
+			}
+			return object.GetLabels()[OpenHydraWorkloadLabelKey] == OpenHydraWorkloadLabelValue
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(interface{}) { handler() },
+			UpdateFunc: func(interface{}, interface{}) { handler() },
+			DeleteFunc: func(interface{}) { handler() },
+		},
+	}
+	for _, informer := range []cache.SharedIndexInformer{help.podInformer, help.svcInformer} {
+		if _, err := informer.AddEventHandler(workloadHandler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (help *DefaultHelper) UpdateConfigMap(name, namespace string, data map[string]string) error {
 	if help.clientSet == nil {
 		return fmt.Errorf("client is nil")
@@ -9,6 +9,7 @@ import (
 	. "github.com/onsi/gomega"
 	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 var _ = Describe("K8s", func() {
@@ -127,13 +128,44 @@ var _ = Describe("K8s", func() {
 			Expect(volume[0].HostPath).To(BeNil())
 			Expect(string(volume[0].EmptyDir.Medium)).To(Equal("Memory"))
 		})
+		It("should be expected with persistent volume claim", func() {
+			volumes = []apis.Volume{
+				{
+					PersistentVolumeClaim: &apis.PersistentVolumeClaim{
+						Name:      "workspace",
+						ClaimName: "openhydra-workspace-test",
+					},
+				},
+			}
+			volume := createVolume(volumes)
+			Expect(volume[0].Name).To(Equal("workspace"))
+			Expect(volume[0].HostPath).To(BeNil())
+			Expect(volume[0].PersistentVolumeClaim.ClaimName).To(Equal("openhydra-workspace-test"))
+			Expect(volume[0].PersistentVolumeClaim.ReadOnly).To(BeFalse())
+		})
+		It("should be expected with nfs", func() {
+			volumes = []apis.Volume{
+				{
+					NFS: &apis.NFS{
+						Name:     "public-dataset",
+						Server:   "10.0.0.2",
+						Path:     "/export/dataset",
+						ReadOnly: true,
+					},
+				},
+			}
+			volume := createVolume(volumes)
+			Expect(volume[0].Name).To(Equal("public-dataset"))
+			Expect(volume[0].HostPath).To(BeNil())
+			Expect(*volume[0].NFS).To(Equal(coreV1.NFSVolumeSource{Server: "10.0.0.2", Path: "/export/dataset", ReadOnly: true}))
+		})
 	})
 
 	Describe("createContainers", func() {
 		It("should be expected", func() {
 			baseName := fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username)
 			reqRequest, limRequest := createResource(cpuMemorySet, gpuSet)
-			containers := createContainers(baseName, deployParameter.Image, "", deployParameter.VolumeMounts, reqRequest, limRequest, deployParameter.Command, deployParameter.Args, deployParameter.Ports, deployParameter.CustomLabels)
+			containers := createContainers(baseName, deployParameter.Image, "", "", deployParameter.VolumeMounts, reqRequest, limRequest, deployParameter.Command, deployParameter.Args, deployParameter.Ports, nil, deployParameter.CustomLabels, nil, nil)
 			Expect(containers[0].Name).To(Equal(fmt.Sprintf("%s-%s", baseName, "container")))
 			Expect(containers[0].Image).To(Equal(deployParameter.Image))
 			Expect(containers[0].VolumeMounts[0].Name).To(Equal("test"))
@@ -150,7 +182,7 @@ var _ = Describe("K8s", func() {
 			deployParameter.Args = nil
 			baseName := fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username)
 			reqRequest, limRequest := createResource(cpuMemorySet, gpuSet)
-			containers := createContainers(baseName, deployParameter.Image, "", deployParameter.VolumeMounts, reqRequest, limRequest, deployParameter.Command, deployParameter.Args, deployParameter.Ports, deployParameter.CustomLabels)
+			containers := createContainers(baseName, deployParameter.Image, "", "", deployParameter.VolumeMounts, reqRequest, limRequest, deployParameter.Command, deployParameter.Args, deployParameter.Ports, nil, deployParameter.CustomLabels, nil, nil)
 			Expect(containers[0].Name).To(Equal(fmt.Sprintf("%s-%s", baseName, "container")))
 			Expect(containers[0].Image).To(Equal(deployParameter.Image))
 			Expect(containers[0].VolumeMounts[0].Name).To(Equal("test"))
@@ -162,6 +194,17 @@ var _ = Describe("K8s", func() {
 			Expect(containers[0].Args).To(BeNil())
 			Expect(containers[0].Ports[0].ContainerPort).To(Equal(int32(8080)))
 		})
+		It("should be expected with base url", func() {
+			baseName := fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username)
+			reqRequest, limRequest := createResource(cpuMemorySet, gpuSet)
+			containers := createContainers(baseName, deployParameter.Image, "user1", "gpu", deployParameter.VolumeMounts, reqRequest, limRequest, deployParameter.Command, deployParameter.Args, map[string]int{"jupyter-lab": 8888, "vscode": 8080}, map[string]string{"vscode": "proxy/vscode"}, deployParameter.CustomLabels, nil, nil)
+			envs := map[string]string{}
+			for _, env := range containers[0].Env {
+				envs[env.Name] = env.Value
+			}
+			Expect(envs["OPENHYDRA_JUPYTER_LAB"]).To(Equal("user1--gpu--jupyter-lab"))
+			Expect(envs["OPENHYDRA_VSCODE"]).To(Equal("proxy/vscode"))
+		})
 	})
 
 	Describe("createDeployment", func() {
@@ -189,6 +232,87 @@ var _ = Describe("K8s", func() {
 			Expect(deployment.Spec.Template.Spec.Volumes[0].HostPath.Path).To(Equal("/test/path"))
 			Expect(deployment.Spec.Template.Spec.Affinity).To(Equal(affinity))
 		})
+		It("should be expected with env and init containers", func() {
+			deployParameter.Env = []apis.EnvVar{
+				{Name: "PIP_INDEX_URL", Value: "https://mirror.example.com/simple"},
+				{Name: "JUPYTER_TOKEN", SecretKeyRef: &apis.KeyRef{Name: "token-testUsername", Key: "token"}},
+			}
+			deployParameter.EnvFrom = []apis.EnvFromSource{
+				{ConfigMapName: "proxy", Optional: true},
+				{SecretName: "registry", Prefix: "REGISTRY_"},
+			}
+			deployParameter.InitContainers = []apis.InitContainer{
+				{Name: "fetch", Command: []string{"git", "clone"}, Env: []apis.EnvVar{{Name: "GIT_DEPTH", Value: "1"}}},
+				{Name: "setup", Image: "busybox"},
+			}
+			deployment := createDeployment(deployParameter)
+			container := deployment.Spec.Template.Spec.Containers[0]
+			Expect(container.Env[len(container.Env)-2]).To(Equal(coreV1.EnvVar{Name: "PIP_INDEX_URL", Value: "https://mirror.example.com/simple"}))
+			secretEnv := container.Env[len(container.Env)-1]
+			Expect(secretEnv.ValueFrom.SecretKeyRef.Name).To(Equal("token-testUsername"))
+			Expect(secretEnv.ValueFrom.SecretKeyRef.Key).To(Equal("token"))
+			Expect(*secretEnv.ValueFrom.SecretKeyRef.Optional).To(BeFalse())
+			Expect(container.EnvFrom).To(HaveLen(2))
+			Expect(container.EnvFrom[0].ConfigMapRef.Name).To(Equal("proxy"))
+			Expect(*container.EnvFrom[0].ConfigMapRef.Optional).To(BeTrue())
+			Expect(container.EnvFrom[1].SecretRef.Name).To(Equal("registry"))
+			Expect(*container.EnvFrom[1].SecretRef.Optional).To(BeFalse())
+			Expect(container.EnvFrom[1].Prefix).To(Equal("REGISTRY_"))
+
+			initContainers := deployment.Spec.Template.Spec.InitContainers
+			Expect(initContainers).To(HaveLen(2))
+			Expect(initContainers[0].Name).To(Equal(fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username) + "-init-fetch"))
+			Expect(initContainers[0].Image).To(Equal(deployParameter.Image))
+			Expect(initContainers[0].Command).To(Equal([]string{"git", "clone"}))
+			Expect(initContainers[0].Env).To(HaveLen(3))
+			Expect(initContainers[0].Env[2].Name).To(Equal("GIT_DEPTH"))
+			Expect(initContainers[0].EnvFrom).To(Equal(container.EnvFrom))
+			Expect(initContainers[0].VolumeMounts).To(Equal(container.VolumeMounts))
+			Expect(initContainers[0].Resources).To(Equal(container.Resources))
+			Expect(initContainers[1].Image).To(Equal("busybox"))
+			Expect(initContainers[1].Env).To(HaveLen(2))
+		})
+		It("should be expected with health check", func() {
+			deployParameter.DeviceId = "gpu"
+			deployParameter.HealthChecks = map[string]apis.HealthCheck{
+				"testPort": {Type: HealthCheckHTTP, Path: "/{base-url}/api/status", InitialDelaySeconds: 5, FailureThreshold: 6},
+			}
+			deployment := createDeployment(deployParameter)
+			container := deployment.Spec.Template.Spec.Containers[0]
+			Expect(container.ReadinessProbe.HTTPGet.Path).To(Equal("/testUsername--gpu--testPort/api/status"))
+			Expect(container.ReadinessProbe.HTTPGet.Port.IntValue()).To(Equal(8080))
+			Expect(container.ReadinessProbe.InitialDelaySeconds).To(Equal(int32(5)))
+			Expect(container.ReadinessProbe.FailureThreshold).To(Equal(int32(6)))
+			Expect(container.LivenessProbe).To(BeNil())
+
+			deployParameter.BaseURLs = map[string]string{"testPort": "apis/proxy/testPort"}
+			deployParameter.HealthChecks = map[string]apis.HealthCheck{"testPort": {Type: HealthCheckTCP, Liveness: true}}
+			deployment = createDeployment(deployParameter)
+			container = deployment.Spec.Template.Spec.Containers[0]
+			Expect(container.ReadinessProbe.HTTPGet).To(BeNil())
+			Expect(container.ReadinessProbe.TCPSocket.Port.IntValue()).To(Equal(8080))
+			Expect(container.LivenessProbe).To(Equal(container.ReadinessProbe))
+
+			Expect(createProbe(apis.HealthCheck{Type: HealthCheckHTTP}, 8888, "").HTTPGet.Path).To(Equal("/"))
+			Expect(createProbe(apis.HealthCheck{Type: HealthCheckHTTP, Path: "/{base-url}/lab"}, 8888, "apis/proxy/lab/").HTTPGet.Path).To(Equal("/apis/proxy/lab/lab"))
+		})
+		It("should be expected with scheduling", func() {
+			deployParameter.NodeSelector = map[string]string{"pool": "lab"}
+			deployParameter.Tolerations = []coreV1.Toleration{{Key: "nvidia.com/gpu", Operator: coreV1.TolerationOpExists}}
+			deployParameter.TopologySpreadConstraints = []coreV1.TopologySpreadConstraint{{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: coreV1.DoNotSchedule}}
+			deployParameter.PriorityClassName = "sandbox"
+			podSpec := createDeployment(deployParameter).Spec.Template.Spec
+			Expect(podSpec.NodeSelector).To(Equal(deployParameter.NodeSelector))
+			Expect(podSpec.Tolerations).To(Equal(deployParameter.Tolerations))
+			Expect(podSpec.PriorityClassName).To(Equal("sandbox"))
+			Expect(podSpec.TopologySpreadConstraints[0].WhenUnsatisfiable).To(Equal(coreV1.DoNotSchedule))
+			Expect(podSpec.TopologySpreadConstraints[0].LabelSelector.MatchLabels).To(Equal(map[string]string{
+				OpenHydraWorkloadLabelKey: OpenHydraWorkloadLabelValue,
+				OpenHydraSandboxKey:       "testSandbox",
+			}))
+			// constraints of parameter are left as they are
+			Expect(deployParameter.TopologySpreadConstraints[0].LabelSelector).To(BeNil())
+		})
 		It("should be expected label proper set", func() {
 			deployParameter.CustomLabels = map[string]string{
 				"testLabel": "testValue",
@@ -196,6 +320,19 @@ var _ = Describe("K8s", func() {
 			deployment := createDeployment(deployParameter)
 			Expect(deployment.Labels["testLabel"]).To(Equal("testValue"))
 		})
+		It("should be expected with device id", func() {
+			deployParameter.DeviceId = "gpu"
+			deployment := createDeployment(deployParameter)
+			Expect(deployment.Name).To(Equal(fmt.Sprintf(OpenHydraDeployNameTemplate, "testUsername--gpu")))
+			Expect(deployment.Labels[OpenHydraDeviceLabelKey]).To(Equal("gpu"))
+			Expect(deployment.Spec.Selector.MatchLabels[OpenHydraDeviceLabelKey]).To(Equal("gpu"))
+			Expect(deployment.Spec.Template.Labels[OpenHydraDeviceLabelKey]).To(Equal("gpu"))
+		})
+		It("should be expected default device keep name of user", func() {
+			deployment := createDeployment(deployParameter)
+			Expect(deployment.Name).To(Equal(fmt.Sprintf(OpenHydraDeployNameTemplate, deployParameter.Username)))
+			Expect(deployment.Labels[OpenHydraDeviceLabelKey]).To(Equal(OpenHydraDefaultDeviceId))
+		})
 		It("should be expected custom label key should be ignored", func() {
 			deployParameter.CustomLabels = map[string]string{
 				OpenHydraUserLabelKey: "testValue",
@@ -205,4 +342,80 @@ var _ = Describe("K8s", func() {
 		})
 
 	})
+
+	Describe("device naming", func() {
+		It("should be expected", func() {
+			Expect(DeviceId("")).To(Equal(OpenHydraDefaultDeviceId))
+			Expect(DeviceBaseName("user1", "")).To(Equal("user1"))
+			Expect(DeviceBaseName("user1", OpenHydraDefaultDeviceId)).To(Equal("user1"))
+			Expect(DeviceBaseName("user1", "gpu")).To(Equal("user1--gpu"))
+			Expect(DeviceBaseName("user1-gpu", "")).NotTo(Equal(DeviceBaseName("user1", "gpu")))
+			Expect(DeviceRouteName("user1", "", "jupyter-lab")).To(Equal("user1-jupyter-lab"))
+			Expect(DeviceRouteName("user1", "gpu", "jupyter-lab")).To(Equal("user1--gpu--jupyter-lab"))
+			Expect(LegacyDeviceLabelSelector("user1")).To(Equal("openhydra-user=user1,!openhydra-device"))
+			Expect(DeviceLabelSelector("user1", "")).To(Equal("openhydra-user=user1,openhydra-device=default"))
+		})
+	})
+
+	Describe("device route", func() {
+		var routeParameter *DeviceRouteParameters
+		BeforeEach(func() {
+			routeParameter = &DeviceRouteParameters{
+				Namespace: "test",
+				Username:  "user1",
+				DeviceId:  "gpu",
+				Ports:     map[string]int{"jupyter-lab": 8888},
+				Kind:      DeviceRouteKindIngress,
+			}
+		})
+		It("ingress should be expected with path routing", func() {
+			ingress := createIngress(routeParameter, "jupyter-lab")
+			Expect(ingress.Name).To(Equal("openhydra-route-user1--gpu--jupyter-lab"))
+			Expect(ingress.Namespace).To(Equal("test"))
+			Expect(ingress.Labels[OpenHydraUserLabelKey]).To(Equal("user1"))
+			Expect(ingress.Labels[OpenHydraDeviceLabelKey]).To(Equal("gpu"))
+			Expect(ingress.Spec.IngressClassName).To(BeNil())
+			Expect(ingress.Spec.TLS).To(BeEmpty())
+			Expect(ingress.Spec.Rules).To(HaveLen(1))
+			Expect(ingress.Spec.Rules[0].Host).To(Equal(""))
+			path := ingress.Spec.Rules[0].HTTP.Paths[0]
+			Expect(path.Path).To(Equal("/user1--gpu--jupyter-lab"))
+			Expect(path.Backend.Service.Name).To(Equal("openhydra-service-user1--gpu"))
+			Expect(path.Backend.Service.Port.Name).To(Equal("jupyter-lab"))
+		})
+		It("ingress should be expected with host routing and tls", func() {
+			routeParameter.HostRouting = true
+			routeParameter.Host = "lab.example.com"
+			routeParameter.IngressClassName = "nginx"
+			routeParameter.TLSSecretName = "lab-tls"
+			ingress := createIngress(routeParameter, "jupyter-lab")
+			Expect(*ingress.Spec.IngressClassName).To(Equal("nginx"))
+			Expect(ingress.Spec.Rules[0].Host).To(Equal("user1--gpu--jupyter-lab.lab.example.com"))
+			Expect(ingress.Spec.Rules[0].HTTP.Paths[0].Path).To(Equal("/"))
+			Expect(ingress.Spec.TLS).To(HaveLen(1))
+			Expect(ingress.Spec.TLS[0].SecretName).To(Equal("lab-tls"))
+			Expect(ingress.Spec.TLS[0].Hosts).To(Equal([]string{"user1--gpu--jupyter-lab.lab.example.com"}))
+		})
+		It("httproute should be expected", func() {
+			routeParameter.Kind = DeviceRouteKindHTTPRoute
+			routeParameter.Host = "lab.example.com"
+			routeParameter.GatewayName = "gateway"
+			routeParameter.GatewayNamespace = "gateway-system"
+			route := createHTTPRoute(routeParameter, "jupyter-lab", 8888)
+			Expect(route.GetKind()).To(Equal("HTTPRoute"))
+			Expect(route.GetName()).To(Equal("openhydra-route-user1--gpu--jupyter-lab"))
+			Expect(route.GetLabels()[OpenHydraUserLabelKey]).To(Equal("user1"))
+			parentRefs, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+			Expect(parentRefs).To(Equal([]interface{}{map[string]interface{}{"name": "gateway", "namespace": "gateway-system"}}))
+			hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames")
+			Expect(hostnames).To(Equal([]string{"lab.example.com"}))
+			rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+			Expect(rules).To(HaveLen(1))
+			rule := rules[0].(map[string]interface{})
+			Expect(rule["matches"]).To(Equal([]interface{}{map[string]interface{}{"path": map[string]interface{}{"type": "PathPrefix", "value": "/user1--gpu--jupyter-lab"}}}))
+			Expect(rule["backendRefs"]).To(Equal([]interface{}{map[string]interface{}{"name": "openhydra-service-user1--gpu", "port": int64(8888)}}))
+			_, err := route.MarshalJSON()
+			Expect(err).To(BeNil())
+		})
+	})
 })
@@ -0,0 +1,190 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// dns namespace used when network policy config does not set one
+var defaultDnsNamespaceSelector = map[string]string{"kubernetes.io/metadata.name": "kube-system"}
+
+func networkPolicyPorts(protocol coreV1.Protocol, ports []int32) []networkingV1.NetworkPolicyPort {
+	var result []networkingV1.NetworkPolicyPort
+	for _, port := range ports {
+		proto := protocol
+		value := intstr.FromInt32(port)
+		result = append(result, networkingV1.NetworkPolicyPort{Protocol: &proto, Port: &value})
+	}
+	return result
+}
+
+// networkPolicyPeer converts configured peer, peer setting neither cidr nor selectors is anyone so it is nil
+func networkPolicyPeer(peer config.NetworkPeer) []networkingV1.NetworkPolicyPeer {
+	if peer.CIDR != "" {
+		return []networkingV1.NetworkPolicyPeer{{IPBlock: &networkingV1.IPBlock{CIDR: peer.CIDR, Except: peer.Except}}}
+	}
+	if peer.NamespaceSelector == nil && peer.PodSelector == nil {
+		return nil
+	}
+	result := networkingV1.NetworkPolicyPeer{}
+	if peer.NamespaceSelector != nil {
+		result.NamespaceSelector = &metaV1.LabelSelector{MatchLabels: peer.NamespaceSelector}
+	}
+	if peer.PodSelector != nil {
+		result.PodSelector = &metaV1.LabelSelector{MatchLabels: peer.PodSelector}
+	}
+	return []networkingV1.NetworkPolicyPeer{result}
+}
+
+// deviceNetworkPolicy isolates devices of user, they only accept traffic from devices of the same user and ingress peers
+// and only reach devices of the same user, dns and egress peers, nothing is reached in exam mode
+func deviceNetworkPolicy(username string, policyConfig *config.NetworkPolicyConfig) *networkingV1.NetworkPolicy {
+	userLabels := map[string]string{
+		k8s.OpenHydraWorkloadLabelKey: k8s.OpenHydraWorkloadLabelValue,
+		k8s.OpenHydraUserLabelKey:     username,
+	}
+	sameUser := []networkingV1.NetworkPolicyPeer{{PodSelector: &metaV1.LabelSelector{MatchLabels: userLabels}}}
+
+	policy := &networkingV1.NetworkPolicy{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      fmt.Sprintf(k8s.OpenHydraNetworkPolicyNameTemplate, username),
+			Namespace: OpenhydraNamespace,
+			Labels:    userLabels,
+		},
+		Spec: networkingV1.NetworkPolicySpec{
+			PodSelector: metaV1.LabelSelector{MatchLabels: userLabels},
+			PolicyTypes: []networkingV1.PolicyType{networkingV1.PolicyTypeIngress, networkingV1.PolicyTypeEgress},
+			Ingress:     []networkingV1.NetworkPolicyIngressRule{{From: sameUser}},
+		},
+	}
+	for _, peer := range policyConfig.Ingress {
+		policy.Spec.Ingress = append(policy.Spec.Ingress, networkingV1.NetworkPolicyIngressRule{
+			From:  networkPolicyPeer(peer),
+			Ports: networkPolicyPorts(coreV1.ProtocolTCP, peer.Ports),
+		})
+	}
+	if policyConfig.ExamMode {
+		// egress type without any rule denies all egress
+		return policy
+	}
+
+	dnsNamespace := policyConfig.DnsNamespaceSelector
+	if dnsNamespace == nil {
+		dnsNamespace = defaultDnsNamespaceSelector
+	}
+	policy.Spec.Egress = []networkingV1.NetworkPolicyEgressRule{
+		{To: sameUser},
+		{
+			To:    []networkingV1.NetworkPolicyPeer{{NamespaceSelector: &metaV1.LabelSelector{MatchLabels: dnsNamespace}}},
+			Ports: append(networkPolicyPorts(coreV1.ProtocolUDP, []int32{53}), networkPolicyPorts(coreV1.ProtocolTCP, []int32{53})...),
+		},
+	}
+	for _, peer := range policyConfig.Egress {
+		policy.Spec.Egress = append(policy.Spec.Egress, networkingV1.NetworkPolicyEgressRule{
+			To:    networkPolicyPeer(peer),
+			Ports: networkPolicyPorts(coreV1.ProtocolTCP, peer.Ports),
+		})
+	}
+	return policy
+}
+
+// checkNetworkPolicyConfig refuses network policy without ingress peers, sandbox would be unreachable in every exposure mode
+// since browser comes through open-hydra-server, ingress controller or node port and none of them is known to open-hydra
+func checkNetworkPolicyConfig(serverConfig *config.OpenHydraServerConfig) error {
+	if len(serverConfig.NetworkPolicy.Ingress) > 0 {
+		return nil
+	}
+	switch serverConfig.SandboxExposureMode {
+	case SandboxExposureProxy:
+		return fmt.Errorf("network policy needs an ingress peer selecting open-hydra-server pods in %s exposure mode", serverConfig.SandboxExposureMode)
+	case SandboxExposureIngress, SandboxExposureHTTPRoute:
+		return fmt.Errorf("network policy needs an ingress peer selecting ingress controller or gateway pods in %s exposure mode", serverConfig.SandboxExposureMode)
+	default:
+		return fmt.Errorf("network policy needs an ingress peer with cidr of clients or nodes in %s exposure mode", serverConfig.SandboxExposureMode)
+	}
+}
+
+// ensureNetworkPolicy applies network policy of user before device of user is created so device is never left open
+func (builder *OpenHydraRouteBuilder) ensureNetworkPolicy(username string, serverConfig *config.OpenHydraServerConfig) error {
+	if serverConfig.NetworkPolicy == nil {
+		return nil
+	}
+	if err := checkNetworkPolicyConfig(serverConfig); err != nil {
+		return err
+	}
+	policy := deviceNetworkPolicy(username, serverConfig.NetworkPolicy)
+	policy.Annotations = map[string]string{k8s.OpenHydraPolicyAppliedAtAnnotation: time.Now().UTC().Format(time.RFC3339Nano)}
+	return builder.k8sHelper.ApplyNetworkPolicy(policy, builder.kubeClient)
+}
+
+// networkPolicyRecentlyApplied tells whether policy is applied by a device create which may not have created deployment yet
+func networkPolicyRecentlyApplied(policy networkingV1.NetworkPolicy, now time.Time) bool {
+	appliedAt := policy.CreationTimestamp.Time
+	if value, err := time.Parse(time.RFC3339Nano, policy.Annotations[k8s.OpenHydraPolicyAppliedAtAnnotation]); err == nil {
+		appliedAt = value
+	}
+	return now.Sub(appliedAt) < deviceReconcileGracePeriod
+}
+
+// reconcileNetworkPolicies keeps a network policy for every user owning devices in line with config
+// policy of user without devices is deleted unless a device create applied it within grace period,
+// all policies are deleted once network policy config is removed
+func (builder *OpenHydraRouteBuilder) reconcileNetworkPolicies(serverConfig *config.OpenHydraServerConfig) error {
+	workloadLabel := fmt.Sprintf("%s=%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue)
+	policies, err := builder.k8sHelper.ListNetworkPolicies(workloadLabel, OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+
+	desired := map[string]*networkingV1.NetworkPolicy{}
+	if serverConfig.NetworkPolicy != nil {
+		// policies are left as they are rather than opened or closed by a config which can not work
+		if err := checkNetworkPolicyConfig(serverConfig); err != nil {
+			return err
+		}
+		deploys, err := builder.k8sHelper.ListDeploymentWithLabel(workloadLabel, OpenhydraNamespace, builder.kubeClient)
+		if err != nil {
+			return err
+		}
+		for _, deploy := range deploys {
+			if username, found := deploy.Labels[k8s.OpenHydraUserLabelKey]; found {
+				policy := deviceNetworkPolicy(username, serverConfig.NetworkPolicy)
+				desired[policy.Name] = policy
+			}
+		}
+	}
+
+	now := time.Now()
+	for _, policy := range policies {
+		want, found := desired[policy.Name]
+		if !found {
+			if serverConfig.NetworkPolicy != nil && networkPolicyRecentlyApplied(policy, now) {
+				continue
+			}
+			if err := builder.k8sHelper.DeleteNetworkPolicy(policy.Name, OpenhydraNamespace, builder.kubeClient); err != nil {
+				slog.Error("Failed to delete network policy", "policy", policy.Name, "error", err)
+			}
+			continue
+		}
+		if equality.Semantic.DeepEqual(policy.Spec, want.Spec) {
+			delete(desired, policy.Name)
+		}
+	}
+	for name, policy := range desired {
+		// other users are still reconciled
+		if err := builder.k8sHelper.ApplyNetworkPolicy(policy, builder.kubeClient); err != nil {
+			slog.Error("Failed to apply network policy", "policy", name, "error", err)
+		}
+	}
+	return nil
+}
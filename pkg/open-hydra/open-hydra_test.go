@@ -2,11 +2,14 @@ package openhydra
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"open-hydra/cmd/open-hydra-server/app/config"
 	"open-hydra/cmd/open-hydra-server/app/option"
 	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
@@ -19,6 +22,8 @@ import (
 	"open-hydra/pkg/util"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"net/http/httptest"
 
@@ -27,11 +32,17 @@ import (
 	"mime/multipart"
 
 	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/websocket"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsV1 "k8s.io/api/apps/v1"
 	coreV1 "k8s.io/api/core/v1"
+	networkingV1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
 var _ = Describe("open-hydra api-resource test", func() {
@@ -78,6 +89,20 @@ var _ = Describe("open-hydra api-resource test", func() {
 			Kind:         CourseKind,
 			Verbs:        metaV1.Verbs{"get", "list", "watch", "create", "update", "delete"},
 		},
+		{
+			Name:         DeviceBatchPath,
+			SingularName: "devbatch",
+			Namespaced:   false,
+			Kind:         DeviceBatchKind,
+			Verbs:        metaV1.Verbs{"get", "list", "create"},
+		},
+		{
+			Name:         UsageReportPath,
+			SingularName: "usagereport",
+			Namespaced:   false,
+			Kind:         UsageReportKind,
+			Verbs:        metaV1.Verbs{"get"},
+		},
 	}
 	BeforeEach(func() {
 	})
@@ -388,6 +413,22 @@ var _ = Describe("SumUpGpuResources test", func() {
 		Expect(gpuResources.Spec.GpuResourceSumUp["muxi"].Allocated).To(Equal(int64(0)))
 	})
 
+	It("sum up gpu profiles should be expected", func() {
+		fakeK8sHelper.ServerConfig.GpuProfiles = map[string]config.GpuProfile{
+			"whole":      {Type: GpuProfileTypeWhole, ResourceKey: "nvidia.com/gpu"},
+			"mig-1g.5gb": {Type: GpuProfileTypeMig, ResourceKey: "nvidia.com/mig-1g.5gb"},
+		}
+		nodes.Items = append(nodes.Items, createFakeNode("test", "test", "nvidia.com/mig-1g.5gb", "7"))
+		pods = append(pods, createFakeGpuPod("test", "test", "nvidia.com/mig-1g.5gb", "2"))
+		gpuResources, err := builder.SumUpGpuResources(pods, nodes)
+		Expect(err).To(BeNil())
+		Expect(gpuResources.Spec.GpuProfileSumUp["whole"].Allocatable).To(Equal(int64(2)))
+		Expect(gpuResources.Spec.GpuProfileSumUp["whole"].Allocated).To(Equal(int64(2)))
+		Expect(gpuResources.Spec.GpuProfileSumUp["mig-1g.5gb"].Type).To(Equal(GpuProfileTypeMig))
+		Expect(gpuResources.Spec.GpuProfileSumUp["mig-1g.5gb"].Allocatable).To(Equal(int64(7)))
+		Expect(gpuResources.Spec.GpuProfileSumUp["mig-1g.5gb"].Allocated).To(Equal(int64(2)))
+	})
+
 	It("add more device without config it should be expected", func() {
 		fakeK8sHelper.ServerConfig.GpuResourceKeys = append(fakeK8sHelper.ServerConfig.GpuResourceKeys, "huawei")
 		nodes.Items = append(nodes.Items, createFakeNode("test", "test", "huawei", "2"))
@@ -417,6 +458,73 @@ var _ = Describe("SumUpGpuResources test", func() {
 	})
 })
 
+var _ = Describe("gpu queue test", func() {
+	now := time.Now()
+	entry := func(username string, gpu int64, priority int32, queuedAgo time.Duration) gpuQueueEntry {
+		return gpuQueueEntry{
+			label:    k8s.DeviceLabelSelector(username, ""),
+			username: username,
+			driver:   "nvidia.com/gpu",
+			gpu:      gpu,
+			priority: priority,
+			queuedAt: now.Add(-queuedAgo),
+		}
+	}
+
+	It("fair share should serve priority then user with less gpu", func() {
+		entries := []gpuQueueEntry{
+			entry("heavy", 1, 0, 3*time.Minute),
+			entry("light", 1, 0, 2*time.Minute),
+			entry("urgent", 1, 5, time.Minute),
+		}
+		sortGpuQueue(entries, GpuQueuePolicyFairShare, map[string]int64{"heavy": 2})
+		Expect(entries[0].username).To(Equal("urgent"))
+		Expect(entries[1].username).To(Equal("light"))
+		Expect(entries[2].username).To(Equal("heavy"))
+
+		sortGpuQueue(entries, GpuQueuePolicyFifo, map[string]int64{"heavy": 2})
+		Expect(entries[0].username).To(Equal("heavy"))
+		Expect(entries[1].username).To(Equal("light"))
+		Expect(entries[2].username).To(Equal("urgent"))
+	})
+
+	It("fair share should serve user whose groups hold less gpu when users hold the same", func() {
+		serverConfig := &config.OpenHydraServerConfig{Quota: &config.QuotaConfig{Groups: []config.GroupQuota{
+			{Name: "busy", Members: []string{"alice", "bob"}},
+			{Name: "idle", Members: []string{"carol", "dave"}},
+		}}}
+		usage := map[string]int64{"bob": 2, "dave": 1}
+		Expect(groupGpuUsage("alice", usage, serverConfig)).To(Equal(int64(2)))
+		Expect(groupGpuUsage("nobody", usage, serverConfig)).To(BeZero())
+
+		entries := []gpuQueueEntry{entry("alice", 1, 0, 2*time.Minute), entry("carol", 1, 0, time.Minute)}
+		for index := range entries {
+			entries[index].groupUsage = groupGpuUsage(entries[index].username, usage, serverConfig)
+		}
+		sortGpuQueue(entries, GpuQueuePolicyFairShare, usage)
+		Expect(entries[0].username).To(Equal("carol"))
+		Expect(entries[1].username).To(Equal("alice"))
+	})
+
+	It("estimated wait should follow gpu release", func() {
+		holders := []gpuHolder{
+			{gpu: 1, releaseAt: now.Add(30 * time.Minute)},
+			{gpu: 1, releaseAt: now.Add(10 * time.Minute)},
+		}
+		entries := []gpuQueueEntry{
+			entry("a", 1, 0, 0),
+			entry("b", 2, 0, 0),
+			entry("c", 3, 0, 0),
+		}
+		waits := estimateGpuWait(entries, 0, holders, now, time.Hour)
+		Expect(waits[0]).To(Equal(int64(600)))
+		// waits for the other holder and device a
+		Expect(waits[1]).To(Equal(int64(4200)))
+		// cluster only has 2 gpu
+		Expect(waits[2]).To(Equal(int64(-1)))
+	})
+})
+
 var _ = Describe("open-hydra-server combineDeviceList test", func() {
 	var pods []coreV1.Pod
 	var services []coreV1.Service
@@ -589,15 +697,199 @@ var _ = Describe("open-hydra-server combineDeviceList test", func() {
 			Expect(devices[0].Spec.SandboxURLs).To(Equal("http://localhost:5000,http://localhost:8888"))
 		})
 
+		It("should report gpu of any configured vendor", func() {
+			pods[1].Spec.Containers = []coreV1.Container{
+				{Resources: coreV1.ResourceRequirements{Requests: coreV1.ResourceList{"amd.com/gpu": resource.MustParse("1")}}},
+				{Resources: coreV1.ResourceRequirements{Requests: coreV1.ResourceList{"amd.com/gpu": resource.MustParse("2")}}},
+			}
+			devices := combineDeviceList(pods, services, users, openHydraConfig)
+			Expect(devices[1].Spec.DeviceType).To(Equal("gpu"))
+			Expect(devices[1].Spec.GpuDriver).To(Equal("amd.com/gpu"))
+			Expect(devices[1].Spec.DeviceGpu).To(Equal(uint8(3)))
+		})
+
+		It("gpu model should be read from node labels", func() {
+			labels := map[string]string{"nvidia.com/gpu.product": "NVIDIA-A100-SXM4-40GB"}
+			Expect(gpuModelOfNode("nvidia.com/gpu", labels, openHydraConfig)).To(Equal("NVIDIA-A100-SXM4-40GB"))
+			// mig slice falls back to label of the same vendor
+			Expect(gpuModelOfNode("nvidia.com/mig-1g.5gb", labels, openHydraConfig)).To(Equal("NVIDIA-A100-SXM4-40GB"))
+			Expect(gpuModelOfNode("amd.com/gpu", labels, openHydraConfig)).To(BeEmpty())
+			Expect(gpuModelOfNode("huawei", labels, openHydraConfig)).To(BeEmpty())
+		})
+
+		It("gpu model affinity should be added to every node selector term", func() {
+			affinity := &coreV1.Affinity{NodeAffinity: &coreV1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &coreV1.NodeSelector{
+				NodeSelectorTerms: []coreV1.NodeSelectorTerm{
+					{MatchExpressions: []coreV1.NodeSelectorRequirement{{Key: "zone", Operator: coreV1.NodeSelectorOpIn, Values: []string{"a"}}}},
+					{MatchExpressions: []coreV1.NodeSelectorRequirement{{Key: "nvidia.com/gpu.product", Operator: coreV1.NodeSelectorOpIn, Values: []string{"T4"}}}},
+				},
+			}}}
+			result := withGpuModelAffinity(affinity, "nvidia.com/gpu.product", "A100")
+			terms := result.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+			Expect(len(terms[0].MatchExpressions)).To(Equal(2))
+			Expect(len(terms[1].MatchExpressions)).To(Equal(1))
+			Expect(gpuModelOfAffinity(result, "nvidia.com/gpu.product")).To(Equal("A100"))
+			// given affinity is left untouched
+			Expect(gpuModelOfAffinity(affinity, "nvidia.com/gpu.product")).To(Equal("T4"))
+			Expect(gpuModelOfAffinity(withGpuModelAffinity(nil, "nvidia.com/gpu.product", "A100"), "nvidia.com/gpu.product")).To(Equal("A100"))
+		})
+
+		It("should be route address", func() {
+			openHydraConfig.SandboxExposureMode = SandboxExposureHTTPRoute
+			openHydraConfig.SandboxRoute = &config.SandboxRouteConfig{Routing: SandboxRoutingHost, Host: "lab.example.com", Https: true, Port: 8443}
+			openHydraConfig.ApplyPortNameForIngress = map[string]string{"jupyter-lab": "lab"}
+			result := combineUrl(openHydraConfig.ServerIP, "user1", "gpu", "jupyter-lab", 0, false, openHydraConfig)
+			Expect(result).To(Equal("https://user1--gpu--jupyter-lab.lab.example.com:8443/user1--gpu--jupyter-lab/lab"))
+			openHydraConfig.SandboxRoute = &config.SandboxRouteConfig{Routing: SandboxRoutingPath}
+			openHydraConfig.ServerIP = "localhost,10.0.0.10"
+			result = combineUrl(openHydraConfig.ServerIP, "user1", "", "vscode", 0, false, openHydraConfig)
+			Expect(result).To(Equal("http://localhost/user1-vscode,http://10.0.0.10/user1-vscode"))
+		})
+
 		It("should be container two address", func() {
-			result := combineUrl(openHydraConfig.ServerIP, "", "", 5000, false, nil)
+			result := combineUrl(openHydraConfig.ServerIP, "", "", "", 5000, false, nil)
 			Expect(result).To(Equal("http://localhost:5000"))
 			openHydraConfig.ServerIP = "localhost,10.0.0.10"
-			result = combineUrl(openHydraConfig.ServerIP, "", "", 5000, false, nil)
+			result = combineUrl(openHydraConfig.ServerIP, "", "", "", 5000, false, nil)
 			Expect(result).To(Equal("http://localhost:5000,http://10.0.0.10:5000"))
 		})
 	})
 
+	Describe("sandbox proxy host test", func() {
+		It("should be expected", func() {
+			Expect(sandboxProxyHost("user1", "", "jupyter-lab", openHydraConfig)).To(BeEmpty())
+			request := httptest.NewRequest(http.MethodGet, "http://localhost/lab", nil)
+			// sandbox is never served on origin of api
+			Expect(onSandboxProxyHost(request, "user1", "", "jupyter-lab", openHydraConfig)).To(BeFalse())
+			Expect(checkSandboxProxyConfig(openHydraConfig)).To(BeNil())
+			openHydraConfig.SandboxExposureMode = SandboxExposureProxy
+			Expect(checkSandboxProxyConfig(openHydraConfig)).NotTo(BeNil())
+
+			openHydraConfig.SandboxProxyDomain = "sandbox.example.com"
+			Expect(checkSandboxProxyConfig(openHydraConfig)).To(BeNil())
+			host := sandboxProxyHost("user1", "", "jupyter-lab", openHydraConfig)
+			Expect(host).To(MatchRegexp(`^jupyter-lab-[0-9a-f]{16}\.sandbox\.example\.com$`))
+			Expect(sandboxProxyHost("user1", k8s.OpenHydraDefaultDeviceId, "jupyter-lab", openHydraConfig)).To(Equal(host))
+			// devices of different users never share origin
+			Expect(sandboxProxyHost("user1-gpu", "", "jupyter-lab", openHydraConfig)).NotTo(Equal(host))
+			Expect(sandboxProxyHost("user1", "gpu", "jupyter-lab", openHydraConfig)).NotTo(Equal(host))
+			Expect(onSandboxProxyHost(request, "user1", "", "jupyter-lab", openHydraConfig)).To(BeFalse())
+			request.Host = host + ":8443"
+			Expect(onSandboxProxyHost(request, "user1", "", "jupyter-lab", openHydraConfig)).To(BeTrue())
+			Expect(onSandboxProxyHost(request, "user1", "", "vscode", openHydraConfig)).To(BeFalse())
+
+			openHydraConfig.SandboxProxyBaseURL = "https://openhydra.example.com:8443"
+			Expect(sandboxProxyUrl("user1", "", "jupyter-lab", openHydraConfig)).To(HavePrefix(fmt.Sprintf("https://%s:8443%s/", host, sandboxProxyPath("user1", "", "jupyter-lab"))))
+		})
+	})
+
+	Describe("deviceStatusFromPod test", func() {
+		It("should be expected unschedulable", func() {
+			pod := coreV1.Pod{Status: coreV1.PodStatus{
+				Phase: coreV1.PodPending,
+				Conditions: []coreV1.PodCondition{
+					{Type: coreV1.PodScheduled, Status: coreV1.ConditionFalse, Reason: "Unschedulable", Message: "0/1 nodes are available: 1 Insufficient nvidia.com/gpu."},
+				},
+			}}
+			status := deviceStatusFromPod(pod)
+			Expect(status.Reason).To(Equal("Unschedulable"))
+			Expect(status.Message).To(ContainSubstring("Insufficient nvidia.com/gpu"))
+			Expect(len(status.Conditions)).To(Equal(1))
+		})
+		It("should be expected image pull back off", func() {
+			pod := coreV1.Pod{Status: coreV1.PodStatus{
+				Phase: coreV1.PodPending,
+				Conditions: []coreV1.PodCondition{
+					{Type: coreV1.PodScheduled, Status: coreV1.ConditionTrue},
+				},
+				ContainerStatuses: []coreV1.ContainerStatus{
+					{Name: "test", State: coreV1.ContainerState{Waiting: &coreV1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}}},
+				},
+			}}
+			status := deviceStatusFromPod(pod)
+			Expect(status.Reason).To(Equal("ImagePullBackOff"))
+			Expect(status.Message).To(Equal("Back-off pulling image"))
+			Expect(len(status.ContainerStatuses)).To(Equal(1))
+		})
+		It("should be expected failed init container", func() {
+			pod := coreV1.Pod{Status: coreV1.PodStatus{
+				InitContainerStatuses: []coreV1.ContainerStatus{
+					{Name: "init", State: coreV1.ContainerState{Terminated: &coreV1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}},
+				},
+			}}
+			status := deviceStatusFromPod(pod)
+			Expect(status.Reason).To(Equal("Error"))
+			Expect(status.Message).To(Equal("container init exited with code 1"))
+		})
+		It("should be expected running pod has no reason", func() {
+			pod := coreV1.Pod{Status: coreV1.PodStatus{
+				Phase: coreV1.PodRunning,
+				ContainerStatuses: []coreV1.ContainerStatus{
+					{Name: "test", Ready: true, State: coreV1.ContainerState{Running: &coreV1.ContainerStateRunning{}}},
+				},
+			}}
+			Expect(deviceStatusFromPod(pod).Reason).To(Equal(""))
+		})
+		It("should be expected running pod not ready yet", func() {
+			pod := coreV1.Pod{Status: coreV1.PodStatus{
+				Phase: coreV1.PodRunning,
+				Conditions: []coreV1.PodCondition{
+					{Type: coreV1.PodScheduled, Status: coreV1.ConditionTrue},
+					{Type: coreV1.PodReady, Status: coreV1.ConditionFalse, Reason: "ContainersNotReady", Message: "containers with unready status: [test]"},
+				},
+				ContainerStatuses: []coreV1.ContainerStatus{
+					{Name: "test", State: coreV1.ContainerState{Running: &coreV1.ContainerStateRunning{}}},
+				},
+			}}
+			status := deviceStatusFromPod(pod)
+			Expect(status.Reason).To(Equal("ContainersNotReady"))
+			Expect(status.Message).To(Equal("containers with unready status: [test]"))
+			Expect(podReady(pod)).To(BeFalse())
+			pod.Status.Conditions[1] = coreV1.PodCondition{Type: coreV1.PodReady, Status: coreV1.ConditionTrue}
+			Expect(deviceStatusFromPod(pod).Reason).To(Equal(""))
+			Expect(podReady(pod)).To(BeTrue())
+			// ready is only meaningful for sandbox declaring a health check
+			Expect(podHealthChecked(pod)).To(BeFalse())
+			pod.Spec.Containers = []coreV1.Container{{Name: "test", ReadinessProbe: &coreV1.Probe{}}}
+			Expect(podHealthChecked(pod)).To(BeTrue())
+		})
+	})
+
+	Describe("combineDeviceEvents test", func() {
+		It("should be expected ordered and limited", func() {
+			var events []coreV1.Event
+			base := time.Now()
+			for i := 0; i < maxDeviceEvents+5; i++ {
+				events = append(events, coreV1.Event{
+					InvolvedObject: coreV1.ObjectReference{Kind: "Pod", Name: "p1"},
+					Reason:         fmt.Sprintf("r%d", i),
+					LastTimestamp:  metaV1.NewTime(base.Add(-time.Duration(i) * time.Minute)),
+				})
+			}
+			result := combineDeviceEvents(events)
+			Expect(len(result)).To(Equal(maxDeviceEvents))
+			Expect(result[len(result)-1].Reason).To(Equal("r0"))
+			Expect(result[0].Reason).To(Equal(fmt.Sprintf("r%d", maxDeviceEvents-1)))
+			Expect(result[0].Object).To(Equal("Pod/p1"))
+		})
+	})
+
+	Describe("pickDevicePod test", func() {
+		It("should be expected newest pod which is not terminating", func() {
+			now := metaV1.Now()
+			older := metaV1.NewTime(now.Add(-time.Minute))
+			container := []coreV1.Container{{Name: "test"}}
+			pods := []coreV1.Pod{
+				{ObjectMeta: metaV1.ObjectMeta{Name: "old", CreationTimestamp: older}, Spec: coreV1.PodSpec{Containers: container}},
+				{ObjectMeta: metaV1.ObjectMeta{Name: "terminating", CreationTimestamp: now, DeletionTimestamp: &now}, Spec: coreV1.PodSpec{Containers: container}},
+			}
+			Expect(pickDevicePod(pods).Name).To(Equal("old"))
+			pods = append(pods, coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "new", CreationTimestamp: now}, Spec: coreV1.PodSpec{Containers: container}})
+			Expect(pickDevicePod(pods).Name).To(Equal("new"))
+			Expect(pickDevicePod(nil)).To(BeNil())
+		})
+	})
+
 	Describe("ParseJsonToPluginList result test", func() {
 		It("should be expected", func() {
 			jsonData, err := json.Marshal(pluginList)
@@ -608,6 +900,255 @@ var _ = Describe("open-hydra-server combineDeviceList test", func() {
 		})
 	})
 
+	Describe("checkUserAffinity test", func() {
+		It("should be expected", func() {
+			affinity := func(keys ...string) *coreV1.Affinity {
+				term := coreV1.NodeSelectorTerm{}
+				for _, key := range keys {
+					term.MatchExpressions = append(term.MatchExpressions, coreV1.NodeSelectorRequirement{Key: key, Operator: coreV1.NodeSelectorOpExists})
+				}
+				return &coreV1.Affinity{NodeAffinity: &coreV1.NodeAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []coreV1.PreferredSchedulingTerm{{Weight: 1, Preference: term}},
+				}}
+			}
+			restrict := config.SchedulingPolicy{UserAffinity: UserAffinityRestrict, AllowedAffinityKeys: []string{"zone"}}
+			deny := config.SchedulingPolicy{UserAffinity: UserAffinityDeny}
+			Expect(checkUserAffinity(affinity("kubernetes.io/hostname"), config.SchedulingPolicy{}, openHydraConfig)).To(BeNil())
+			Expect(checkUserAffinity(nil, deny, openHydraConfig)).To(BeNil())
+			Expect(checkUserAffinity(affinity("zone", "nvidia.com/gpu.product"), restrict, openHydraConfig)).To(BeNil())
+			Expect(checkUserAffinity(affinity("zone", "kubernetes.io/hostname"), restrict, openHydraConfig)).NotTo(BeNil())
+			Expect(checkUserAffinity(&coreV1.Affinity{PodAffinity: &coreV1.PodAffinity{}}, restrict, openHydraConfig)).NotTo(BeNil())
+			// affinity of gpu model is set by server itself
+			Expect(checkUserAffinity(affinity("nvidia.com/gpu.product"), deny, openHydraConfig)).To(BeNil())
+			Expect(checkUserAffinity(affinity("zone"), deny, openHydraConfig)).NotTo(BeNil())
+			Expect(checkUserAffinity(affinity("zone"), config.SchedulingPolicy{UserAffinity: "unknown"}, openHydraConfig)).NotTo(BeNil())
+		})
+	})
+
+	Describe("watchHub test", func() {
+		It("should be expected", func() {
+			user := func(name, description string) runtime.Object {
+				return &xUserV1.OpenHydraUser{ObjectMeta: metaV1.ObjectMeta{Name: name}, Spec: xUserV1.OpenHydraUserSpec{Description: description}}
+			}
+			hub := newWatchHub(nil)
+			Expect(hub.sync(map[string]runtime.Object{"a": user("a", ""), "b": user("b", "")})).To(Equal(hub.version(2)))
+			backlog, watcher, err := hub.subscribe("")
+			Expect(err).To(BeNil())
+			Expect(backlog).To(HaveLen(2))
+			Expect(backlog[0].Type).To(Equal(watch.Added))
+			Expect(backlog[1].Object.(*xUserV1.OpenHydraUser).ResourceVersion).To(Equal(hub.version(2)))
+
+			// unchanged object keeps its resource version
+			unchanged := user("a", "")
+			Expect(hub.sync(map[string]runtime.Object{"a": unchanged, "b": user("b", "changed")})).To(Equal(hub.version(3)))
+			Expect(unchanged.(*xUserV1.OpenHydraUser).ResourceVersion).To(Equal(hub.version(1)))
+			event := <-watcher
+			Expect(event.Type).To(Equal(watch.Modified))
+			Expect(event.Object.(*xUserV1.OpenHydraUser).Spec.Description).To(Equal("changed"))
+
+			Expect(hub.sync(map[string]runtime.Object{"a": user("a", "")})).To(Equal(hub.version(4)))
+			event = <-watcher
+			Expect(event.Type).To(Equal(watch.Deleted))
+			Expect(event.Object.(*xUserV1.OpenHydraUser).Name).To(Equal("b"))
+			Expect(event.Object.(*xUserV1.OpenHydraUser).ResourceVersion).To(Equal(hub.version(4)))
+			hub.unsubscribe(watcher)
+			Expect(hub.watched()).To(BeFalse())
+
+			backlog, _, err = hub.subscribe(hub.version(2))
+			Expect(err).To(BeNil())
+			Expect(backlog).To(HaveLen(2))
+			Expect(backlog[0].Type).To(Equal(watch.Modified))
+			_, _, err = hub.subscribe(hub.version(5))
+			Expect(errors.IsResourceExpired(err)).To(BeTrue())
+			// counter of server before restart falls in history of this one but is never replayed
+			_, _, err = hub.subscribe("1.2")
+			Expect(errors.IsResourceExpired(err)).To(BeTrue())
+			_, _, err = hub.subscribe("2")
+			Expect(errors.IsResourceExpired(err)).To(BeTrue())
+			_, _, err = hub.subscribe("latest")
+			Expect(errors.IsBadRequest(err)).To(BeTrue())
+		})
+	})
+
+	Describe("usageReport test", func() {
+		It("should be expected", func() {
+			base := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+			record := func(username, event string, hour int, cpuMilli, gpu int64, gpuModel string) xDeviceV1.UsageRecord {
+				return xDeviceV1.UsageRecord{Spec: xDeviceV1.UsageRecordSpec{
+					Event:             event,
+					Time:              metaV1.NewTime(base.Add(time.Duration(hour) * time.Hour)),
+					OpenHydraUsername: username,
+					DeviceId:          "default",
+					SandboxName:       "jupyter-lab",
+					CpuMilli:          cpuMilli,
+					MemoryBytes:       1 << 30,
+					Gpu:               gpu,
+					GpuDriver:         "nvidia.com/gpu",
+					GpuModel:          gpuModel,
+				}}
+			}
+			records := []xDeviceV1.UsageRecord{
+				// running since before range
+				record("a", UsageEventStart, -10, 1000, 1, "A100"),
+				record("a", UsageEventResize, 2, 2000, 2, "A100"),
+				record("a", UsageEventStop, 4, 2000, 2, "A100"),
+				// still running at end of range
+				record("b", UsageEventStart, 6, 500, 0, ""),
+			}
+			openHydraConfig.Quota = &config.QuotaConfig{Groups: []config.GroupQuota{{Name: "class-b", Members: []string{"a", "b"}}}}
+			from, to := base, base.Add(10*time.Hour)
+
+			items := usageReport(records, from, to, to.Add(time.Hour), UsageGroupByUser, openHydraConfig)
+			Expect(items).To(HaveLen(2))
+			Expect(items[0].Key).To(Equal("a"))
+			Expect(items[0].CpuCoreHours).To(BeNumerically("~", 6))
+			Expect(items[0].GpuHours).To(BeNumerically("~", 6))
+			Expect(items[0].MemoryGiBHours).To(BeNumerically("~", 4))
+			Expect(items[1].Key).To(Equal("b"))
+			Expect(items[1].CpuCoreHours).To(BeNumerically("~", 2))
+
+			items = usageReport(records, from, to, to.Add(time.Hour), UsageGroupByGroup, openHydraConfig)
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].Key).To(Equal("class-b"))
+			Expect(items[0].GpuHours).To(BeNumerically("~", 6))
+			Expect(items[0].Devices).To(Equal(2))
+
+			items = usageReport(records, from, to, to.Add(time.Hour), UsageGroupByGpu, openHydraConfig)
+			Expect(items).To(HaveLen(2))
+			Expect(items[0].Key).To(Equal("A100"))
+			Expect(items[1].Key).To(Equal("cpu"))
+
+			// device still running is only counted until now
+			items = usageReport(records, from, to, base.Add(8*time.Hour), UsageGroupBySandbox, openHydraConfig)
+			Expect(items).To(HaveLen(1))
+			Expect(items[0].CpuCoreHours).To(BeNumerically("~", 7))
+		})
+	})
+
+	Describe("gpuBudgets test", func() {
+		It("should be expected", func() {
+			// wednesday
+			now := time.Date(2024, 3, 6, 15, 0, 0, 0, time.UTC)
+			from, resetAt := budgetPeriod("", now)
+			Expect(from).To(Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)))
+			Expect(resetAt).To(Equal(time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC)))
+			from, resetAt = budgetPeriod(BudgetPeriodMonth, now)
+			Expect(from).To(Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(resetAt).To(Equal(time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)))
+			from, _ = budgetPeriod(BudgetPeriodWeek, time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC))
+			Expect(from).To(Equal(time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)))
+
+			userHours, roleHours, groupHours := 10.0, 5.0, 8.0
+			openHydraConfig.Quota = &config.QuotaConfig{
+				Roles:  map[int]config.ResourceQuota{2: {GpuHours: &roleHours}},
+				Users:  map[string]config.ResourceQuota{"a": {GpuHours: &userHours}},
+				Groups: []config.GroupQuota{{Name: "class-b", Members: []string{"a", "b"}, Quota: config.ResourceQuota{GpuHours: &groupHours}}},
+			}
+			used := map[string]float64{"a": 6, "b": 3}
+			budgets := gpuBudgets("a", 2, used, openHydraConfig)
+			Expect(budgets).To(HaveLen(2))
+			Expect(budgets[0].GpuHours).To(Equal(10.0))
+			Expect(budgets[0].Remaining).To(Equal(4.0))
+			Expect(budgets[1].Name).To(Equal("class-b"))
+			Expect(budgets[1].Used).To(Equal(9.0))
+			Expect(budgets[1].Remaining).To(BeZero())
+			Expect(exhaustedGpuBudget(budgets).Name).To(Equal("class-b"))
+
+			budgets = gpuBudgets("c", 2, used, openHydraConfig)
+			Expect(budgets).To(HaveLen(1))
+			Expect(budgets[0].GpuHours).To(Equal(5.0))
+			Expect(exhaustedGpuBudget(budgets)).To(BeNil())
+			Expect(gpuBudgets("c", 1, used, openHydraConfig)).To(BeEmpty())
+		})
+	})
+
+	Describe("deviceNetworkPolicy test", func() {
+		It("should be expected", func() {
+			policyConfig := &config.NetworkPolicyConfig{
+				Ingress: []config.NetworkPeer{{NamespaceSelector: map[string]string{"kubernetes.io/metadata.name": "ingress-nginx"}}},
+				Egress:  []config.NetworkPeer{{CIDR: "10.0.0.10/32", Ports: []int32{443}}},
+			}
+			policy := deviceNetworkPolicy("a", policyConfig)
+			Expect(policy.Name).To(Equal("openhydra-network-a"))
+			Expect(policy.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue(k8s.OpenHydraUserLabelKey, "a"))
+			Expect(policy.Spec.PolicyTypes).To(HaveLen(2))
+			Expect(policy.Spec.Ingress).To(HaveLen(2))
+			Expect(policy.Spec.Ingress[0].From[0].PodSelector.MatchLabels).To(HaveKeyWithValue(k8s.OpenHydraUserLabelKey, "a"))
+			Expect(policy.Spec.Ingress[1].From[0].NamespaceSelector.MatchLabels).To(HaveKeyWithValue("kubernetes.io/metadata.name", "ingress-nginx"))
+			Expect(policy.Spec.Ingress[1].Ports).To(BeEmpty())
+			// same user, dns and mirror
+			Expect(policy.Spec.Egress).To(HaveLen(3))
+			Expect(policy.Spec.Egress[1].To[0].NamespaceSelector.MatchLabels).To(HaveKeyWithValue("kubernetes.io/metadata.name", "kube-system"))
+			Expect(policy.Spec.Egress[1].Ports).To(HaveLen(2))
+			Expect(policy.Spec.Egress[2].To[0].IPBlock.CIDR).To(Equal("10.0.0.10/32"))
+			Expect(policy.Spec.Egress[2].Ports[0].Port.IntValue()).To(Equal(443))
+
+			policyConfig.ExamMode = true
+			policy = deviceNetworkPolicy("a", policyConfig)
+			Expect(policy.Spec.Ingress).To(HaveLen(2))
+			Expect(policy.Spec.PolicyTypes).To(ContainElement(networkingV1.PolicyTypeEgress))
+			Expect(policy.Spec.Egress).To(BeEmpty())
+		})
+	})
+
+	Describe("applySandboxEnv test", func() {
+		var device *xDeviceV1.Device
+		var sandbox apis.Sandbox
+		BeforeEach(func() {
+			device = &xDeviceV1.Device{Spec: xDeviceV1.DeviceSpec{OpenHydraUsername: "user1", OpenHydraProjectId: "p1", SandboxName: "jupyter-lab"}}
+			sandbox = apis.Sandbox{
+				Env: []apis.EnvVar{
+					{Name: "WORK_DIR", Value: "/data/{project-id}/{username}-{device-id}"},
+					{Name: "JUPYTER_TOKEN", SecretKeyRef: &apis.KeyRef{Name: "token-{username}", Key: "token"}},
+				},
+				EnvFrom: []apis.EnvFromSource{{ConfigMapName: "proxy-{username}"}},
+				InitContainers: []apis.InitContainer{
+					{Name: "fetch", Args: []string{"clone", "{project-id}"}, Env: []apis.EnvVar{{Name: "OWNER", Value: "{username}"}}},
+				},
+			}
+		})
+		It("should be expected", func() {
+			deployParameter := &k8s.DeploymentParameters{}
+			err := applySandboxEnv(deployParameter, sandbox, device)
+			Expect(err).To(BeNil())
+			Expect(deployParameter.Env[0].Value).To(Equal("/data/p1/user1-default"))
+			Expect(deployParameter.Env[1].SecretKeyRef.Name).To(Equal("token-user1"))
+			Expect(deployParameter.EnvFrom[0].ConfigMapName).To(Equal("proxy-user1"))
+			Expect(deployParameter.InitContainers[0].Args).To(Equal([]string{"clone", "p1"}))
+			Expect(deployParameter.InitContainers[0].Env[0].Value).To(Equal("user1"))
+			// sandbox definition is left as it is
+			Expect(sandbox.Env[1].SecretKeyRef.Name).To(Equal("token-{username}"))
+		})
+		It("should be bad request", func() {
+			for _, mutate := range []func(){
+				func() { sandbox.Env[0].Name = "OPENHYDRA_USER" },
+				func() { sandbox.Env[1].Value = "token" },
+				func() { sandbox.EnvFrom[0].SecretName = "proxy" },
+				func() { sandbox.InitContainers = append(sandbox.InitContainers, apis.InitContainer{Name: "fetch"}) },
+				func() { sandbox.InitContainers[0].Name = "Fetch" },
+				func() { sandbox.Env[1].SecretKeyRef.Name = "{project-id}-creds" },
+				func() {
+					sandbox.Env[1].SecretKeyRef = nil
+					sandbox.Env[1].ConfigMapKeyRef = &apis.KeyRef{Name: "{device-id}", Key: "token"}
+				},
+				func() { sandbox.EnvFrom[0].ConfigMapName = "proxy-{project-id}" },
+				func() { sandbox.EnvFrom[0].ConfigMapName = ""; sandbox.EnvFrom[0].SecretName = "{device-id}-creds" },
+			} {
+				sandbox.Env[0].Name = "WORK_DIR"
+				sandbox.Env[1].Value = ""
+				sandbox.Env[1].SecretKeyRef = &apis.KeyRef{Name: "token-{username}", Key: "token"}
+				sandbox.Env[1].ConfigMapKeyRef = nil
+				sandbox.EnvFrom[0].SecretName = ""
+				sandbox.EnvFrom[0].ConfigMapName = "proxy-{username}"
+				sandbox.InitContainers = sandbox.InitContainers[:1]
+				sandbox.InitContainers[0].Name = "fetch"
+				mutate()
+				err := applySandboxEnv(&k8s.DeploymentParameters{}, sandbox, device)
+				Expect(errors.IsBadRequest(err)).To(BeTrue())
+			}
+		})
+	})
+
 	Describe("preCreateUserDir result test", func() {
 		It("should be expected", func() {
 			err := preCreateUserDir(volumes, "test", openHydraConfig)
@@ -621,6 +1162,26 @@ var _ = Describe("open-hydra-server combineDeviceList test", func() {
 			os.RemoveAll("/tmp/workspace/jupyter-lab/test")
 			os.RemoveAll("/tmp/workspace/vscode/test")
 		})
+		It("should be nfs volume", func() {
+			volumes = append(volumes,
+				apis.Volume{HostPath: &apis.HostPath{Name: "public-dataset", Path: "{dataset-public}"}},
+				apis.Volume{HostPath: &apis.HostPath{Name: "public-course", Path: "{course-public}/intro"}},
+			)
+			openHydraConfig.PublicDatasetVolumeType = DatasetVolumeNFS
+			err := preCreateUserDir(volumes, "test", openHydraConfig)
+			Expect(err).NotTo(BeNil())
+
+			openHydraConfig.NfsConfig = &config.NfsConfig{Server: "10.0.0.2", PublicCoursePath: "/export/course"}
+			err = preCreateUserDir(volumes, "test", openHydraConfig)
+			Expect(err).To(BeNil())
+			// private dir is still a host path
+			Expect(volumes[0].HostPath.Path).To(Equal("/tmp/workspace/jupyter-lab/test"))
+			Expect(volumes[3].HostPath).To(BeNil())
+			Expect(*volumes[3].NFS).To(Equal(apis.NFS{Name: "public-dataset", Server: "10.0.0.2", Path: openHydraConfig.PublicDatasetBasePath, ReadOnly: true}))
+			Expect(volumes[4].NFS.Path).To(Equal("/export/course/intro"))
+			os.RemoveAll("/tmp/workspace/jupyter-lab/test")
+			os.RemoveAll("/tmp/workspace/vscode/test")
+		})
 	})
 })
 
@@ -640,6 +1201,7 @@ var _ = Describe("open-hydra-server authorization test", func() {
 	var openHydraSettingsURL = fmt.Sprintf("http://localhost/apis/%s/v1/%s/default", option.GroupVersion.Group, SettingPath)
 	var openHydraDatasetsURL = fmt.Sprintf("http://localhost/apis/%s/v1/%s", option.GroupVersion.Group, DatasetPath)
 	var openHydraCoursesURL = fmt.Sprintf("http://localhost/apis/%s/v1/%s", option.GroupVersion.Group, CoursePath)
+	var openHydraDeviceBatchesURL = fmt.Sprintf("http://localhost/apis/%s/v1/%s", option.GroupVersion.Group, DeviceBatchPath)
 	var fakeK8sHelper *k8s.Fake
 	var fakeService = func() *restful.WebService {
 		ws := new(restful.WebService)
@@ -710,6 +1272,44 @@ var _ = Describe("open-hydra-server authorization test", func() {
 		container.Dispatch(resp.ResponseWriter, req.Request)
 		return resp, httpResponse
 	}
+	// fakeSandbox serves every device port through device proxy, it echoes websocket message and answers http request with what it sees
+	var fakeSandbox = func() func() {
+		sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if websocket.IsWebSocketUpgrade(r) {
+				conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				messageType, message, err := conn.ReadMessage()
+				if err == nil {
+					conn.WriteMessage(messageType, message)
+				}
+				return
+			}
+			var cookies []string
+			for _, cookie := range r.Cookies() {
+				cookies = append(cookies, cookie.Name)
+			}
+			json.NewEncoder(w).Encode(map[string]string{
+				"host":    r.Host,
+				"path":    r.URL.Path,
+				"query":   r.URL.RawQuery,
+				"auth":    r.Header.Get(openHydraAuthStringHeader),
+				"cookies": strings.Join(cookies, ","),
+			})
+		}))
+		previousTransport := sandboxProxyTransport
+		sandboxProxyTransport = &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, sandbox.Listener.Addr().String())
+			},
+		}
+		return func() {
+			sandboxProxyTransport = previousTransport
+			sandbox.Close()
+		}
+	}
 	var createMultiPartBody = func(txtData map[string]string, filePath string) (io.Reader, string, error) {
 		var (
 			buf = new(bytes.Buffer)
@@ -753,6 +1353,14 @@ var _ = Describe("open-hydra-server authorization test", func() {
 		builder.AddDeviceGetRoute()
 		builder.AddDeviceUpdateRoute()
 		builder.AddDeviceDeleteRoute()
+		builder.AddDeviceStopRoute()
+		builder.AddDeviceStartRoute()
+		builder.AddDeviceEventsRoute()
+		builder.AddDeviceLogRoute()
+		builder.AddDeviceExecRoute()
+		builder.AddDeviceProxyRoute()
+		builder.AddDeviceAccessRoute()
+		builder.AddDeviceQuotaRoute()
 		builder.AddSummaryGetRoute()
 		builder.AddDatasetListRoute()
 		builder.AddDatasetCreateRoute()
@@ -767,6 +1375,11 @@ var _ = Describe("open-hydra-server authorization test", func() {
 		builder.AddCourseGetRoute()
 		builder.AddCourseUpdateRoute()
 		builder.AddCourseDeleteRoute()
+		builder.AddDeviceBatchCreateRoute()
+		builder.AddDeviceBatchListRoute()
+		builder.AddDeviceBatchGetRoute()
+		builder.AddUsageReportRoute()
+		builder.AddDeviceBudgetRoute()
 		if !fakeK8sHelper.ServerConfig.DisableAuth {
 			builder.RootWS.Filter(builder.Filter)
 		}
@@ -990,7 +1603,9 @@ var _ = Describe("open-hydra-server authorization test", func() {
 			Expect(err).To(BeNil())
 			Expect(target.Spec.DeviceGpu).To(Equal(uint8(1)))
 			Expect(target.Spec.DeviceType).To(Equal("gpu"))
-			Expect(target.Spec.DeviceStatus).To(Equal("Creating"))
+			// no gpu in fake cluster so device waits in gpu queue
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusQueued))
+			Expect(target.Spec.LineNo).To(Equal("1"))
 			Expect(target.Spec.OpenHydraUsername).To(Equal("teacher"))
 
 			target = xDeviceV1.Device{}
@@ -1349,6 +1964,1699 @@ var _ = Describe("open-hydra-server authorization test", func() {
 			Expect(len(target.Items)).To(Equal(0))
 		})
 
+		It("open-hydra multiple devices per user should be expected", func() {
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 2
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			// same device id should be rejected
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			second := createDevice("student", "test", "", 0)
+			second.Spec.DeviceId = "Bad_Id"
+			body, err = json.Marshal(second)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			// separator of device names
+			second.Spec.DeviceId = "my--lab"
+			body, err = json.Marshal(second)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			second.Spec.DeviceId = "vscode"
+			body, err = json.Marshal(second)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			// limit reached
+			third := createDevice("student", "test", "", 0)
+			third.Spec.DeviceId = "third"
+			body, err = json.Marshal(third)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			// limit is never skipped when devices of user can not be listed
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 3
+			fakeK8sHelper.ListDeploymentError = fmt.Errorf("etcd unavailable")
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			fakeK8sHelper.ListDeploymentError = nil
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+
+			// 0 means no limit, it is left out when fake config map is rendered so device is created directly
+			serverConfig := *fakeK8sHelper.ServerConfig
+			serverConfig.MaximumDevicesPerUser = 0
+			err = builder.createDevice(third, &serverConfig)
+			Expect(err).To(BeNil())
+
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", "vscode"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(len(deploy)).To(Equal(1))
+			Expect(deploy[0].Name).To(Equal("openhydra-deploy-student--vscode"))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student?deviceId=vscode", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			result, err := io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.DeviceId).To(Equal("vscode"))
+			Expect(target.Spec.SandboxName).To(Equal("test"))
+
+			_, r2 = callApi(http.MethodDelete, openHydraDevicesURL+"/student?deviceId=vscode", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student?deviceId=vscode", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+
+			// other devices are left untouched
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, "student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(len(deploy)).To(Equal(2))
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(len(deploy)).To(Equal(1))
+			Expect(deploy[0].Name).To(Equal("openhydra-deploy-student"))
+		})
+
+		It("open-hydra device events should be expected", func() {
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			event := func(kind, name, reason string) coreV1.Event {
+				return coreV1.Event{
+					ObjectMeta:     metaV1.ObjectMeta{Namespace: OpenhydraNamespace},
+					InvolvedObject: coreV1.ObjectReference{Kind: kind, Name: name},
+					Type:           coreV1.EventTypeWarning,
+					Reason:         reason,
+				}
+			}
+			fakeK8sHelper.Events = []coreV1.Event{
+				event("Pod", "openhydra-deploy-student-fake", "FailedScheduling"),
+				event("ReplicaSet", "openhydra-deploy-student-7c9d", "FailedCreate"),
+				event("ReplicaSet", "openhydra-deploy-student-vscode-7c9d", "FailedCreate"),
+				event("Pod", "openhydra-deploy-teacher-fake", "FailedScheduling"),
+			}
+			replicaSet := func(name, username, deviceId string) appsV1.ReplicaSet {
+				return appsV1.ReplicaSet{ObjectMeta: metaV1.ObjectMeta{Name: name, Namespace: OpenhydraNamespace, Labels: map[string]string{
+					k8s.OpenHydraUserLabelKey:   username,
+					k8s.OpenHydraDeviceLabelKey: deviceId,
+				}}}
+			}
+			fakeK8sHelper.ReplicaSets = []appsV1.ReplicaSet{
+				replicaSet("openhydra-deploy-student-7c9d", "student", k8s.OpenHydraDefaultDeviceId),
+				replicaSet("openhydra-deploy-student-vscode-7c9d", "student", "vscode"),
+			}
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/events", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var events xDeviceV1.DeviceEventList
+			result, err := io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &events)
+			Expect(err).To(BeNil())
+			Expect(len(events.Items)).To(Equal(2))
+			var objects []string
+			for _, item := range events.Items {
+				objects = append(objects, item.Object)
+			}
+			Expect(objects).To(ConsistOf("Pod/openhydra-deploy-student-fake", "ReplicaSet/openhydra-deploy-student-7c9d"))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			result, err = io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &target)
+			Expect(err).To(BeNil())
+			Expect(len(target.Status.Events)).To(Equal(2))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher/events", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/events", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+		})
+
+		It("open-hydra device log should be expected", func() {
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			fakeK8sHelper.PodLogs["openhydra-deploy-student-fake"] = "line1\nline2\nline3\n"
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/log?tailLines=2", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(r2.Body.String()).To(Equal("line2\nline3\n"))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/log", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(r2.Body.String()).To(Equal("line1\nline2\nline3\n"))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/log?tailLines=abc", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/log?follow=maybe", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher/log", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/log?deviceId=vscode", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("open-hydra device exec should be expected", func() {
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			server := httptest.NewServer(container)
+			defer server.Close()
+			execURL := func(username string) string {
+				return fmt.Sprintf("ws%s/apis/%s/v1/%s/%s/exec", strings.TrimPrefix(server.URL, "http"), option.GroupVersion.Group, DevicePath, username)
+			}
+			dial := func(user *xUserV1.OpenHydraUser, username string) (*websocket.Conn, *http.Response, error) {
+				credential := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", user.Name, user.Spec.Password)))
+				dialer := websocket.Dialer{Subprotocols: []string{deviceExecProtocol, openHydraWebSocketAuthProtocolPrefix + credential}}
+				return dialer.Dial(execURL(username), nil)
+			}
+
+			conn, _, err := dial(student, "student")
+			Expect(err).To(BeNil())
+			Expect(conn.Subprotocol()).To(Equal(deviceExecProtocol))
+			Expect(conn.WriteJSON(deviceExecMessage{Type: deviceExecMessageResize, Cols: 80, Rows: 24})).To(Succeed())
+			_, output, err := conn.ReadMessage()
+			Expect(err).To(BeNil())
+			Expect(string(output)).To(Equal("resize 80x24\n"))
+			Expect(conn.WriteJSON(deviceExecMessage{Type: deviceExecMessageStdin, Data: "ls\n"})).To(Succeed())
+			_, output, err = conn.ReadMessage()
+			Expect(err).To(BeNil())
+			Expect(string(output)).To(Equal("ls\n"))
+			conn.Close()
+
+			_, resp, err := dial(student, "teacher")
+			Expect(err).NotTo(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			_, resp, err = websocket.DefaultDialer.Dial(execURL("student"), nil)
+			Expect(err).NotTo(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+			fakeK8sHelper.ServerConfig.DeviceExecIdleTimeoutSeconds = 1
+			conn, _, err = dial(teacher, "student")
+			Expect(err).To(BeNil())
+			_, _, err = conn.ReadMessage()
+			closeErr, ok := err.(*websocket.CloseError)
+			Expect(ok).To(BeTrue())
+			Expect(closeErr.Text).To(Equal("idle timeout"))
+			conn.Close()
+		})
+
+		It("open-hydra device proxy should be expected", func() {
+			restore := fakeSandbox()
+			defer restore()
+
+			fakeK8sHelper.ServerConfig.SandboxExposureMode = SandboxExposureProxy
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			// device is not created while it could only be served on origin of api
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+			fakeK8sHelper.ServerConfig.SandboxProxyDomain = "sandbox.example.com"
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			proxyPath := fmt.Sprintf("/apis/%s/v1/%s/student/proxy/default/jupyter-lab", option.GroupVersion.Group, DevicePath)
+			host := sandboxProxyHost("student", "", "jupyter-lab", fakeK8sHelper.ServerConfig)
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Env).To(ContainElement(coreV1.EnvVar{Name: "OPENHYDRA_JUPYTER_LAB", Value: strings.TrimPrefix(proxyPath, "/")}))
+			service, err := fakeK8sHelper.GetUserService(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(service.Spec.Type).To(Equal(coreV1.ServiceTypeClusterIP))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.SandboxURLs).To(Equal("http://" + host + proxyPath + "/lab"))
+
+			server := httptest.NewServer(container)
+			defer server.Close()
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			get := func(path string, header http.Header, cookies ...*http.Cookie) (int, map[string]string) {
+				request, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+				Expect(err).To(BeNil())
+				request.Host = host
+				for key, values := range header {
+					if key == "Host" {
+						request.Host = values[0]
+						continue
+					}
+					request.Header[key] = values
+				}
+				for _, cookie := range cookies {
+					request.AddCookie(cookie)
+				}
+				resp, err := client.Do(request)
+				Expect(err).To(BeNil())
+				defer resp.Body.Close()
+				result := map[string]string{}
+				if resp.StatusCode == http.StatusOK {
+					Expect(json.NewDecoder(resp.Body).Decode(&result)).To(Succeed())
+				}
+				return resp.StatusCode, result
+			}
+
+			// browser has no credential before opening link of device and login does not give any
+			code, _ := get(proxyPath+"/lab", nil)
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			login, err := json.Marshal(student)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraUsersURL+"/login/student", map[string][]string{"Content-Type": {"application/json"}}, bytes.NewReader(login))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(r2.Result().Cookies()).To(BeEmpty())
+
+			body, err = json.Marshal(xDeviceV1.DeviceAccess{Spec: xDeviceV1.DeviceAccessSpec{Port: "jupyter-lab"}})
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/student/access", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var access xDeviceV1.DeviceAccess
+			Expect(json.NewDecoder(r2.Body).Decode(&access)).To(Succeed())
+			Expect(access.Spec.TTLSeconds).To(Equal(int64(fakeK8sHelper.ServerConfig.SessionTTLSeconds)))
+			link, err := url.Parse(access.Spec.URLs)
+			Expect(err).To(BeNil())
+			Expect(link.Host).To(Equal(host))
+			request, err := http.NewRequest(http.MethodGet, server.URL+link.RequestURI(), nil)
+			Expect(err).To(BeNil())
+			request.Host = host
+			resp, err := client.Do(request)
+			Expect(err).To(BeNil())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusFound))
+			var session *http.Cookie
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == deviceAccessCookie {
+					session = cookie
+				}
+			}
+			Expect(session).NotTo(BeNil())
+			Expect(session.HttpOnly).To(BeTrue())
+			// cookie is kept to host of device
+			Expect(session.Domain).To(BeEmpty())
+			Expect(strings.HasPrefix(proxyPath+"/", session.Path)).To(BeTrue())
+
+			code, result := get(proxyPath+"/lab", nil, session, &http.Cookie{Name: "_xsrf", Value: "sandbox"})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(result["path"]).To(Equal(proxyPath + "/lab"))
+			Expect(result["host"]).To(Equal(fmt.Sprintf("openhydra-service-student.%s.svc:8888", OpenhydraNamespace)))
+			Expect(result["cookies"]).To(Equal("_xsrf"))
+
+			code, result = get(proxyPath+"/api/status", createTokenValue(teacher, nil))
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(result["path"]).To(Equal(proxyPath + "/api/status"))
+			Expect(result["auth"]).To(Equal(""))
+			// sandbox is never served on origin of api
+			code, _ = get(proxyPath+"/api/status", http.Header{"Host": {"localhost"}, openHydraAuthStringHeader: createTokenValue(teacher, nil)[openHydraAuthStringHeader]})
+			Expect(code).To(Equal(http.StatusForbidden))
+
+			// cookie is not accepted by other api, other host or from other origin
+			code, _ = get(strings.TrimPrefix(openHydraDevicesURL, "http://localhost")+"/student", nil, session)
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = get(proxyPath+"/lab", nil, &http.Cookie{Name: deviceAccessCookie, Value: session.Value + "x"})
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = get(strings.ReplaceAll(proxyPath, "/student/", "/teacher/")+"/lab", nil, session)
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = get(strings.ReplaceAll(proxyPath, "jupyter-lab", "vscode")+"/lab", nil, session)
+			Expect(code).To(Equal(http.StatusUnauthorized))
+			code, _ = get(proxyPath+"/lab", http.Header{"Origin": {"http://" + sandboxProxyHost("teacher", "", "jupyter-lab", fakeK8sHelper.ServerConfig)}}, session)
+			Expect(code).To(Equal(http.StatusForbidden))
+			code, _ = get(proxyPath+"/lab", http.Header{"Origin": {"http://" + host}}, session)
+			Expect(code).To(Equal(http.StatusOK))
+
+			// student can not open device of other user
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/access", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			credential := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", student.Name, student.Spec.Password)))
+			dialer := websocket.Dialer{Subprotocols: []string{openHydraWebSocketAuthProtocolPrefix + credential}}
+			conn, _, err := dialer.Dial(fmt.Sprintf("ws%s%s/api/kernels", strings.TrimPrefix(server.URL, "http"), proxyPath), http.Header{"Host": {host}})
+			Expect(err).To(BeNil())
+			defer conn.Close()
+			Expect(conn.Subprotocol()).To(Equal(openHydraWebSocketAuthProtocolPrefix + credential))
+			Expect(conn.WriteMessage(websocket.TextMessage, []byte("hello"))).To(Succeed())
+			_, message, err := conn.ReadMessage()
+			Expect(err).To(BeNil())
+			Expect(string(message)).To(Equal("hello"))
+		})
+
+		It("open-hydra device access should be expected", func() {
+			restore := fakeSandbox()
+			defer restore()
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			issue := func(user *xUserV1.OpenHydraUser, spec xDeviceV1.DeviceAccessSpec) (int, xDeviceV1.DeviceAccess) {
+				body, err := json.Marshal(xDeviceV1.DeviceAccess{Spec: spec})
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL+"/student/access", createTokenValue(user, nil), bytes.NewReader(body))
+				var access xDeviceV1.DeviceAccess
+				if r2.Code == http.StatusOK {
+					Expect(json.NewDecoder(r2.Body).Decode(&access)).To(Succeed())
+				}
+				return r2.Code, access
+			}
+
+			// device is not served under path of device proxy
+			code, _ := issue(teacher, xDeviceV1.DeviceAccessSpec{})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			fakeK8sHelper.ServerConfig.SandboxExposureMode = SandboxExposureProxy
+			// device is not served on its own host
+			code, _ = issue(teacher, xDeviceV1.DeviceAccessSpec{ReadOnly: true})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			fakeK8sHelper.ServerConfig.SandboxProxyDomain = "sandbox.example.com"
+			host := sandboxProxyHost("student", "", "jupyter-lab", fakeK8sHelper.ServerConfig)
+			// link of owner is not recorded
+			code, _ = issue(student, xDeviceV1.DeviceAccessSpec{})
+			Expect(code).To(Equal(http.StatusOK))
+			code, _ = issue(teacher, xDeviceV1.DeviceAccessSpec{TTLSeconds: 7200})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = issue(teacher, xDeviceV1.DeviceAccessSpec{Port: "vscode"})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = issue(teacher, xDeviceV1.DeviceAccessSpec{DeviceId: "missing"})
+			Expect(code).To(Equal(http.StatusNotFound))
+
+			code, access := issue(teacher, xDeviceV1.DeviceAccessSpec{ReadOnly: true})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(access.Spec.Operator).To(Equal("teacher"))
+			Expect(access.Spec.DeviceId).To(Equal(k8s.OpenHydraDefaultDeviceId))
+			Expect(access.Spec.TTLSeconds).To(Equal(int64(900)))
+			Expect(access.Spec.ExpiresAt.Time).To(BeTemporally("~", time.Now().Add(900*time.Second), 5*time.Second))
+			proxyPath := fmt.Sprintf("/apis/%s/v1/%s/student/proxy/default/jupyter-lab", option.GroupVersion.Group, DevicePath)
+			Expect(access.Spec.URLs).To(HavePrefix("http://" + host + proxyPath + "/lab?" + deviceAccessQueryParameter + "="))
+
+			// student is told about access
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student/events", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var events xDeviceV1.DeviceEventList
+			Expect(json.NewDecoder(r2.Body).Decode(&events)).To(Succeed())
+			Expect(events.Items).To(HaveLen(1))
+			Expect(events.Items[0].Reason).To(Equal(deviceAccessEventReason))
+			Expect(events.Items[0].Message).To(HavePrefix("teacher teacher is given read-only access to every port of device"))
+
+			server := httptest.NewServer(container)
+			defer server.Close()
+			client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+			send := func(method, path string, cookies ...*http.Cookie) *http.Response {
+				request, err := http.NewRequest(method, server.URL+path, nil)
+				Expect(err).To(BeNil())
+				request.Host = host
+				for _, cookie := range cookies {
+					request.AddCookie(cookie)
+				}
+				resp, err := client.Do(request)
+				Expect(err).To(BeNil())
+				return resp
+			}
+
+			resp := send(http.MethodGet, strings.TrimPrefix(access.Spec.URLs, "http://"+host))
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusFound))
+			Expect(resp.Header.Get("Location")).To(Equal(proxyPath + "/lab"))
+			var accessCookie *http.Cookie
+			for _, cookie := range resp.Cookies() {
+				if cookie.Name == deviceAccessCookie {
+					accessCookie = cookie
+				}
+			}
+			Expect(accessCookie).NotTo(BeNil())
+			Expect(accessCookie.Path).To(Equal(fmt.Sprintf("/apis/%s/v1/%s/student/proxy/default/", option.GroupVersion.Group, DevicePath)))
+
+			resp = send(http.MethodGet, proxyPath+"/lab?tree=1", accessCookie)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			result := map[string]string{}
+			Expect(json.NewDecoder(resp.Body).Decode(&result)).To(Succeed())
+			resp.Body.Close()
+			Expect(result["path"]).To(Equal(proxyPath + "/lab"))
+			Expect(result["query"]).To(Equal("tree=1"))
+			Expect(result["cookies"]).To(Equal(""))
+
+			// read-only access can not change anything
+			resp = send(http.MethodPost, proxyPath+"/api/contents", accessCookie)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+			// link only opens device it is issued for
+			resp = send(http.MethodGet, strings.ReplaceAll(proxyPath, "/student/", "/teacher/")+"/lab", accessCookie)
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			resp = send(http.MethodGet, proxyPath+"/lab", &http.Cookie{Name: deviceAccessCookie, Value: accessCookie.Value + "x"})
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+			code, access = issue(teacher, xDeviceV1.DeviceAccessSpec{Port: "jupyter-lab", TTLSeconds: 60})
+			Expect(code).To(Equal(http.StatusOK))
+			link, err := url.Parse(access.Spec.URLs)
+			Expect(err).To(BeNil())
+			resp = send(http.MethodPost, proxyPath+"/api/contents?"+link.RawQuery)
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			result = map[string]string{}
+			Expect(json.NewDecoder(resp.Body).Decode(&result)).To(Succeed())
+			resp.Body.Close()
+			Expect(result["query"]).To(Equal(""))
+		})
+
+		It("open-hydra scheduling policy should be expected", func() {
+			fakeK8sHelper.ServerConfig.SchedulingPolicy = &config.SchedulingPolicyConfig{
+				Sandboxes: map[string]config.SchedulingPolicy{
+					"jupyter-lab": {
+						NodeSelector:              map[string]string{"pool": "lab", "disk": "ssd"},
+						Tolerations:               []config.Toleration{{Key: "nvidia.com/gpu", Operator: "Exists", Effect: "NoSchedule"}},
+						TopologySpreadConstraints: []config.TopologySpreadConstraint{{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname"}},
+						PriorityClassName:         "sandbox",
+						UserAffinity:              UserAffinityAllow,
+					},
+				},
+				Roles: map[int]config.SchedulingPolicy{
+					2: {NodeSelector: map[string]string{"pool": "student"}, UserAffinity: UserAffinityDeny},
+				},
+			}
+			affinity := &coreV1.Affinity{NodeAffinity: &coreV1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &coreV1.NodeSelector{NodeSelectorTerms: []coreV1.NodeSelectorTerm{
+					{MatchExpressions: []coreV1.NodeSelectorRequirement{{Key: "kubernetes.io/hostname", Operator: coreV1.NodeSelectorOpIn, Values: []string{"node1"}}}},
+				}},
+			}}
+
+			device2.Spec.Affinity = affinity
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			device2.Spec.Affinity = nil
+			body, err = json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			podSpec := deploy[0].Spec.Template.Spec
+			Expect(podSpec.NodeSelector).To(Equal(map[string]string{"pool": "student", "disk": "ssd"}))
+			Expect(podSpec.Tolerations).To(Equal([]coreV1.Toleration{{Key: "nvidia.com/gpu", Operator: coreV1.TolerationOpExists, Effect: coreV1.TaintEffectNoSchedule}}))
+			Expect(podSpec.PriorityClassName).To(Equal("sandbox"))
+			Expect(podSpec.TopologySpreadConstraints).To(HaveLen(1))
+			Expect(podSpec.TopologySpreadConstraints[0].WhenUnsatisfiable).To(Equal(coreV1.ScheduleAnyway))
+			Expect(podSpec.TopologySpreadConstraints[0].LabelSelector.MatchLabels[k8s.OpenHydraSandboxKey]).To(Equal("jupyter-lab"))
+
+			// teacher falls back to policy of sandbox
+			device := createDevice("teacher", "jupyter-lab", "", 0)
+			device.Spec.Affinity = affinity
+			body, err = json.Marshal(device)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("teacher", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Spec.Template.Spec.Affinity).To(Equal(affinity))
+			Expect(deploy[0].Spec.Template.Spec.NodeSelector).To(Equal(map[string]string{"pool": "lab", "disk": "ssd"}))
+		})
+
+		It("open-hydra device create should roll back and be retried", func() {
+			label := k8s.DeviceLabelSelector("student", "")
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			fakeK8sHelper.CreateServiceError = fmt.Errorf("service quota exceeded")
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy).To(BeEmpty())
+
+			fakeK8sHelper.CreateServiceError = nil
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			service, err := fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(service.OwnerReferences).To(HaveLen(1))
+			Expect(service.OwnerReferences[0].Kind).To(Equal("Deployment"))
+			Expect(service.OwnerReferences[0].UID).To(Equal(deploy[0].UID))
+
+			// deployment left without service is finished by retry
+			Expect(fakeK8sHelper.DeleteUserService(label, OpenhydraNamespace, nil)).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			_, err = fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			// young deployment may belong to a create still running
+			_, r2 = callApi(http.MethodDelete, openHydraDevicesURL+"/student", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			fakeK8sHelper.DeploymentCreationTimestamp = metaV1.Now()
+			defer func() { fakeK8sHelper.DeploymentCreationTimestamp = metaV1.Time{} }()
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(fakeK8sHelper.DeleteUserService(label, OpenhydraNamespace, nil)).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusConflict))
+			_, err = fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).NotTo(BeNil())
+		})
+
+		It("open-hydra device reconciler should repair device without service", func() {
+			label := k8s.DeviceLabelSelector("student", "")
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			service, err := fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			ports := service.Spec.Ports
+
+			Expect(fakeK8sHelper.DeleteUserService(label, OpenhydraNamespace, nil)).To(BeNil())
+			Expect(builder.reconcileDevices(fakeK8sHelper.ServerConfig)).To(BeNil())
+			service, err = fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(service.Spec.Ports).To(ConsistOf(ports))
+			Expect(service.OwnerReferences).To(HaveLen(1))
+		})
+
+		It("open-hydra device reconciler should label legacy device as default device", func() {
+			label := k8s.DeviceLabelSelector("student", "")
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			// device created before users own multiple devices has no device label
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			delete(deploy[0].Labels, k8s.OpenHydraDeviceLabelKey)
+			delete(deploy[0].Spec.Template.Labels, k8s.OpenHydraDeviceLabelKey)
+			service, err := fakeK8sHelper.GetUserService(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			delete(service.Labels, k8s.OpenHydraDeviceLabelKey)
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(k8s.LegacyDeviceLabelSelector("student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy).To(HaveLen(1))
+
+			Expect(builder.reconcileDevices(fakeK8sHelper.ServerConfig)).To(BeNil())
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(label, OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy).To(HaveLen(1))
+			Expect(deploy[0].Spec.Template.Labels[k8s.OpenHydraDeviceLabelKey]).To(Equal(k8s.OpenHydraDefaultDeviceId))
+			services, err := fakeK8sHelper.ListService(OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(services).To(HaveLen(1))
+			Expect(services[0].Labels[k8s.OpenHydraDeviceLabelKey]).To(Equal(k8s.OpenHydraDefaultDeviceId))
+		})
+
+		It("open-hydra usage ledger should be expected", func() {
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			Expect(err).To(BeNil())
+			listRecords := func() []xDeviceV1.UsageRecord {
+				records, err := fakeDb.ListUsageRecords(time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+				Expect(err).To(BeNil())
+				return records.Items
+			}
+
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(builder.syncUsageLedger(serverConfig)).To(BeNil())
+			records := listRecords()
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].Spec.Event).To(Equal(UsageEventStart))
+			Expect(records[0].Spec.OpenHydraUsername).To(Equal("student"))
+			Expect(records[0].Spec.SandboxName).To(Equal("jupyter-lab"))
+
+			// nothing changed nothing is recorded
+			Expect(builder.syncUsageLedger(serverConfig)).To(BeNil())
+			Expect(listRecords()).To(HaveLen(1))
+
+			_, err = fakeK8sHelper.UpdateDeployment(&k8s.DeploymentParameters{
+				Username:    "student",
+				Namespace:   OpenhydraNamespace,
+				SandboxName: "jupyter-lab",
+				CpuMemorySet: k8s.CpuMemorySet{
+					CpuRequest:    "2000m",
+					CpuLimit:      "2000m",
+					MemoryRequest: "1024Mi",
+					MemoryLimit:   "1024Mi",
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(builder.syncUsageLedger(serverConfig)).To(BeNil())
+			records = listRecords()
+			Expect(records).To(HaveLen(2))
+			Expect(records[1].Spec.Event).To(Equal(UsageEventResize))
+			Expect(records[1].Spec.CpuMilli).To(Equal(int64(2000)))
+
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/student/stop", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			// ledger is rebuilt from database after restart
+			builder.usageSessions = nil
+			Expect(builder.syncUsageLedger(serverConfig)).To(BeNil())
+			records = listRecords()
+			Expect(records).To(HaveLen(3))
+			Expect(records[2].Spec.Event).To(Equal(UsageEventStop))
+			Expect(records[2].Spec.CpuMilli).To(Equal(int64(2000)))
+
+			reportURL := fmt.Sprintf("http://localhost/apis/%s/v1/%s?groupBy=sandbox&from=%s", option.GroupVersion.Group, UsageReportPath, time.Now().Add(-time.Hour).UTC().Format(time.RFC3339))
+			_, r2 = callApi(http.MethodGet, reportURL, createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			_, r2 = callApi(http.MethodGet, reportURL, createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var report xDeviceV1.UsageReport
+			Expect(json.NewDecoder(r2.Body).Decode(&report)).To(BeNil())
+			Expect(report.Spec.GroupBy).To(Equal("sandbox"))
+			Expect(report.Spec.Items).To(HaveLen(1))
+			Expect(report.Spec.Items[0].Key).To(Equal("jupyter-lab"))
+			Expect(report.Spec.Items[0].Devices).To(Equal(1))
+
+			_, r2 = callApi(http.MethodGet, reportURL+"&format=csv", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(r2.Header().Get("Content-Type")).To(HavePrefix("text/csv"))
+			lines := strings.Split(strings.TrimSpace(r2.Body.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[0]).To(Equal("sandbox,cpuCoreHours,memoryGiBHours,gpuHours,devices"))
+			Expect(lines[1]).To(HavePrefix("jupyter-lab,"))
+
+			_, r2 = callApi(http.MethodGet, strings.Replace(reportURL, "groupBy=sandbox", "groupBy=course", 1), createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("open-hydra watch should be expected", func() {
+			// context of watch is already done so only events up to now are written
+			callWatch := func(url string) (int, []map[string]interface{}) {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				request := httptest.NewRequest(http.MethodGet, url, nil).WithContext(ctx)
+				request.Header = createTokenValue(teacher, nil)
+				request.Host = "localhost"
+				recorder := httptest.NewRecorder()
+				container.Dispatch(recorder, request)
+				var events []map[string]interface{}
+				decoder := json.NewDecoder(recorder.Body)
+				for decoder.More() {
+					var event map[string]interface{}
+					Expect(decoder.Decode(&event)).To(BeNil())
+					events = append(events, event)
+				}
+				return recorder.Code, events
+			}
+
+			_, r2 := callApi(http.MethodGet, openHydraUsersURL, createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var users xUserV1.OpenHydraUserList
+			Expect(json.NewDecoder(r2.Body).Decode(&users)).To(BeNil())
+			Expect(users.ResourceVersion).To(Equal(builder.watchHubs[OpenHydraUserPath].version(2)))
+			Expect(users.Items[0].ResourceVersion).NotTo(BeEmpty())
+
+			code, events := callWatch(openHydraUsersURL + "?watch=true")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(events).To(HaveLen(2))
+			Expect(events[0]["type"]).To(Equal("ADDED"))
+
+			body, err := json.Marshal(newStudent)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraUsersURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusCreated))
+			code, events = callWatch(openHydraUsersURL + "?watch=true&resourceVersion=" + users.ResourceVersion)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(events).To(HaveLen(1))
+			Expect(events[0]["type"]).To(Equal("ADDED"))
+			Expect(events[0]["object"].(map[string]interface{})["metadata"].(map[string]interface{})["name"]).To(Equal(newStudent.Name))
+
+			// device of user changes along with its pod
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL, createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var devices xDeviceV1.DeviceList
+			Expect(json.NewDecoder(r2.Body).Decode(&devices)).To(BeNil())
+			body, err = json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			code, events = callWatch(openHydraDevicesURL + "?watch=true&resourceVersion=" + devices.ResourceVersion)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(events).To(HaveLen(1))
+			Expect(events[0]["type"]).To(Equal("MODIFIED"))
+			Expect(events[0]["object"].(map[string]interface{})["spec"].(map[string]interface{})["openHydraUsername"]).To(Equal("student"))
+
+			// client has to list again after server restart
+			code, events = callWatch(openHydraDevicesURL + "?watch=true&resourceVersion=1000")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(events).To(HaveLen(1))
+			Expect(events[0]["type"]).To(Equal("ERROR"))
+			Expect(events[0]["object"].(map[string]interface{})["code"]).To(BeEquivalentTo(http.StatusGone))
+
+			code, _ = callWatch(openHydraDevicesURL + "?watch=true&timeoutSeconds=-1")
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("open-hydra workspace claim should be expected", func() {
+			volumes := []apis.Volume{
+				{HostPath: &apis.HostPath{Name: "jupyter-lab", Path: "{workspace}/jupyter-lab/{username}"}},
+				{HostPath: &apis.HostPath{Name: "public-dataset", Path: "{dataset-public}"}},
+				{HostPath: &apis.HostPath{Name: "vscode", Path: "{workspace}/vscode/{username}"}},
+				{EmptyDir: &apis.EmptyDir{Name: "shm", Medium: "Memory"}},
+			}
+			serverConfig := config.DefaultConfig()
+			serverConfig.WorkspaceStorageClass = "fast"
+			serverConfig.Quota = &config.QuotaConfig{
+				Roles: map[int]config.ResourceQuota{2: {Workspace: "20Gi"}},
+			}
+			result, _, err := builder.useWorkspaceClaim(volumes, nil, "student", 2, serverConfig)
+			Expect(err).To(BeNil())
+			Expect(result).To(HaveLen(4))
+			Expect(result[0].HostPath).To(BeNil())
+			Expect(*result[0].PersistentVolumeClaim).To(Equal(apis.PersistentVolumeClaim{Name: "jupyter-lab", ClaimName: "openhydra-workspace-student"}))
+			Expect(result[1]).To(Equal(volumes[1]))
+			Expect(result[2].PersistentVolumeClaim.Name).To(Equal("vscode"))
+			Expect(result[2].PersistentVolumeClaim.ClaimName).To(Equal("openhydra-workspace-student"))
+			Expect(result[3]).To(Equal(volumes[3]))
+			Expect(fakeK8sHelper.Workspaces).To(HaveLen(1))
+			claim := fakeK8sHelper.Workspaces["openhydra-workspace-student"]
+			Expect(*claim.Spec.StorageClassName).To(Equal("fast"))
+			Expect(claim.Spec.AccessModes).To(Equal([]coreV1.PersistentVolumeAccessMode{coreV1.ReadWriteOnce}))
+			Expect(claim.Spec.Resources.Requests.Storage().String()).To(Equal("20Gi"))
+
+			// claim of user is reused by next device
+			_, _, err = builder.useWorkspaceClaim(volumes, nil, "student", 2, serverConfig)
+			Expect(err).To(BeNil())
+			Expect(fakeK8sHelper.Workspaces).To(HaveLen(1))
+
+			_, _, err = builder.useWorkspaceClaim(volumes, nil, "teacher", 1, serverConfig)
+			Expect(err).To(BeNil())
+			claim = fakeK8sHelper.Workspaces["openhydra-workspace-teacher"]
+			Expect(claim.Spec.Resources.Requests.Storage().String()).To(Equal("10Gi"))
+
+			serverConfig.Quota.Users = map[string]config.ResourceQuota{"newStudent": {Workspace: "lots"}}
+			_, _, err = builder.useWorkspaceClaim(volumes, nil, "newStudent", 2, serverConfig)
+			Expect(err).NotTo(BeNil())
+			Expect(fakeK8sHelper.Workspaces).To(HaveLen(2))
+
+			// claim attached to a single node can not be shared by devices of user
+			serverConfig.MaximumDevicesPerUser = 2
+			_, _, err = builder.useWorkspaceClaim(volumes, nil, "student", 2, serverConfig)
+			Expect(err).NotTo(BeNil())
+			serverConfig.WorkspaceAccessMode = string(coreV1.ReadWriteMany)
+			_, _, err = builder.useWorkspaceClaim(volumes, nil, "student", 2, serverConfig)
+			Expect(err).To(BeNil())
+		})
+
+		It("open-hydra workspace claim should keep dir of every sandbox", func() {
+			sandboxes := map[string]struct {
+				volumes []apis.Volume
+				mounts  []apis.VolumeMount
+			}{
+				"jupyter-lab": {
+					volumes: []apis.Volume{
+						{HostPath: &apis.HostPath{Name: "jupyter-lab", Path: "{workspace}/jupyter-lab/{username}"}},
+						{HostPath: &apis.HostPath{Name: "public-dataset", Path: "{dataset-public}"}},
+					},
+					mounts: []apis.VolumeMount{
+						{Name: "jupyter-lab", MountPath: "/root/notebook"},
+						{Name: "public-dataset", MountPath: "/root/notebook/dataset-public", ReadOnly: true},
+					},
+				},
+				"keras": {
+					volumes: []apis.Volume{
+						{HostPath: &apis.HostPath{Name: "keras", Path: "{workspace}/keras/{username}"}},
+					},
+					mounts: []apis.VolumeMount{
+						{Name: "keras", MountPath: "/home/workspace"},
+					},
+				},
+			}
+			serverConfig := config.DefaultConfig()
+			subPaths := map[string]string{}
+			for name, sandbox := range sandboxes {
+				volumes, mounts, err := builder.useWorkspaceClaim(sandbox.volumes, sandbox.mounts, "student", 2, serverConfig)
+				Expect(err).To(BeNil())
+				Expect(volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("openhydra-workspace-student"))
+				Expect(mounts).To(HaveLen(len(sandbox.mounts)))
+				subPaths[name] = mounts[0].SubPath
+				if len(mounts) > 1 {
+					Expect(mounts[1].SubPath).To(BeEmpty())
+				}
+				// mounts of sandbox in plugin config map are left as they are
+				Expect(sandbox.mounts[0].SubPath).To(BeEmpty())
+			}
+			Expect(subPaths).To(Equal(map[string]string{"jupyter-lab": "jupyter-lab/student", "keras": "keras/student"}))
+			Expect(fakeK8sHelper.Workspaces).To(HaveLen(1))
+
+			deploy, err := fakeK8sHelper.CreateDeployment(&k8s.DeploymentParameters{
+				Username:     "student",
+				Namespace:    OpenhydraNamespace,
+				SandboxName:  "keras",
+				VolumeMounts: []apis.VolumeMount{{Name: "keras", MountPath: "/home/workspace", SubPath: "keras/student"}},
+				CpuMemorySet: k8s.CpuMemorySet{
+					CpuRequest:    "1000m",
+					CpuLimit:      "1000m",
+					MemoryRequest: "1024Mi",
+					MemoryLimit:   "1024Mi",
+				},
+			})
+			Expect(err).To(BeNil())
+			Expect(deploy.Spec.Template.Spec.Containers[0].VolumeMounts[0].SubPath).To(Equal("keras/student"))
+		})
+
+		It("open-hydra device update should be expected", func() {
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			update := &xDeviceV1.Device{
+				Spec: xDeviceV1.DeviceSpec{
+					DeviceCpu: "3000",
+					DeviceRam: "4096",
+				},
+			}
+			body, err = json.Marshal(update)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			result, err := io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.DeviceCpu).To(Equal("3000"))
+			Expect(target.Spec.DeviceRam).To(Equal("4096"))
+			Expect(target.Spec.SandboxName).To(Equal("jupyter-lab"))
+			Expect(target.Spec.DeviceType).To(Equal("cpu"))
+
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, "student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Resources.Limits.Cpu().String()).To(Equal("3"))
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Resources.Limits.Memory().String()).To(Equal("4Gi"))
+			Expect(deploy[0].Spec.Strategy.Type).To(Equal(appsV1.RecreateDeploymentStrategyType))
+		})
+
+		It("open-hydra device update should roll back when service fails", func() {
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			// new sandbox has more ports so service of device is replaced
+			fakeK8sHelper.ServerConfig.MaximumPortsPerSandbox = 4
+			fakeK8sHelper.CreateServiceError = fmt.Errorf("service quota exceeded")
+			update := &xDeviceV1.Device{
+				Spec: xDeviceV1.DeviceSpec{
+					SandboxName: "jupyter-lab-lot-ports",
+				},
+			}
+			body, err = json.Marshal(update)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Labels[k8s.OpenHydraSandboxKey]).To(Equal("jupyter-lab"))
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Ports).To(HaveLen(1))
+			fakeK8sHelper.CreateServiceError = nil
+		})
+
+		It("open-hydra device update should be rejected as expected", func() {
+			update := &xDeviceV1.Device{
+				Spec: xDeviceV1.DeviceSpec{
+					DeviceGpu: 1,
+				},
+			}
+			body, err := json.Marshal(update)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+
+			body, err = json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			body, err = json.Marshal(update)
+			Expect(err).To(BeNil())
+			// student can not add gpu
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			// no gpu free in cluster
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+			// student can not update device of others
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/teacher", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			fakeK8sHelper.Nodes = []coreV1.Node{
+				{
+					Status: coreV1.NodeStatus{
+						Allocatable: coreV1.ResourceList{
+							"nvidia.com/gpu": resource.MustParse("1"),
+						},
+					},
+				},
+			}
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, "student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Image).To(Equal("nvidia-gpu-image"))
+
+			// sandbox exceeds maximum ports so deployment should be left untouched
+			update = &xDeviceV1.Device{
+				Spec: xDeviceV1.DeviceSpec{
+					SandboxName: "jupyter-lab-lot-ports",
+				},
+			}
+			body, err = json.Marshal(update)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, "student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Labels[k8s.OpenHydraSandboxKey]).To(Equal("jupyter-lab"))
+		})
+
+		It("open-hydra device stop and start should be expected", func() {
+			deployment, err := fakeK8sHelper.CreateDeployment(&k8s.DeploymentParameters{
+				Username:    "student",
+				Namespace:   OpenhydraNamespace,
+				SandboxName: "jupyter-lab",
+				CpuMemorySet: k8s.CpuMemorySet{
+					CpuRequest:    "1000m",
+					CpuLimit:      "1000m",
+					MemoryRequest: "1024Mi",
+					MemoryLimit:   "1024Mi",
+				},
+			})
+			Expect(err).To(BeNil())
+			err = fakeK8sHelper.CreateService(OpenhydraNamespace, "student", "", "jupyter-lab", nil, map[string]int{"lab": 8888}, coreV1.ServiceTypeNodePort, deployment)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL+"/student/stop", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			result, err := io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusStopped))
+			Expect(target.Spec.SandboxName).To(Equal("jupyter-lab"))
+			Expect(target.Spec.DeviceType).To(Equal("cpu"))
+
+			// device can not be created again while it is stopped
+			body, err := json.Marshal(createDevice("student", "jupyter-lab", "", 0))
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/student/start", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			pods, err := fakeK8sHelper.ListPodWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraUserLabelKey, "student"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(len(pods)).To(Equal(1))
+		})
+
+		It("open-hydra gpu queue should be expected", func() {
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 3
+			fakeK8sHelper.Nodes = []coreV1.Node{
+				{
+					Status: coreV1.NodeStatus{
+						Allocatable: coreV1.ResourceList{
+							"nvidia.com/gpu": resource.MustParse("1"),
+						},
+					},
+				},
+			}
+			createGpuDevice := func(deviceId string, priority int32) xDeviceV1.Device {
+				device := createDevice("teacher", "jupyter-lab", "nvidia.com/gpu", 1)
+				device.Spec.DeviceId = deviceId
+				device.Spec.QueuePriority = priority
+				body, err := json.Marshal(device)
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+				Expect(r2.Code).To(Equal(http.StatusOK))
+				var target xDeviceV1.Device
+				err = json.NewDecoder(r2.Body).Decode(&target)
+				Expect(err).To(BeNil())
+				return target
+			}
+			getDevice := func(deviceId string) xDeviceV1.Device {
+				_, r2 := callApi(http.MethodGet, openHydraDevicesURL+"/teacher?deviceId="+deviceId, createTokenValue(teacher, nil), nil)
+				Expect(r2.Code).To(Equal(http.StatusOK))
+				var target xDeviceV1.Device
+				err := json.NewDecoder(r2.Body).Decode(&target)
+				Expect(err).To(BeNil())
+				return target
+			}
+
+			// the only gpu is free
+			target := createGpuDevice("", 0)
+			Expect(target.Spec.DeviceStatus).To(Equal("Creating"))
+
+			target = createGpuDevice("second", 0)
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusQueued))
+			Expect(target.Spec.LineNo).To(Equal("1"))
+			// running device is expected to hold gpu for a whole session
+			Expect(target.Spec.EstimatedWaitSeconds).To(BeNumerically(">", 3500))
+
+			// higher priority jumps the queue
+			target = createGpuDevice("third", 10)
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusQueued))
+			Expect(target.Spec.LineNo).To(Equal("1"))
+			target = getDevice("second")
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusQueued))
+			Expect(target.Spec.LineNo).To(Equal("2"))
+			Expect(target.Spec.EstimatedWaitSeconds).To(BeNumerically(">", 7000))
+
+			sumUp, err := builder.SumUpGpuResources(nil, &coreV1.NodeList{Items: fakeK8sHelper.Nodes})
+			Expect(err).To(BeNil())
+			Expect(sumUp.Spec.TotalLine).To(Equal(uint16(2)))
+
+			// queued device can not be updated
+			body, err := json.Marshal(&xDeviceV1.Device{Spec: xDeviceV1.DeviceSpec{DeviceCpu: "2000"}})
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPut, openHydraDevicesURL+"/teacher?deviceId=second", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			// gpu is released by stopping default device
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/stop", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			err = builder.syncGpuQueue(fakeK8sHelper.ServerConfig)
+			Expect(err).To(BeNil())
+			target = getDevice("third")
+			Expect(target.Spec.DeviceStatus).To(Equal(string(coreV1.PodRunning)))
+			target = getDevice("second")
+			Expect(target.Spec.LineNo).To(Equal("1"))
+
+			// starting a stopped gpu device queues it again
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/start", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusQueued))
+			Expect(target.Spec.LineNo).To(Equal("2"))
+
+			// gpu added to cluster goes to queue first, running device can not take it by resize
+			fakeK8sHelper.Nodes[0].Status.Allocatable["nvidia.com/gpu"] = resource.MustParse("2")
+			body, err = json.Marshal(&xDeviceV1.Device{Spec: xDeviceV1.DeviceSpec{DeviceGpu: 2}})
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/teacher?deviceId=third", createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+			Expect(r2.Body.String()).To(ContainSubstring("waiting in gpu queue"))
+			fakeK8sHelper.Nodes[0].Status.Allocatable["nvidia.com/gpu"] = resource.MustParse("1")
+
+			// stopping a queued device takes it out of queue
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/stop?deviceId=second", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			target = getDevice("second")
+			Expect(target.Spec.DeviceStatus).To(Equal(DeviceStatusStopped))
+			err = builder.syncGpuQueue(fakeK8sHelper.ServerConfig)
+			Expect(err).To(BeNil())
+			target = getDevice("")
+			Expect(target.Spec.LineNo).To(Equal("1"))
+
+			// queued devices are let go once queue is disabled
+			fakeK8sHelper.ServerConfig.GpuQueuePolicy = GpuQueuePolicyDisabled
+			err = builder.syncGpuQueue(fakeK8sHelper.ServerConfig)
+			Expect(err).To(BeNil())
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(gpuQueueSelector(), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(len(deploy)).To(Equal(0))
+		})
+
+		It("open-hydra gpu profile should be expected", func() {
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 3
+			fakeK8sHelper.ServerConfig.GpuQueuePolicy = GpuQueuePolicyDisabled
+			fakeK8sHelper.ServerConfig.GpuResourceKeys = append(fakeK8sHelper.ServerConfig.GpuResourceKeys, "nvidia.com/mig-1g.5gb", "nvidia.com/gpu.shared")
+			fakeK8sHelper.ServerConfig.GpuProfiles = map[string]config.GpuProfile{
+				"mig-1g.5gb": {Type: GpuProfileTypeMig, ResourceKey: "nvidia.com/mig-1g.5gb", ImageKey: "nvidia.com/gpu"},
+				"shared":     {Type: GpuProfileTypeShared, ResourceKey: "nvidia.com/gpu.shared"},
+			}
+			create := func(deviceId, gpuProfile, gpuDriver string) *httptest.ResponseRecorder {
+				device := createDevice("teacher", "jupyter-lab", gpuDriver, 0)
+				device.Spec.DeviceId = deviceId
+				device.Spec.GpuProfile = gpuProfile
+				body, err := json.Marshal(device)
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+				return r2
+			}
+
+			r2 := create("mig", "mig-1g.5gb", "")
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			err := json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.DeviceType).To(Equal("gpu"))
+			Expect(target.Spec.GpuDriver).To(Equal("nvidia.com/mig-1g.5gb"))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("teacher", "mig"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy[0].Spec.Template.Spec.Containers[0].Image).To(Equal("nvidia-gpu-image"))
+			mig := deploy[0].Spec.Template.Spec.Containers[0].Resources.Requests["nvidia.com/mig-1g.5gb"]
+			Expect(mig.Value()).To(Equal(int64(1)))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher?deviceId=mig", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.GpuProfile).To(Equal("mig-1g.5gb"))
+
+			// unknown profile
+			Expect(create("unknown", "mig-7g.40gb", "").Code).To(Equal(http.StatusBadRequest))
+			// gpu driver conflicts with profile
+			Expect(create("conflict", "mig-1g.5gb", "nvidia.com/gpu").Code).To(Equal(http.StatusBadRequest))
+			// sandbox has no image for shared profile
+			Expect(create("shared", "shared", "").Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("open-hydra gpu model should be expected", func() {
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 5
+			fakeK8sHelper.ServerConfig.GpuQueuePolicy = GpuQueuePolicyDisabled
+			fakeK8sHelper.Nodes = []coreV1.Node{
+				{
+					ObjectMeta: metaV1.ObjectMeta{Name: "node-a100", Labels: map[string]string{"nvidia.com/gpu.product": "A100"}},
+					Status:     coreV1.NodeStatus{Allocatable: coreV1.ResourceList{"nvidia.com/gpu": resource.MustParse("2")}},
+				},
+				{
+					ObjectMeta: metaV1.ObjectMeta{Name: "node-4090", Labels: map[string]string{"nvidia.com/gpu.product": "RTX-4090"}},
+					Status:     coreV1.NodeStatus{Allocatable: coreV1.ResourceList{"nvidia.com/gpu": resource.MustParse("4")}},
+				},
+			}
+			fakeK8sHelper.ServerConfig.Quota = &config.QuotaConfig{
+				Users: map[string]config.ResourceQuota{
+					"teacher": {GpuModels: map[string]int64{"A100": 1}},
+				},
+			}
+			create := func(deviceId, gpuModel string) int {
+				device := createDevice("teacher", "jupyter-lab", "nvidia.com/gpu", 1)
+				device.Spec.DeviceId = deviceId
+				device.Spec.GpuModel = gpuModel
+				body, err := json.Marshal(device)
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+				return r2.Code
+			}
+
+			Expect(create("a100", "A100")).To(Equal(http.StatusOK))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("teacher", "a100"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(gpuModelOfAffinity(deploy[0].Spec.Template.Spec.Affinity, "nvidia.com/gpu.product")).To(Equal("A100"))
+
+			_, r2 := callApi(http.MethodGet, openHydraDevicesURL+"/teacher?deviceId=a100", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.GpuModel).To(Equal("A100"))
+
+			// model is not found on any node
+			Expect(create("h100", "H100")).To(Equal(http.StatusBadRequest))
+			// quota of A100 is used up
+			Expect(create("second", "A100")).To(Equal(http.StatusForbidden))
+			// model is not allowed by quota
+			Expect(create("4090", "RTX-4090")).To(Equal(http.StatusForbidden))
+			// gpu without model could land on any model
+			Expect(create("any", "")).To(Equal(http.StatusForbidden))
+
+			fakeK8sHelper.ServerConfig.Quota.Users["teacher"] = config.ResourceQuota{GpuModels: map[string]int64{"A100": 1, "RTX-4090": 4}}
+			Expect(create("4090", "RTX-4090")).To(Equal(http.StatusOK))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher/quota", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var quota xDeviceV1.DeviceQuota
+			err = json.NewDecoder(r2.Body).Decode(&quota)
+			Expect(err).To(BeNil())
+			Expect(quota.Spec.Used.GpuModels["RTX-4090"]).To(Equal(int64(1)))
+			Expect(quota.Spec.Remaining.GpuModels["RTX-4090"]).To(Equal(int64(3)))
+			Expect(quota.Spec.Remaining.GpuModels["A100"]).To(Equal(int64(0)))
+
+			sumUp, err := builder.SumUpGpuResources(nil, &coreV1.NodeList{Items: fakeK8sHelper.Nodes})
+			Expect(err).To(BeNil())
+			Expect(sumUp.Spec.GpuModelSumUp["A100"].Nodes).To(Equal(1))
+			Expect(sumUp.Spec.GpuModelSumUp["A100"].GpuResourceSumUp["nvidia.com/gpu"].Allocatable).To(Equal(int64(2)))
+			Expect(sumUp.Spec.GpuModelSumUp["RTX-4090"].GpuResourceSumUp["nvidia.com/gpu"].Allocatable).To(Equal(int64(4)))
+		})
+
+		It("open-hydra sandbox route should be expected", func() {
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 5
+			fakeK8sHelper.ServerConfig.SandboxExposureMode = SandboxExposureIngress
+			fakeK8sHelper.ServerConfig.SandboxRoute = &config.SandboxRouteConfig{
+				Routing:       SandboxRoutingPath,
+				Host:          "lab.example.com",
+				TLSSecretName: "lab-tls",
+			}
+			device := createDevice("teacher", "test", "", 0)
+			device.Spec.DeviceId = "routed"
+			body, err := json.Marshal(device)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+
+			route, found := fakeK8sHelper.Routes[k8s.DeviceLabelSelector("teacher", "routed")]
+			Expect(found).To(BeTrue())
+			Expect(route.Kind).To(Equal(k8s.DeviceRouteKindIngress))
+			Expect(route.Host).To(Equal("lab.example.com"))
+			Expect(route.TLSSecretName).To(Equal("lab-tls"))
+			Expect(route.Ports).To(Equal(map[string]int{"jupyter-lab": 8888}))
+			service, err := fakeK8sHelper.GetUserService(k8s.DeviceLabelSelector("teacher", "routed"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(service.Spec.Type).To(Equal(coreV1.ServiceTypeClusterIP))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher?deviceId=routed", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var target xDeviceV1.Device
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Spec.SandboxURLs).To(Equal("https://lab.example.com/teacher--routed--jupyter-lab/lab"))
+
+			_, r2 = callApi(http.MethodDelete, openHydraDevicesURL+"/teacher?deviceId=routed", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			_, found = fakeK8sHelper.Routes[k8s.DeviceLabelSelector("teacher", "routed")]
+			Expect(found).To(BeFalse())
+
+			// host routing without host can not build route
+			fakeK8sHelper.ServerConfig.SandboxRoute = &config.SandboxRouteConfig{Routing: SandboxRoutingHost}
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+		})
+
+		It("open-hydra device quota should be expected", func() {
+			devices := int64(2)
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 5
+			fakeK8sHelper.ServerConfig.Quota = &config.QuotaConfig{
+				Roles: map[int]config.ResourceQuota{
+					2: {Devices: &devices, Cpu: "5"},
+				},
+				Users: map[string]config.ResourceQuota{
+					"student": {Gpu: map[string]int64{"nvidia.com/gpu": 1}},
+				},
+				Groups: []config.GroupQuota{
+					{Name: "class1", Members: []string{"student", "teacher"}, Quota: config.ResourceQuota{Gpu: map[string]int64{"nvidia.com/gpu": 1}}},
+				},
+			}
+			create := func(user *xUserV1.OpenHydraUser, device *xDeviceV1.Device) int {
+				body, err := json.Marshal(device)
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(user, nil), bytes.NewReader(body))
+				return r2.Code
+			}
+
+			Expect(create(student, device2)).To(Equal(http.StatusOK))
+			// gpu is granted to student by quota
+			gpuDevice := createDevice("student", "jupyter-lab", "nvidia.com/gpu", 1)
+			gpuDevice.Spec.DeviceId = "gpu"
+			Expect(create(student, gpuDevice)).To(Equal(http.StatusOK))
+			// devices quota of student role is used up
+			third := createDevice("student", "jupyter-lab", "", 0)
+			third.Spec.DeviceId = "third"
+			Expect(create(student, third)).To(Equal(http.StatusForbidden))
+			// gpu of group is held by student
+			Expect(create(teacher, device1)).To(Equal(http.StatusForbidden))
+
+			_, r2 := callApi(http.MethodGet, openHydraDevicesURL+"/student/quota", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var quota xDeviceV1.DeviceQuota
+			err := json.NewDecoder(r2.Body).Decode(&quota)
+			Expect(err).To(BeNil())
+			Expect(*quota.Spec.Hard.Devices).To(Equal(int64(2)))
+			Expect(*quota.Spec.Used.Devices).To(Equal(int64(2)))
+			Expect(*quota.Spec.Remaining.Devices).To(Equal(int64(0)))
+			Expect(quota.Spec.Remaining.Cpu).To(Equal("1"))
+			Expect(quota.Spec.Remaining.Gpu["nvidia.com/gpu"]).To(Equal(int64(0)))
+			Expect(quota.Spec.Remaining.Memory).To(BeEmpty())
+			Expect(len(quota.Spec.Groups)).To(Equal(1))
+			Expect(quota.Spec.Groups[0].Used.Gpu["nvidia.com/gpu"]).To(Equal(int64(1)))
+			Expect(quota.Spec.Groups[0].Remaining.Gpu["nvidia.com/gpu"]).To(Equal(int64(0)))
+
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher/quota", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			// stopped device gives gpu back to group
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/student/stop?deviceId=gpu", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(create(teacher, device1)).To(Equal(http.StatusOK))
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/student/start?deviceId=gpu", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			// cpu quota applies to update as well
+			body, err := json.Marshal(&xDeviceV1.Device{Spec: xDeviceV1.DeviceSpec{DeviceCpu: "6000"}})
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraDevicesURL+"/student", createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("open-hydra gpu budget should be expected", func() {
+			gpuHours := 1.0
+			fakeK8sHelper.ServerConfig.MaximumDevicesPerUser = 5
+			fakeK8sHelper.ServerConfig.GpuQueuePolicy = GpuQueuePolicyDisabled
+			fakeK8sHelper.ServerConfig.Quota = &config.QuotaConfig{
+				Users: map[string]config.ResourceQuota{
+					"student": {Gpu: map[string]int64{"nvidia.com/gpu": 2}, GpuHours: &gpuHours},
+				},
+				StopGpuDevicesOnBudgetExhausted: true,
+				BudgetGracePeriodSeconds:        3600,
+			}
+			create := func(deviceId string, gpu uint8) int {
+				device := createDevice("student", "jupyter-lab", "nvidia.com/gpu", gpu)
+				device.Spec.DeviceId = deviceId
+				body, err := json.Marshal(device)
+				Expect(err).To(BeNil())
+				_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+				return r2.Code
+			}
+			Expect(create("gpu", 1)).To(Equal(http.StatusOK))
+
+			// budget is used up, only cpu device can be created
+			gpuHours = 0
+			Expect(create("second", 1)).To(Equal(http.StatusForbidden))
+			Expect(create("cpu", 0)).To(Equal(http.StatusOK))
+
+			_, r2 := callApi(http.MethodGet, openHydraDevicesURL+"/student/budget", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var budget xDeviceV1.DeviceBudget
+			Expect(json.NewDecoder(r2.Body).Decode(&budget)).To(BeNil())
+			Expect(budget.Spec.Period).To(Equal(BudgetPeriodWeek))
+			Expect(budget.Spec.Exhausted).To(BeTrue())
+			Expect(budget.Spec.Budgets).To(HaveLen(1))
+			Expect(budget.Spec.Budgets[0].Scope).To(Equal(BudgetScopeUser))
+			Expect(budget.Spec.Budgets[0].Remaining).To(BeZero())
+			Expect(budget.Spec.StopAt).To(BeNil())
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/teacher/budget", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+
+			// running gpu device is warned first
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			Expect(err).To(BeNil())
+			Expect(builder.enforceGpuBudgets(serverConfig)).To(BeNil())
+			stopAt, found := builder.getGpuBudgetStop("student")
+			Expect(found).To(BeTrue())
+			Expect(stopAt).To(BeTemporally(">", time.Now().Add(59*time.Minute)))
+			_, r2 = callApi(http.MethodGet, openHydraDevicesURL+"/student?deviceId=gpu", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			var device xDeviceV1.Device
+			Expect(json.NewDecoder(r2.Body).Decode(&device)).To(BeNil())
+			Expect(device.Status.Reason).To(Equal(DeviceReasonGpuBudgetExhausted))
+
+			// and stopped once grace period is over
+			builder.setGpuBudgetStops(map[string]time.Time{"student": time.Now()})
+			Expect(builder.enforceGpuBudgets(serverConfig)).To(BeNil())
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", "gpu"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(*deploy[0].Spec.Replicas).To(Equal(int32(0)))
+			deploy, err = fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", "cpu"), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(*deploy[0].Spec.Replicas).To(Equal(int32(1)))
+			_, found = builder.getGpuBudgetStop("student")
+			Expect(found).To(BeFalse())
+		})
+
+		It("open-hydra network policy should be expected", func() {
+			fakeK8sHelper.ServerConfig.NetworkPolicy = &config.NetworkPolicyConfig{
+				Egress: []config.NetworkPeer{{CIDR: "10.0.0.10/32"}},
+			}
+			// sandbox could not be reached by anyone
+			body, err := json.Marshal(device2)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusInternalServerError))
+			Expect(fakeK8sHelper.NetworkPolicies).To(BeEmpty())
+
+			fakeK8sHelper.ServerConfig.NetworkPolicy.Ingress = []config.NetworkPeer{{CIDR: "192.168.0.0/16"}}
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			policy, found := fakeK8sHelper.NetworkPolicies["openhydra-network-student"]
+			Expect(found).To(BeTrue())
+			Expect(policy.Spec.Egress).To(HaveLen(3))
+
+			// exam mode blocks all egress right away
+			examMode := true
+			body, err = json.Marshal(&xSetting.Setting{Spec: xSetting.SettingSpec{ExamMode: &examMode}})
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPut, openHydraSettingsURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			Expect(fakeK8sHelper.NetworkPolicies["openhydra-network-student"].Spec.Egress).To(BeEmpty())
+
+			// policy of user without devices is removed
+			_, r2 = callApi(http.MethodDelete, openHydraDevicesURL+"/student", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusOK))
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			Expect(err).To(BeNil())
+			Expect(serverConfig.NetworkPolicy.ExamMode).To(BeTrue())
+			// policy just applied may belong to a create which has not created deployment yet
+			Expect(builder.reconcileNetworkPolicies(serverConfig)).To(BeNil())
+			policy = fakeK8sHelper.NetworkPolicies["openhydra-network-student"]
+			Expect(policy.Annotations).To(HaveKey(k8s.OpenHydraPolicyAppliedAtAnnotation))
+			policy.Annotations[k8s.OpenHydraPolicyAppliedAtAnnotation] = time.Now().Add(-deviceReconcileGracePeriod).UTC().Format(time.RFC3339Nano)
+			Expect(builder.reconcileNetworkPolicies(serverConfig)).To(BeNil())
+			Expect(fakeK8sHelper.NetworkPolicies).To(BeEmpty())
+
+			fakeK8sHelper.ServerConfig.NetworkPolicy = nil
+			_, r2 = callApi(http.MethodPut, openHydraSettingsURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("open-hydra device stop and start should be rejected as expected", func() {
+			_, r2 := callApi(http.MethodPost, openHydraDevicesURL+"/student/stop", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/stop", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			_, r2 = callApi(http.MethodPost, openHydraDevicesURL+"/teacher/start", createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("device batch will reject students", func() {
+			batch := &xDeviceV1.DeviceBatch{
+				Spec: xDeviceV1.DeviceBatchSpec{
+					Operation: DeviceBatchOperationDelete,
+					Usernames: []string{"student"},
+				},
+			}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(student, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+			_, r2 = callApi(http.MethodGet, openHydraDeviceBatchesURL, createTokenValue(student, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("device batch with invalid operation should be rejected", func() {
+			batch := &xDeviceV1.DeviceBatch{
+				Spec: xDeviceV1.DeviceBatchSpec{
+					Operation: "restart",
+					Usernames: []string{"student"},
+				},
+			}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+
+			batch.Spec.Operation = DeviceBatchOperationCreate
+			body, err = json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("device batch delete by teacher should be expected", func() {
+			_, err := fakeK8sHelper.CreateDeployment(&k8s.DeploymentParameters{
+				Username:    "student",
+				Namespace:   OpenhydraNamespace,
+				SandboxName: "jupyter-lab",
+				CpuMemorySet: k8s.CpuMemorySet{
+					CpuRequest:    "1000m",
+					CpuLimit:      "1000m",
+					MemoryRequest: "1024Mi",
+					MemoryLimit:   "1024Mi",
+				},
+			})
+			Expect(err).To(BeNil())
+			batch := &xDeviceV1.DeviceBatch{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name: "unit-test",
+				},
+				Spec: xDeviceV1.DeviceBatchSpec{
+					Operation: DeviceBatchOperationDelete,
+					Usernames: []string{"student", "teacher", "student"},
+				},
+			}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusAccepted))
+			var target xDeviceV1.DeviceBatch
+			result, err := io.ReadAll(r2.Body)
+			Expect(err).To(BeNil())
+			err = json.Unmarshal(result, &target)
+			Expect(err).To(BeNil())
+			Expect(target.Status.Total).To(Equal(2))
+
+			// same name should conflict
+			body, err = json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusConflict))
+
+			// teacher owns no device, it is not reported as deleted
+			Eventually(func() string {
+				_, r2 := callApi(http.MethodGet, openHydraDeviceBatchesURL+"/unit-test", createTokenValue(teacher, nil), nil)
+				target = xDeviceV1.DeviceBatch{}
+				_ = json.NewDecoder(r2.Body).Decode(&target)
+				return target.Status.Phase
+			}).Should(Equal(DeviceBatchPhaseFailed))
+			Expect(target.Status.Succeeded).To(Equal(1))
+			Expect(target.Status.Failed).To(Equal(1))
+			Expect(target.Status.Results[0].OpenHydraUsername).To(Equal("student"))
+			Expect(target.Status.Results[0].Result).To(Equal(DeviceBatchPhaseSucceeded))
+			Expect(target.Status.Results[1].OpenHydraUsername).To(Equal("teacher"))
+			Expect(target.Status.Results[1].Result).To(Equal(DeviceBatchResultNotFound))
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy).To(BeEmpty())
+
+			_, r2 = callApi(http.MethodGet, openHydraDeviceBatchesURL+"/not-found", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("device batch delete by label selector should select stopped devices", func() {
+			_, err := fakeK8sHelper.CreateDeployment(&k8s.DeploymentParameters{
+				Username:     "student",
+				Namespace:    OpenhydraNamespace,
+				SandboxName:  "jupyter-lab",
+				CustomLabels: map[string]string{"class": "ai-101"},
+				CpuMemorySet: k8s.CpuMemorySet{
+					CpuRequest:    "1000m",
+					CpuLimit:      "1000m",
+					MemoryRequest: "1024Mi",
+					MemoryLimit:   "1024Mi",
+				},
+			})
+			Expect(err).To(BeNil())
+			err = fakeK8sHelper.ScaleUserDeployment(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, 0, nil)
+			Expect(err).To(BeNil())
+			pods, err := fakeK8sHelper.ListPodWithLabel("class=ai-101", OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(pods).To(BeEmpty())
+
+			batch := &xDeviceV1.DeviceBatch{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name: "stopped",
+				},
+				Spec: xDeviceV1.DeviceBatchSpec{
+					Operation:     DeviceBatchOperationDelete,
+					LabelSelector: "class=ai-101",
+				},
+			}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusAccepted))
+
+			Eventually(func() []appsV1.Deployment {
+				deploy, _ := fakeK8sHelper.ListDeploymentWithLabel("class=ai-101", OpenhydraNamespace, nil)
+				return deploy
+			}).Should(BeEmpty())
+		})
+
+		It("device batch delete by label selector should delete matched device only", func() {
+			for deviceId, sandbox := range map[string]string{"": "jupyter-lab", "gpu": "vscode"} {
+				_, err := fakeK8sHelper.CreateDeployment(&k8s.DeploymentParameters{
+					Username:    "student",
+					DeviceId:    deviceId,
+					Namespace:   OpenhydraNamespace,
+					SandboxName: sandbox,
+					CpuMemorySet: k8s.CpuMemorySet{
+						CpuRequest:    "1000m",
+						CpuLimit:      "1000m",
+						MemoryRequest: "1024Mi",
+						MemoryLimit:   "1024Mi",
+					},
+				})
+				Expect(err).To(BeNil())
+			}
+
+			batch := &xDeviceV1.DeviceBatch{
+				ObjectMeta: metaV1.ObjectMeta{
+					Name: "vscode",
+				},
+				Spec: xDeviceV1.DeviceBatchSpec{
+					Operation:     DeviceBatchOperationDelete,
+					LabelSelector: fmt.Sprintf("%s=vscode", k8s.OpenHydraSandboxKey),
+				},
+			}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 := callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusAccepted))
+			var target xDeviceV1.DeviceBatch
+			err = json.NewDecoder(r2.Body).Decode(&target)
+			Expect(err).To(BeNil())
+			Expect(target.Status.Results).To(HaveLen(1))
+			Expect(target.Status.Results[0].DeviceId).To(Equal("gpu"))
+
+			Eventually(func() []appsV1.Deployment {
+				deploy, _ := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", "gpu"), OpenhydraNamespace, nil)
+				return deploy
+			}).Should(BeEmpty())
+			deploy, err := fakeK8sHelper.ListDeploymentWithLabel(k8s.DeviceLabelSelector("student", ""), OpenhydraNamespace, nil)
+			Expect(err).To(BeNil())
+			Expect(deploy).To(HaveLen(1))
+		})
+
+		It("device batch should be dropped after retention", func() {
+			finishedAt := func(ago time.Duration) *xDeviceV1.DeviceBatch {
+				batch := &xDeviceV1.DeviceBatch{Status: xDeviceV1.DeviceBatchStatus{Phase: DeviceBatchPhaseRunning}}
+				if ago > 0 {
+					completionTime := metaV1.NewTime(time.Now().Add(-ago))
+					batch.Status.Phase = DeviceBatchPhaseSucceeded
+					batch.Status.CompletionTime = &completionTime
+				}
+				return batch
+			}
+			builder.deviceBatches = map[string]*xDeviceV1.DeviceBatch{
+				"expired": finishedAt(2 * time.Hour), "older": finishedAt(2 * time.Minute), "newer": finishedAt(time.Minute), "running": finishedAt(0),
+			}
+			for name, batch := range builder.deviceBatches {
+				batch.Name = name
+			}
+			fakeK8sHelper.ServerConfig.DeviceBatchRetentionSeconds = 3600
+			fakeK8sHelper.ServerConfig.MaximumDeviceBatches = 3
+			_, r2 := callApi(http.MethodGet, openHydraDeviceBatchesURL+"/expired", createTokenValue(teacher, nil), nil)
+			Expect(r2.Code).To(Equal(http.StatusNotFound))
+			Expect(builder.deviceBatches).To(HaveLen(3))
+
+			// oldest finished batch makes room for new one
+			batch := &xDeviceV1.DeviceBatch{Spec: xDeviceV1.DeviceBatchSpec{Operation: DeviceBatchOperationDelete, Usernames: []string{"student"}}}
+			body, err := json.Marshal(batch)
+			Expect(err).To(BeNil())
+			_, r2 = callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusAccepted))
+			Expect(builder.deviceBatches).NotTo(HaveKey("older"))
+			Expect(builder.deviceBatches).To(HaveKey("newer"))
+
+			// nothing is dropped while all batches are running
+			fakeK8sHelper.ServerConfig.MaximumDeviceBatches = 1
+			builder.deviceBatches = map[string]*xDeviceV1.DeviceBatch{"running": finishedAt(0)}
+			_, r2 = callApi(http.MethodPost, openHydraDeviceBatchesURL, createTokenValue(teacher, nil), bytes.NewReader(body))
+			Expect(r2.Code).To(Equal(http.StatusTooManyRequests))
+		})
+
 		AfterEach(func() {
 		})
 	})
@@ -0,0 +1,434 @@
+package openhydra
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+	"open-hydra/pkg/util"
+
+	"github.com/emicklei/go-restful/v3"
+	appsV1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// deviceUsage is what devices hold, cpu and memory are taken from limits
+type deviceUsage struct {
+	devices     int64
+	cpuMilli    int64
+	memoryBytes int64
+	// gpu resource key -> gpu
+	gpu map[string]int64
+	// gpu model -> gpu, only gpu of device asking for model is counted
+	gpuModels map[string]int64
+}
+
+func (usage *deviceUsage) add(other deviceUsage) {
+	usage.devices += other.devices
+	usage.cpuMilli += other.cpuMilli
+	usage.memoryBytes += other.memoryBytes
+	for key, gpu := range other.gpu {
+		if usage.gpu == nil {
+			usage.gpu = map[string]int64{}
+		}
+		usage.gpu[key] += gpu
+	}
+	for model, gpu := range other.gpuModels {
+		if usage.gpuModels == nil {
+			usage.gpuModels = map[string]int64{}
+		}
+		usage.gpuModels[model] += gpu
+	}
+}
+
+// sub returns what is left after taking other away from usage
+func (usage deviceUsage) sub(other deviceUsage) deviceUsage {
+	result := deviceUsage{
+		devices:     usage.devices - other.devices,
+		cpuMilli:    usage.cpuMilli - other.cpuMilli,
+		memoryBytes: usage.memoryBytes - other.memoryBytes,
+		gpu:         map[string]int64{},
+		gpuModels:   map[string]int64{},
+	}
+	for key, gpu := range usage.gpu {
+		result.gpu[key] += gpu
+	}
+	for key, gpu := range other.gpu {
+		result.gpu[key] -= gpu
+	}
+	for model, gpu := range usage.gpuModels {
+		result.gpuModels[model] += gpu
+	}
+	for model, gpu := range other.gpuModels {
+		result.gpuModels[model] -= gpu
+	}
+	return result
+}
+
+func (usage deviceUsage) toQuotaResources() xDeviceV1.QuotaResources {
+	devices := usage.devices
+	return xDeviceV1.QuotaResources{
+		Devices:   &devices,
+		Cpu:       resource.NewMilliQuantity(usage.cpuMilli, resource.DecimalSI).String(),
+		Memory:    resource.NewQuantity(usage.memoryBytes, resource.BinarySI).String(),
+		Gpu:       usage.gpu,
+		GpuModels: usage.gpuModels,
+	}
+}
+
+// usageOfDeployment counts device of deployment, resources are only held while device is running or queued
+func usageOfDeployment(deploy appsV1.Deployment, serverConfig *config.OpenHydraServerConfig) deviceUsage {
+	usage := deviceUsage{devices: 1}
+	if len(deploy.Spec.Template.Spec.Containers) == 0 {
+		return usage
+	}
+	if deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0 && !k8s.IsDeploymentQueued(deploy) {
+		return usage
+	}
+	limits := deploy.Spec.Template.Spec.Containers[0].Resources.Limits
+	usage.cpuMilli = limits.Cpu().MilliValue()
+	usage.memoryBytes = limits.Memory().Value()
+	if driver, gpu := deploymentGpu(deploy, serverConfig.GpuResourceKeys); gpu > 0 {
+		usage.gpu = map[string]int64{driver: gpu}
+		if model := gpuModelOfAffinity(deploy.Spec.Template.Spec.Affinity, gpuModelLabelKey(driver, serverConfig)); model != "" {
+			usage.gpuModels = map[string]int64{model: gpu}
+		}
+	}
+	return usage
+}
+
+// usageOfDeployParameter is what a running device built from deployParameter holds
+func usageOfDeployParameter(deployParameter *k8s.DeploymentParameters, serverConfig *config.OpenHydraServerConfig) deviceUsage {
+	cpu := resource.MustParse(deployParameter.CpuMemorySet.CpuLimit)
+	memory := resource.MustParse(deployParameter.CpuMemorySet.MemoryLimit)
+	usage := deviceUsage{
+		devices:     1,
+		cpuMilli:    cpu.MilliValue(),
+		memoryBytes: memory.Value(),
+	}
+	if deployParameter.GpuSet.Gpu > 0 {
+		usage.gpu = map[string]int64{deployParameter.GpuSet.GpuDriverName: int64(deployParameter.GpuSet.Gpu)}
+		if model := gpuModelOfAffinity(deployParameter.Affinity, gpuModelLabelKey(deployParameter.GpuSet.GpuDriverName, serverConfig)); model != "" {
+			usage.gpuModels = map[string]int64{model: int64(deployParameter.GpuSet.Gpu)}
+		}
+	}
+	return usage
+}
+
+// userQuota merges role quota with quota of user, fields set for user win
+func userQuota(username string, role int, serverConfig *config.OpenHydraServerConfig) config.ResourceQuota {
+	result := config.ResourceQuota{}
+	if serverConfig.Quota == nil {
+		return result
+	}
+	for _, quota := range []config.ResourceQuota{serverConfig.Quota.Roles[role], serverConfig.Quota.Users[username]} {
+		if quota.Devices != nil {
+			result.Devices = quota.Devices
+		}
+		if quota.Cpu != "" {
+			result.Cpu = quota.Cpu
+		}
+		if quota.Memory != "" {
+			result.Memory = quota.Memory
+		}
+		if quota.Workspace != "" {
+			result.Workspace = quota.Workspace
+		}
+		if quota.GpuHours != nil {
+			result.GpuHours = quota.GpuHours
+		}
+		for key, gpu := range quota.Gpu {
+			if result.Gpu == nil {
+				result.Gpu = map[string]int64{}
+			}
+			result.Gpu[key] = gpu
+		}
+		for model, gpu := range quota.GpuModels {
+			if result.GpuModels == nil {
+				result.GpuModels = map[string]int64{}
+			}
+			result.GpuModels[model] = gpu
+		}
+	}
+	return result
+}
+
+// userGroups returns quota of groups user belongs to
+func userGroups(username string, serverConfig *config.OpenHydraServerConfig) []config.GroupQuota {
+	if serverConfig.Quota == nil {
+		return nil
+	}
+	var result []config.GroupQuota
+	for _, group := range serverConfig.Quota.Groups {
+		for _, member := range group.Members {
+			if member == username {
+				result = append(result, group)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// quotaGrantsGpu reports whether user is explicitly given gpu of gpuDriver
+func quotaGrantsGpu(username string, role int, gpuDriver string, serverConfig *config.OpenHydraServerConfig) bool {
+	return userQuota(username, role, serverConfig).Gpu[gpuDriver] > 0
+}
+
+func quotaHard(quota config.ResourceQuota) (deviceUsage, error) {
+	hard := deviceUsage{gpu: quota.Gpu, gpuModels: quota.GpuModels}
+	if quota.Devices != nil {
+		hard.devices = *quota.Devices
+	}
+	if quota.Cpu != "" {
+		cpu, err := resource.ParseQuantity(quota.Cpu)
+		if err != nil {
+			return hard, fmt.Errorf("invalid cpu quota %s: %v", quota.Cpu, err)
+		}
+		hard.cpuMilli = cpu.MilliValue()
+	}
+	if quota.Memory != "" {
+		memory, err := resource.ParseQuantity(quota.Memory)
+		if err != nil {
+			return hard, fmt.Errorf("invalid memory quota %s: %v", quota.Memory, err)
+		}
+		hard.memoryBytes = memory.Value()
+	}
+	return hard, nil
+}
+
+// quotaExceeded tells which resource goes beyond quota once delta is added to used, empty when none
+// only resources growing by delta are checked so device can always shrink even if quota was lowered meanwhile
+func quotaExceeded(quota config.ResourceQuota, used, delta deviceUsage) (string, error) {
+	hard, err := quotaHard(quota)
+	if err != nil {
+		return "", err
+	}
+	if quota.Devices != nil && delta.devices > 0 && used.devices+delta.devices > hard.devices {
+		return fmt.Sprintf("devices: used %d, requested %d, limited %d", used.devices, delta.devices, hard.devices), nil
+	}
+	if quota.Cpu != "" && delta.cpuMilli > 0 && used.cpuMilli+delta.cpuMilli > hard.cpuMilli {
+		return fmt.Sprintf("cpu: used %dm, requested %dm, limited %s", used.cpuMilli, delta.cpuMilli, quota.Cpu), nil
+	}
+	if quota.Memory != "" && delta.memoryBytes > 0 && used.memoryBytes+delta.memoryBytes > hard.memoryBytes {
+		return fmt.Sprintf("memory: used %dMi, requested %dMi, limited %s", used.memoryBytes>>20, delta.memoryBytes>>20, quota.Memory), nil
+	}
+	for key, gpu := range delta.gpu {
+		limit, found := quota.Gpu[key]
+		if found && gpu > 0 && used.gpu[key]+gpu > limit {
+			return fmt.Sprintf("%s: used %d, requested %d, limited %d", key, used.gpu[key], gpu, limit), nil
+		}
+	}
+	if quota.GpuModels != nil {
+		// gpu not asking for any model could land on model not allowed
+		withoutModel := int64(0)
+		for _, gpu := range delta.gpu {
+			withoutModel += gpu
+		}
+		for _, gpu := range delta.gpuModels {
+			withoutModel -= gpu
+		}
+		if withoutModel > 0 {
+			return "gpu model: gpu model must be given", nil
+		}
+		for model, gpu := range delta.gpuModels {
+			limit := quota.GpuModels[model]
+			if gpu > 0 && used.gpuModels[model]+gpu > limit {
+				return fmt.Sprintf("gpu model %s: used %d, requested %d, limited %d", model, used.gpuModels[model], gpu, limit), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// usageByUser sums up what devices of every user hold
+func (builder *OpenHydraRouteBuilder) usageByUser(serverConfig *config.OpenHydraServerConfig) (map[string]deviceUsage, error) {
+	deploys, err := builder.k8sHelper.ListDeploymentWithLabel(fmt.Sprintf("%s=%s", k8s.OpenHydraWorkloadLabelKey, k8s.OpenHydraWorkloadLabelValue), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]deviceUsage{}
+	for _, deploy := range deploys {
+		username, found := deploy.Labels[k8s.OpenHydraUserLabelKey]
+		if !found {
+			continue
+		}
+		usage := result[username]
+		usage.add(usageOfDeployment(deploy, serverConfig))
+		result[username] = usage
+	}
+	return result, nil
+}
+
+// checkDeviceQuota returns forbidden error when delta does not fit into quota of user or any group of user
+// or delta asks for more gpu while gpu hours budget is used up
+// caller must hold quotaLock until devices are changed so concurrent requests can not both pass the check
+func (builder *OpenHydraRouteBuilder) checkDeviceQuota(username string, role int, delta deviceUsage, serverConfig *config.OpenHydraServerConfig) error {
+	if serverConfig.Quota == nil {
+		return nil
+	}
+	usage, err := builder.usageByUser(serverConfig)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+
+	exceeded, err := quotaExceeded(userQuota(username, role, serverConfig), usage[username], delta)
+	if err != nil {
+		return errors.NewInternalError(err)
+	}
+	if exceeded != "" {
+		return errors.NewForbidden(xDeviceV1.Resource("device"), username, fmt.Errorf("exceeded quota of user, %s", exceeded))
+	}
+
+	for _, group := range userGroups(username, serverConfig) {
+		used := deviceUsage{}
+		for _, member := range group.Members {
+			used.add(usage[member])
+		}
+		exceeded, err = quotaExceeded(group.Quota, used, delta)
+		if err != nil {
+			return errors.NewInternalError(err)
+		}
+		if exceeded != "" {
+			return errors.NewForbidden(xDeviceV1.Resource("device"), username, fmt.Errorf("exceeded quota of group %s, %s", group.Name, exceeded))
+		}
+	}
+
+	for _, gpu := range delta.gpu {
+		if gpu > 0 {
+			return builder.checkGpuBudget(username, role, serverConfig)
+		}
+	}
+	return nil
+}
+
+// quotaResources shows hard, used and remaining of quota, resource not limited by quota is left out of hard and remaining
+func quotaResources(quota config.ResourceQuota, used deviceUsage) (xDeviceV1.QuotaResources, xDeviceV1.QuotaResources, error) {
+	hard, err := quotaHard(quota)
+	if err != nil {
+		return xDeviceV1.QuotaResources{}, xDeviceV1.QuotaResources{}, err
+	}
+	left := hard.sub(used)
+	remaining := xDeviceV1.QuotaResources{}
+	if quota.Devices != nil {
+		devices := max(left.devices, 0)
+		remaining.Devices = &devices
+	}
+	if quota.Cpu != "" {
+		remaining.Cpu = resource.NewMilliQuantity(max(left.cpuMilli, 0), resource.DecimalSI).String()
+	}
+	if quota.Memory != "" {
+		remaining.Memory = resource.NewQuantity(max(left.memoryBytes, 0), resource.BinarySI).String()
+	}
+	for key := range quota.Gpu {
+		if remaining.Gpu == nil {
+			remaining.Gpu = map[string]int64{}
+		}
+		remaining.Gpu[key] = max(left.gpu[key], 0)
+	}
+	for model := range quota.GpuModels {
+		if remaining.GpuModels == nil {
+			remaining.GpuModels = map[string]int64{}
+		}
+		remaining.GpuModels[model] = max(left.gpuModels[model], 0)
+	}
+	return xDeviceV1.QuotaResources{
+		Devices:   quota.Devices,
+		Cpu:       quota.Cpu,
+		Memory:    quota.Memory,
+		Gpu:       quota.Gpu,
+		GpuModels: quota.GpuModels,
+	}, remaining, nil
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceQuotaRoute() {
+	path := "/" + DevicePath + "/{username}/quota"
+	builder.addPathAuthorization(path, http.MethodGet, 3)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getDeviceQuota").To(builder.DeviceQuotaRouteHandler).
+		Returns(http.StatusNotFound, "not found", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.DeviceQuota{}))
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceQuotaRouteHandler(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("username") == "" {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "username is empty")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can get quota of other user
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to get quota of user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	user, err := builder.Database.GetUser(username)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusNotFound, fmt.Sprintf("user %s not found", username))
+		return
+	}
+
+	usage, err := builder.usageByUser(serverConfig)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	result := xDeviceV1.DeviceQuota{}
+	util.FillKindAndApiVersion(&result.TypeMeta, "DeviceQuota")
+	result.Name = username
+	result.Spec.OpenHydraUsername = username
+	result.Spec.Used = usage[username].toQuotaResources()
+	result.Spec.Hard, result.Spec.Remaining, err = quotaResources(userQuota(username, user.Spec.Role, serverConfig), usage[username])
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	for _, group := range userGroups(username, serverConfig) {
+		used := deviceUsage{}
+		for _, member := range group.Members {
+			used.add(usage[member])
+		}
+		groupUsage := xDeviceV1.GroupQuotaUsage{Name: group.Name, Used: used.toQuotaResources()}
+		groupUsage.Hard, groupUsage.Remaining, err = quotaResources(group.Quota, used)
+		if err != nil {
+			writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+			return
+		}
+		result.Spec.Groups = append(result.Spec.Groups, groupUsage)
+	}
+
+	response.WriteEntity(&result)
+}
+
+// roleFromHeader returns role number in request header, 0 when it is not a number
+func roleFromHeader(request *restful.Request) int {
+	role, _ := strconv.Atoi(request.HeaderParameter(openHydraHeaderRole))
+	return role
+}
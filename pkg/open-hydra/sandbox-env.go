@@ -0,0 +1,110 @@
+package openhydra
+
+import (
+	"fmt"
+	"strings"
+
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/apis"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// env set by open-hydra-server itself, sandbox can not override it
+const reservedEnvPrefix = "OPENHYDRA_"
+
+// renderSandboxTemplate replaces {username}, {project-id} and {device-id} with the ones of device
+func renderSandboxTemplate(value string, device *xDeviceV1.Device) string {
+	return strings.NewReplacer(
+		"{username}", device.Spec.OpenHydraUsername,
+		"{project-id}", device.Spec.OpenHydraProjectId,
+		"{device-id}", k8s.DeviceId(device.Spec.DeviceId),
+	).Replace(value)
+}
+
+// renderSandboxRefName replaces {username} in name of a referred secret or config map, project id and device id
+// are picked by the student so they are refused here to keep one from referring secrets of others
+func renderSandboxRefName(name string, device *xDeviceV1.Device) (string, error) {
+	if strings.Contains(name, "{project-id}") || strings.Contains(name, "{device-id}") {
+		return "", fmt.Errorf("referred name %s should only use {username}", name)
+	}
+	return strings.ReplaceAll(name, "{username}", device.Spec.OpenHydraUsername), nil
+}
+
+func renderSandboxTemplates(values []string, device *xDeviceV1.Device) []string {
+	var result []string
+	for _, value := range values {
+		result = append(result, renderSandboxTemplate(value, device))
+	}
+	return result
+}
+
+func renderSandboxEnv(env []apis.EnvVar, device *xDeviceV1.Device) ([]apis.EnvVar, error) {
+	var result []apis.EnvVar
+	for _, item := range env {
+		if item.Name == "" || strings.HasPrefix(item.Name, reservedEnvPrefix) {
+			return nil, fmt.Errorf("env name %s is empty or reserved", item.Name)
+		}
+		if (item.SecretKeyRef != nil && (item.ConfigMapKeyRef != nil || item.Value != "")) || (item.ConfigMapKeyRef != nil && item.Value != "") {
+			return nil, fmt.Errorf("env %s should only set one of value, secret key ref and config map key ref", item.Name)
+		}
+		item.Value = renderSandboxTemplate(item.Value, device)
+		var err error
+		if item.SecretKeyRef != nil {
+			ref := *item.SecretKeyRef
+			if ref.Name, err = renderSandboxRefName(ref.Name, device); err != nil {
+				return nil, err
+			}
+			item.SecretKeyRef = &ref
+		}
+		if item.ConfigMapKeyRef != nil {
+			ref := *item.ConfigMapKeyRef
+			if ref.Name, err = renderSandboxRefName(ref.Name, device); err != nil {
+				return nil, err
+			}
+			item.ConfigMapKeyRef = &ref
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+// applySandboxEnv renders env, env from and init containers of sandbox for device into deployParameter
+func applySandboxEnv(deployParameter *k8s.DeploymentParameters, sandbox apis.Sandbox, device *xDeviceV1.Device) error {
+	env, err := renderSandboxEnv(sandbox.Env, device)
+	if err != nil {
+		return errors.NewBadRequest(fmt.Sprintf("invalid env of sandbox %s: %v", device.Spec.SandboxName, err))
+	}
+	deployParameter.Env = env
+
+	for _, item := range sandbox.EnvFrom {
+		if (item.SecretName == "") == (item.ConfigMapName == "") {
+			return errors.NewBadRequest(fmt.Sprintf("env from of sandbox %s should set one of secret name and config map name", device.Spec.SandboxName))
+		}
+		if item.SecretName, err = renderSandboxRefName(item.SecretName, device); err != nil {
+			return errors.NewBadRequest(fmt.Sprintf("invalid env from of sandbox %s: %v", device.Spec.SandboxName, err))
+		}
+		if item.ConfigMapName, err = renderSandboxRefName(item.ConfigMapName, device); err != nil {
+			return errors.NewBadRequest(fmt.Sprintf("invalid env from of sandbox %s: %v", device.Spec.SandboxName, err))
+		}
+		deployParameter.EnvFrom = append(deployParameter.EnvFrom, item)
+	}
+
+	names := map[string]bool{}
+	for _, init := range sandbox.InitContainers {
+		if errs := validation.IsDNS1123Label(init.Name); len(errs) > 0 || names[init.Name] {
+			return errors.NewBadRequest(fmt.Sprintf("init container name %s of sandbox %s is invalid or duplicated", init.Name, device.Spec.SandboxName))
+		}
+		names[init.Name] = true
+		init.Env, err = renderSandboxEnv(init.Env, device)
+		if err != nil {
+			return errors.NewBadRequest(fmt.Sprintf("invalid env of init container %s of sandbox %s: %v", init.Name, device.Spec.SandboxName, err))
+		}
+		init.Command = renderSandboxTemplates(init.Command, device)
+		init.Args = renderSandboxTemplates(init.Args, device)
+		deployParameter.InitContainers = append(deployParameter.InitContainers, init)
+	}
+	return nil
+}
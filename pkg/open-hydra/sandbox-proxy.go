@@ -0,0 +1,280 @@
+package openhydra
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/cmd/open-hydra-server/app/option"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	"github.com/emicklei/go-restful/v3"
+	"github.com/gorilla/websocket"
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// sandbox sees full path of request, so it must serve port under the same path which it gets as base url
+	deviceProxyPath = "/" + DevicePath + "/{username}/proxy/{deviceId}/{port}/{subpath:*}"
+)
+
+var deviceProxyMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+
+// sandboxProxyTransport forwards requests to device service, replaced by tests
+var sandboxProxyTransport http.RoundTripper = http.DefaultTransport
+
+// sandboxProxied tells whether sandbox ports are reached through device proxy of open-hydra-server
+func sandboxProxied(serverConfig *config.OpenHydraServerConfig) bool {
+	return serverConfig != nil && serverConfig.SandboxExposureMode == SandboxExposureProxy
+}
+
+// checkSandboxProxyConfig refuses proxy exposure without proxy domain, sandbox would be served on origin of api otherwise
+func checkSandboxProxyConfig(serverConfig *config.OpenHydraServerConfig) error {
+	if sandboxProxied(serverConfig) && serverConfig.SandboxProxyDomain == "" {
+		return fmt.Errorf("sandbox proxy domain is required by sandbox exposure mode %s so every device is served on its own host", SandboxExposureProxy)
+	}
+	return nil
+}
+
+// sandboxProxyPath returns path of device proxy for a port of device, sandbox gets it without leading slash as base url
+func sandboxProxyPath(username, deviceId, portName string) string {
+	return fmt.Sprintf("/apis/%s/%s/%s/%s/proxy/%s/%s", option.GroupVersion.Group, option.GroupVersion.Version, DevicePath, username, k8s.DeviceId(deviceId), portName)
+}
+
+// sandboxProxyBaseURLs returns base url of every port of device with proxy exposure, nil is returned otherwise
+func sandboxProxyBaseURLs(username, deviceId string, ports map[string]int, serverConfig *config.OpenHydraServerConfig) map[string]string {
+	if !sandboxProxied(serverConfig) {
+		return nil
+	}
+	result := make(map[string]string, len(ports))
+	for portName := range ports {
+		result[portName] = strings.TrimPrefix(sandboxProxyPath(username, deviceId, portName), "/")
+	}
+	return result
+}
+
+// sandboxProxyHost returns host a port of device is served on, every port gets its own origin so sandbox can not use credential
+// of browser against api or other sandboxes, name of device is hashed to fit in a dns label, empty is returned without proxy domain
+func sandboxProxyHost(username, deviceId, portName string, serverConfig *config.OpenHydraServerConfig) string {
+	if serverConfig.SandboxProxyDomain == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(username + "/" + k8s.DeviceId(deviceId)))
+	return fmt.Sprintf("%s-%x.%s", portName, sum[:8], strings.TrimPrefix(serverConfig.SandboxProxyDomain, "."))
+}
+
+// onSandboxProxyHost tells whether request is sent to host of port of device, no host is without proxy domain
+func onSandboxProxyHost(request *http.Request, username, deviceId, portName string, serverConfig *config.OpenHydraServerConfig) bool {
+	host := sandboxProxyHost(username, deviceId, portName, serverConfig)
+	if host == "" {
+		return false
+	}
+	requestHost := request.Host
+	if hostname, _, err := net.SplitHostPort(requestHost); err == nil {
+		requestHost = hostname
+	}
+	return strings.EqualFold(requestHost, host)
+}
+
+// sandboxProxyUrl returns url of a port of device reached through device proxy on its own host
+func sandboxProxyUrl(username, deviceId, portName string, serverConfig *config.OpenHydraServerConfig) string {
+	path := sandboxProxyPath(username, deviceId, portName) + "/"
+	if suffix, found := serverConfig.ApplyPortNameForIngress[portName]; found {
+		path += suffix
+	}
+	host := sandboxProxyHost(username, deviceId, portName, serverConfig)
+	if host == "" {
+		// device is not served anywhere without proxy domain
+		return ""
+	}
+	scheme := "http"
+	if base, err := url.Parse(serverConfig.SandboxProxyBaseURL); err == nil && base.Host != "" {
+		scheme = base.Scheme
+		if base.Port() != "" {
+			host = net.JoinHostPort(host, base.Port())
+		}
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+func (builder *OpenHydraRouteBuilder) AddDeviceProxyRoute() {
+	for _, method := range deviceProxyMethods {
+		builder.addPathAuthorization(deviceProxyPath, method, 3)
+		builder.RootWS.Route(builder.RootWS.Method(method).Path(deviceProxyPath).Operation("proxyDevice"+method[:1]+strings.ToLower(method[1:])).To(builder.DeviceProxyRouteHandler).
+			Doc("forward http and websocket request to port of device on its own host, access link is accepted there as well as auth header").
+			Consumes("*/*").
+			Produces("*/*").
+			Param(builder.RootWS.PathParameter("deviceId", "id of device, default for default device")).
+			Param(builder.RootWS.PathParameter("port", "name of sandbox port")).
+			Returns(http.StatusNotFound, "not found", "").
+			Returns(http.StatusInternalServerError, "internal server error", "").
+			Returns(http.StatusBadGateway, "bad gateway", "").
+			Returns(http.StatusForbidden, "forbidden", "").
+			Returns(http.StatusUnauthorized, "unauthorized", ""))
+	}
+}
+
+func (builder *OpenHydraRouteBuilder) DeviceProxyRouteHandler(request *restful.Request, response *restful.Response) {
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	username := request.PathParameter("username")
+	deviceId := k8s.DeviceId(request.PathParameter("deviceId"))
+	portName := request.PathParameter("port")
+	if err := checkSandboxProxyConfig(serverConfig); err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !onSandboxProxyHost(request.Request, username, deviceId, portName, serverConfig) {
+		// sandbox is untrusted content, it is never served on origin of api or of other sandboxes
+		writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("port %s of device %s for user %s is only served on host %s", portName, deviceId, username, sandboxProxyHost(username, deviceId, portName, serverConfig)))
+		return
+	}
+	if access, found := request.Attribute(deviceAccessAttribute).(*deviceAccessToken); found {
+		if access.ReadOnly && !deviceAccessReadOnlyAllowed(request.Request) {
+			writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s only has read-only access to device for user: %s", access.Operator, username))
+			return
+		}
+		if value := request.QueryParameter(deviceAccessQueryParameter); value != "" && (request.Request.Method == http.MethodGet || request.Request.Method == http.MethodHead) {
+			// link is turned into cookie so sandbox pages work without it and it does not stay in browser history
+			slog.Info("audit: device access link opened", "operator", access.Operator, "username", username, "deviceId", deviceId, "port", portName, "readOnly", access.ReadOnly)
+			http.SetCookie(response.ResponseWriter, access.cookie(value))
+			location := *request.Request.URL
+			query := location.Query()
+			query.Del(deviceAccessQueryParameter)
+			location.RawQuery = query.Encode()
+			http.Redirect(response.ResponseWriter, request.Request, location.RequestURI(), http.StatusFound)
+			return
+		}
+	}
+	if !serverConfig.DisableAuth {
+		reqUser := request.HeaderParameter(openHydraHeaderUser)
+		reqRole := request.HeaderParameter(openHydraHeaderRole)
+		if reqUser == "" || reqRole == "" {
+			writeHttpResponseAndLogError(response, http.StatusUnauthorized, "no user or role found in request header")
+			return
+		}
+
+		if reqRole != "1" {
+			// only teacher can open device of other user
+			if username != reqUser {
+				writeHttpResponseAndLogError(response, http.StatusForbidden, fmt.Sprintf("user: %s do not have the right to open device for user: %s", reqUser, username))
+				return
+			}
+		}
+	}
+
+	service, err := builder.k8sHelper.GetUserService(k8s.DeviceLabelSelector(username, deviceId), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s", username, deviceId)))
+		return
+	}
+	target := sandboxProxyTarget(service, portName)
+	if target == nil {
+		writeAPIStatusError(response, errors.NewNotFound(xDeviceV1.Resource("device"), fmt.Sprintf("%s/%s port %s", username, deviceId, portName)))
+		return
+	}
+
+	authProtocol := ""
+	for _, protocol := range websocket.Subprotocols(request.Request) {
+		if strings.HasPrefix(protocol, openHydraWebSocketAuthProtocolPrefix) {
+			authProtocol = protocol
+			break
+		}
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(proxyRequest *httputil.ProxyRequest) {
+			proxyRequest.SetURL(target)
+			proxyRequest.SetXForwarded()
+			stripOpenHydraCredential(proxyRequest.Out)
+		},
+		ModifyResponse: func(proxyResponse *http.Response) error {
+			// browser fails websocket when none of offered sub protocols is accepted, credential one stands in for sandbox
+			if proxyResponse.StatusCode == http.StatusSwitchingProtocols && authProtocol != "" && proxyResponse.Header.Get("Sec-WebSocket-Protocol") == "" {
+				proxyResponse.Header.Set("Sec-WebSocket-Protocol", authProtocol)
+			}
+			return nil
+		},
+		Transport: sandboxProxyTransport,
+		ErrorHandler: func(_ http.ResponseWriter, _ *http.Request, proxyErr error) {
+			writeHttpResponseAndLogError(response, http.StatusBadGateway, fmt.Sprintf("Failed to proxy port %s of device %s for user %s: %v", portName, deviceId, username, proxyErr))
+		},
+	}
+	proxy.ServeHTTP(response.ResponseWriter, request.Request)
+}
+
+// sandboxProxyTarget returns address of port of device service, nil when service has no such port
+func sandboxProxyTarget(service *coreV1.Service, portName string) *url.URL {
+	for _, port := range service.Spec.Ports {
+		if port.Name == portName {
+			return &url.URL{Scheme: "http", Host: fmt.Sprintf("%s.%s.svc:%d", service.Name, OpenhydraNamespace, port.Port)}
+		}
+	}
+	return nil
+}
+
+// stripOpenHydraCredential removes credential of open-hydra from request so sandbox never sees it
+func stripOpenHydraCredential(request *http.Request) {
+	request.Header.Del(openHydraAuthStringHeader)
+	request.Header.Del(openHydraHeaderUser)
+	request.Header.Del(openHydraHeaderRole)
+
+	cookies := request.Cookies()
+	request.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != deviceAccessCookie {
+			request.AddCookie(cookie)
+		}
+	}
+
+	if query := request.URL.Query(); query.Has(deviceAccessQueryParameter) {
+		query.Del(deviceAccessQueryParameter)
+		request.URL.RawQuery = query.Encode()
+	}
+
+	var protocols []string
+	for _, protocol := range websocket.Subprotocols(request) {
+		if !strings.HasPrefix(protocol, openHydraWebSocketAuthProtocolPrefix) {
+			protocols = append(protocols, protocol)
+		}
+	}
+	request.Header.Del("Sec-WebSocket-Protocol")
+	if len(protocols) > 0 {
+		request.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	}
+}
+
+// newSessionSecret returns configured session secret or a random one
+func newSessionSecret(serverConfig *config.OpenHydraServerConfig) []byte {
+	if serverConfig != nil && serverConfig.SessionSecret != "" {
+		return []byte(serverConfig.SessionSecret)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		// should never happen, access link can not be verified without a secret
+		panic(fmt.Sprintf("failed to generate session secret: %v", err))
+	}
+	return secret
+}
+
+// signature returns hmac of payload signed by session secret
+func (builder *OpenHydraRouteBuilder) signature(payload string) string {
+	mac := hmac.New(sha256.New, builder.sessionSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,122 @@
+package openhydra
+
+import (
+	"fmt"
+	"strings"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	// device gets a node port service, suits aio install
+	SandboxExposureNodePort = "nodeport"
+	// every port of device gets an ingress
+	SandboxExposureIngress = "ingress"
+	// every port of device gets a gateway api httproute
+	SandboxExposureHTTPRoute = "httproute"
+	// device is reached through device proxy of open-hydra-server
+	SandboxExposureProxy = "proxy"
+
+	SandboxRoutingPath = "path"
+	SandboxRoutingHost = "host"
+)
+
+// sandboxRouted tells whether sandbox ports are exposed by ingress or httproute instead of node port
+func sandboxRouted(serverConfig *config.OpenHydraServerConfig) bool {
+	return serverConfig != nil && (serverConfig.SandboxExposureMode == SandboxExposureIngress || serverConfig.SandboxExposureMode == SandboxExposureHTTPRoute)
+}
+
+// sandboxServiceType returns type of device service, routed or proxied sandbox only needs cluster ip
+func sandboxServiceType(serverConfig *config.OpenHydraServerConfig) coreV1.ServiceType {
+	if sandboxRouted(serverConfig) || sandboxProxied(serverConfig) {
+		return coreV1.ServiceTypeClusterIP
+	}
+	return coreV1.ServiceTypeNodePort
+}
+
+// deviceRouteParameter builds route of device from sandbox route config, nil is returned with node port exposure
+func deviceRouteParameter(username, deviceId string, ports map[string]int, serverConfig *config.OpenHydraServerConfig) (*k8s.DeviceRouteParameters, error) {
+	if !sandboxRouted(serverConfig) {
+		return nil, nil
+	}
+	route := serverConfig.SandboxRoute
+	if route == nil {
+		return nil, fmt.Errorf("sandbox route is not configured for sandbox exposure mode %s", serverConfig.SandboxExposureMode)
+	}
+	if route.Routing == SandboxRoutingHost && route.Host == "" {
+		return nil, fmt.Errorf("host of sandbox route is required by host routing")
+	}
+	if serverConfig.SandboxExposureMode == SandboxExposureHTTPRoute && route.GatewayName == "" {
+		return nil, fmt.Errorf("gateway name of sandbox route is required by httproute")
+	}
+	kind := k8s.DeviceRouteKindIngress
+	if serverConfig.SandboxExposureMode == SandboxExposureHTTPRoute {
+		kind = k8s.DeviceRouteKindHTTPRoute
+	}
+	return &k8s.DeviceRouteParameters{
+		Namespace:        OpenhydraNamespace,
+		Username:         username,
+		DeviceId:         deviceId,
+		Ports:            ports,
+		Kind:             kind,
+		HostRouting:      route.Routing == SandboxRoutingHost,
+		Host:             route.Host,
+		IngressClassName: route.IngressClassName,
+		TLSSecretName:    route.TLSSecretName,
+		Annotations:      route.Annotations,
+		GatewayName:      route.GatewayName,
+		GatewayNamespace: route.GatewayNamespace,
+	}, nil
+}
+
+// createDeviceRoutes exposes ports of device by ingress or httproute, nothing is done with node port exposure
+// routes are owned by owner when given
+func (builder *OpenHydraRouteBuilder) createDeviceRoutes(username, deviceId string, ports map[string]int, owner *appsV1.Deployment, serverConfig *config.OpenHydraServerConfig) error {
+	routeParameter, err := deviceRouteParameter(username, deviceId, ports, serverConfig)
+	if err != nil || routeParameter == nil {
+		return err
+	}
+	routeParameter.Owner = owner
+	return builder.k8sHelper.CreateDeviceRoute(routeParameter, builder.kubeClient)
+}
+
+// sandboxRouteUrl returns url of a port of device exposed by ingress or httproute
+// sandbox is expected to serve under /<device>-<port> which it gets in env OPENHYDRA_<PORT>, so path is kept with host routing as well
+func sandboxRouteUrl(username, deviceId, portName string, serverConfig *config.OpenHydraServerConfig) string {
+	route := serverConfig.SandboxRoute
+	if route == nil {
+		route = &config.SandboxRouteConfig{}
+	}
+	scheme := "http"
+	if route.Https || route.TLSSecretName != "" {
+		scheme = "https"
+	}
+	port := ""
+	if route.Port != 0 {
+		port = fmt.Sprintf(":%d", route.Port)
+	}
+	routeName := k8s.DeviceRouteName(username, deviceId, portName)
+	path := "/" + routeName
+	if suffix, found := serverConfig.ApplyPortNameForIngress[portName]; found {
+		path = fmt.Sprintf("%s/%s", path, suffix)
+	}
+
+	var hosts []string
+	switch {
+	case route.Routing == SandboxRoutingHost:
+		hosts = []string{fmt.Sprintf("%s.%s", routeName, route.Host)}
+	case route.Host != "":
+		hosts = []string{route.Host}
+	default:
+		hosts = strings.Split(serverConfig.ServerIP, ",")
+	}
+	var result []string
+	for _, host := range hosts {
+		result = append(result, fmt.Sprintf("%s://%s%s%s", scheme, host, port, path))
+	}
+	return strings.Join(result, ",")
+}
@@ -0,0 +1,111 @@
+package openhydra
+
+import (
+	"fmt"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	coreV1 "k8s.io/api/core/v1"
+)
+
+const (
+	// affinity of device request is used as it is
+	UserAffinityAllow = "allow"
+	// node affinity of device request may only refer allowed affinity keys
+	UserAffinityRestrict = "restrict"
+	// device request can not set affinity
+	UserAffinityDeny = "deny"
+)
+
+// schedulingPolicy merges scheduling policy of role over the one of sandbox
+func schedulingPolicy(sandboxName string, role int, serverConfig *config.OpenHydraServerConfig) config.SchedulingPolicy {
+	result := config.SchedulingPolicy{}
+	if serverConfig.SchedulingPolicy == nil {
+		return result
+	}
+	for _, policy := range []config.SchedulingPolicy{serverConfig.SchedulingPolicy.Sandboxes[sandboxName], serverConfig.SchedulingPolicy.Roles[role]} {
+		for key, value := range policy.NodeSelector {
+			if result.NodeSelector == nil {
+				result.NodeSelector = map[string]string{}
+			}
+			result.NodeSelector[key] = value
+		}
+		result.Tolerations = append(result.Tolerations, policy.Tolerations...)
+		result.TopologySpreadConstraints = append(result.TopologySpreadConstraints, policy.TopologySpreadConstraints...)
+		if policy.PriorityClassName != "" {
+			result.PriorityClassName = policy.PriorityClassName
+		}
+		if policy.UserAffinity != "" {
+			result.UserAffinity = policy.UserAffinity
+			result.AllowedAffinityKeys = policy.AllowedAffinityKeys
+		}
+	}
+	return result
+}
+
+// checkUserAffinity ensures affinity of device request is allowed by policy
+// node labels of gpu model are always allowed since open-hydra-server sets them itself, unknown mode is taken as deny
+func checkUserAffinity(affinity *coreV1.Affinity, policy config.SchedulingPolicy, serverConfig *config.OpenHydraServerConfig) error {
+	if affinity == nil || policy.UserAffinity == "" || policy.UserAffinity == UserAffinityAllow {
+		return nil
+	}
+	if affinity.PodAffinity != nil || affinity.PodAntiAffinity != nil {
+		return fmt.Errorf("pod affinity is not allowed")
+	}
+	if affinity.NodeAffinity == nil {
+		return nil
+	}
+
+	allowed := map[string]bool{}
+	for _, labelKey := range serverConfig.GpuModelNodeLabels {
+		allowed[labelKey] = true
+	}
+	if policy.UserAffinity == UserAffinityRestrict {
+		for _, key := range policy.AllowedAffinityKeys {
+			allowed[key] = true
+		}
+	}
+
+	var terms []coreV1.NodeSelectorTerm
+	if affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		terms = append(terms, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms...)
+	}
+	for _, preferred := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		terms = append(terms, preferred.Preference)
+	}
+	for _, term := range terms {
+		for _, requirement := range append(append([]coreV1.NodeSelectorRequirement{}, term.MatchExpressions...), term.MatchFields...) {
+			if !allowed[requirement.Key] {
+				return fmt.Errorf("node affinity on %s is not allowed", requirement.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// applySchedulingPolicy puts node selector, tolerations, topology spread and priority class of policy on deployment
+func applySchedulingPolicy(deployParameter *k8s.DeploymentParameters, policy config.SchedulingPolicy) {
+	deployParameter.NodeSelector = policy.NodeSelector
+	deployParameter.PriorityClassName = policy.PriorityClassName
+	for _, toleration := range policy.Tolerations {
+		deployParameter.Tolerations = append(deployParameter.Tolerations, coreV1.Toleration{
+			Key:               toleration.Key,
+			Operator:          coreV1.TolerationOperator(toleration.Operator),
+			Value:             toleration.Value,
+			Effect:            coreV1.TaintEffect(toleration.Effect),
+			TolerationSeconds: toleration.TolerationSeconds,
+		})
+	}
+	for _, constraint := range policy.TopologySpreadConstraints {
+		whenUnsatisfiable := coreV1.UnsatisfiableConstraintAction(constraint.WhenUnsatisfiable)
+		if whenUnsatisfiable == "" {
+			whenUnsatisfiable = coreV1.ScheduleAnyway
+		}
+		deployParameter.TopologySpreadConstraints = append(deployParameter.TopologySpreadConstraints, coreV1.TopologySpreadConstraint{
+			MaxSkew:           constraint.MaxSkew,
+			TopologyKey:       constraint.TopologyKey,
+			WhenUnsatisfiable: whenUnsatisfiable,
+		})
+	}
+}
@@ -2,6 +2,7 @@ package openhydra
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	xSetting "open-hydra/pkg/apis/open-hydra-api/setting/core/v1"
 
@@ -33,6 +34,10 @@ func (builder *OpenHydraRouteBuilder) GetSettingRouteHandler(request *restful.Re
 	result.Name = request.PathParameter("name")
 	result.Spec = xSetting.SettingSpec{}
 	result.Spec.DefaultGpuPerDevice = serverConfig.DefaultGpuPerDevice
+	if serverConfig.NetworkPolicy != nil {
+		examMode := serverConfig.NetworkPolicy.ExamMode
+		result.Spec.ExamMode = &examMode
+	}
 	// now get all plugins from configmap
 	cm, err := builder.k8sHelper.GetConfigMap("openhydra-plugin", OpenhydraNamespace)
 	if err != nil {
@@ -76,6 +81,13 @@ func (builder *OpenHydraRouteBuilder) UpdateSettingRouteHandler(request *restful
 
 	util.FillKindAndApiVersion(&setting.TypeMeta, SettingKind)
 	serverConfig.DefaultGpuPerDevice = setting.Spec.DefaultGpuPerDevice
+	if setting.Spec.ExamMode != nil {
+		if serverConfig.NetworkPolicy == nil {
+			writeHttpResponseAndLogError(response, http.StatusBadRequest, "exam mode requires network policy to be configured")
+			return
+		}
+		serverConfig.NetworkPolicy.ExamMode = *setting.Spec.ExamMode
+	}
 
 	configJson, err := yaml.Marshal(serverConfig)
 	if err != nil {
@@ -89,5 +101,12 @@ func (builder *OpenHydraRouteBuilder) UpdateSettingRouteHandler(request *restful
 		return
 	}
 
+	// exam mode takes effect now rather than on next reconcile
+	if setting.Spec.ExamMode != nil {
+		if err := builder.reconcileNetworkPolicies(serverConfig); err != nil {
+			slog.Error("Failed to reconcile network policies after setting is updated", "error", err)
+		}
+	}
+
 	response.WriteHeaderAndEntity(http.StatusOK, setting)
 }
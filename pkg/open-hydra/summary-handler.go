@@ -66,7 +66,6 @@ func (builder *OpenHydraRouteBuilder) SumUpGpuResources(pods []coreV1.Pod, nodeL
 		Allocatable *resource.Quantity
 		Allocated   *resource.Quantity
 	}{}
-	var totalLine uint16
 	if len(serverConfig.GpuResourceKeys) == 0 {
 		// warn
 		slog.Warn("gpu resource key is empty, so total gpu number will be 0 and all device use gpu will fall back to default")
@@ -95,12 +94,16 @@ func (builder *OpenHydraRouteBuilder) SumUpGpuResources(pods []coreV1.Pod, nodeL
 					gpuResourceCount[gpuResourceKey].Allocated.Add(gpuRequests)
 				}
 			}
-			if pod.Status.Phase == coreV1.PodPending {
-				totalLine++
-			}
 		}
 	}
 
+	// devices waiting in gpu queue
+	queued, err := builder.k8sHelper.ListDeploymentWithLabel(gpuQueueSelector(), OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return nil, err
+	}
+	totalLine := uint16(len(queued))
+
 	// sum up all gpu device allocation status
 	gpuDriverSumUp := map[string]xSumUpV1.GpuResourceSumUp{}
 	for key, allocationStatus := range gpuResourceCount {
@@ -131,6 +134,8 @@ func (builder *OpenHydraRouteBuilder) SumUpGpuResources(pods []coreV1.Pod, nodeL
 			DefaultGpuPerDevice: serverConfig.DefaultGpuPerDevice,
 			TotalLine:           totalLine,
 			GpuResourceSumUp:    gpuDriverSumUp,
+			GpuProfileSumUp:     sumUpGpuProfiles(pods, nodeList, serverConfig.GpuProfiles),
+			GpuModelSumUp:       gpuModelCatalog(nodeList.Items, pods, serverConfig),
 		},
 	}, nil
 }
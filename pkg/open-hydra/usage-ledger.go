@@ -0,0 +1,280 @@
+package openhydra
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/open-hydra/k8s"
+	"open-hydra/pkg/util"
+
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	UsageEventStart  = "start"
+	UsageEventStop   = "stop"
+	UsageEventResize = "resize"
+)
+
+// usageRecordOfPod is what running pod of device holds from now on, cpu and memory are taken from limits
+// gpu model is the one device asks for, otherwise the one of node pod runs on
+func usageRecordOfPod(pod coreV1.Pod, nodeLabels map[string]map[string]string, serverConfig *config.OpenHydraServerConfig) xDeviceV1.UsageRecord {
+	record := xDeviceV1.UsageRecord{}
+	util.FillKindAndApiVersion(&record.TypeMeta, "UsageRecord")
+	record.Spec.OpenHydraUsername = pod.Labels[k8s.OpenHydraUserLabelKey]
+	record.Spec.DeviceId = k8s.DeviceId(pod.Labels[k8s.OpenHydraDeviceLabelKey])
+	record.Spec.SandboxName = pod.Labels[k8s.OpenHydraSandboxKey]
+	record.Spec.PodName = pod.Name
+	if len(pod.Spec.Containers) == 0 {
+		return record
+	}
+	limits := pod.Spec.Containers[0].Resources.Limits
+	record.Spec.CpuMilli = limits.Cpu().MilliValue()
+	record.Spec.MemoryBytes = limits.Memory().Value()
+	if driver, gpu := containersGpu(pod.Spec.Containers, serverConfig.GpuResourceKeys); gpu > 0 {
+		record.Spec.GpuDriver = driver
+		record.Spec.Gpu = gpu
+		record.Spec.GpuModel = gpuModelOfAffinity(pod.Spec.Affinity, gpuModelLabelKey(driver, serverConfig))
+		if record.Spec.GpuModel == "" {
+			record.Spec.GpuModel = gpuModelOfNode(driver, nodeLabels[pod.Spec.NodeName], serverConfig)
+		}
+	}
+	return record
+}
+
+// sameUsage reports whether two records hold the same resources, pod being replaced alone is not a resize
+func sameUsage(left, right xDeviceV1.UsageRecordSpec) bool {
+	return left.CpuMilli == right.CpuMilli && left.MemoryBytes == right.MemoryBytes && left.Gpu == right.Gpu &&
+		left.GpuDriver == right.GpuDriver && left.GpuModel == right.GpuModel && left.SandboxName == right.SandboxName
+}
+
+// loadUsageSessions rebuilds devices running after last record from ledger, e.g. after server restart or leader change
+func (builder *OpenHydraRouteBuilder) loadUsageSessions(now time.Time) (map[string]xDeviceV1.UsageRecord, error) {
+	records, err := builder.Database.ListUsageRecords(now, now)
+	if err != nil {
+		return nil, err
+	}
+	sessions := map[string]xDeviceV1.UsageRecord{}
+	for _, record := range records.Items {
+		label := k8s.DeviceLabelSelector(record.Spec.OpenHydraUsername, record.Spec.DeviceId)
+		if record.Spec.Event == UsageEventStop {
+			delete(sessions, label)
+			continue
+		}
+		sessions[label] = record
+	}
+	return sessions, nil
+}
+
+// syncUsageLedger records start, stop and resize of devices by comparing running pods with devices running after last sync
+// record failed to be written is tried again on next sync
+func (builder *OpenHydraRouteBuilder) syncUsageLedger(serverConfig *config.OpenHydraServerConfig) error {
+	builder.usageLock.Lock()
+	defer builder.usageLock.Unlock()
+
+	now := time.Now()
+	if builder.usageSessions == nil {
+		sessions, err := builder.loadUsageSessions(now)
+		if err != nil {
+			return fmt.Errorf("failed to load usage ledger: %v", err)
+		}
+		builder.usageSessions = sessions
+	}
+
+	pods, err := builder.k8sHelper.ListPod(OpenhydraNamespace, builder.kubeClient)
+	if err != nil {
+		return err
+	}
+	running := map[string]coreV1.Pod{}
+	gpuPods := false
+	for _, pod := range pods {
+		if pod.Labels[k8s.OpenHydraWorkloadLabelKey] != k8s.OpenHydraWorkloadLabelValue || pod.Status.Phase != coreV1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		label := k8s.DeviceLabelSelector(pod.Labels[k8s.OpenHydraUserLabelKey], pod.Labels[k8s.OpenHydraDeviceLabelKey])
+		// newest pod wins while device is rolled to new resources
+		if previous, found := running[label]; found && previous.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			continue
+		}
+		running[label] = pod
+		if _, gpu := containersGpu(pod.Spec.Containers, serverConfig.GpuResourceKeys); gpu > 0 {
+			gpuPods = true
+		}
+	}
+
+	nodeLabels := map[string]map[string]string{}
+	if gpuPods {
+		nodes, err := builder.k8sHelper.GetAllNode(builder.kubeClient)
+		if err != nil {
+			slog.Warn("Failed to list node, gpu model of usage is taken from device only", "error", err)
+		}
+		for _, node := range nodes {
+			nodeLabels[node.Name] = node.Labels
+		}
+	}
+
+	write := func(label string, record xDeviceV1.UsageRecord, event string) {
+		record.Spec.Event = event
+		record.Spec.Time = metaV1.NewTime(now)
+		if err := builder.Database.CreateUsageRecord(&record); err != nil {
+			slog.Error("Failed to create usage record", "device", label, "event", event, "error", err)
+			return
+		}
+		if event == UsageEventStop {
+			delete(builder.usageSessions, label)
+			return
+		}
+		builder.usageSessions[label] = record
+	}
+
+	for label, pod := range running {
+		record := usageRecordOfPod(pod, nodeLabels, serverConfig)
+		session, found := builder.usageSessions[label]
+		if !found {
+			write(label, record, UsageEventStart)
+		} else if !sameUsage(session.Spec, record.Spec) {
+			write(label, record, UsageEventResize)
+		}
+	}
+	for label, session := range builder.usageSessions {
+		if _, found := running[label]; !found {
+			write(label, session, UsageEventStop)
+		}
+	}
+	return nil
+}
+
+// notifyUsageLedger asks usage ledger loop to sync as soon as possible
+func (builder *OpenHydraRouteBuilder) notifyUsageLedger() {
+	select {
+	case builder.usageNotify <- struct{}{}:
+	default:
+	}
+}
+
+// RunUsageLedger syncs usage ledger whenever pods of devices change and periodically until stopChan is closed
+// gpu budgets are enforced right after every sync since they are taken from ledger
+func (builder *OpenHydraRouteBuilder) RunUsageLedger(stopChan <-chan struct{}) {
+	err := builder.k8sHelper.AddWorkloadChangeHandler(builder.notifyUsageLedger)
+	if err != nil {
+		// ledger still follows devices on every resync, only times get less accurate
+		slog.Error("Failed to watch pods of devices for usage ledger", "error", err)
+	}
+	interval := time.Duration(builder.cfg.UsageLedgerSyncIntervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = 60 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+			case <-builder.usageNotify:
+			}
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			if err != nil {
+				slog.Error("Failed to get server config for usage ledger", "error", err)
+				continue
+			}
+			if err := builder.syncUsageLedger(serverConfig); err != nil {
+				slog.Error("Failed to sync usage ledger", "error", err)
+			}
+			if err := builder.enforceGpuBudgets(serverConfig); err != nil {
+				slog.Error("Failed to enforce gpu budgets", "error", err)
+			}
+		}
+	}()
+}
+
+// usageReportKeys returns keys usage of record is summed up by, user in no group is left out of group report
+func usageReportKeys(record xDeviceV1.UsageRecordSpec, groupBy string, serverConfig *config.OpenHydraServerConfig) []string {
+	switch groupBy {
+	case UsageGroupByGroup:
+		var keys []string
+		for _, group := range userGroups(record.OpenHydraUsername, serverConfig) {
+			keys = append(keys, group.Name)
+		}
+		return keys
+	case UsageGroupBySandbox:
+		return []string{record.SandboxName}
+	case UsageGroupByGpu:
+		if record.Gpu == 0 {
+			return []string{"cpu"}
+		}
+		if record.GpuModel != "" {
+			return []string{record.GpuModel}
+		}
+		return []string{record.GpuDriver}
+	default:
+		return []string{record.OpenHydraUsername}
+	}
+}
+
+// usageReport sums up what devices held in [from, to) by groupBy, device still running is counted until now
+// records of every device are expected in time order, the first one may be before from
+func usageReport(records []xDeviceV1.UsageRecord, from, to, now time.Time, groupBy string, serverConfig *config.OpenHydraServerConfig) []xDeviceV1.UsageReportItem {
+	items := map[string]*xDeviceV1.UsageReportItem{}
+	devices := map[string]map[string]bool{}
+	add := func(session xDeviceV1.UsageRecordSpec, start, end time.Time) {
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			return
+		}
+		hours := end.Sub(start).Hours()
+		for _, key := range usageReportKeys(session, groupBy, serverConfig) {
+			item, found := items[key]
+			if !found {
+				item = &xDeviceV1.UsageReportItem{Key: key}
+				items[key] = item
+				devices[key] = map[string]bool{}
+			}
+			item.CpuCoreHours += float64(session.CpuMilli) / 1000 * hours
+			item.MemoryGiBHours += float64(session.MemoryBytes) / (1 << 30) * hours
+			item.GpuHours += float64(session.Gpu) * hours
+			devices[key][k8s.DeviceLabelSelector(session.OpenHydraUsername, session.DeviceId)] = true
+		}
+	}
+
+	sorted := append([]xDeviceV1.UsageRecord{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Spec.Time.Before(&sorted[j].Spec.Time)
+	})
+	// device label selector -> record device is running with since
+	open := map[string]xDeviceV1.UsageRecordSpec{}
+	for _, record := range sorted {
+		label := k8s.DeviceLabelSelector(record.Spec.OpenHydraUsername, record.Spec.DeviceId)
+		if session, found := open[label]; found {
+			add(session, session.Time.Time, record.Spec.Time.Time)
+			delete(open, label)
+		}
+		if record.Spec.Event != UsageEventStop {
+			open[label] = record.Spec
+		}
+	}
+	for _, session := range open {
+		add(session, session.Time.Time, now)
+	}
+
+	result := []xDeviceV1.UsageReportItem{}
+	for key, item := range items {
+		item.Devices = len(devices[key])
+		result = append(result, *item)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
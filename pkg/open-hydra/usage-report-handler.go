@@ -0,0 +1,119 @@
+package openhydra
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	"open-hydra/pkg/util"
+
+	"github.com/emicklei/go-restful/v3"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	UsageGroupByUser    = "user"
+	UsageGroupByGroup   = "group"
+	UsageGroupBySandbox = "sandbox"
+	UsageGroupByGpu     = "gpu"
+	mimeCSV             = "text/csv"
+)
+
+func (builder *OpenHydraRouteBuilder) AddUsageReportRoute() {
+	path := "/" + UsageReportPath
+	builder.addPathAuthorization(path, http.MethodGet, 1)
+	builder.RootWS.Route(builder.RootWS.GET(path).Operation("getUsageReport").To(builder.UsageReportRouteHandler).
+		Produces(restful.MIME_JSON, mimeCSV).
+		Param(builder.RootWS.QueryParameter("groupBy", "user, group, sandbox or gpu, default user")).
+		Param(builder.RootWS.QueryParameter("from", "start of time range in RFC3339, default first day of this month")).
+		Param(builder.RootWS.QueryParameter("to", "end of time range in RFC3339, default now")).
+		Param(builder.RootWS.QueryParameter("format", "csv to export report as csv, same as accepting text/csv")).
+		Returns(http.StatusBadRequest, "bad request", "").
+		Returns(http.StatusInternalServerError, "internal server error", "").
+		Returns(http.StatusForbidden, "forbidden", "").
+		Returns(http.StatusUnauthorized, "unauthorized", "").
+		Returns(http.StatusOK, "OK", xDeviceV1.UsageReport{}))
+}
+
+func (builder *OpenHydraRouteBuilder) UsageReportRouteHandler(request *restful.Request, response *restful.Response) {
+	groupBy := request.QueryParameter("groupBy")
+	if groupBy == "" {
+		groupBy = UsageGroupByUser
+	}
+	if groupBy != UsageGroupByUser && groupBy != UsageGroupByGroup && groupBy != UsageGroupBySandbox && groupBy != UsageGroupByGpu {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("invalid groupBy: %s", groupBy))
+		return
+	}
+
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+	var err error
+	if value := request.QueryParameter("from"); value != "" {
+		from, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("invalid from: %s", value))
+			return
+		}
+	}
+	if value := request.QueryParameter("to"); value != "" {
+		to, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("invalid to: %s", value))
+			return
+		}
+	}
+	if !to.After(from) {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, "to should be after from")
+		return
+	}
+
+	serverConfig, err := builder.GetServerConfigFromConfigMap()
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError,
+			fmt.Sprintf("Failed to get server config: %v", err))
+		return
+	}
+
+	records, err := builder.Database.ListUsageRecords(from, to)
+	if err != nil {
+		writeHttpResponseAndLogError(response, http.StatusInternalServerError, fmt.Sprintf("Failed to list usage records: %v", err))
+		return
+	}
+
+	result := xDeviceV1.UsageReport{}
+	util.FillKindAndApiVersion(&result.TypeMeta, "UsageReport")
+	result.Name = groupBy
+	result.Spec.From = metaV1.NewTime(from)
+	result.Spec.To = metaV1.NewTime(to)
+	result.Spec.GroupBy = groupBy
+	result.Spec.Items = usageReport(records.Items, from, to, now, groupBy, serverConfig)
+
+	if request.QueryParameter("format") == "csv" || strings.Contains(request.HeaderParameter("Accept"), mimeCSV) {
+		writeUsageReportCSV(response, &result)
+		return
+	}
+	_ = response.WriteEntity(result)
+}
+
+func writeUsageReportCSV(response *restful.Response, report *xDeviceV1.UsageReport) {
+	response.AddHeader("Content-Type", mimeCSV+"; charset=utf-8")
+	response.AddHeader("Content-Disposition", fmt.Sprintf("attachment; filename=usage-by-%s.csv", report.Spec.GroupBy))
+	response.WriteHeader(http.StatusOK)
+	writer := csv.NewWriter(response)
+	_ = writer.Write([]string{report.Spec.GroupBy, "cpuCoreHours", "memoryGiBHours", "gpuHours", "devices"})
+	for _, item := range report.Spec.Items {
+		_ = writer.Write([]string{
+			item.Key,
+			strconv.FormatFloat(item.CpuCoreHours, 'f', 2, 64),
+			strconv.FormatFloat(item.MemoryGiBHours, 'f', 2, 64),
+			strconv.FormatFloat(item.GpuHours, 'f', 2, 64),
+			strconv.Itoa(item.Devices),
+		})
+	}
+	writer.Flush()
+}
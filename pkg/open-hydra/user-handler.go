@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"strings"
 
 	"github.com/emicklei/go-restful/v3"
 
@@ -43,7 +44,7 @@ func (builder *OpenHydraRouteBuilder) AddXUserListRoute() {
 	// only teacher can list all users
 	path := "/" + OpenHydraUserPath
 	builder.addPathAuthorization(path, http.MethodGet, 1)
-	builder.RootWS.Route(builder.RootWS.GET(path).Operation("listUser").To(builder.XUserListRouteHandler).
+	builder.RootWS.Route(builder.watchable(builder.RootWS.GET(path)).Operation("listUser").To(builder.XUserListRouteHandler).
 		Returns(http.StatusInternalServerError, "internal server error", "").
 		Returns(http.StatusForbidden, "forbidden", "").
 		Returns(http.StatusUnauthorized, "unauthorized", "").
@@ -51,6 +52,11 @@ func (builder *OpenHydraRouteBuilder) AddXUserListRoute() {
 }
 
 func (builder *OpenHydraRouteBuilder) XUserListRouteHandler(request *restful.Request, response *restful.Response) {
+	if isWatch(request) {
+		builder.serveWatch(request, response, builder.watchHubs[OpenHydraUserPath])
+		return
+	}
+
 	xUserList, err := builder.Database.ListUsers()
 	if err != nil {
 		// do not return database related error to client
@@ -59,6 +65,7 @@ func (builder *OpenHydraRouteBuilder) XUserListRouteHandler(request *restful.Req
 	}
 	xUserList.Kind = "List"
 	xUserList.APIVersion = "v1"
+	xUserList.ResourceVersion = builder.watchHubs[OpenHydraUserPath].sync(userObjects(&xUserList))
 	response.WriteEntity(xUserList)
 }
 
@@ -79,6 +86,11 @@ func (builder *OpenHydraRouteBuilder) XUserCreateRouteHandler(request *restful.R
 		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("Failed to read request entity: %v", err))
 		return
 	}
+	// username is part of device names, device names are joined by the separator
+	if strings.Contains(xUser.Name, k8s.DeviceNameSeparator) {
+		writeHttpResponseAndLogError(response, http.StatusBadRequest, fmt.Sprintf("username %s must not contain %s", xUser.Name, k8s.DeviceNameSeparator))
+		return
+	}
 	err = builder.Database.CreateUser(&xUser)
 	if err != nil {
 		writeAPIStatusError(response, err)
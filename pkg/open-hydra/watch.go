@@ -0,0 +1,425 @@
+package openhydra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	xCourseV1 "open-hydra/pkg/apis/open-hydra-api/course/core/v1"
+	xDatasetV1 "open-hydra/pkg/apis/open-hydra-api/dataset/core/v1"
+	xDeviceV1 "open-hydra/pkg/apis/open-hydra-api/device/core/v1"
+	xUserV1 "open-hydra/pkg/apis/open-hydra-api/user/core/v1"
+	"open-hydra/pkg/database"
+	"open-hydra/pkg/open-hydra/k8s"
+
+	"github.com/emicklei/go-restful/v3"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const (
+	// events kept in memory for watch to resume from, older resource version has to list again
+	watchHistoryLength = 1000
+	// events buffered for a single watch, watch falling behind is closed and resumes by resource version
+	watchBufferLength = 100
+	// watch is closed after it unless timeoutSeconds is given
+	defaultWatchTimeout = 30 * time.Minute
+	// watched resource is listed again at least this often, changes of deployment are not seen by informers
+	watchResyncPeriod = 30 * time.Second
+)
+
+// watchEvent is a kubernetes style watch event
+type watchEvent struct {
+	Type            watch.EventType `json:"type"`
+	Object          runtime.Object  `json:"object"`
+	resourceVersion uint64
+}
+
+// watchHub turns lists of a resource into watch events by comparing every list with the previous one
+// resource version is <epoch>.<counter>, counter of the hub starts from zero again after server restarts
+// so epoch tells resource versions of the server before restart or of another leader apart
+type watchHub struct {
+	// list returns current objects by key
+	list            func() (map[string]runtime.Object, error)
+	lock            sync.Mutex
+	epoch           string
+	resourceVersion uint64
+	// key -> object of last list carrying resource version of its last change
+	objects map[string]runtime.Object
+	// key -> object of last list encoded without resource version, to tell whether it is changed
+	encoded  map[string][]byte
+	history  []watchEvent
+	watchers map[chan watchEvent]struct{}
+	notifier chan struct{}
+}
+
+func newWatchHub(list func() (map[string]runtime.Object, error)) *watchHub {
+	return &watchHub{
+		list:     list,
+		epoch:    strconv.FormatInt(time.Now().UnixNano(), 10),
+		objects:  map[string]runtime.Object{},
+		encoded:  map[string][]byte{},
+		watchers: map[chan watchEvent]struct{}{},
+		notifier: make(chan struct{}, 1),
+	}
+}
+
+// version formats counter of hub as resource version given to clients
+func (hub *watchHub) version(counter uint64) string {
+	return fmt.Sprintf("%s.%d", hub.epoch, counter)
+}
+
+func setResourceVersion(obj runtime.Object, resourceVersion string) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetResourceVersion(resourceVersion)
+	}
+}
+
+// sync publishes what is changed since last sync and returns current resource version
+// objects are updated in place with resource version of their last change so list can be answered with them
+func (hub *watchHub) sync(objects map[string]runtime.Object) string {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj := objects[key]
+		setResourceVersion(obj, "")
+		encoded, err := json.Marshal(obj)
+		if err != nil {
+			slog.Warn("Failed to encode watched object", "key", key, "error", err)
+			continue
+		}
+		previous, found := hub.objects[key]
+		if found && bytes.Equal(encoded, hub.encoded[key]) {
+			if accessor, err := meta.Accessor(previous); err == nil {
+				setResourceVersion(obj, accessor.GetResourceVersion())
+			}
+			continue
+		}
+		eventType := watch.Added
+		if found {
+			eventType = watch.Modified
+		}
+		hub.resourceVersion++
+		setResourceVersion(obj, hub.version(hub.resourceVersion))
+		hub.objects[key] = obj
+		hub.encoded[key] = encoded
+		hub.publish(watchEvent{Type: eventType, Object: obj, resourceVersion: hub.resourceVersion})
+	}
+
+	var deleted []string
+	for key := range hub.objects {
+		if _, found := objects[key]; !found {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		hub.resourceVersion++
+		obj := hub.objects[key].DeepCopyObject()
+		setResourceVersion(obj, hub.version(hub.resourceVersion))
+		delete(hub.objects, key)
+		delete(hub.encoded, key)
+		hub.publish(watchEvent{Type: watch.Deleted, Object: obj, resourceVersion: hub.resourceVersion})
+	}
+	return hub.version(hub.resourceVersion)
+}
+
+// publish keeps event for resume and sends it to watchers, caller must hold lock
+func (hub *watchHub) publish(event watchEvent) {
+	hub.history = append(hub.history, event)
+	if len(hub.history) > watchHistoryLength {
+		hub.history = hub.history[len(hub.history)-watchHistoryLength:]
+	}
+	for watcher := range hub.watchers {
+		select {
+		case watcher <- event:
+		default:
+			// watch falling behind is closed rather than blocking others
+			delete(hub.watchers, watcher)
+			close(watcher)
+		}
+	}
+}
+
+// subscribe returns events after resourceVersion along with channel of later events
+// empty or zero resource version starts with current objects as added events
+func (hub *watchHub) subscribe(resourceVersion string) ([]watchEvent, chan watchEvent, error) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+
+	var backlog []watchEvent
+	if resourceVersion == "" || resourceVersion == "0" {
+		keys := make([]string, 0, len(hub.objects))
+		for key := range hub.objects {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			backlog = append(backlog, watchEvent{Type: watch.Added, Object: hub.objects[key]})
+		}
+	} else {
+		epoch, counter, found := strings.Cut(resourceVersion, ".")
+		if !found {
+			// plain counter is given by server before resource version carries epoch
+			epoch, counter = "", epoch
+		}
+		since, err := strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return nil, nil, errors.NewBadRequest(fmt.Sprintf("invalid resource version %s", resourceVersion))
+		}
+		// counter of another epoch may fall in history of this one but tells nothing about it
+		if epoch != hub.epoch {
+			return nil, nil, errors.NewResourceExpired(fmt.Sprintf("resource version %s is given by another server", resourceVersion))
+		}
+		oldest := hub.resourceVersion - uint64(len(hub.history))
+		if since < oldest || since > hub.resourceVersion {
+			return nil, nil, errors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", since, oldest))
+		}
+		for _, event := range hub.history {
+			if event.resourceVersion > since {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	watcher := make(chan watchEvent, watchBufferLength)
+	hub.watchers[watcher] = struct{}{}
+	return backlog, watcher, nil
+}
+
+func (hub *watchHub) unsubscribe(watcher chan watchEvent) {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	if _, found := hub.watchers[watcher]; found {
+		delete(hub.watchers, watcher)
+		close(watcher)
+	}
+}
+
+func (hub *watchHub) watched() bool {
+	hub.lock.Lock()
+	defer hub.lock.Unlock()
+	return len(hub.watchers) > 0
+}
+
+// notify asks hub to list again, it never blocks
+func (hub *watchHub) notify() {
+	select {
+	case hub.notifier <- struct{}{}:
+	default:
+	}
+}
+
+// run lists again on notification and every resync period while someone watches
+func (hub *watchHub) run(stopChan <-chan struct{}) {
+	ticker := time.NewTicker(watchResyncPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		case <-hub.notifier:
+		}
+		if !hub.watched() {
+			continue
+		}
+		objects, err := hub.list()
+		if err != nil {
+			slog.Error("Failed to list watched resource", "error", err)
+			continue
+		}
+		hub.sync(objects)
+	}
+}
+
+// watchable documents watch parameters of list route
+func (builder *OpenHydraRouteBuilder) watchable(route *restful.RouteBuilder) *restful.RouteBuilder {
+	return route.
+		Param(builder.RootWS.QueryParameter("watch", "stream added, modified and deleted events instead of list when true")).
+		Param(builder.RootWS.QueryParameter("resourceVersion", "watch resumes after resource version of list or last event")).
+		Param(builder.RootWS.QueryParameter("timeoutSeconds", "watch is closed after given seconds"))
+}
+
+// isWatch tells whether list request asks for watch
+func isWatch(request *restful.Request) bool {
+	value := request.QueryParameter("watch")
+	return value == "true" || value == "1"
+}
+
+// serveWatch streams events of hub as newline delimited json until client leaves or watch times out
+// expired resource version is answered by an error event so client knows to list again
+func (builder *OpenHydraRouteBuilder) serveWatch(request *restful.Request, response *restful.Response, hub *watchHub) {
+	timeout := defaultWatchTimeout
+	if value := request.QueryParameter("timeoutSeconds"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			writeAPIStatusError(response, errors.NewBadRequest(fmt.Sprintf("invalid timeoutSeconds %s", value)))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	// watch starts from current state rather than the one of last resync
+	objects, err := hub.list()
+	if err != nil {
+		writeAPIStatusError(response, errors.NewInternalError(err))
+		return
+	}
+	hub.sync(objects)
+
+	backlog, watcher, err := hub.subscribe(request.QueryParameter("resourceVersion"))
+	if err != nil && !errors.IsResourceExpired(err) {
+		writeAPIStatusError(response, err)
+		return
+	}
+	if err != nil {
+		status := err.(errors.APIStatus).Status()
+		backlog = []watchEvent{{Type: watch.Error, Object: &status}}
+	} else {
+		defer hub.unsubscribe(watcher)
+	}
+
+	response.AddHeader("Content-Type", restful.MIME_JSON)
+	response.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(response)
+	for _, event := range backlog {
+		if err := encoder.Encode(event); err != nil {
+			return
+		}
+	}
+	response.Flush()
+	if watcher == nil {
+		return
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-request.Request.Context().Done():
+			return
+		case <-timer.C:
+			return
+		case event, ok := <-watcher:
+			if !ok {
+				// fell behind, client resumes from resource version of last event
+				return
+			}
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			response.Flush()
+		}
+	}
+}
+
+// notifyWatchHubs is told about changes made through database, devices are listed along with users
+func (builder *OpenHydraRouteBuilder) notifyWatchHubs(change database.Change) {
+	switch change.Kind {
+	case database.ChangeKindUser:
+		builder.watchHubs[OpenHydraUserPath].notify()
+		builder.watchHubs[DevicePath].notify()
+	case database.ChangeKindDataset:
+		builder.watchHubs[DatasetPath].notify()
+	case database.ChangeKindCourse:
+		builder.watchHubs[CoursePath].notify()
+	}
+}
+
+func (builder *OpenHydraRouteBuilder) newWatchHubs() map[string]*watchHub {
+	return map[string]*watchHub{
+		DevicePath: newWatchHub(func() (map[string]runtime.Object, error) {
+			serverConfig, err := builder.GetServerConfigFromConfigMap()
+			if err != nil {
+				return nil, err
+			}
+			devices, err := builder.listDevices(serverConfig)
+			if err != nil {
+				return nil, err
+			}
+			return deviceObjects(&devices), nil
+		}),
+		OpenHydraUserPath: newWatchHub(func() (map[string]runtime.Object, error) {
+			users, err := builder.Database.ListUsers()
+			if err != nil {
+				return nil, err
+			}
+			return userObjects(&users), nil
+		}),
+		DatasetPath: newWatchHub(func() (map[string]runtime.Object, error) {
+			datasets, err := builder.Database.ListDatasets()
+			if err != nil {
+				return nil, err
+			}
+			return datasetObjects(&datasets), nil
+		}),
+		CoursePath: newWatchHub(func() (map[string]runtime.Object, error) {
+			courses, err := builder.Database.ListCourses()
+			if err != nil {
+				return nil, err
+			}
+			return courseObjects(&courses), nil
+		}),
+	}
+}
+
+// deviceObjects keys devices by user and device id, name of device is name of its user
+func deviceObjects(devices *xDeviceV1.DeviceList) map[string]runtime.Object {
+	result := map[string]runtime.Object{}
+	for index := range devices.Items {
+		result[k8s.DeviceLabelSelector(devices.Items[index].Name, devices.Items[index].Spec.DeviceId)] = &devices.Items[index]
+	}
+	return result
+}
+
+func userObjects(users *xUserV1.OpenHydraUserList) map[string]runtime.Object {
+	result := map[string]runtime.Object{}
+	for index := range users.Items {
+		result[users.Items[index].Name] = &users.Items[index]
+	}
+	return result
+}
+
+func datasetObjects(datasets *xDatasetV1.DatasetList) map[string]runtime.Object {
+	result := map[string]runtime.Object{}
+	for index := range datasets.Items {
+		result[datasets.Items[index].Name] = &datasets.Items[index]
+	}
+	return result
+}
+
+func courseObjects(courses *xCourseV1.CourseList) map[string]runtime.Object {
+	result := map[string]runtime.Object{}
+	for index := range courses.Items {
+		result[courses.Items[index].Name] = &courses.Items[index]
+	}
+	return result
+}
+
+// RunWatchHubs keeps watched resources up to date until stopChan is closed
+func (builder *OpenHydraRouteBuilder) RunWatchHubs(stopChan <-chan struct{}) {
+	err := builder.k8sHelper.AddWorkloadChangeHandler(builder.watchHubs[DevicePath].notify)
+	if err != nil {
+		// device watch still follows database changes and resync
+		slog.Error("Failed to watch pods and services of devices", "error", err)
+	}
+	for _, hub := range builder.watchHubs {
+		go hub.run(stopChan)
+	}
+}
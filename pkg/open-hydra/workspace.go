@@ -0,0 +1,92 @@
+package openhydra
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"open-hydra/cmd/open-hydra-server/app/config"
+	"open-hydra/pkg/open-hydra/apis"
+
+	coreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	// private dirs of sandbox are created by open-hydra-server under workspace path and mounted by host path
+	WorkspaceVolumeHostPath = "hostpath"
+	// every user has a persistent volume claim mounted in place of private dirs of sandbox
+	WorkspaceVolumePVC = "pvc"
+)
+
+// privateHostPath tells whether volume is host path dir of user, other host paths are shared by everyone
+func privateHostPath(volume apis.Volume) bool {
+	return volume.HostPath != nil && (strings.Contains(volume.HostPath.Path, "{username}") || strings.Contains(volume.HostPath.Path, "{workspace}"))
+}
+
+// workspaceSize returns size of workspace claim of user, workspace of quota wins over config
+func workspaceSize(username string, role int, serverConfig *config.OpenHydraServerConfig) (resource.Quantity, error) {
+	size := serverConfig.WorkspaceSize
+	if quota := userQuota(username, role, serverConfig); quota.Workspace != "" {
+		size = quota.Workspace
+	}
+	result, err := resource.ParseQuantity(size)
+	if err != nil {
+		return result, fmt.Errorf("invalid workspace size %s: %v", size, err)
+	}
+	return result, nil
+}
+
+// workspaceSubPath is dir of private host path inside workspace claim, it keeps the layout of workspace path
+// so every sandbox keeps its own dir as it does on host
+func workspaceSubPath(volume apis.Volume, username string) string {
+	subPath := strings.Replace(volume.HostPath.Path, "{username}", username, -1)
+	subPath = strings.Replace(subPath, "{workspace}", "", -1)
+	return strings.Trim(subPath, "/")
+}
+
+// useWorkspaceClaim ensures workspace claim of user and returns volumes with private dirs replaced by that claim
+// volume keeps its name and its mounts get sub path of private dir so sandboxes do not share the claim root
+func (builder *OpenHydraRouteBuilder) useWorkspaceClaim(volumes []apis.Volume, volumeMounts []apis.VolumeMount, username string, role int, serverConfig *config.OpenHydraServerConfig) ([]apis.Volume, []apis.VolumeMount, error) {
+	var result []apis.Volume
+	var claimName string
+	subPaths := map[string]string{}
+	for _, volume := range volumes {
+		if !privateHostPath(volume) {
+			result = append(result, volume)
+			continue
+		}
+		if claimName == "" {
+			accessMode := coreV1.PersistentVolumeAccessMode(serverConfig.WorkspaceAccessMode)
+			if serverConfig.MaximumDevicesPerUser != 1 && accessMode != coreV1.ReadWriteMany && accessMode != coreV1.ReadOnlyMany {
+				// devices of user may land on different nodes and fail on multi-attach of the claim, 0 means no limit on devices
+				return nil, nil, fmt.Errorf("workspace access mode %s can not be shared by several devices of user, use %s or a single device per user", accessMode, coreV1.ReadWriteMany)
+			}
+			size, err := workspaceSize(username, role, serverConfig)
+			if err != nil {
+				return nil, nil, err
+			}
+			claimName, err = builder.k8sHelper.EnsureUserWorkspace(OpenhydraNamespace, username, serverConfig.WorkspaceStorageClass, accessMode, size, builder.kubeClient)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		subPaths[volume.HostPath.Name] = workspaceSubPath(volume, username)
+		result = append(result, apis.Volume{
+			PersistentVolumeClaim: &apis.PersistentVolumeClaim{
+				Name:      volume.HostPath.Name,
+				ClaimName: claimName,
+			},
+		})
+	}
+
+	// mounts belong to sandbox in plugin config map, they are copied rather than changed
+	var mounts []apis.VolumeMount
+	for _, mount := range volumeMounts {
+		if subPath, found := subPaths[mount.Name]; found {
+			mount.SubPath = path.Join(subPath, mount.SubPath)
+		}
+		mounts = append(mounts, mount)
+	}
+	return result, mounts, nil
+}